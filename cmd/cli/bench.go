@@ -2,14 +2,13 @@ package main
 
 import (
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
 	"runtime"
 	"time"
 )
 
 // BenchmarkGeneration benchmarks the key generation
 func BenchmarkGeneration(runs, bits int) {
-	pgpMan := magicbuilder.MakePGP(nil)
+	pgpMan := makePGP()
 
 	fmt.Printf("Benchmarking GPG Key Generation with %d bits and %d runs.\n", bits, runs)
 	fmt.Printf("Running on %s-%s\n", runtime.GOOS, runtime.GOARCH)
@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
+	"github.com/quan-to/chevron/internal/keymagic"
 	"github.com/quan-to/chevron/internal/tools"
 	"io"
 	"io/ioutil"
@@ -11,11 +11,9 @@ import (
 	"strings"
 )
 
-func ImportKey(filename, keyPassword string, keyPasswordFd int) {
+func ImportKey(filename, keyPassword string, keyPasswordFd int, dryRun bool) {
 	var data []byte
 	var err error
-	pgpMan := magicbuilder.MakePGP(nil)
-	pgpMan.LoadKeys(ctx)
 
 	if filename == "-" {
 		// Read from stdin
@@ -42,6 +40,19 @@ func ImportKey(filename, keyPassword string, keyPasswordFd int) {
 		}
 	}
 
+	if dryRun {
+		LintKey(string(data))
+		return
+	}
+
+	err = keymagic.CheckKeyLintPolicy(string(data))
+	if err != nil {
+		panic(fmt.Sprintf("Key %s rejected: %s\n", filename, err))
+	}
+
+	pgpMan := makePGP()
+	pgpMan.LoadKeys(ctx)
+
 	n, err := pgpMan.LoadKey(ctx, string(data))
 
 	if err != nil {
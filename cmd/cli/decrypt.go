@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"encoding/base64"
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
 	"io"
 	"io/ioutil"
 	"os"
@@ -14,7 +13,7 @@ func Decrypt(input, output string) {
 	var err error
 	var data []byte
 
-	pgpMan := magicbuilder.MakePGP(nil)
+	pgpMan := makePGP()
 	pgpMan.LoadKeys(ctx)
 
 	if input == "-" {
@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
 	"io"
 	"io/ioutil"
 	"os"
@@ -14,7 +13,7 @@ import (
 func EncryptFile(input, output, recipient string) {
 	var err error
 	var data []byte
-	pgpMan := magicbuilder.MakePGP(nil)
+	pgpMan := makePGP()
 	pgpMan.LoadKeys(ctx)
 
 	ent := pgpMan.GetPublicKeyEntity(ctx, recipient)
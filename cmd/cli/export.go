@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
 	"github.com/quan-to/chevron/internal/models"
 	"os"
 	"strings"
@@ -14,7 +13,7 @@ import (
 // ExportKey exports the specified public / secret key
 func ExportKey(name, password string, secret bool) {
 	var err error
-	pgpMan := magicbuilder.MakePGP(nil)
+	pgpMan := makePGP()
 	pgpMan.LoadKeys(ctx)
 
 	// First Search the key
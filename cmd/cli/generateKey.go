@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
+	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/internal/tools"
 	"io/ioutil"
 	"os"
@@ -11,9 +11,9 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-// GenerateFlow generates a GPG Key with specified parameters
-func GenerateFlow(password, output, identifier string, bits int) {
-	pgpMan := magicbuilder.MakePGP(nil)
+// GenerateFlow generates a GPG Key with specified parameters. The metadata is only stored when saving to the default backend
+func GenerateFlow(password, output, identifier string, bits int, metadata *models.KeyMetadata) {
+	pgpMan := makePGP()
 	if password == "" {
 		_, _ = fmt.Fprint(os.Stderr, "Please enter the password: ")
 		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
@@ -39,7 +39,7 @@ func GenerateFlow(password, output, identifier string, bits int) {
 	if output == "-" {
 		fmt.Println(key)
 	} else if output == "+" {
-		err := pgpMan.SaveKey(fingerPrint, key, nil)
+		err := pgpMan.SaveKeyWithMetadata(fingerPrint, key, nil, metadata)
 		if err != nil {
 			panic(fmt.Sprintf("Error saving key to default backend: %s", err))
 		}
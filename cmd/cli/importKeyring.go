@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"io"
+	"os"
+)
+
+// ImportKeyRing imports the public keys of a binary or armored keyring dump, like a gpg export or a SKS dump, to the PKS and the key backend
+func ImportKeyRing(filename string, options models.KeyImportOptions) {
+	var input io.Reader = os.Stdin
+
+	if filename == "-" {
+		_, _ = fmt.Fprintf(os.Stderr, "Reading from stdin:\n")
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			panic(fmt.Sprintf("Error loading file %s: %s\n", filename, err))
+		}
+		defer f.Close()
+		input = f
+	}
+
+	var pgpMan interfaces.PGPManager
+	if options.KeyRing {
+		pgpMan = makePGP()
+		pgpMan.LoadKeys(ctx)
+	}
+
+	importer := keymagic.MakeKeyImporter(nil, pgpMan, options)
+
+	result, err := importer.Import(ctx, input, func(progress models.KeyImportResult) {
+		_, _ = fmt.Fprintf(os.Stderr, "Read %d keys: %d added, %d updated, %d unchanged, %d skipped, %d failed\n",
+			progress.Read, progress.Added, progress.Updated, progress.Unchanged, progress.Skipped, progress.Failed)
+	})
+
+	for _, e := range result.Errors {
+		_, _ = fmt.Fprintf(os.Stderr, "Key %d %s: %s\n", e.Index, e.FingerPrint, e.Error)
+	}
+
+	if len(result.Errors) < result.Skipped+result.Failed {
+		_, _ = fmt.Fprintf(os.Stderr, "... and %d more errors\n", result.Skipped+result.Failed-len(result.Errors))
+	}
+
+	if err != nil {
+		panic(fmt.Sprintf("Error reading %s after %d keys: %s\n", filename, result.Read, err))
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Done. Read %d keys: %d added, %d updated, %d unchanged, %d skipped, %d failed\n",
+		result.Read, result.Added, result.Updated, result.Unchanged, result.Skipped, result.Failed)
+}
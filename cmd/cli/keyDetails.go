@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"strings"
+	"time"
+)
+
+func formatExpiration(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+
+	return t.String()
+}
+
+func formatCapabilities(canSign, canEncrypt, canCertify bool) string {
+	capabilities := make([]string, 0)
+	if canSign {
+		capabilities = append(capabilities, "sign")
+	}
+	if canEncrypt {
+		capabilities = append(capabilities, "encrypt")
+	}
+	if canCertify {
+		capabilities = append(capabilities, "certify")
+	}
+
+	return strings.Join(capabilities, ",")
+}
+
+func printSignature(prefix string, sig models.KeySignatureDetails) {
+	fmt.Printf("%s%s by %s (%s) at %s", prefix, sig.Type, sig.IssuerKeyID, sig.Hash, sig.CreationTime)
+	if sig.RevocationReason != "" {
+		fmt.Printf(": %s", sig.RevocationReason)
+	}
+	fmt.Println()
+}
+
+// KeyDetails prints the algorithms, subkeys, user IDs, certifications and revocations of a stored or cached key
+func KeyDetails(fingerPrint string, asJSON bool) {
+	pgpMan := makePGP()
+	pgpMan.LoadKeys(ctx)
+
+	ent := pgpMan.GetPublicKeyEntity(ctx, fingerPrint)
+	if ent == nil {
+		panic(fmt.Sprintf("Key %s was not found\n", fingerPrint))
+	}
+
+	d := tools.GetKeyDetails(ent)
+
+	if asJSON {
+		data, _ := json.MarshalIndent(d, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("Key %s\n", d.FingerPrint)
+	fmt.Printf("  Algorithm:    %s %d bits\n", d.Algorithm, d.Bits)
+	fmt.Printf("  Created:      %s\n", d.CreationTime)
+	fmt.Printf("  Expires:      %s\n", formatExpiration(d.ExpirationTime))
+	fmt.Printf("  Capabilities: %s\n", formatCapabilities(d.CanSign, d.CanEncrypt, d.CanCertify))
+	fmt.Printf("  Private Key:  %v\n", d.ContainsPrivateKey)
+	fmt.Printf("  Revoked:      %v\n", d.Revoked)
+	for _, rev := range d.Revocations {
+		printSignature("    ", rev)
+	}
+
+	for _, uid := range d.UserIDs {
+		fmt.Printf("  User ID %s\n", uid.UserID)
+		fmt.Printf("    Primary: %v, Revoked: %v, Self Signature Hash: %s\n", uid.Primary, uid.Revoked, uid.SelfSignatureHash)
+		for _, sig := range uid.Certifications {
+			printSignature("    ", sig)
+		}
+	}
+
+	for _, sub := range d.SubKeys {
+		fmt.Printf("  Subkey %s\n", sub.FingerPrint)
+		fmt.Printf("    Algorithm:    %s %d bits\n", sub.Algorithm, sub.Bits)
+		fmt.Printf("    Created:      %s\n", sub.CreationTime)
+		fmt.Printf("    Expires:      %s\n", formatExpiration(sub.ExpirationTime))
+		fmt.Printf("    Capabilities: %s\n", formatCapabilities(sub.CanSign, sub.CanEncrypt, sub.CanCertify))
+		fmt.Printf("    Revoked:      %v\n", sub.Revoked)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/keymagic"
+)
+
+// LintKey prints the lint results of the keys in data without importing them
+func LintKey(data string) {
+	results, err := keymagic.LintKey(data)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading key: %s\n", err))
+	}
+
+	for _, result := range results {
+		status := "OK"
+		if result.Rejected {
+			status = "REJECTED"
+		}
+
+		fmt.Printf("Key %s: %s\n", result.FingerPrint, status)
+		for _, issue := range result.Issues {
+			fmt.Printf("  [%s] %s %s: %s\n", issue.Severity, issue.Code, issue.KeyID, issue.Message)
+		}
+	}
+}
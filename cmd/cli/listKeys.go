@@ -2,20 +2,28 @@ package main
 
 import (
 	"fmt"
-	"github.com/quan-to/chevron/internal/etc/magicbuilder"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
+	"strings"
 )
 
-// ListKeys list the Public / Private keys stored in the default backend
-func ListKeys() {
-	pgpMan := magicbuilder.MakePGP(nil)
+// ListKeys list the Public / Private keys stored in the default backend that matches the filter
+func ListKeys(filter models.KeyInfoFilter) {
+	pgpMan := makePGP()
 	pgpMan.LoadKeys(ctx)
 
-	keys := pgpMan.GetLoadedKeys()
+	keys := keymagic.FilterKeys(pgpMan.GetLoadedKeys(), filter)
 	fmt.Printf("There is %d private keys stored.\n", len(keys))
 	if len(keys) > 0 {
-		fmt.Printf("%-18s %4s %12s     %-50s\n", "FingerPrint", "Bits", "Private", "Identifier")
+		fmt.Printf("%-18s %4s %12s     %-16s %-16s %-20s %-50s\n", "FingerPrint", "Bits", "Private", "Owner Team", "Purpose", "Tags", "Identifier")
 		for _, key := range keys {
-			fmt.Printf("%-18s %4d %12v     %-50s\n", key.FingerPrint, key.Bits, key.ContainsPrivateKey, key.Identifier)
+			ownerTeam, purpose, tags := "", "", ""
+			if key.Metadata != nil {
+				ownerTeam = key.Metadata.OwnerTeam
+				purpose = key.Metadata.Purpose
+				tags = strings.Join(key.Metadata.Tags, ",")
+			}
+			fmt.Printf("%-18s %4d %12v     %-16s %-16s %-20s %-50s\n", key.FingerPrint, key.Bits, key.ContainsPrivateKey, ownerTeam, purpose, tags, key.Identifier)
 		}
 	}
 }
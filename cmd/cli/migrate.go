@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/kubernetes"
+	"github.com/quan-to/chevron/internal/vaultManager"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"os"
+	"sort"
+)
+
+const masterKeyPrefix = "__master__"
+
+// migrateBackend describes one side of a migration
+type migrateBackend struct {
+	kind         string
+	folder       string
+	masterFolder string
+	base64       bool
+	skipDataType bool
+}
+
+func (mb migrateBackend) build(prefix, folder string) interfaces.StorageBackend {
+	switch mb.kind {
+	case "disk":
+		return keybackend.MakeSaveToDiskBackend(nil, folder, prefix)
+	case "vault":
+		vm := vaultManager.MakeVaultManager(nil, prefix)
+		if vm == nil {
+			panic("Error creating vault backend\n")
+		}
+		vm.SetSkipDataType(mb.skipDataType)
+		return vm
+	case "s3":
+		return keybackend.MakeS3Backend(nil, prefix)
+	case "kubernetes":
+		return kubernetes.MakeSecretsBackend(nil, prefix)
+	}
+
+	panic(fmt.Sprintf("Unknown backend %s\n", mb.kind))
+}
+
+// MigrateKeys copies all keys, and optionally the secrets manager master key entries, from one key backend to another
+func MigrateKeys(from, to migrateBackend, skipMaster bool, opts keybackend.MigrateOptions) {
+	if from == to {
+		panic("Source and destination are the same backend\n")
+	}
+
+	src := from.build(config.KeyPrefix, from.folder)
+	dst := to.build(config.KeyPrefix, to.folder)
+
+	keyOpts := opts
+	keyOpts.SourceBase64 = from.base64
+	keyOpts.DestinationBase64 = to.base64
+
+	var kw interfaces.KeyWrapper
+	if config.EncryptedStorage {
+		kw = makeKeyWrapper()
+	}
+
+	failed := printMigrateResult("keys", src, dst, keyOpts, kw)
+
+	if !skipMaster {
+		src = from.build(masterKeyPrefix, from.masterFolder)
+		dst = to.build(masterKeyPrefix, to.masterFolder)
+
+		masterOpts := opts
+		masterOpts.SourceBase64 = config.MasterGPGKeyBase64Encoded
+		masterOpts.DestinationBase64 = config.MasterGPGKeyBase64Encoded
+
+		failed += printMigrateResult("master keys", src, dst, masterOpts, nil)
+	}
+
+	if failed > 0 {
+		panic(fmt.Sprintf("%d entries failed to migrate\n", failed))
+	}
+}
+
+// printMigrateResult migrates the entries from src to dst and prints the result. If kw is not nil, both raw backends are
+// envelope encrypted by it
+func printMigrateResult(what string, src, dst interfaces.StorageBackend, opts keybackend.MigrateOptions, kw interfaces.KeyWrapper) int {
+	_, _ = fmt.Fprintf(os.Stderr, "Migrating %s from %s to %s\n", what, src.Path(), dst.Path())
+
+	var result *keybackend.MigrateResult
+	var err error
+
+	if kw != nil {
+		result, err = keybackend.MigrateEncrypted(nil, src, dst, kw, opts)
+	} else {
+		result, err = keybackend.Migrate(nil, src, dst, opts)
+	}
+
+	if err != nil {
+		panic(fmt.Sprintf("Error migrating %s: %s\n", what, err))
+	}
+
+	action, summary := "Migrated", "migrated"
+	if opts.DryRun {
+		action, summary = "Would migrate", "to migrate"
+	}
+
+	for _, v := range result.Migrated {
+		_, _ = fmt.Fprintf(os.Stderr, "\t%s %s\n", action, v)
+	}
+
+	for _, v := range result.Skipped {
+		_, _ = fmt.Fprintf(os.Stderr, "\tSkipped %s (already in destination)\n", v)
+	}
+
+	failedKeys := make([]string, 0, len(result.Failed))
+	for k := range result.Failed {
+		failedKeys = append(failedKeys, k)
+	}
+	sort.Strings(failedKeys)
+
+	for _, k := range failedKeys {
+		_, _ = fmt.Fprintf(os.Stderr, "\tFailed %s: %s\n", k, result.Failed[k])
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "%s: %d %s, %d skipped, %d failed\n", what, len(result.Migrated), summary, len(result.Skipped), len(result.Failed))
+
+	return len(result.Failed)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/etc/magicbuilder"
+	"github.com/quan-to/chevron/pkg/interfaces"
+)
+
+// makeKeyWrapper creates a KeyWrapper using the configured master key
+func makeKeyWrapper() interfaces.KeyWrapper {
+	kw, ok := magicbuilder.MakeSM(nil).(interfaces.KeyWrapper)
+	if !ok {
+		panic("The secrets manager cannot wrap keys")
+	}
+
+	return kw
+}
+
+// makePGP creates a PGPManager for the default backend, envelope encrypted if ENCRYPTED_STORAGE is enabled
+func makePGP() interfaces.PGPManager {
+	if config.EncryptedStorage {
+		return magicbuilder.MakeEncryptedPGP(nil, makeKeyWrapper())
+	}
+
+	return magicbuilder.MakePGP(nil)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/etc/kbBuilder"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// RewrapKeys re-wraps the data keys of the encrypted key backend from the old master key to the current master key
+func RewrapKeys(oldMasterKeyPath, oldMasterKeyPasswordPath string) {
+	keyData, err := ioutil.ReadFile(oldMasterKeyPath)
+	if err != nil {
+		panic(fmt.Sprintf("Error loading old master key from %s: %s\n", oldMasterKeyPath, err))
+	}
+
+	passData, err := ioutil.ReadFile(oldMasterKeyPasswordPath)
+	if err != nil {
+		panic(fmt.Sprintf("Error loading old master key password from %s: %s\n", oldMasterKeyPasswordPath, err))
+	}
+
+	previous, err := keymagic.MakeKeyWrapper(nil, string(keyData), strings.Trim(string(passData), "\n\r"))
+	if err != nil {
+		panic(fmt.Sprintf("Error loading old master key: %s\n", err))
+	}
+
+	eb := keybackend.MakeEncryptedBackend(nil, kbBuilder.BuildKeyBackend(nil), makeKeyWrapper())
+
+	n, err := eb.Rewrap(ctx, previous)
+	if err != nil {
+		panic(fmt.Sprintf("Error re-wrapping keys (%d re-wrapped before failure): %s\n", n, err))
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Re-wrapped %d entries\n", n)
+}
@@ -3,6 +3,12 @@ package main
 import (
 	"context"
 	"os"
+	"path"
+	"strconv"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/models"
 
 	"github.com/quan-to/slog"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -19,6 +25,10 @@ func main() {
 	genIdentifier := gen.Flag("id", "Key Identifier").Default("").String()
 	genOutput := gen.Flag("output", "Filename of the output ( use - for stdout, use + for default key backend )").Default("+").String()
 	genPassword := gen.Flag("password", "Key Password (if not provided, it will be prompted)").Default("").String()
+	genOwnerTeam := gen.Flag("owner-team", "Team that owns the key (stored in the key metadata)").String()
+	genPurpose := gen.Flag("purpose", "Purpose of the key (stored in the key metadata)").String()
+	genCreatedBy := gen.Flag("created-by", "Who is creating the key (stored in the key metadata)").Default(os.Getenv("USER")).String()
+	genTags := gen.Flag("tag", "Tag of the key (stored in the key metadata). Can be repeated").Strings()
 	// endregion
 	// region Benchmark Generate
 
@@ -30,7 +40,16 @@ func main() {
 	// endregion
 
 	// region List Keys
-	_ = kingpin.Command("list-keys", "List Stored Keys")
+	listKeys := kingpin.Command("list-keys", "List Stored Keys")
+	listKeysOwnerTeam := listKeys.Flag("owner-team", "Only list keys owned by this team").String()
+	listKeysPurpose := listKeys.Flag("purpose", "Only list keys with this purpose").String()
+	listKeysTags := listKeys.Flag("tag", "Only list keys with this tag. Can be repeated").Strings()
+	// endregion
+
+	// region Key Details
+	keyDetails := kingpin.Command("key-details", "Show the algorithms, subkeys, user IDs, certifications and revocations of a key")
+	keyDetailsFp := keyDetails.Arg("fingerPrint", "Finger Print of the key").Required().String()
+	keyDetailsJSON := keyDetails.Flag("json", "Output in JSON format").Bool()
 	// endregion
 
 	// region Export
@@ -52,6 +71,15 @@ func main() {
 	importInput := cmdImport.Flag("input", "Filename of the input (use - to stdin)").Default("-").String()
 	keyPassword := cmdImport.Flag("keyPassword", "Key Password (required only for private keys)").Default("").String()
 	keyPasswordFd := cmdImport.Flag("keyPasswordFd", "File Descriptor for Key Password input").Default("-1").Int()
+	importDryRun := cmdImport.Flag("dry-run", "Only lint the keys without importing them").Bool()
+	// endregion
+
+	// region Import Keyring
+	importKeyRing := kingpin.Command("import-keyring", "Import the public keys of a binary or armored keyring dump to the PKS and the key backend")
+	importKeyRingInput := importKeyRing.Flag("input", "Filename of the keyring dump (use - to stdin)").Default("-").String()
+	importKeyRingPKS := importKeyRing.Flag("pks", "Import to the internal PKS (requires ENABLE_RETHINKDB_SKS)").Bool()
+	importKeyRingKeyRing := importKeyRing.Flag("keyring", "Import to the key backend").Bool()
+	importKeyRingBatchSize := importKeyRing.Flag("batch-size", "Number of keys written to the database at once").Default("100").Int()
 	// endregion
 
 	// region Decrypt
@@ -60,6 +88,48 @@ func main() {
 	decryptOutput := decrypt.Flag("output", "Filename of the output (use - to stdout)").Default("-").String()
 	// endregion
 
+	// region Rewrap
+	rewrap := kingpin.Command("rewrap", "Re-wrap the encrypted key backend data keys with the current master key")
+	rewrapOldKey := rewrap.Flag("old-master-key", "Path of the previous master key").Required().String()
+	rewrapOldKeyPassword := rewrap.Flag("old-master-key-password", "Path of the previous master key password").Required().String()
+	// endregion
+
+	// region Migrate
+	backends := []string{"disk", "vault", "s3", "kubernetes"}
+	masterFolder := path.Dir(config.MasterGPGKeyPath)
+	migrate := kingpin.Command("migrate", "Migrate keys and master key entries from one key backend to another")
+	migrateFrom := migrate.Flag("from", "Source backend").Required().Enum(backends...)
+	migrateTo := migrate.Flag("to", "Destination backend").Required().Enum(backends...)
+	migrateFromFolder := migrate.Flag("from-folder", "Source keys folder (disk backend)").Default(config.PrivateKeyFolder).String()
+	migrateToFolder := migrate.Flag("to-folder", "Destination keys folder (disk backend)").Default(config.PrivateKeyFolder).String()
+	migrateFromMasterFolder := migrate.Flag("from-master-folder", "Source master key entries folder (disk backend)").Default(masterFolder).String()
+	migrateToMasterFolder := migrate.Flag("to-master-folder", "Destination master key entries folder (disk backend)").Default(masterFolder).String()
+	migrateFromBase64 := migrate.Flag("from-base64", "Keys are base64 encoded in the source").Default(strconv.FormatBool(config.KeysBase64Encoded)).Bool()
+	migrateToBase64 := migrate.Flag("to-base64", "Keys should be base64 encoded in the destination. Should match --from-base64 with ENCRYPTED_STORAGE").Default(strconv.FormatBool(config.KeysBase64Encoded)).Bool()
+	migrateFromSkipDataType := migrate.Flag("from-vault-skip-data-type", "Source vault paths does not have the data type").Default(strconv.FormatBool(config.VaultSkipDataType)).Bool()
+	migrateToSkipDataType := migrate.Flag("to-vault-skip-data-type", "Destination vault paths does not have the data type").Default(strconv.FormatBool(config.VaultSkipDataType)).Bool()
+	migrateSkipMaster := migrate.Flag("skip-master", "Do not migrate the master key entries").Bool()
+	migrateDryRun := migrate.Flag("dry-run", "Only show what would be migrated").Bool()
+	migrateVerify := migrate.Flag("verify", "Read back and compare each migrated key").Bool()
+	migrateOverwrite := migrate.Flag("overwrite", "Overwrite keys that already exists in the destination with different content").Bool()
+	// endregion
+
+	// region Key Versions
+	versions := kingpin.Command("versions", "Manage the versions of the keys stored in Vault KV v2")
+	versionsList := versions.Command("list", "List the versions of a key")
+	versionsListFp := versionsList.Arg("fingerPrint", "Finger Print of the key").Required().String()
+	versionsRead := versions.Command("read", "Export a specific version of a key")
+	versionsReadFp := versionsRead.Arg("fingerPrint", "Finger Print of the key").Required().String()
+	versionsReadVersion := versionsRead.Arg("version", "Version number").Required().Int()
+	versionsReadOutput := versionsRead.Flag("output", "Filename of the output (use - to stdout)").Default("-").String()
+	versionsUndelete := versions.Command("undelete", "Restore a deleted version of a key")
+	versionsUndeleteFp := versionsUndelete.Arg("fingerPrint", "Finger Print of the key").Required().String()
+	versionsUndeleteVersion := versionsUndelete.Arg("version", "Version number").Required().Int()
+	versionsRollback := versions.Command("rollback", "Make a previous version the current version of a key")
+	versionsRollbackFp := versionsRollback.Arg("fingerPrint", "Finger Print of the key").Required().String()
+	versionsRollbackVersion := versionsRollback.Arg("version", "Version number").Required().Int()
+	// endregion
+
 	selectedCmd := kingpin.Parse()
 
 	slog.SetDefaultOutput(os.Stderr)
@@ -76,18 +146,65 @@ func main() {
 
 	switch selectedCmd {
 	case "gen":
-		GenerateFlow(*genPassword, *genOutput, *genIdentifier, int(*genBits))
+		GenerateFlow(*genPassword, *genOutput, *genIdentifier, int(*genBits), &models.KeyMetadata{
+			OwnerTeam: *genOwnerTeam,
+			Purpose:   *genPurpose,
+			CreatedBy: *genCreatedBy,
+			Tags:      *genTags,
+		})
 	case "benchgen":
 		BenchmarkGeneration(*benchGenRuns, int(*benchGenBits))
 	case "list-keys":
-		ListKeys()
+		ListKeys(models.KeyInfoFilter{
+			OwnerTeam: *listKeysOwnerTeam,
+			Purpose:   *listKeysPurpose,
+			Tags:      *listKeysTags,
+		})
+	case "key-details":
+		KeyDetails(*keyDetailsFp, *keyDetailsJSON)
 	case "export":
 		ExportKey(*exportName, *exportPass, *exportSecret)
 	case "encrypt":
 		EncryptFile(*encryptInput, *encryptOutput, *encryptRecipient)
 	case "import":
-		ImportKey(*importInput, *keyPassword, *keyPasswordFd)
+		ImportKey(*importInput, *keyPassword, *keyPasswordFd, *importDryRun)
+	case "import-keyring":
+		ImportKeyRing(*importKeyRingInput, models.KeyImportOptions{
+			PKS:       *importKeyRingPKS,
+			KeyRing:   *importKeyRingKeyRing,
+			BatchSize: *importKeyRingBatchSize,
+			// The CLI has direct access to the database
+			VerifiedUserIDs: true,
+		})
 	case "decrypt":
 		Decrypt(*decryptInput, *decryptOutput)
+	case "rewrap":
+		RewrapKeys(*rewrapOldKey, *rewrapOldKeyPassword)
+	case "versions list":
+		ListKeyVersions(*versionsListFp)
+	case "versions read":
+		ReadKeyVersion(*versionsReadFp, *versionsReadVersion, *versionsReadOutput)
+	case "versions undelete":
+		UndeleteKeyVersion(*versionsUndeleteFp, *versionsUndeleteVersion)
+	case "versions rollback":
+		RollbackKeyVersion(*versionsRollbackFp, *versionsRollbackVersion)
+	case "migrate":
+		MigrateKeys(migrateBackend{
+			kind:         *migrateFrom,
+			folder:       *migrateFromFolder,
+			masterFolder: *migrateFromMasterFolder,
+			base64:       *migrateFromBase64,
+			skipDataType: *migrateFromSkipDataType,
+		}, migrateBackend{
+			kind:         *migrateTo,
+			folder:       *migrateToFolder,
+			masterFolder: *migrateToMasterFolder,
+			base64:       *migrateToBase64,
+			skipDataType: *migrateToSkipDataType,
+		}, *migrateSkipMaster, keybackend.MigrateOptions{
+			DryRun:    *migrateDryRun,
+			Verify:    *migrateVerify,
+			Overwrite: *migrateOverwrite,
+		})
 	}
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/vaultManager"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func makeVersionedBackend() *vaultManager.VaultManager {
+	if !config.VaultStorage || config.EncryptedStorage {
+		panic("Key versions are only available with vault storage without encrypted storage\n")
+	}
+
+	vm := vaultManager.MakeVaultManager(nil, config.KeyPrefix)
+	if vm == nil {
+		panic("Error creating vault backend\n")
+	}
+
+	if !vm.IsVersioned() {
+		panic(fmt.Sprintf("The vault backend %s is not a KV v2 secrets engine\n", config.VaultBackend))
+	}
+
+	return vm
+}
+
+// ListKeyVersions list the stored versions of the specified key
+func ListKeyVersions(fingerPrint string) {
+	versions, err := makeVersionedBackend().ListVersions(strings.ToUpper(fingerPrint))
+	if err != nil {
+		panic(fmt.Sprintf("Error listing versions of %s: %s\n", fingerPrint, err))
+	}
+
+	fmt.Printf("%-8s %-8s %-30s %-30s %s\n", "Version", "Current", "Created", "Deleted", "Destroyed")
+	for _, v := range versions {
+		deleted := ""
+		if v.DeletionTime != nil {
+			deleted = v.DeletionTime.String()
+		}
+		fmt.Printf("%-8d %-8v %-30s %-30s %v\n", v.Version, v.Current, v.CreatedTime.String(), deleted, v.Destroyed)
+	}
+}
+
+// ReadKeyVersion outputs the specified version of the stored key
+func ReadKeyVersion(fingerPrint string, version int, output string) {
+	data, _, err := makeVersionedBackend().ReadVersion(strings.ToUpper(fingerPrint), version)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading version %d of %s: %s\n", version, fingerPrint, err))
+	}
+
+	if config.KeysBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			panic(fmt.Sprintf("Error decoding key: %s\n", err))
+		}
+		data = string(b)
+	}
+
+	if output == "-" {
+		fmt.Println(data)
+		return
+	}
+
+	err = ioutil.WriteFile(output, []byte(data), 0600)
+	if err != nil {
+		panic(fmt.Sprintf("Error saving file %s: %s\n", output, err))
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Key saved to %s\n", output)
+}
+
+// UndeleteKeyVersion restores a soft deleted version of the specified key
+func UndeleteKeyVersion(fingerPrint string, version int) {
+	err := makeVersionedBackend().Undelete(strings.ToUpper(fingerPrint), version)
+	if err != nil {
+		panic(fmt.Sprintf("Error undeleting version %d of %s: %s\n", version, fingerPrint, err))
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Version %d of %s restored\n", version, fingerPrint)
+}
+
+// RollbackKeyVersion makes the specified version the current version of the key
+func RollbackKeyVersion(fingerPrint string, version int) {
+	err := makeVersionedBackend().Rollback(strings.ToUpper(fingerPrint), version)
+	if err != nil {
+		panic(fmt.Sprintf("Error rolling back %s to version %d: %s\n", fingerPrint, version, err))
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "Key %s rolled back to version %d\n", fingerPrint, version)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/quan-to/chevron/internal/kubernetes"
 	"github.com/quan-to/chevron/internal/server"
 	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
 	"github.com/quan-to/slog"
 	"os"
 	"os/signal"
@@ -29,10 +30,28 @@ func main() {
 
 	ctx := context.Background()
 	sm := magicbuilder.MakeSM(log)
-	gpg := magicbuilder.MakePGP(log)
+	var gpg interfaces.PGPManager
+	var kw interfaces.KeyWrapper
+
+	if config.EncryptedStorage {
+		var ok bool
+		kw, ok = sm.(interfaces.KeyWrapper)
+		if !ok {
+			log.Fatal("Encrypted storage is enabled but the secrets manager cannot wrap keys")
+		}
+		gpg = magicbuilder.MakeEncryptedPGP(log, kw)
+	} else {
+		gpg = magicbuilder.MakePGP(log)
+	}
 
 	gpg.LoadKeys(ctx)
 
+	watcherStop := make(chan bool, 1)
+
+	if config.KeyWatcher {
+		go magicbuilder.MakeKeyWatcher(log, sm, gpg, kw).Run(watcherStop)
+	}
+
 	if config.SingleKeyMode {
 		stop, err = server.RunRemoteSignerServerSingleKey(log, sm, gpg)
 		if err != nil {
@@ -46,7 +65,22 @@ func main() {
 	kubeStop := make(chan bool)
 
 	if kubernetes.InKubernetes() {
-		go kubernetes.KubeRoutine(kubeStop)
+		go kubernetes.KubeRoutine(kubeStop, magicbuilder.MakeClusterAuth(log, sm, gpg))
+	}
+
+	expiryStop := make(chan bool, 1)
+
+	if config.KeyExpiryMonitor {
+		monitor, interval := magicbuilder.MakeKeyExpiryMonitor(log, gpg)
+		go monitor.Run(expiryStop, interval)
+	}
+
+	syncStop := make(chan bool, 1)
+	peerSync := config.EnableRethinkSKS && config.PKSSyncPeers != ""
+
+	if peerSync {
+		ps, interval := magicbuilder.MakePKSPeerSync()
+		go ps.Run(syncStop, interval)
 	}
 
 	c := make(chan os.Signal)
@@ -57,6 +91,15 @@ func main() {
 		if kubernetes.InKubernetes() {
 			kubeStop <- true // Send Stop signal to Kubernetes Routine
 		}
+		if config.KeyWatcher {
+			watcherStop <- true // Send Stop signal to Key Watcher
+		}
+		if config.KeyExpiryMonitor {
+			expiryStop <- true // Send Stop signal to Key Expiry Monitor
+		}
+		if peerSync {
+			syncStop <- true // Send Stop signal to Peer Sync
+		}
 		stop <- true      // Send stop signal to HTTP
 		<-stop            // Wait HTTP to Cleanup
 		localStop <- true // Send Local stop
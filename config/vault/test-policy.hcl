@@ -88,4 +88,15 @@ path "secret/*" {
   capabilities = ["create", "read", "update", "delete", "list", "sudo"]
 }
 
+# Allow using the transit engine for master key operations
+path "transit/keys/*" {
+  capabilities = ["create", "read", "update"]
+}
+
+path "transit/encrypt/*" {
+  capabilities = ["update"]
+}
 
+path "transit/decrypt/*" {
+  capabilities = ["update"]
+}
@@ -12,6 +12,7 @@ require (
 	github.com/asticode/go-astilectron-bootstrap v0.3.4
 	github.com/asticode/go-astilectron-bundler v0.5.2
 	github.com/bouk/monkey v1.0.1
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/golang/protobuf v1.3.5 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/google/uuid v1.1.1
@@ -34,7 +35,7 @@ require (
 	github.com/quan-to/slog v0.1.1
 	github.com/ryanuber/go-glob v1.0.0 // indirect
 	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
-	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4 // indirect
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 	gopkg.in/fatih/pool.v2 v2.0.0 // indirect
 	gopkg.in/rethinkdb/rethinkdb-go.v6 v6.1.1
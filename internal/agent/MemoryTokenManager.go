@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	remote_signer "github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/pkg/interfaces"
 	"github.com/quan-to/slog"
 	"sync"
@@ -17,6 +18,7 @@ type memoryUser struct {
 	createdAt   time.Time
 	fingerPrint string
 	expiration  time.Time
+	scopes      []string
 }
 
 func (mu *memoryUser) GetUsername() string {
@@ -47,6 +49,10 @@ func (mu *memoryUser) GetExpiration() time.Time {
 	return mu.expiration
 }
 
+func (mu *memoryUser) GetScopes() []string {
+	return mu.scopes
+}
+
 type MemoryTokenManager struct {
 	storedTokens map[string]*memoryUser
 	lock         sync.Mutex
@@ -81,6 +87,7 @@ func (mtm *MemoryTokenManager) AddUserWithExpiration(user interfaces.UserData, e
 		createdAt:   user.GetCreatedAt(),
 		fingerPrint: user.GetFingerPrint(),
 		fullname:    user.GetFullName(),
+		scopes:      userScopes(user),
 		expiration:  user.GetCreatedAt().Add(time.Duration(expiration) * time.Second),
 	}
 
@@ -100,6 +107,7 @@ func (mtm *MemoryTokenManager) AddUser(user interfaces.UserData) string {
 		createdAt:   user.GetCreatedAt(),
 		fingerPrint: user.GetFingerPrint(),
 		fullname:    user.GetFullName(),
+		scopes:      userScopes(user),
 		expiration:  user.GetCreatedAt().Add(time.Duration(remote_signer.AgentTokenExpiration) * time.Second),
 	}
 
@@ -145,3 +153,45 @@ func (mtm *MemoryTokenManager) GetUserData(token string) interfaces.UserData {
 
 	return mtm.storedTokens[token]
 }
+
+// ListAPIKeys returns the unexpired tokens with API scopes
+func (mtm *MemoryTokenManager) ListAPIKeys() []models.APIKey {
+	mtm.lock.Lock()
+	defer mtm.lock.Unlock()
+
+	keys := make([]models.APIKey, 0)
+	now := time.Now()
+
+	for token, user := range mtm.storedTokens {
+		if len(user.scopes) == 0 || now.After(user.expiration) {
+			continue
+		}
+
+		keys = append(keys, models.APIKey{
+			ID:          models.APIKeyID(token),
+			UserName:    user.username,
+			FullName:    user.fullname,
+			FingerPrint: user.fingerPrint,
+			Scopes:      user.scopes,
+			CreatedAt:   user.createdAt,
+			Expiration:  user.expiration,
+		})
+	}
+
+	return keys
+}
+
+// RevokeAPIKey removes the token with API scopes of the API key ID from the internal memory
+func (mtm *MemoryTokenManager) RevokeAPIKey(id string) error {
+	mtm.lock.Lock()
+	defer mtm.lock.Unlock()
+
+	for token, user := range mtm.storedTokens {
+		if len(user.scopes) > 0 && models.APIKeyID(token) == id {
+			delete(mtm.storedTokens, token)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("not exists")
+}
@@ -40,6 +40,7 @@ func (rtm *rethinkTokenManager) AddUserWithExpiration(user interfaces.UserData,
 		Username:    user.GetUsername(),
 		CreatedAt:   user.GetCreatedAt(),
 		Fullname:    user.GetFullName(),
+		Scopes:      userScopes(user),
 		Expiration:  user.GetCreatedAt().Add(time.Duration(expiration) * time.Second),
 		Token:       token,
 	})
@@ -59,6 +60,7 @@ func (rtm *rethinkTokenManager) AddUser(user interfaces.UserData) string {
 		Username:    user.GetUsername(),
 		CreatedAt:   user.GetCreatedAt(),
 		Fullname:    user.GetFullName(),
+		Scopes:      userScopes(user),
 		Expiration:  user.GetCreatedAt().Add(time.Duration(config.AgentTokenExpiration) * time.Second),
 		Token:       token,
 	})
@@ -118,3 +120,45 @@ func (rtm *rethinkTokenManager) InvalidateToken(token string) error {
 
 	return models.RemoveUserToken(conn, token)
 }
+
+// ListAPIKeys returns the unexpired tokens with API scopes stored in the database
+func (rtm *rethinkTokenManager) ListAPIKeys() []models.APIKey {
+	conn := etc.GetConnection()
+	tokens, err := models.GetScopedUserTokens(conn)
+	if err != nil {
+		rtm.log.Error("Error listing the API keys: %s", err)
+		return []models.APIKey{}
+	}
+
+	keys := make([]models.APIKey, 0, len(tokens))
+	for _, ut := range tokens {
+		keys = append(keys, models.APIKey{
+			ID:          models.APIKeyID(ut.Token),
+			UserName:    ut.Username,
+			FullName:    ut.Fullname,
+			FingerPrint: ut.FingerPrint,
+			Scopes:      ut.Scopes,
+			CreatedAt:   ut.CreatedAt,
+			Expiration:  ut.Expiration,
+		})
+	}
+
+	return keys
+}
+
+// RevokeAPIKey removes the token with API scopes of the API key ID from the database
+func (rtm *rethinkTokenManager) RevokeAPIKey(id string) error {
+	conn := etc.GetConnection()
+	tokens, err := models.GetScopedUserTokens(conn)
+	if err != nil {
+		return err
+	}
+
+	for _, ut := range tokens {
+		if models.APIKeyID(ut.Token) == id {
+			return models.RemoveUserToken(conn, ut.Token)
+		}
+	}
+
+	return fmt.Errorf("not exists")
+}
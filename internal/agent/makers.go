@@ -23,3 +23,12 @@ func MakeAuthManager(logger slog.Instance) interfaces.AuthManager {
 
 	return MakeJSONAuthManager(logger)
 }
+
+// userScopes returns the API scopes of the user, if it has any
+func userScopes(user interfaces.UserData) []string {
+	if su, ok := user.(interfaces.ScopedUserData); ok {
+		return su.GetScopes()
+	}
+
+	return nil
+}
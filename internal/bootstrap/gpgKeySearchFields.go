@@ -0,0 +1,36 @@
+package bootstrap
+
+import (
+	"github.com/quan-to/chevron/internal/models"
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+func AddSearchFieldsToGPGKey(conn *r.Session) {
+	l := log.SubScope("SearchFields")
+	l.Await("Running")
+	keys, err := models.FetchKeysWithoutSearchFields(conn)
+	if err != nil {
+		l.Fatal(err)
+	}
+
+	l.Note("Got %d keys to fill search fields", len(keys))
+
+	for _, k := range keys {
+		parsed, err := models.AsciiArmored2GPGKey(k.AsciiArmoredPublicKey)
+		if err != nil {
+			l.Error("Error parsing key %s: %s", k.FullFingerPrint, err)
+			continue
+		}
+
+		k.Algorithm = parsed.Algorithm
+		k.CreationTime = parsed.CreationTime
+		k.ExpirationTime = parsed.ExpirationTime
+		k.Revoked = parsed.Revoked
+		err = k.Save(conn)
+		if err != nil {
+			l.Error("Error saving key: %s", err)
+		}
+	}
+
+	l.Done("Done")
+}
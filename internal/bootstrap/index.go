@@ -14,6 +14,7 @@ func RunBootstraps() {
 		log.Note("Running database bootstrap because RethinkDB is enabled.")
 		conn := database.GetConnection()
 		AddSubkeysToGPGKey(conn)
+		AddSearchFieldsToGPGKey(conn)
 	} else {
 		log.WarnNote("RethinkDB is disabled. Skipping database bootstrap.")
 	}
@@ -15,7 +15,26 @@ var SyslogFacility string
 var PrivateKeyFolder string
 var KeyPrefix string
 var SKSServer string
+var SKSServers string
+var SKSTimeout string
+var SKSRetries int
+var SKSRetryBackoff string
+var SKSBreakerThreshold int
+var SKSBreakerCooldown string
+var SKSWKDLookup bool
+var PKSUpstreamLookup bool
 var HttpPort int
+var TLSCertFile string
+var TLSKeyFile string
+var TLSClientCAFile string
+var TLSClientAuth string
+var TLSIdentitiesFile string
+var TLSReloadInterval string
+var ClusterKeyFingerPrint string
+var ClusterRequestMaxAge string
+var ClusterTLSCertFile string
+var ClusterTLSKeyFile string
+var ClusterTLSCAFile string
 var MaxKeyRingCache int
 var EnableRethinkSKS bool
 var RethinkDBHost string
@@ -30,6 +49,7 @@ var MasterGPGKeyBase64Encoded bool
 var KeysBase64Encoded bool
 var IgnoreKubernetesCA bool
 var VaultStorage bool
+var EncryptedStorage bool
 var VaultAddress string
 var VaultRootToken string
 var ReadonlyKeyPath bool
@@ -41,6 +61,52 @@ var VaultNamespace string
 var VaultBackend string
 var VaultSkipDataType bool
 var VaultTokenTTL string
+var VaultTransit bool
+var VaultTransitPath string
+var VaultTransitKey string
+var KubernetesStorage bool
+var S3Storage bool
+var S3Endpoint string
+var S3Region string
+var S3Bucket string
+var S3AccessKeyID string
+var S3SecretAccessKey string
+var S3UsePathStyle bool
+var S3ServerSideEncryption string
+var S3KMSKeyID string
+var S3CacheTTL string
+var KeyWatcher bool
+var KeyWatcherInterval string
+var KeyLintRejectLevel string
+var KeyLintExpiringIn string
+var KeyExpiryMonitor bool
+var KeyExpiryMonitorInterval string
+var KeyExpiryThresholds string
+var KeyExpiryWatchList string
+var KeyExpiryWebhookURL string
+var KeyRingCacheTTL string
+var KeyRingNegativeCacheTTL string
+var WKDDomains string
+var PKSVerifyOwnership bool
+var PKSVerifyTTL string
+var SMTPServer string
+var SMTPUsername string
+var SMTPPassword string
+var SMTPFrom string
+var PKSMaxKeySize int
+var PKSMaxUserIDs int
+var PKSMaxCertifications int
+var PKSStripCertifications bool
+var PKSTrustedCertifiers string
+var PKSStripUserAttributes bool
+var PKSUploadRateLimit int
+var PKSUploadClientIPHeader string
+var PKSAdminKeys string
+var PKSRequestMaxAge string
+var PKSSyncPeers string
+var PKSSyncVerifiedPeers string
+var PKSSyncInterval string
+var PKSSyncTimeout string
 var AgentTargetURL string
 var AgentTokenExpiration int
 var AgentKeyFingerPrint string
@@ -50,6 +116,7 @@ var RequestIDHeader string
 
 var RethinkTokenManager bool
 var RethinkAuthManager bool
+var APIAuth bool
 var Environment string
 
 var AgentExternalURL string
@@ -69,6 +136,12 @@ func Setup() {
 	RethinkDBPort = -1
 	RethinkDBPoolSize = -1
 	AgentTokenExpiration = -1
+	PKSMaxKeySize = -1
+	PKSMaxUserIDs = -1
+	PKSMaxCertifications = -1
+	PKSUploadRateLimit = -1
+	SKSRetries = -1
+	SKSBreakerThreshold = -1
 	ShowLines = false
 
 	// Load envvars
@@ -76,6 +149,12 @@ func Setup() {
 	SyslogFacility = os.Getenv("SYSLOG_FACILITY")
 	PrivateKeyFolder = os.Getenv("PRIVATE_KEY_FOLDER")
 	SKSServer = os.Getenv("SKS_SERVER")
+	SKSServers = os.Getenv("SKS_SERVERS")
+	SKSTimeout = os.Getenv("SKS_TIMEOUT")
+	SKSRetryBackoff = os.Getenv("SKS_RETRY_BACKOFF")
+	SKSBreakerCooldown = os.Getenv("SKS_BREAKER_COOLDOWN")
+	SKSWKDLookup = strings.ToLower(os.Getenv("SKS_WKD_LOOKUP")) == "true"
+	PKSUpstreamLookup = strings.ToLower(os.Getenv("PKS_UPSTREAM_LOOKUP")) == "true"
 	KeyPrefix = os.Getenv("KEY_PREFIX")
 	ShowLines = os.Getenv("SHOW_LINES") == "true"
 	LogFormat = slog.ToFormat(os.Getenv("LOG_FORMAT"))
@@ -136,6 +215,7 @@ func Setup() {
 	IgnoreKubernetesCA = strings.ToLower(os.Getenv("IGNORE_KUBERNETES_CA")) == "true"
 
 	VaultStorage = strings.ToLower(os.Getenv("VAULT_STORAGE")) == "true"
+	EncryptedStorage = strings.ToLower(os.Getenv("ENCRYPTED_STORAGE")) == "true"
 	VaultAddress = os.Getenv("VAULT_ADDRESS")
 	VaultRootToken = os.Getenv("VAULT_ROOT_TOKEN")
 	ReadonlyKeyPath = os.Getenv("READONLY_KEYPATH") == "true"
@@ -147,16 +227,134 @@ func Setup() {
 	VaultBackend = os.Getenv("VAULT_BACKEND")
 	VaultSkipDataType = os.Getenv("VAULT_SKIP_DATA_TYPE") == "true"
 	VaultTokenTTL = os.Getenv("VAULT_TOKEN_TTL")
+	VaultTransit = strings.ToLower(os.Getenv("VAULT_TRANSIT")) == "true"
+	VaultTransitPath = os.Getenv("VAULT_TRANSIT_PATH")
+	VaultTransitKey = os.Getenv("VAULT_TRANSIT_KEY")
+	KubernetesStorage = strings.ToLower(os.Getenv("KUBERNETES_STORAGE")) == "true"
+	S3Storage = strings.ToLower(os.Getenv("S3_STORAGE")) == "true"
+	S3Endpoint = os.Getenv("S3_ENDPOINT")
+	S3Region = os.Getenv("S3_REGION")
+	S3Bucket = os.Getenv("S3_BUCKET")
+	S3AccessKeyID = os.Getenv("S3_ACCESS_KEY_ID")
+	S3SecretAccessKey = os.Getenv("S3_SECRET_ACCESS_KEY")
+	S3UsePathStyle = strings.ToLower(os.Getenv("S3_USE_PATH_STYLE")) == "true"
+	S3ServerSideEncryption = os.Getenv("S3_SERVER_SIDE_ENCRYPTION")
+	S3KMSKeyID = os.Getenv("S3_KMS_KEY_ID")
+	S3CacheTTL = os.Getenv("S3_CACHE_TTL")
+	KeyWatcher = strings.ToLower(os.Getenv("KEY_WATCHER")) == "true"
+	KeyWatcherInterval = os.Getenv("KEY_WATCHER_INTERVAL")
+	KeyLintRejectLevel = strings.ToLower(os.Getenv("KEY_LINT_REJECT_LEVEL"))
+	KeyLintExpiringIn = os.Getenv("KEY_LINT_EXPIRING_IN")
+	KeyExpiryMonitor = strings.ToLower(os.Getenv("KEY_EXPIRY_MONITOR")) == "true"
+	KeyExpiryMonitorInterval = os.Getenv("KEY_EXPIRY_MONITOR_INTERVAL")
+	KeyExpiryThresholds = os.Getenv("KEY_EXPIRY_THRESHOLDS")
+	KeyExpiryWatchList = os.Getenv("KEY_EXPIRY_WATCH_LIST")
+	KeyExpiryWebhookURL = os.Getenv("KEY_EXPIRY_WEBHOOK_URL")
+	KeyRingCacheTTL = os.Getenv("KEY_RING_CACHE_TTL")
+	KeyRingNegativeCacheTTL = os.Getenv("KEY_RING_NEGATIVE_CACHE_TTL")
+	WKDDomains = strings.ToLower(os.Getenv("WKD_DOMAINS"))
 	AgentTargetURL = os.Getenv("AGENT_TARGET_URL")
 	AgentKeyFingerPrint = os.Getenv("AGENT_KEY_FINGERPRINT")
 	AgentBypassLogin = os.Getenv("AGENT_BYPASS_LOGIN") == "true"
 	RethinkTokenManager = os.Getenv("RETHINK_TOKEN_MANAGER") == "true"
+	APIAuth = os.Getenv("API_AUTH") == "true"
 	RethinkAuthManager = os.Getenv("RETHINK_AUTH_MANAGER") == "true"
+	PKSVerifyOwnership = strings.ToLower(os.Getenv("PKS_VERIFY_OWNERSHIP")) == "true"
+	PKSVerifyTTL = os.Getenv("PKS_VERIFY_TTL")
+	SMTPServer = os.Getenv("SMTP_SERVER")
+	SMTPUsername = os.Getenv("SMTP_USERNAME")
+	SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	SMTPFrom = os.Getenv("SMTP_FROM")
+	PKSStripCertifications = strings.ToLower(os.Getenv("PKS_STRIP_CERTIFICATIONS")) == "true"
+	PKSTrustedCertifiers = os.Getenv("PKS_TRUSTED_CERTIFIERS")
+	PKSStripUserAttributes = strings.ToLower(os.Getenv("PKS_STRIP_USER_ATTRIBUTES")) == "true"
+	PKSAdminKeys = os.Getenv("PKS_ADMIN_KEYS")
+	PKSUploadClientIPHeader = os.Getenv("PKS_UPLOAD_CLIENT_IP_HEADER")
+	PKSRequestMaxAge = os.Getenv("PKS_REQUEST_MAX_AGE")
+	PKSSyncPeers = os.Getenv("PKS_SYNC_PEERS")
+	PKSSyncVerifiedPeers = os.Getenv("PKS_SYNC_VERIFIED_PEERS")
+	PKSSyncInterval = os.Getenv("PKS_SYNC_INTERVAL")
+	PKSSyncTimeout = os.Getenv("PKS_SYNC_TIMEOUT")
+	TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	TLSClientCAFile = os.Getenv("TLS_CLIENT_CA_FILE")
+	TLSClientAuth = strings.ToLower(os.Getenv("TLS_CLIENT_AUTH"))
+	TLSIdentitiesFile = os.Getenv("TLS_IDENTITIES_FILE")
+	TLSReloadInterval = os.Getenv("TLS_RELOAD_INTERVAL")
+	ClusterKeyFingerPrint = strings.ToUpper(os.Getenv("CLUSTER_KEY_FINGERPRINT"))
+	ClusterRequestMaxAge = os.Getenv("CLUSTER_REQUEST_MAX_AGE")
+	ClusterTLSCertFile = os.Getenv("CLUSTER_TLS_CERT_FILE")
+	ClusterTLSKeyFile = os.Getenv("CLUSTER_TLS_KEY_FILE")
+	ClusterTLSCAFile = os.Getenv("CLUSTER_TLS_CA_FILE")
+
+	var pKSMaxKeySize = os.Getenv("PKS_MAX_KEY_SIZE")
+	if pKSMaxKeySize != "" {
+		i, err := strconv.ParseInt(pKSMaxKeySize, 10, 32)
+		if err != nil {
+			slog.Error("Error parsing PKS_MAX_KEY_SIZE: %s", err)
+			panic(err)
+		}
+		PKSMaxKeySize = int(i)
+	}
+
+	var pKSMaxUserIDs = os.Getenv("PKS_MAX_USER_IDS")
+	if pKSMaxUserIDs != "" {
+		i, err := strconv.ParseInt(pKSMaxUserIDs, 10, 32)
+		if err != nil {
+			slog.Error("Error parsing PKS_MAX_USER_IDS: %s", err)
+			panic(err)
+		}
+		PKSMaxUserIDs = int(i)
+	}
+
+	var pKSMaxCertifications = os.Getenv("PKS_MAX_CERTIFICATIONS")
+	if pKSMaxCertifications != "" {
+		i, err := strconv.ParseInt(pKSMaxCertifications, 10, 32)
+		if err != nil {
+			slog.Error("Error parsing PKS_MAX_CERTIFICATIONS: %s", err)
+			panic(err)
+		}
+		PKSMaxCertifications = int(i)
+	}
+
+	var pKSUploadRateLimit = os.Getenv("PKS_UPLOAD_RATE_LIMIT")
+	if pKSUploadRateLimit != "" {
+		i, err := strconv.ParseInt(pKSUploadRateLimit, 10, 32)
+		if err != nil {
+			slog.Error("Error parsing PKS_UPLOAD_RATE_LIMIT: %s", err)
+			panic(err)
+		}
+		PKSUploadRateLimit = int(i)
+	}
+
+	var sKSRetries = os.Getenv("SKS_RETRIES")
+	if sKSRetries != "" {
+		i, err := strconv.ParseInt(sKSRetries, 10, 32)
+		if err != nil {
+			slog.Error("Error parsing SKS_RETRIES: %s", err)
+			panic(err)
+		}
+		SKSRetries = int(i)
+	}
+
+	var sKSBreakerThreshold = os.Getenv("SKS_BREAKER_THRESHOLD")
+	if sKSBreakerThreshold != "" {
+		i, err := strconv.ParseInt(sKSBreakerThreshold, 10, 32)
+		if err != nil {
+			slog.Error("Error parsing SKS_BREAKER_THRESHOLD: %s", err)
+			panic(err)
+		}
+		SKSBreakerThreshold = int(i)
+	}
 
 	if (RethinkAuthManager || RethinkTokenManager) && !EnableRethinkSKS {
 		slog.Fatal("Rethink Auth / Token Manager requires Rethink SKS")
 	}
 
+	if PKSVerifyOwnership && !EnableRethinkSKS {
+		slog.Fatal("PKS ownership verification requires Rethink SKS")
+	}
+
 	RequestIDHeader = os.Getenv("REQUESTID_HEADER")
 	AgentExternalURL = os.Getenv("AGENT_EXTERNAL_URL")
 	AgentAdminExternalURL = os.Getenv("AGENTADMIN_EXTERNAL_URL")
@@ -236,6 +434,118 @@ func Setup() {
 		VaultBackend = "secret"
 	}
 
+	if VaultTransitPath == "" {
+		VaultTransitPath = "transit"
+	}
+
+	if VaultTransitKey == "" {
+		VaultTransitKey = "remote-signer-master"
+	}
+
+	if S3Endpoint == "" {
+		S3Endpoint = "https://s3.amazonaws.com"
+	}
+
+	if S3Region == "" {
+		S3Region = "us-east-1"
+	}
+
+	if S3CacheTTL == "" {
+		S3CacheTTL = "0s"
+	}
+
+	if KeyWatcherInterval == "" {
+		KeyWatcherInterval = "30s"
+	}
+
+	if KeyLintExpiringIn == "" {
+		KeyLintExpiringIn = "720h"
+	}
+
+	if KeyExpiryMonitorInterval == "" {
+		KeyExpiryMonitorInterval = "1h"
+	}
+
+	if KeyExpiryThresholds == "" {
+		KeyExpiryThresholds = "30,7,1"
+	}
+
+	if KeyRingCacheTTL == "" {
+		KeyRingCacheTTL = "1h"
+	}
+
+	if KeyRingNegativeCacheTTL == "" {
+		KeyRingNegativeCacheTTL = "1m"
+	}
+
+	if PKSVerifyTTL == "" {
+		PKSVerifyTTL = "24h"
+	}
+
+	if PKSMaxKeySize == -1 {
+		PKSMaxKeySize = 1048576
+	}
+
+	if PKSMaxUserIDs == -1 {
+		PKSMaxUserIDs = 100
+	}
+
+	if PKSMaxCertifications == -1 {
+		PKSMaxCertifications = 100
+	}
+
+	if PKSUploadRateLimit == -1 {
+		PKSUploadRateLimit = 0
+	}
+
+	if PKSRequestMaxAge == "" {
+		PKSRequestMaxAge = "5m"
+	}
+
+	if PKSSyncInterval == "" {
+		PKSSyncInterval = "10m"
+	}
+
+	if PKSSyncTimeout == "" {
+		PKSSyncTimeout = "30s"
+	}
+
+	if TLSClientAuth == "" {
+		TLSClientAuth = "optional"
+	}
+
+	if TLSReloadInterval == "" {
+		TLSReloadInterval = "1m"
+	}
+
+	if ClusterRequestMaxAge == "" {
+		ClusterRequestMaxAge = "1m"
+	}
+
+	if SKSServers == "" {
+		SKSServers = SKSServer
+	}
+
+	if SKSTimeout == "" {
+		SKSTimeout = "10s"
+	}
+
+	if SKSRetries == -1 {
+		SKSRetries = 2
+	}
+
+	if SKSRetryBackoff == "" {
+		SKSRetryBackoff = "500ms"
+	}
+
+	if SKSBreakerThreshold == -1 {
+		SKSBreakerThreshold = 5
+	}
+
+	if SKSBreakerCooldown == "" {
+		SKSBreakerCooldown = "1m"
+	}
+
 	if AgentTargetURL == "" {
 		AgentTargetURL = "https://api.sandbox.contaquanto.com/all"
 	}
@@ -13,6 +13,14 @@ func PushVariables() {
 		"PrivateKeyFolder":          PrivateKeyFolder,
 		"KeyPrefix":                 KeyPrefix,
 		"SKSServer":                 SKSServer,
+		"SKSServers":                SKSServers,
+		"SKSTimeout":                SKSTimeout,
+		"SKSRetries":                SKSRetries,
+		"SKSRetryBackoff":           SKSRetryBackoff,
+		"SKSBreakerThreshold":       SKSBreakerThreshold,
+		"SKSBreakerCooldown":        SKSBreakerCooldown,
+		"SKSWKDLookup":              SKSWKDLookup,
+		"PKSUpstreamLookup":         PKSUpstreamLookup,
 		"HttpPort":                  HttpPort,
 		"MaxKeyRingCache":           MaxKeyRingCache,
 		"EnableRethinkSKS":          EnableRethinkSKS,
@@ -30,6 +38,7 @@ func PushVariables() {
 		"VaultAddress":              VaultAddress,
 		"VaultRootToken":            VaultRootToken,
 		"VaultStorage":              VaultStorage,
+		"EncryptedStorage":          EncryptedStorage,
 		"ReadonlyKeyPath":           ReadonlyKeyPath,
 		"VaultSkipVerify":           VaultSkipVerify,
 		"VaultUseUserpass":          VaultUseUserpass,
@@ -38,11 +47,69 @@ func PushVariables() {
 		"VaultNamespace":            VaultNamespace,
 		"VaultBackend":              VaultBackend,
 		"VaultSkipDataType":         VaultSkipDataType,
+		"VaultTransit":              VaultTransit,
+		"VaultTransitPath":          VaultTransitPath,
+		"VaultTransitKey":           VaultTransitKey,
+		"KubernetesStorage":         KubernetesStorage,
+		"S3Storage":                 S3Storage,
+		"S3Endpoint":                S3Endpoint,
+		"S3Region":                  S3Region,
+		"S3Bucket":                  S3Bucket,
+		"S3AccessKeyID":             S3AccessKeyID,
+		"S3SecretAccessKey":         S3SecretAccessKey,
+		"S3UsePathStyle":            S3UsePathStyle,
+		"S3ServerSideEncryption":    S3ServerSideEncryption,
+		"S3KMSKeyID":                S3KMSKeyID,
+		"S3CacheTTL":                S3CacheTTL,
+		"KeyWatcher":                KeyWatcher,
+		"KeyWatcherInterval":        KeyWatcherInterval,
+		"KeyLintRejectLevel":        KeyLintRejectLevel,
+		"KeyLintExpiringIn":         KeyLintExpiringIn,
+		"KeyExpiryMonitor":          KeyExpiryMonitor,
+		"KeyExpiryMonitorInterval":  KeyExpiryMonitorInterval,
+		"KeyExpiryThresholds":       KeyExpiryThresholds,
+		"KeyExpiryWatchList":        KeyExpiryWatchList,
+		"KeyRingCacheTTL":           KeyRingCacheTTL,
+		"KeyRingNegativeCacheTTL":   KeyRingNegativeCacheTTL,
+		"WKDDomains":                WKDDomains,
+		"PKSVerifyOwnership":        PKSVerifyOwnership,
+		"PKSVerifyTTL":              PKSVerifyTTL,
+		"SMTPServer":                SMTPServer,
+		"SMTPUsername":              SMTPUsername,
+		"SMTPPassword":              SMTPPassword,
+		"SMTPFrom":                  SMTPFrom,
+		"PKSMaxKeySize":             PKSMaxKeySize,
+		"PKSMaxUserIDs":             PKSMaxUserIDs,
+		"PKSMaxCertifications":      PKSMaxCertifications,
+		"PKSStripCertifications":    PKSStripCertifications,
+		"PKSTrustedCertifiers":      PKSTrustedCertifiers,
+		"PKSStripUserAttributes":    PKSStripUserAttributes,
+		"PKSUploadRateLimit":        PKSUploadRateLimit,
+		"PKSUploadClientIPHeader":   PKSUploadClientIPHeader,
+		"PKSAdminKeys":              PKSAdminKeys,
+		"PKSRequestMaxAge":          PKSRequestMaxAge,
+		"PKSSyncPeers":              PKSSyncPeers,
+		"PKSSyncVerifiedPeers":      PKSSyncVerifiedPeers,
+		"PKSSyncInterval":           PKSSyncInterval,
+		"PKSSyncTimeout":            PKSSyncTimeout,
+		"TLSCertFile":               TLSCertFile,
+		"TLSKeyFile":                TLSKeyFile,
+		"TLSClientCAFile":           TLSClientCAFile,
+		"TLSClientAuth":             TLSClientAuth,
+		"TLSIdentitiesFile":         TLSIdentitiesFile,
+		"TLSReloadInterval":         TLSReloadInterval,
+		"ClusterKeyFingerPrint":     ClusterKeyFingerPrint,
+		"ClusterRequestMaxAge":      ClusterRequestMaxAge,
+		"ClusterTLSCertFile":        ClusterTLSCertFile,
+		"ClusterTLSKeyFile":         ClusterTLSKeyFile,
+		"ClusterTLSCAFile":          ClusterTLSCAFile,
+		"KeyExpiryWebhookURL":       KeyExpiryWebhookURL,
 		"AgentTargetURL":            AgentTargetURL,
 		"AgentTokenExpiration":      AgentTokenExpiration,
 		"AgentKeyFingerPrint":       AgentKeyFingerPrint,
 		"AgentBypassLogin":          AgentBypassLogin,
 		"RethinkTokenManager":       RethinkTokenManager,
+		"APIAuth":                   APIAuth,
 		"RethinkAuthManager":        RethinkAuthManager,
 		"Environment":               Environment,
 		"AgentExternalURL":          AgentExternalURL,
@@ -66,6 +133,14 @@ func PopVariables() {
 	PrivateKeyFolder = insMap["PrivateKeyFolder"].(string)
 	KeyPrefix = insMap["KeyPrefix"].(string)
 	SKSServer = insMap["SKSServer"].(string)
+	SKSServers = insMap["SKSServers"].(string)
+	SKSTimeout = insMap["SKSTimeout"].(string)
+	SKSRetries = insMap["SKSRetries"].(int)
+	SKSRetryBackoff = insMap["SKSRetryBackoff"].(string)
+	SKSBreakerThreshold = insMap["SKSBreakerThreshold"].(int)
+	SKSBreakerCooldown = insMap["SKSBreakerCooldown"].(string)
+	SKSWKDLookup = insMap["SKSWKDLookup"].(bool)
+	PKSUpstreamLookup = insMap["PKSUpstreamLookup"].(bool)
 	HttpPort = insMap["HttpPort"].(int)
 	MaxKeyRingCache = insMap["MaxKeyRingCache"].(int)
 	EnableRethinkSKS = insMap["EnableRethinkSKS"].(bool)
@@ -83,6 +158,7 @@ func PopVariables() {
 	VaultAddress = insMap["VaultAddress"].(string)
 	VaultRootToken = insMap["VaultRootToken"].(string)
 	VaultStorage = insMap["VaultStorage"].(bool)
+	EncryptedStorage = insMap["EncryptedStorage"].(bool)
 	ReadonlyKeyPath = insMap["ReadonlyKeyPath"].(bool)
 	VaultSkipVerify = insMap["VaultSkipVerify"].(bool)
 	VaultUseUserpass = insMap["VaultUseUserpass"].(bool)
@@ -91,11 +167,69 @@ func PopVariables() {
 	VaultNamespace = insMap["VaultNamespace"].(string)
 	VaultBackend = insMap["VaultBackend"].(string)
 	VaultSkipDataType = insMap["VaultSkipDataType"].(bool)
+	VaultTransit = insMap["VaultTransit"].(bool)
+	VaultTransitPath = insMap["VaultTransitPath"].(string)
+	VaultTransitKey = insMap["VaultTransitKey"].(string)
+	KubernetesStorage = insMap["KubernetesStorage"].(bool)
+	S3Storage = insMap["S3Storage"].(bool)
+	S3Endpoint = insMap["S3Endpoint"].(string)
+	S3Region = insMap["S3Region"].(string)
+	S3Bucket = insMap["S3Bucket"].(string)
+	S3AccessKeyID = insMap["S3AccessKeyID"].(string)
+	S3SecretAccessKey = insMap["S3SecretAccessKey"].(string)
+	S3UsePathStyle = insMap["S3UsePathStyle"].(bool)
+	S3ServerSideEncryption = insMap["S3ServerSideEncryption"].(string)
+	S3KMSKeyID = insMap["S3KMSKeyID"].(string)
+	S3CacheTTL = insMap["S3CacheTTL"].(string)
+	KeyWatcher = insMap["KeyWatcher"].(bool)
+	KeyWatcherInterval = insMap["KeyWatcherInterval"].(string)
+	KeyLintRejectLevel = insMap["KeyLintRejectLevel"].(string)
+	KeyLintExpiringIn = insMap["KeyLintExpiringIn"].(string)
+	KeyExpiryMonitor = insMap["KeyExpiryMonitor"].(bool)
+	KeyExpiryMonitorInterval = insMap["KeyExpiryMonitorInterval"].(string)
+	KeyExpiryThresholds = insMap["KeyExpiryThresholds"].(string)
+	KeyExpiryWatchList = insMap["KeyExpiryWatchList"].(string)
+	KeyExpiryWebhookURL = insMap["KeyExpiryWebhookURL"].(string)
+	KeyRingCacheTTL = insMap["KeyRingCacheTTL"].(string)
+	KeyRingNegativeCacheTTL = insMap["KeyRingNegativeCacheTTL"].(string)
+	WKDDomains = insMap["WKDDomains"].(string)
+	PKSVerifyOwnership = insMap["PKSVerifyOwnership"].(bool)
+	PKSVerifyTTL = insMap["PKSVerifyTTL"].(string)
+	SMTPServer = insMap["SMTPServer"].(string)
+	SMTPUsername = insMap["SMTPUsername"].(string)
+	SMTPPassword = insMap["SMTPPassword"].(string)
+	SMTPFrom = insMap["SMTPFrom"].(string)
+	PKSMaxKeySize = insMap["PKSMaxKeySize"].(int)
+	PKSMaxUserIDs = insMap["PKSMaxUserIDs"].(int)
+	PKSMaxCertifications = insMap["PKSMaxCertifications"].(int)
+	PKSStripCertifications = insMap["PKSStripCertifications"].(bool)
+	PKSTrustedCertifiers = insMap["PKSTrustedCertifiers"].(string)
+	PKSStripUserAttributes = insMap["PKSStripUserAttributes"].(bool)
+	PKSUploadRateLimit = insMap["PKSUploadRateLimit"].(int)
+	PKSUploadClientIPHeader = insMap["PKSUploadClientIPHeader"].(string)
+	PKSAdminKeys = insMap["PKSAdminKeys"].(string)
+	PKSRequestMaxAge = insMap["PKSRequestMaxAge"].(string)
+	PKSSyncPeers = insMap["PKSSyncPeers"].(string)
+	PKSSyncVerifiedPeers = insMap["PKSSyncVerifiedPeers"].(string)
+	PKSSyncInterval = insMap["PKSSyncInterval"].(string)
+	PKSSyncTimeout = insMap["PKSSyncTimeout"].(string)
+	TLSCertFile = insMap["TLSCertFile"].(string)
+	TLSKeyFile = insMap["TLSKeyFile"].(string)
+	TLSClientCAFile = insMap["TLSClientCAFile"].(string)
+	TLSClientAuth = insMap["TLSClientAuth"].(string)
+	TLSIdentitiesFile = insMap["TLSIdentitiesFile"].(string)
+	TLSReloadInterval = insMap["TLSReloadInterval"].(string)
+	ClusterKeyFingerPrint = insMap["ClusterKeyFingerPrint"].(string)
+	ClusterRequestMaxAge = insMap["ClusterRequestMaxAge"].(string)
+	ClusterTLSCertFile = insMap["ClusterTLSCertFile"].(string)
+	ClusterTLSKeyFile = insMap["ClusterTLSKeyFile"].(string)
+	ClusterTLSCAFile = insMap["ClusterTLSCAFile"].(string)
 	AgentTargetURL = insMap["AgentTargetURL"].(string)
 	AgentTokenExpiration = insMap["AgentTokenExpiration"].(int)
 	AgentKeyFingerPrint = insMap["AgentKeyFingerPrint"].(string)
 	AgentBypassLogin = insMap["AgentBypassLogin"].(bool)
 	RethinkTokenManager = insMap["RethinkTokenManager"].(bool)
+	APIAuth = insMap["APIAuth"].(bool)
 	RethinkAuthManager = insMap["RethinkAuthManager"].(bool)
 	Environment = insMap["Environment"].(string)
 	AgentExternalURL = insMap["AgentExternalURL"].(string)
@@ -24,6 +24,9 @@ var tablesToInitialize = []models.TableInitStruct{
 	models.GPGKeyTableInit,
 	models.UserModelTableInit,
 	models.UserTokenTableInit,
+	models.PKSNonceTableInit,
+	models.PKSAuditEntryTableInit,
+	models.PKSSyncBucketTableInit,
 }
 
 func init() {
@@ -112,6 +115,23 @@ func InitTables() {
 				}
 			}
 
+			for _, fidx := range v.FunctionIndexes {
+				dbLog.Await("           Checking index %s in %s", v.TableName, fidx.Name)
+				if tools.StringIndexOf(fidx.Name, idxs) == -1 {
+					dbLog.Note("           Index %s not found at table %s. Creating it...", fidx.Name, v.TableName)
+					err := r.Table(v.TableName).IndexCreateFunc(fidx.Name, fidx.Function, r.IndexCreateOpts{Multi: fidx.Multi}).Exec(conn)
+					if err != nil && !strings.Contains(err.Error(), " already exists") {
+						dbLog.Fatal(err)
+					}
+					WaitTableIndexCreate(v.TableName, fidx.Name)
+				} else {
+					dbLog.WarnDone("           Index %s already exists in table %s. Skipping it...", fidx.Name, v.TableName)
+				}
+			}
+
+			// Indexes of big tables take a while to be built
+			_ = r.Table(v.TableName).IndexWait().Exec(conn)
+
 			dbLog.Success("        Finished getting indexes for table %s", v.TableName)
 		}
 	}
@@ -4,26 +4,97 @@ package magicbuilder
 
 import (
 	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/etc/kbBuilder"
 	"github.com/quan-to/chevron/internal/keybackend"
 	"github.com/quan-to/chevron/internal/keymagic"
-	"github.com/quan-to/chevron/internal/vaultManager"
 	"github.com/quan-to/chevron/pkg/interfaces"
 	"github.com/quan-to/slog"
+	"sync"
+	"time"
 )
 
-// MakePGP creates a new PGPManager using environment variables VaultStorage, KeyPrefix, PrivateKeyFolder
-func MakePGP(log slog.Instance) interfaces.PGPManager {
-	var kb interfaces.StorageBackend
+var keyBackendLock sync.Mutex
+var sharedKeyBackend interfaces.StorageBackend
+var sharedEncryptedBackend *keybackend.EncryptedBackend
+
+// keyBackend returns the key backend shared by the PGPManager and the KeyWatcher, so both see the same
+// envelope header and conditional write state. If kw is not nil the backend is envelope encrypted by it
+func keyBackend(log slog.Instance, kw interfaces.KeyWrapper) interfaces.StorageBackend {
+	keyBackendLock.Lock()
+	defer keyBackendLock.Unlock()
+
+	if sharedKeyBackend == nil {
+		sharedKeyBackend = kbBuilder.BuildKeyBackend(log)
+	}
 
-	if config.VaultStorage {
-		kb = vaultManager.MakeVaultManager(log, config.KeyPrefix)
-	} else {
-		kb = keybackend.MakeSaveToDiskBackend(log, config.PrivateKeyFolder, config.KeyPrefix)
+	if kw == nil {
+		return sharedKeyBackend
 	}
 
+	if sharedEncryptedBackend == nil {
+		sharedEncryptedBackend = keybackend.MakeEncryptedBackend(log, sharedKeyBackend, kw)
+	}
+
+	return sharedEncryptedBackend
+}
+
+// MakePGP creates a new PGPManager using environment variables VaultStorage, S3Storage, KubernetesStorage, KeyPrefix, PrivateKeyFolder
+func MakePGP(log slog.Instance) interfaces.PGPManager {
+	kb := keyBackend(log, nil)
+
 	return keymagic.MakePGPManager(log, kb, keymagic.MakeKeyRingManager(log))
 }
 
+// MakeEncryptedPGP creates a new PGPManager using environment variables VaultStorage, S3Storage, KubernetesStorage, KeyPrefix, PrivateKeyFolder
+// with the key backend envelope encrypted by the specified KeyWrapper
+func MakeEncryptedPGP(log slog.Instance, kw interfaces.KeyWrapper) interfaces.PGPManager {
+	kb := keyBackend(log, kw)
+
+	return keymagic.MakePGPManager(log, kb, keymagic.MakeKeyRingManager(log))
+}
+
+// MakeKeyWatcher creates a KeyWatcher for gpg over the key backend defined by the same environment variables of MakePGP,
+// using KeyWatcherInterval as the polling interval. If kw is not nil the key backend is the same envelope encrypted backend of MakeEncryptedPGP.
+// Reloaded keys are unlocked again with the passwords stored in sm
+func MakeKeyWatcher(log slog.Instance, sm interfaces.SecretsManager, gpg interfaces.PGPManager, kw interfaces.KeyWrapper) *keymagic.KeyWatcher {
+	interval, err := time.ParseDuration(config.KeyWatcherInterval)
+	if err != nil {
+		slog.Fatal("Error parsing KEY_WATCHER_INTERVAL: %s", err)
+	}
+
+	return keymagic.MakeKeyWatcher(log, sm, gpg, keyBackend(log, kw), interval)
+}
+
+// MakeKeyExpiryMonitor returns the shared KeyExpiryMonitor of gpg with the KeyExpiryMonitorInterval check interval
+func MakeKeyExpiryMonitor(log slog.Instance, gpg interfaces.PGPManager) (*keymagic.KeyExpiryMonitor, time.Duration) {
+	interval, err := time.ParseDuration(config.KeyExpiryMonitorInterval)
+	if err != nil {
+		slog.Fatal("Error parsing KEY_EXPIRY_MONITOR_INTERVAL: %s", err)
+	}
+
+	monitor, err := keymagic.GetKeyExpiryMonitor(log, gpg)
+	if err != nil {
+		slog.Fatal("Error creating key expiry monitor: %s", err)
+	}
+
+	return monitor, interval
+}
+
+// MakePKSPeerSync returns the shared PKSPeerSync with the PKSSyncInterval sync interval
+func MakePKSPeerSync() (*keymagic.PKSPeerSync, time.Duration) {
+	interval, err := time.ParseDuration(config.PKSSyncInterval)
+	if err != nil {
+		slog.Fatal("Error parsing PKS_SYNC_INTERVAL: %s", err)
+	}
+
+	return keymagic.GetPKSPeerSync(), interval
+}
+
+// MakeClusterAuth creates a ClusterAuth to sign and verify the requests between the cluster peers
+func MakeClusterAuth(log slog.Instance, sm interfaces.SecretsManager, gpg interfaces.PGPManager) *keymagic.ClusterAuth {
+	return keymagic.MakeClusterAuth(log, sm, gpg)
+}
+
 // MakeVoidPGP creates a PGPManager that does not store anything anywhere
 func MakeVoidPGP(log slog.Instance) interfaces.PGPManager {
 	return keymagic.MakePGPManager(log, keybackend.MakeVoidBackend(), keymagic.MakeKeyRingManager(log))
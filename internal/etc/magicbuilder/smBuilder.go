@@ -1,12 +1,19 @@
+// +build !js,!wasm
+
 package magicbuilder
 
 import (
+	"github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/keymagic"
 	"github.com/quan-to/chevron/pkg/interfaces"
 	"github.com/quan-to/slog"
 )
 
-// MakeSM creates a new Instance of SecretsManager
+// MakeSM creates a new Instance of SecretsManager using Vault Transit Engine if VaultTransit is enabled
 func MakeSM(log slog.Instance) interfaces.SecretsManager {
+	if config.VaultTransit {
+		return keymagic.MakeVaultSecretsManager(log)
+	}
+
 	return keymagic.MakeSecretsManager(log)
 }
@@ -11,10 +11,11 @@ import (
 )
 
 type diskBackend struct {
-	folder      string
-	prefix      string
-	saveEnabled bool
-	log         slog.Instance
+	folder         string
+	originalFolder string
+	prefix         string
+	saveEnabled    bool
+	log            slog.Instance
 }
 
 const metadataPrefix = "metadata-"
@@ -28,6 +29,7 @@ func MakeSaveToDiskBackend(log slog.Instance, folder, prefix string) interfaces.
 	}
 
 	saveEnabled := true
+	originalFolder := folder
 	log.Info("Initialized diskBackendBackend on folder %s with prefix %s", folder, prefix)
 	if config.ReadonlyKeyPath {
 		log.Warn("Readonly keypath. Creating temporary storage in disk.")
@@ -48,10 +50,11 @@ func MakeSaveToDiskBackend(log slog.Instance, folder, prefix string) interfaces.
 	}
 
 	return &diskBackend{
-		folder:      folder,
-		prefix:      prefix,
-		saveEnabled: saveEnabled,
-		log:         log,
+		folder:         folder,
+		originalFolder: originalFolder,
+		prefix:         prefix,
+		saveEnabled:    saveEnabled,
+		log:            log,
 	}
 }
 
@@ -80,6 +83,46 @@ func (d *diskBackend) Save(key, data string) error {
 	return err
 }
 
+// Create saves a key to the disk only if its file does not exist. The data is written to a temporary file that is
+// hard linked to the key file, so other instances never read a partially written key
+func (d *diskBackend) Create(key, data string) error {
+	if !d.saveEnabled {
+		d.log.Warn("Save disabled")
+		return nil
+	}
+
+	d.log.DebugAwait("Creating %s", path.Join(d.folder, d.prefix+key))
+
+	// The metadata prefix keeps the temporary file out of List
+	tmp, err := ioutil.TempFile(d.folder, metadataPrefix+".create-")
+	if err != nil {
+		d.log.ErrorDone("Error creating %s: %s", path.Join(d.folder, d.prefix+key), err)
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Link(tmp.Name(), path.Join(d.folder, d.prefix+key))
+	}
+
+	if os.IsExist(err) {
+		d.log.DebugDone("Key %s already exists", path.Join(d.folder, d.prefix+key))
+		return interfaces.ErrKeyConflict
+	}
+
+	if err != nil {
+		d.log.ErrorDone("Error creating %s: %s", path.Join(d.folder, d.prefix+key), err)
+	}
+
+	return err
+}
+
 func (d *diskBackend) SaveWithMetadata(key, data, metadata string) error {
 	d.log.DebugAwait("Saving to %s", path.Join(d.folder, d.prefix+key))
 	err := ioutil.WriteFile(path.Join(d.folder, d.prefix+key), []byte(data), 0600)
@@ -121,6 +164,9 @@ func (d *diskBackend) Read(key string) (data string, metadata string, err error)
 	sdata, err := ioutil.ReadFile(path.Join(d.folder, d.prefix+key))
 	if err != nil {
 		d.log.ErrorDone("Error reading to %s: %s", path.Join(d.folder, d.prefix+key), err)
+		if os.IsNotExist(err) {
+			return "", "", interfaces.ErrKeyNotFound
+		}
 		return "", "", err
 	}
 
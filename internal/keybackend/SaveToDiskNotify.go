@@ -0,0 +1,55 @@
+// +build !js,!wasm
+
+package keybackend
+
+import (
+	"github.com/fsnotify/fsnotify"
+)
+
+// Notify watches the key folder and sends a value in the returned channel every time a file changes in it
+func (d *diskBackend) Notify(stop chan bool) (<-chan bool, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	err = watcher.Add(d.folder)
+	if err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	if d.folder != d.originalFolder {
+		d.log.Warn("Readonly keypath. Watching the temporary folder %s, changes in %s will not be seen.", d.folder, d.originalFolder)
+	}
+
+	changes := make(chan bool, 1)
+
+	go func() {
+		defer close(changes)
+		defer func() { _ = watcher.Close() }()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				d.log.Debug("Filesystem event %s on %s", event.Op, event.Name)
+				select {
+				case changes <- true:
+				default: // There is already a pending notification
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				d.log.Error("Error watching %s: %s", d.folder, err)
+			}
+		}
+	}()
+
+	return changes, nil
+}
@@ -0,0 +1,437 @@
+package keybackend
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"io"
+	"sync"
+)
+
+const envelopeVersion = 1
+const envelopeHeaderKey = "__envelope__"
+const dataKeySize = 32
+
+// envelope is the stored representation of an encrypted entry
+type envelope struct {
+	Version    int
+	WrapperID  string
+	WrappedKey string
+	Nonce      []byte
+	Data       []byte
+}
+
+// envelopePayload is the plain content of an envelope
+type envelopePayload struct {
+	Key      string
+	Data     string
+	Metadata string
+}
+
+// EncryptedBackend is a StorageBackend that envelope encrypts the keys, metadata and key names of another StorageBackend
+type EncryptedBackend struct {
+	sync.Mutex
+	backend interfaces.StorageBackend
+	wrapper interfaces.KeyWrapper
+	nameKey []byte
+	names   map[string]string
+	log     slog.Instance
+}
+
+// MakeEncryptedBackend creates an instance of EncryptedBackend that stores envelope encrypted entries in backend.
+// Each entry is encrypted with its own data key, which is wrapped with the master key of wrapper.
+func MakeEncryptedBackend(log slog.Instance, backend interfaces.StorageBackend, wrapper interfaces.KeyWrapper) *EncryptedBackend {
+	if log == nil {
+		log = slog.Scope("encryptedBackend")
+	} else {
+		log = log.SubScope("encryptedBackend")
+	}
+
+	log.Info("Initialized encryptedBackend over %s", backend.Name())
+
+	return &EncryptedBackend{
+		backend: backend,
+		wrapper: wrapper,
+		names:   map[string]string{},
+		log:     log,
+	}
+}
+
+func (e *EncryptedBackend) Name() string {
+	return fmt.Sprintf("encryptedBackend StorageBackend (%s)", e.backend.Name())
+}
+
+func (e *EncryptedBackend) Path() string {
+	return e.backend.Path()
+}
+
+// Save saves a key to the backend
+func (e *EncryptedBackend) Save(key, data string) error {
+	return e.SaveWithMetadata(key, data, "")
+}
+
+// SaveWithMetadata saves a key to backend storing some metadata with it. Both are stored in the same envelope.
+func (e *EncryptedBackend) SaveWithMetadata(key, data, metadata string) error {
+	ctx := context.Background()
+	e.log.DebugAwait("Saving encrypted %s", key)
+
+	storedName, err := e.storedName(ctx, key)
+	if err != nil {
+		e.log.ErrorDone("Error loading envelope header: %s", err)
+		return err
+	}
+
+	payload, _ := json.Marshal(envelopePayload{
+		Key:      key,
+		Data:     data,
+		Metadata: metadata,
+	})
+
+	env, err := e.seal(ctx, payload)
+	if err != nil {
+		e.log.ErrorDone("Error encrypting %s: %s", key, err)
+		return err
+	}
+
+	err = e.backend.Save(storedName, string(env))
+	if err != nil {
+		e.log.ErrorDone("Error saving %s: %s", key, err)
+		return err
+	}
+
+	e.Lock()
+	e.names[storedName] = key
+	e.Unlock()
+
+	return nil
+}
+
+// Delete deletes a key from the backend
+func (e *EncryptedBackend) Delete(key string) error {
+	ctx := context.Background()
+	storedName, err := e.storedName(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = e.backend.Delete(storedName)
+	if err != nil {
+		return err
+	}
+
+	e.Lock()
+	delete(e.names, storedName)
+	e.Unlock()
+
+	return nil
+}
+
+// Read reads and decrypts a key from the backend
+func (e *EncryptedBackend) Read(key string) (data string, metadata string, err error) {
+	ctx := context.Background()
+	storedName, err := e.storedName(ctx, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	payload, err := e.readPayload(ctx, storedName)
+	if err != nil {
+		return "", "", err
+	}
+
+	if payload.Key != key {
+		return "", "", fmt.Errorf("envelope key mismatch for %s", key)
+	}
+
+	return payload.Data, payload.Metadata, nil
+}
+
+// List lists the stored keys
+func (e *EncryptedBackend) List() ([]string, error) {
+	ctx := context.Background()
+	storedNames, err := e.backend.List()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+
+	for _, storedName := range storedNames {
+		if storedName == envelopeHeaderKey {
+			continue
+		}
+
+		e.Lock()
+		key, ok := e.names[storedName]
+		e.Unlock()
+
+		if !ok {
+			payload, err := e.readPayload(ctx, storedName)
+			if err != nil {
+				e.log.Error("Error reading envelope %s: %s", storedName, err)
+				continue
+			}
+			key = payload.Key
+			e.Lock()
+			e.names[storedName] = key
+			e.Unlock()
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Notify forwards the change notifications of the underlying backend, if it supports them
+func (e *EncryptedBackend) Notify(stop chan bool) (<-chan bool, error) {
+	nb, ok := e.backend.(interfaces.NotifyingStorageBackend)
+	if !ok {
+		return nil, fmt.Errorf("%s does not support notifications", e.backend.Name())
+	}
+
+	return nb.Notify(stop)
+}
+
+// Rewrap re-wraps every stored data key that was wrapped by previous with the current master key.
+// Returns the number of re-wrapped entries.
+func (e *EncryptedBackend) Rewrap(ctx context.Context, previous interfaces.KeyWrapper) (int, error) {
+	currentID := e.wrapper.WrapperID(ctx)
+	previousID := previous.WrapperID(ctx)
+	e.log.Info("Re-wrapping data keys from %s to %s", previousID, currentID)
+
+	storedNames, err := e.backend.List()
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+
+	for _, storedName := range storedNames {
+		data, _, err := e.backend.Read(storedName)
+		if err != nil {
+			return rewrapped, err
+		}
+
+		var env envelope
+		err = json.Unmarshal([]byte(data), &env)
+		if err != nil {
+			return rewrapped, fmt.Errorf("invalid envelope at %s: %s", storedName, err)
+		}
+
+		if env.WrapperID == currentID {
+			continue
+		}
+
+		if env.WrapperID != previousID {
+			e.log.Warn("Envelope %s is wrapped by unknown key %s. Skipping...", storedName, env.WrapperID)
+			continue
+		}
+
+		dataKey, err := previous.UnwrapKey(ctx, env.WrappedKey)
+		if err != nil {
+			return rewrapped, fmt.Errorf("cannot unwrap %s: %s", storedName, err)
+		}
+
+		env.WrappedKey, err = e.wrapper.WrapKey(ctx, dataKey)
+		if err != nil {
+			return rewrapped, fmt.Errorf("cannot wrap %s: %s", storedName, err)
+		}
+		env.WrapperID = currentID
+
+		d, _ := json.Marshal(env)
+		err = e.backend.Save(storedName, string(d))
+		if err != nil {
+			return rewrapped, err
+		}
+
+		rewrapped++
+	}
+
+	e.log.Info("Re-wrapped %d entries", rewrapped)
+
+	return rewrapped, nil
+}
+
+// storedName returns the obfuscated name used to store key in the underlying backend
+func (e *EncryptedBackend) storedName(ctx context.Context, key string) (string, error) {
+	nameKey, err := e.getNameKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, nameKey)
+	_, _ = mac.Write([]byte(key))
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// getNameKey loads (or creates if needed) the key used to obfuscate key names.
+// The header is only created when the backend reports that it does not exist, since a new name key
+// would make every stored entry unreachable. If the backend supports conditional writes, the header
+// is only created if no other instance created it first
+func (e *EncryptedBackend) getNameKey(ctx context.Context) ([]byte, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.nameKey != nil {
+		return e.nameKey, nil
+	}
+
+	nameKey, err := e.readNameKey(ctx)
+	if err == nil {
+		return nameKey, nil
+	}
+
+	if err != interfaces.ErrKeyNotFound {
+		return nil, fmt.Errorf("error reading envelope header: %s", err)
+	}
+
+	e.log.Info("No envelope header found. Creating a new one.")
+
+	nameKey = make([]byte, dataKeySize)
+	_, err = io.ReadFull(rand.Reader, nameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := e.seal(ctx, nameKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if cb, ok := e.backend.(interfaces.ConditionalStorageBackend); ok {
+		err = cb.Create(envelopeHeaderKey, string(env))
+		if err == interfaces.ErrKeyConflict {
+			e.log.Info("The envelope header was created by another instance. Using it.")
+			return e.readNameKey(ctx)
+		}
+	} else {
+		e.log.Warn("%s does not support conditional writes. Only one instance should create the envelope header.", e.backend.Name())
+		err = e.backend.Save(envelopeHeaderKey, string(env))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.nameKey = nameKey
+
+	return nameKey, nil
+}
+
+// readNameKey reads the name key from the envelope header. Should be called with the lock held
+func (e *EncryptedBackend) readNameKey(ctx context.Context) ([]byte, error) {
+	data, _, err := e.backend.Read(envelopeHeaderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nameKey, err := e.open(ctx, []byte(data))
+	if err != nil {
+		return nil, err
+	}
+
+	e.nameKey = nameKey
+
+	return nameKey, nil
+}
+
+func (e *EncryptedBackend) readPayload(ctx context.Context, storedName string) (*envelopePayload, error) {
+	data, _, err := e.backend.Read(storedName)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := e.open(ctx, []byte(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var payload envelopePayload
+	err = json.Unmarshal(plain, &payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payload, nil
+}
+
+// seal encrypts data with a new data key and returns the serialized envelope
+func (e *EncryptedBackend) seal(ctx context.Context, data []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := e.wrapper.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		Version:    envelopeVersion,
+		WrapperID:  e.wrapper.WrapperID(ctx),
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Data:       gcm.Seal(nil, nonce, data, nil),
+	})
+}
+
+// open decrypts a serialized envelope
+func (e *EncryptedBackend) open(ctx context.Context, data []byte) ([]byte, error) {
+	var env envelope
+	err := json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envelope: %s", err)
+	}
+
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+
+	if env.WrapperID != e.wrapper.WrapperID(ctx) {
+		return nil, fmt.Errorf("envelope is wrapped by %s but current master key is %s. re-wrap is required", env.WrapperID, e.wrapper.WrapperID(ctx))
+	}
+
+	dataKey, err := e.wrapper.UnwrapKey(ctx, env.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, env.Nonce, env.Data, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,250 @@
+package keybackend
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quan-to/chevron/pkg/interfaces"
+)
+
+// testWrapper is a KeyWrapper that "wraps" keys by tagging them with its id
+type testWrapper struct {
+	id string
+}
+
+func (tw *testWrapper) WrapKey(ctx context.Context, dataKey []byte) (string, error) {
+	return tw.id + ":" + base64.StdEncoding.EncodeToString(dataKey), nil
+}
+
+func (tw *testWrapper) UnwrapKey(ctx context.Context, wrappedKey string) ([]byte, error) {
+	if !strings.HasPrefix(wrappedKey, tw.id+":") {
+		return nil, fmt.Errorf("key not wrapped by %s", tw.id)
+	}
+
+	return base64.StdEncoding.DecodeString(wrappedKey[len(tw.id)+1:])
+}
+
+func (tw *testWrapper) WrapperID(ctx context.Context) string {
+	return tw.id
+}
+
+func makeTestEncryptedBackend(t *testing.T, wrapperID string) (*EncryptedBackend, string) {
+	folder, err := ioutil.TempDir("", "encryptedBackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return MakeEncryptedBackend(nil, MakeSaveToDiskBackend(nil, folder, "test_"), &testWrapper{id: wrapperID}), folder
+}
+
+func TestEncryptedBackend(t *testing.T) {
+	eb, folder := makeTestEncryptedBackend(t, "master")
+	defer os.RemoveAll(folder)
+
+	err := eb.SaveWithMetadata("ABCDEF0123456789", "secret key data", `{"password":"huebr"}`)
+	if err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	data, metadata, err := eb.Read("ABCDEF0123456789")
+	if err != nil {
+		t.Fatalf("Error reading: %s", err)
+	}
+
+	if data != "secret key data" || metadata != `{"password":"huebr"}` {
+		t.Errorf("Unexpected data / metadata read: %q / %q", data, metadata)
+	}
+
+	// Nothing should be in plain text on disk
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range files {
+		if strings.Contains(f.Name(), "ABCDEF0123456789") {
+			t.Errorf("Key name stored in plain text: %s", f.Name())
+		}
+		if strings.HasPrefix(f.Name(), metadataPrefix) {
+			t.Errorf("Metadata file stored: %s", f.Name())
+		}
+		raw, _ := ioutil.ReadFile(path.Join(folder, f.Name()))
+		if strings.Contains(string(raw), "secret key data") || strings.Contains(string(raw), "huebr") {
+			t.Errorf("Data stored in plain text at %s", f.Name())
+		}
+	}
+
+	// A new instance should be able to list and read the keys
+	eb2 := MakeEncryptedBackend(nil, MakeSaveToDiskBackend(nil, folder, "test_"), &testWrapper{id: "master"})
+
+	keys, err := eb2.List()
+	if err != nil {
+		t.Fatalf("Error listing: %s", err)
+	}
+
+	if len(keys) != 1 || keys[0] != "ABCDEF0123456789" {
+		t.Errorf("Expected to list [ABCDEF0123456789] got %v", keys)
+	}
+
+	err = eb2.Delete("ABCDEF0123456789")
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+
+	_, _, err = eb2.Read("ABCDEF0123456789")
+	if err == nil {
+		t.Errorf("Expected error reading deleted key")
+	}
+}
+
+func TestEncryptedBackendRewrap(t *testing.T) {
+	eb, folder := makeTestEncryptedBackend(t, "old")
+	defer os.RemoveAll(folder)
+
+	err := eb.Save("key1", "data1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = eb.Save("key2", "data2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newEb := MakeEncryptedBackend(nil, MakeSaveToDiskBackend(nil, folder, "test_"), &testWrapper{id: "new"})
+
+	_, _, err = newEb.Read("key1")
+	if err == nil {
+		t.Fatalf("Expected error reading key wrapped by another master key")
+	}
+
+	n, err := newEb.Rewrap(context.Background(), &testWrapper{id: "old"})
+	if err != nil {
+		t.Fatalf("Error re-wrapping: %s", err)
+	}
+
+	// Header + 2 keys
+	if n != 3 {
+		t.Errorf("Expected 3 re-wrapped entries got %d", n)
+	}
+
+	data, _, err := newEb.Read("key2")
+	if err != nil {
+		t.Fatalf("Error reading re-wrapped key: %s", err)
+	}
+
+	if data != "data2" {
+		t.Errorf("Expected data2 got %s", data)
+	}
+
+	n, err = newEb.Rewrap(context.Background(), &testWrapper{id: "old"})
+	if err != nil || n != 0 {
+		t.Errorf("Expected nothing to re-wrap, got %d (%v)", n, err)
+	}
+}
+
+// unavailableBackend is a StorageBackend that fails every read, like an unreachable remote storage
+type unavailableBackend struct {
+	interfaces.StorageBackend
+}
+
+func (u *unavailableBackend) Read(key string) (string, string, error) {
+	return "", "", fmt.Errorf("connection refused")
+}
+
+func TestEncryptedBackendUnavailable(t *testing.T) {
+	eb, folder := makeTestEncryptedBackend(t, "master")
+	defer os.RemoveAll(folder)
+
+	err := eb.Save("key1", "data1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disk := MakeSaveToDiskBackend(nil, folder, "test_")
+	header, _, err := disk.Read(envelopeHeaderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unavailable := MakeEncryptedBackend(nil, &unavailableBackend{StorageBackend: disk}, &testWrapper{id: "master"})
+
+	err = unavailable.Save("key2", "data2")
+	if err == nil {
+		t.Fatalf("Expected error saving when the envelope header cannot be read")
+	}
+
+	newHeader, _, err := disk.Read(envelopeHeaderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newHeader != header {
+		t.Errorf("Expected the envelope header to not be replaced when the backend is unavailable")
+	}
+
+	data, _, err := MakeEncryptedBackend(nil, disk, &testWrapper{id: "master"}).Read("key1")
+	if err != nil || data != "data1" {
+		t.Errorf("Expected to read data1 got %q (%v)", data, err)
+	}
+}
+
+func TestEncryptedBackendConcurrentHeader(t *testing.T) {
+	folder, err := ioutil.TempDir("", "encryptedBackend")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	instances := make([]*EncryptedBackend, 8)
+	for i := range instances {
+		instances[i] = MakeEncryptedBackend(nil, MakeSaveToDiskBackend(nil, folder, "test_"), &testWrapper{id: "master"})
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, len(instances))
+
+	for i, eb := range instances {
+		wg.Add(1)
+		go func(i int, eb *EncryptedBackend) {
+			defer wg.Done()
+			errs <- eb.Save(fmt.Sprintf("key%d", i), fmt.Sprintf("data%d", i))
+		}(i, eb)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Error saving: %s", err)
+		}
+	}
+
+	// Every instance should read the keys saved by the others
+	for _, eb := range instances {
+		for i := range instances {
+			data, _, err := eb.Read(fmt.Sprintf("key%d", i))
+			if err != nil || data != fmt.Sprintf("data%d", i) {
+				t.Errorf("Expected to read data%d got %q (%v)", i, data, err)
+			}
+		}
+	}
+
+	err = MakeSaveToDiskBackend(nil, folder, "test_").(interfaces.ConditionalStorageBackend).Create(envelopeHeaderKey, "{}")
+	if err != interfaces.ErrKeyConflict {
+		t.Errorf("Expected ErrKeyConflict creating an existing key got %v", err)
+	}
+
+	keys, err := instances[0].List()
+	if err != nil || len(keys) != len(instances) {
+		t.Errorf("Expected %d keys got %v (%v)", len(instances), keys, err)
+	}
+}
@@ -0,0 +1,158 @@
+package keybackend
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+)
+
+// MigrateOptions controls how keys are copied between two StorageBackend
+type MigrateOptions struct {
+	// SourceBase64 tells that the key data in the source backend is base64 encoded (KeysBase64Encoded)
+	SourceBase64 bool
+	// DestinationBase64 tells that the key data should be base64 encoded in the destination backend
+	DestinationBase64 bool
+	// DryRun only reports what would be done
+	DryRun bool
+	// Verify reads back each written key and compares with the source
+	Verify bool
+	// Overwrite replaces keys that already exists in the destination with different content
+	Overwrite bool
+}
+
+// MigrateResult is the outcome of a migration
+type MigrateResult struct {
+	Migrated []string
+	Skipped  []string
+	Failed   map[string]error
+}
+
+func decodeKeyData(data string, encoded bool) (string, error) {
+	if !encoded {
+		return data, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("error base64 decoding: %s", err)
+	}
+
+	return string(b), nil
+}
+
+func encodeKeyData(data string, encoded bool) string {
+	if !encoded {
+		return data
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(data))
+}
+
+// Migrate copies every key and its metadata from src to dst, converting the key data encoding when needed
+func Migrate(log slog.Instance, src, dst interfaces.StorageBackend, opts MigrateOptions) (*MigrateResult, error) {
+	if log == nil {
+		log = slog.Scope("Migrate")
+	} else {
+		log = log.SubScope("Migrate")
+	}
+
+	log.Info("Migrating keys from %s (%s) to %s (%s)", src.Name(), src.Path(), dst.Name(), dst.Path())
+	if opts.DryRun {
+		log.Warn("Dry run enabled. Nothing will be written.")
+	}
+
+	keys, err := src.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys from %s: %s", src.Name(), err)
+	}
+
+	result := &MigrateResult{
+		Migrated: make([]string, 0),
+		Skipped:  make([]string, 0),
+		Failed:   map[string]error{},
+	}
+
+	for _, key := range keys {
+		skipped, err := migrateKey(log, src, dst, key, opts)
+		switch {
+		case err != nil:
+			log.Error("Error migrating %s: %s", key, err)
+			result.Failed[key] = err
+		case skipped:
+			result.Skipped = append(result.Skipped, key)
+		default:
+			result.Migrated = append(result.Migrated, key)
+		}
+	}
+
+	log.Info("Migrated %d keys, skipped %d, failed %d", len(result.Migrated), len(result.Skipped), len(result.Failed))
+
+	return result, nil
+}
+
+// MigrateEncrypted copies every key between two raw backends that are envelope encrypted by wrapper.
+// The key data encoding is inside the envelopes, so it is copied as is instead of being converted,
+// and migrating between different encodings is refused
+func MigrateEncrypted(log slog.Instance, src, dst interfaces.StorageBackend, wrapper interfaces.KeyWrapper, opts MigrateOptions) (*MigrateResult, error) {
+	if opts.SourceBase64 != opts.DestinationBase64 {
+		return nil, fmt.Errorf("the key encoding cannot be changed while migrating encrypted keys")
+	}
+
+	opts.SourceBase64 = false
+	opts.DestinationBase64 = false
+
+	return Migrate(log, MakeEncryptedBackend(log, src, wrapper), MakeEncryptedBackend(log, dst, wrapper), opts)
+}
+
+func migrateKey(log slog.Instance, src, dst interfaces.StorageBackend, key string, opts MigrateOptions) (skipped bool, err error) {
+	rawData, metadata, err := src.Read(key)
+	if err != nil {
+		return false, fmt.Errorf("error reading from source: %s", err)
+	}
+
+	data, err := decodeKeyData(rawData, opts.SourceBase64)
+	if err != nil {
+		return false, err
+	}
+
+	newData := encodeKeyData(data, opts.DestinationBase64)
+
+	// Also makes the destination aware of the current version for backends with conditional writes
+	dstData, dstMetadata, err := dst.Read(key)
+	if err == nil {
+		if dstData == newData && dstMetadata == metadata {
+			log.Info("Key %s already migrated. Skipping.", key)
+			return true, nil
+		}
+
+		if !opts.Overwrite {
+			return false, fmt.Errorf("key already exists in destination with different content")
+		}
+	}
+
+	if opts.DryRun {
+		log.Info("Would migrate %s", key)
+		return false, nil
+	}
+
+	log.Info("Migrating %s", key)
+	err = dst.SaveWithMetadata(key, newData, metadata)
+	if err != nil {
+		return false, fmt.Errorf("error saving to destination: %s", err)
+	}
+
+	if opts.Verify {
+		vData, vMetadata, err := dst.Read(key)
+		if err != nil {
+			return false, fmt.Errorf("error verifying: %s", err)
+		}
+
+		decoded, err := decodeKeyData(vData, opts.DestinationBase64)
+		if err != nil || decoded != data || vMetadata != metadata {
+			return false, fmt.Errorf("verification failed: data read from destination does not match the source")
+		}
+	}
+
+	return false, nil
+}
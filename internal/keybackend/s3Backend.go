@@ -0,0 +1,439 @@
+package keybackend
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const s3DefaultTimeout = 30 * time.Second
+
+// S3Config is the configuration of a S3 compatible storage backend
+type S3Config struct {
+	// Endpoint is the S3 endpoint URL (for example https://s3.amazonaws.com or http://localhost:9000 for MinIO)
+	Endpoint string
+	// Region is the region used for signing the requests
+	Region string
+	// Bucket is the bucket where the keys are stored
+	Bucket string
+	// AccessKeyID is the access key used for signing the requests
+	AccessKeyID string
+	// SecretAccessKey is the secret key used for signing the requests
+	SecretAccessKey string
+	// PathStyle uses path-style (endpoint/bucket/key) addressing instead of virtual hosted (bucket.endpoint/key)
+	PathStyle bool
+	// ServerSideEncryption is the value of x-amz-server-side-encryption header (AES256 or aws:kms). Empty disables it
+	ServerSideEncryption string
+	// KMSKeyID is the KMS Key used when ServerSideEncryption is aws:kms
+	KMSKeyID string
+	// CacheTTL is the time that read objects are kept in memory. Zero disables the cache
+	CacheTTL time.Duration
+}
+
+type s3CacheEntry struct {
+	data      string
+	metadata  string
+	expiresAt time.Time
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+type s3Backend struct {
+	sync.Mutex
+	config S3Config
+	prefix string
+	client *http.Client
+	etags  map[string]string
+	cache  map[string]s3CacheEntry
+	log    slog.Instance
+}
+
+// MakeS3Backend creates an instance of a StorageBackend that stores keys as objects in the S3 compatible bucket
+// defined by the S3 environment variables
+func MakeS3Backend(log slog.Instance, prefix string) interfaces.StorageBackend {
+	cacheTTL, err := time.ParseDuration(config.S3CacheTTL)
+	if err != nil {
+		slog.Fatal("Error parsing S3_CACHE_TTL: %s", err)
+	}
+
+	return MakeS3BackendWithConfig(log, S3Config{
+		Endpoint:             config.S3Endpoint,
+		Region:               config.S3Region,
+		Bucket:               config.S3Bucket,
+		AccessKeyID:          config.S3AccessKeyID,
+		SecretAccessKey:      config.S3SecretAccessKey,
+		PathStyle:            config.S3UsePathStyle,
+		ServerSideEncryption: config.S3ServerSideEncryption,
+		KMSKeyID:             config.S3KMSKeyID,
+		CacheTTL:             cacheTTL,
+	}, prefix)
+}
+
+// MakeS3BackendWithConfig creates an instance of a StorageBackend that stores keys as objects in a S3 compatible bucket
+func MakeS3BackendWithConfig(log slog.Instance, s3config S3Config, prefix string) interfaces.StorageBackend {
+	if log == nil {
+		log = slog.Scope("s3Backend")
+	} else {
+		log = log.SubScope("s3Backend")
+	}
+
+	if s3config.Region == "" {
+		s3config.Region = "us-east-1"
+	}
+
+	s3config.Endpoint = strings.TrimRight(s3config.Endpoint, "/")
+
+	log.Info("Initialized s3Backend at %s bucket %s with prefix %s", s3config.Endpoint, s3config.Bucket, prefix)
+
+	if s3config.CacheTTL > 0 {
+		log.Info("In-process cache enabled with TTL %s", s3config.CacheTTL)
+	}
+
+	return &s3Backend{
+		config: s3config,
+		prefix: prefix,
+		client: &http.Client{Timeout: s3DefaultTimeout},
+		etags:  map[string]string{},
+		cache:  map[string]s3CacheEntry{},
+		log:    log,
+	}
+}
+
+func (s *s3Backend) Name() string {
+	return "s3Backend StorageBackend"
+}
+
+func (s *s3Backend) Path() string {
+	return fmt.Sprintf("s3://%s/%s*", s.config.Bucket, s.prefix)
+}
+
+func (s *s3Backend) objectURL(object string, query url.Values) *url.URL {
+	u, _ := url.Parse(s.config.Endpoint)
+
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = ""
+	}
+
+	u.Path += "/" + object
+
+	if query != nil {
+		u.RawQuery = s3CanonicalQuery(query)
+	}
+
+	return u
+}
+
+func (s *s3Backend) do(method, object string, query url.Values, body []byte, headers map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, s.objectURL(object, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	signS3Request(req, s.config.AccessKeyID, s.config.SecretAccessKey, s.config.Region, sha256Hex(body), time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+
+	return res, data, err
+}
+
+// putObject stores an object avoiding to overwrite changes made by someone else since our last read / write.
+// Objects that were never read by this instance (for example after a restart) are only created if they don't exist,
+// and objects are never overwritten without a known etag. If create is true, it only creates the object.
+// Returns ErrKeyConflict if the object was created or modified by someone else
+func (s *s3Backend) putObject(object, data string, create bool) error {
+	headers := map[string]string{
+		"Content-Type": "application/octet-stream",
+	}
+
+	if s.config.ServerSideEncryption != "" {
+		headers["x-amz-server-side-encryption"] = s.config.ServerSideEncryption
+		if s.config.KMSKeyID != "" {
+			headers["x-amz-server-side-encryption-aws-kms-key-id"] = s.config.KMSKeyID
+		}
+	}
+
+	s.Lock()
+	etag, known := s.etags[object]
+	s.Unlock()
+
+	if known && !create {
+		headers["If-Match"] = etag
+	} else {
+		headers["If-None-Match"] = "*"
+	}
+
+	res, body, err := s.do("PUT", object, nil, []byte(data), headers)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusPreconditionFailed {
+		s.Lock()
+		delete(s.etags, object)
+		s.Unlock()
+		s.log.Warn("Object %s was created or modified by someone else. Read it again before saving", object)
+		return interfaces.ErrKeyConflict
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error saving %s: [%d] %s", object, res.StatusCode, string(body))
+	}
+
+	s.Lock()
+	s.etags[object] = res.Header.Get("ETag")
+	s.Unlock()
+
+	return nil
+}
+
+// getObject returns the object data. If the object does not exists, found is false
+func (s *s3Backend) getObject(object string) (data string, found bool, err error) {
+	res, body, err := s.do("GET", object, nil, nil, nil)
+	if err != nil {
+		return "", false, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		s.Lock()
+		delete(s.etags, object)
+		s.Unlock()
+		return "", false, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("error reading %s: [%d] %s", object, res.StatusCode, string(body))
+	}
+
+	s.Lock()
+	s.etags[object] = res.Header.Get("ETag")
+	s.Unlock()
+
+	return string(body), true, nil
+}
+
+func (s *s3Backend) deleteObject(object string) error {
+	res, body, err := s.do("DELETE", object, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error deleting %s: [%d] %s", object, res.StatusCode, string(body))
+	}
+
+	s.Lock()
+	delete(s.etags, object)
+	s.Unlock()
+
+	return nil
+}
+
+func (s *s3Backend) cacheGet(key string) (s3CacheEntry, bool) {
+	if s.config.CacheTTL <= 0 {
+		return s3CacheEntry{}, false
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	c, ok := s.cache[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		delete(s.cache, key)
+		return s3CacheEntry{}, false
+	}
+
+	return c, true
+}
+
+func (s *s3Backend) cachePut(key, data, metadata string) {
+	if s.config.CacheTTL <= 0 {
+		return
+	}
+
+	s.Lock()
+	s.cache[key] = s3CacheEntry{
+		data:      data,
+		metadata:  metadata,
+		expiresAt: time.Now().Add(s.config.CacheTTL),
+	}
+	s.Unlock()
+}
+
+func (s *s3Backend) cacheDelete(key string) {
+	s.Lock()
+	delete(s.cache, key)
+	s.Unlock()
+}
+
+func (s *s3Backend) Save(key, data string) error {
+	s.log.DebugAwait("Saving to %s", s.prefix+key)
+	s.cacheDelete(key)
+	err := s.putObject(s.prefix+key, data, false)
+	if err != nil {
+		s.log.ErrorDone("Error saving to %s: %s", s.prefix+key, err)
+	}
+
+	return err
+}
+
+// Create saves a key only if its object does not exist. Returns ErrKeyConflict if it already exists
+func (s *s3Backend) Create(key, data string) error {
+	s.log.DebugAwait("Creating %s", s.prefix+key)
+	s.cacheDelete(key)
+	err := s.putObject(s.prefix+key, data, true)
+	if err != nil && err != interfaces.ErrKeyConflict {
+		s.log.ErrorDone("Error creating %s: %s", s.prefix+key, err)
+	}
+
+	return err
+}
+
+func (s *s3Backend) SaveWithMetadata(key, data, metadata string) error {
+	s.log.DebugAwait("Saving to %s", s.prefix+key)
+	s.cacheDelete(key)
+	err := s.putObject(s.prefix+key, data, false)
+	if err != nil {
+		s.log.ErrorDone("Error saving to %s: %s", s.prefix+key, err)
+		return err
+	}
+
+	err = s.putObject(metadataPrefix+s.prefix+key, metadata, false)
+	if err != nil {
+		s.log.ErrorDone("Error saving to %s: %s", metadataPrefix+s.prefix+key, err)
+	}
+
+	return err
+}
+
+// Delete deletes a key and its metadata from the bucket
+func (s *s3Backend) Delete(key string) error {
+	s.log.DebugAwait("Deleting %s", s.prefix+key)
+	s.cacheDelete(key)
+
+	_, found, err := s.getObject(s.prefix + key)
+	if err != nil {
+		s.log.ErrorDone("Error reading %s: %s", s.prefix+key, err)
+		return err
+	}
+
+	if !found {
+		s.log.ErrorDone("Error reading %s: object does not exist to delete", s.prefix+key)
+		return interfaces.ErrKeyNotFound
+	}
+
+	err = s.deleteObject(s.prefix + key)
+	if err != nil {
+		s.log.ErrorDone("Error deleting %s: %s", s.prefix+key, err)
+		return err
+	}
+
+	_ = s.deleteObject(metadataPrefix + s.prefix + key)
+
+	return nil
+}
+
+func (s *s3Backend) Read(key string) (data string, metadata string, err error) {
+	if c, ok := s.cacheGet(key); ok {
+		s.log.Debug("Reading %s from cache", s.prefix+key)
+		return c.data, c.metadata, nil
+	}
+
+	s.log.DebugAwait("Reading from %s", s.prefix+key)
+
+	data, found, err := s.getObject(s.prefix + key)
+	if err != nil {
+		s.log.ErrorDone("Error reading %s: %s", s.prefix+key, err)
+		return "", "", err
+	}
+
+	if !found {
+		return "", "", interfaces.ErrKeyNotFound
+	}
+
+	metadata, _, err = s.getObject(metadataPrefix + s.prefix + key)
+	if err != nil {
+		metadata = ""
+	}
+
+	s.cachePut(key, data, metadata)
+
+	return data, metadata, nil
+}
+
+func (s *s3Backend) List() ([]string, error) {
+	keys := make([]string, 0)
+	token := ""
+
+	for {
+		q := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.prefix},
+		}
+
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		res, body, err := s.do("GET", "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error listing bucket %s: [%d] %s", s.config.Bucket, res.StatusCode, string(body))
+		}
+
+		var result s3ListResult
+		err = xml.Unmarshal(body, &result)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range result.Contents {
+			// Skip Metadata
+			if strings.HasPrefix(v.Key, metadataPrefix) {
+				continue
+			}
+
+			if len(v.Key) > len(s.prefix) && strings.HasPrefix(v.Key, s.prefix) {
+				keys = append(keys, v.Key[len(s.prefix):])
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+
+		token = result.NextContinuationToken
+	}
+
+	return keys, nil
+}
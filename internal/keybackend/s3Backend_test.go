@@ -0,0 +1,291 @@
+package keybackend
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quan-to/chevron/pkg/interfaces"
+)
+
+const testS3Bucket = "chevron"
+
+// fakeS3 is a minimal in-memory S3 server with path-style addressing
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string]string
+	etags   map[string]string
+	headers map[string]http.Header
+	version int
+	gets    int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3SignAlgorithm+" Credential=access/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	object := strings.TrimPrefix(r.URL.Path, "/"+testS3Bucket+"/")
+
+	switch {
+	case r.Method == "GET" && object == "" && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r)
+	case r.Method == "HEAD":
+		if _, ok := f.objects[object]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etags[object])
+	case r.Method == "GET":
+		f.gets++
+		data, ok := f.objects[object]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etags[object])
+		_, _ = w.Write([]byte(data))
+	case r.Method == "PUT":
+		etag, exists := f.etags[object]
+		if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && (!exists || ifMatch != etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		f.version++
+		f.objects[object] = string(data)
+		f.etags[object] = strconv.Quote(strconv.Itoa(f.version))
+		f.headers[object] = r.Header
+		w.Header().Set("ETag", f.etags[object])
+	case r.Method == "DELETE":
+		delete(f.objects, object)
+		delete(f.etags, object)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list returns one object per page to exercise the continuation token
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	keys := make([]string, 0)
+	for k := range f.objects {
+		if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+
+	res := s3ListResult{}
+	if start < len(keys) {
+		res.Contents = append(res.Contents, struct{ Key string }{Key: keys[start]})
+	}
+
+	if start+1 < len(keys) {
+		res.IsTruncated = true
+		res.NextContinuationToken = fmt.Sprintf("%d", start+1)
+	}
+
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		s3ListResult
+	}{s3ListResult: res})
+
+	_, _ = w.Write(data)
+}
+
+func makeTestS3Backend(t *testing.T, cacheTTL time.Duration) (*fakeS3, *httptest.Server, S3Config) {
+	f := &fakeS3{
+		objects: map[string]string{},
+		etags:   map[string]string{},
+		headers: map[string]http.Header{},
+	}
+
+	server := httptest.NewServer(f)
+
+	return f, server, S3Config{
+		Endpoint:             server.URL,
+		Bucket:               testS3Bucket,
+		AccessKeyID:          "access",
+		SecretAccessKey:      "secret",
+		PathStyle:            true,
+		ServerSideEncryption: "aws:kms",
+		KMSKeyID:             "kms-key",
+		CacheTTL:             cacheTTL,
+	}
+}
+
+func TestS3Backend(t *testing.T) {
+	f, server, cfg := makeTestS3Backend(t, 0)
+	defer server.Close()
+
+	sb := MakeS3BackendWithConfig(nil, cfg, "test_")
+
+	err := sb.SaveWithMetadata("key1", "data1", "meta1")
+	if err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	err = sb.Save("key2", "data2")
+	if err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	if _, ok := f.objects["test_key1"]; !ok {
+		t.Errorf("Expected object test_key1 to be stored")
+	}
+
+	if _, ok := f.objects[metadataPrefix+"test_key1"]; !ok {
+		t.Errorf("Expected object %stest_key1 to be stored", metadataPrefix)
+	}
+
+	h := f.headers["test_key1"]
+	if h.Get("x-amz-server-side-encryption") != "aws:kms" || h.Get("x-amz-server-side-encryption-aws-kms-key-id") != "kms-key" {
+		t.Errorf("Expected server side encryption headers, got %v", h)
+	}
+
+	data, metadata, err := sb.Read("key1")
+	if err != nil {
+		t.Fatalf("Error reading: %s", err)
+	}
+
+	if data != "data1" || metadata != "meta1" {
+		t.Errorf("Unexpected data / metadata read: %q / %q", data, metadata)
+	}
+
+	keys, err := sb.List()
+	if err != nil {
+		t.Fatalf("Error listing: %s", err)
+	}
+
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "key1" || keys[1] != "key2" {
+		t.Errorf("Expected to list [key1 key2] got %v", keys)
+	}
+
+	// Updating a known object should work
+	err = sb.Save("key1", "data1-updated")
+	if err != nil {
+		t.Fatalf("Error updating: %s", err)
+	}
+
+	err = sb.Delete("key1")
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+
+	if len(f.objects) != 1 {
+		t.Errorf("Expected only test_key2 to be left, got %v", f.objects)
+	}
+
+	_, _, err = sb.Read("key1")
+	if err == nil {
+		t.Errorf("Expected error reading deleted key")
+	}
+
+	err = sb.Delete("key1")
+	if err != interfaces.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound deleting non existent key got %v", err)
+	}
+}
+
+func TestS3BackendConditionalWrite(t *testing.T) {
+	_, server, cfg := makeTestS3Backend(t, 0)
+	defer server.Close()
+
+	sb1 := MakeS3BackendWithConfig(nil, cfg, "test_")
+	sb2 := MakeS3BackendWithConfig(nil, cfg, "test_")
+
+	err := sb1.Save("key", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sb2 never saw the object (like after a restart), so it cannot overwrite it without reading it first
+	err = sb2.Save("key", "other data")
+	if err != interfaces.ErrKeyConflict {
+		t.Fatalf("Expected ErrKeyConflict overwriting an unseen object got %v", err)
+	}
+
+	_, _, err = sb2.Read("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sb2.Save("key", "other data")
+	if err != nil {
+		t.Fatalf("Error overwriting a read object: %s", err)
+	}
+
+	// sb1 version is now outdated
+	err = sb1.Save("key", "stale data")
+	if err == nil {
+		t.Fatalf("Expected error overwriting an object modified by someone else")
+	}
+
+	data, _, _ := sb1.Read("key")
+	if data != "other data" {
+		t.Errorf("Expected other data got %s", data)
+	}
+
+	// Create never overwrites, even a known object
+	err = sb1.(interfaces.ConditionalStorageBackend).Create("key", "created data")
+	if err != interfaces.ErrKeyConflict {
+		t.Errorf("Expected ErrKeyConflict creating an existing object got %v", err)
+	}
+
+	err = sb1.(interfaces.ConditionalStorageBackend).Create("new", "created data")
+	if err != nil {
+		t.Errorf("Error creating a new object: %s", err)
+	}
+}
+
+func TestS3BackendCache(t *testing.T) {
+	f, server, cfg := makeTestS3Backend(t, time.Minute)
+	defer server.Close()
+
+	sb := MakeS3BackendWithConfig(nil, cfg, "test_")
+
+	err := sb.Save("key", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		data, _, err := sb.Read("key")
+		if err != nil || data != "data" {
+			t.Fatalf("Error reading: %q %v", data, err)
+		}
+	}
+
+	// data + metadata on first read only
+	if f.gets != 2 {
+		t.Errorf("Expected 2 requests to S3 got %d", f.gets)
+	}
+
+	err = sb.Save("key", "new data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, _ := sb.Read("key")
+	if data != "new data" {
+		t.Errorf("Expected cache to be invalidated on save, got %s", data)
+	}
+}
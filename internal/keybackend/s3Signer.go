@@ -0,0 +1,125 @@
+package keybackend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// region AWS Signature Version 4 (https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html)
+
+const s3SignAlgorithm = "AWS4-HMAC-SHA256"
+const s3Service = "s3"
+const s3AmzDateFormat = "20060102T150405Z"
+const s3ShortDateFormat = "20060102"
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath encodes each segment of the path as specified by SigV4
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, v := range segments {
+		segments[i] = strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0)
+	for _, k := range keys {
+		values := q[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, fmt.Sprintf("%s=%s", s3QueryEscape(k), s3QueryEscape(v)))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func s3QueryEscape(v string) string {
+	return strings.Replace(url.QueryEscape(v), "+", "%20", -1)
+}
+
+// signS3Request signs the request in place using the specified credentials. payloadHash must be the hex sha256 of the body
+func signS3Request(req *http.Request, accessKeyID, secretAccessKey, region, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(s3AmzDateFormat)
+	shortDate := now.UTC().Format(s3ShortDateFormat)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "if-match" || lk == "if-none-match" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	headerNames := make([]string, 0, len(headers))
+	for k := range headers {
+		headerNames = append(headerNames, k)
+	}
+	sort.Strings(headerNames)
+
+	canonicalHeaders := ""
+	for _, k := range headerNames {
+		canonicalHeaders += fmt.Sprintf("%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", shortDate, region, s3Service)
+
+	stringToSign := strings.Join([]string{
+		s3SignAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), shortDate)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, s3Service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3SignAlgorithm, accessKeyID, scope, signedHeaders, signature))
+}
+
+// endregion
@@ -1,7 +1,6 @@
 package keybackend
 
 import (
-	"fmt"
 	"github.com/quan-to/chevron/pkg/interfaces"
 )
 
@@ -40,7 +39,7 @@ func (d *voidBackend) Delete(key string) error {
 
 // Read reads a key from the backend
 func (d *voidBackend) Read(key string) (data string, metadata string, err error) {
-	return "", "", fmt.Errorf("nothing to read")
+	return "", "", interfaces.ErrKeyNotFound
 }
 
 // List lists the stored keys
@@ -0,0 +1,194 @@
+package keybackend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// notifyDebounce is the time to wait after a backend notification so a key and its metadata written together are seen as one change
+const notifyDebounce = 500 * time.Millisecond
+
+var errKeyVersionDeleted = errors.New("current version is deleted")
+
+// Watcher detects added, changed and removed keys in a StorageBackend.
+// Versioned backends are compared by their current key version, other backends by a hash of the stored data.
+type Watcher struct {
+	sync.Mutex
+	kb       interfaces.StorageBackend
+	vkb      interfaces.VersionedStorageBackend
+	interval time.Duration
+	tags     map[string]string
+	log      slog.Instance
+}
+
+// MakeWatcher creates a Watcher for the specified backend that polls it every interval
+func MakeWatcher(log slog.Instance, kb interfaces.StorageBackend, interval time.Duration) *Watcher {
+	if log == nil {
+		log = slog.Scope("Watcher")
+	} else {
+		log = log.SubScope("Watcher")
+	}
+
+	w := &Watcher{
+		kb:       kb,
+		interval: interval,
+		tags:     map[string]string{},
+		log:      log,
+	}
+
+	if vkb, ok := kb.(interfaces.VersionedStorageBackend); ok && vkb.IsVersioned() {
+		log.Info("%s is versioned. Polling key versions.", kb.Name())
+		w.vkb = vkb
+	}
+
+	return w
+}
+
+func (w *Watcher) keyTag(key string) (string, error) {
+	if w.vkb != nil {
+		versions, err := w.vkb.ListVersions(key)
+		if err != nil {
+			return "", err
+		}
+
+		for _, v := range versions {
+			if v.Current {
+				if v.DeletionTime != nil || v.Destroyed {
+					return "", errKeyVersionDeleted
+				}
+				return fmt.Sprintf("v%d", v.Version), nil
+			}
+		}
+
+		return "", fmt.Errorf("no current version for %s", key)
+	}
+
+	data, metadata, err := w.kb.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	_, _ = h.Write([]byte(data))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(metadata))
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Check compares the keys in the backend with the previous check and returns the changes.
+// On the first call all stored keys are returned as added.
+func (w *Watcher) Check() ([]models.KeyEvent, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	keys, err := w.kb.List()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tags := make(map[string]string, len(keys))
+	events := make([]models.KeyEvent, 0)
+
+	for _, key := range keys {
+		tag, err := w.keyTag(key)
+		if err == errKeyVersionDeleted {
+			continue
+		}
+
+		if err != nil {
+			w.log.Error("Error checking key %s: %s", key, err)
+			if old, ok := w.tags[key]; ok {
+				tags[key] = old // Keep it as is until the next check
+			}
+			continue
+		}
+
+		tags[key] = tag
+
+		old, ok := w.tags[key]
+		if !ok {
+			events = append(events, models.KeyEvent{Type: models.KeyEventAdded, Key: key, Time: now})
+		} else if old != tag {
+			events = append(events, models.KeyEvent{Type: models.KeyEventChanged, Key: key, Time: now})
+		}
+	}
+
+	removed := make([]string, 0)
+	for key := range w.tags {
+		if _, ok := tags[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	sort.Strings(removed)
+
+	for _, key := range removed {
+		events = append(events, models.KeyEvent{Type: models.KeyEventRemoved, Key: key, Time: now})
+	}
+
+	w.tags = tags
+
+	return events, nil
+}
+
+// Run checks the backend every interval, and on every backend notification if it is a NotifyingStorageBackend,
+// calling onEvents with the changes until stop receives a value.
+// The keys already stored when Run is called are not reported.
+func (w *Watcher) Run(stop chan bool, onEvents func([]models.KeyEvent)) {
+	w.log.Info("Watching %s -> %s every %s", w.kb.Name(), w.kb.Path(), w.interval)
+
+	if _, err := w.Check(); err != nil {
+		w.log.Error("Error listing keys: %s", err)
+	}
+
+	var notifications <-chan bool
+	notifyStop := make(chan bool, 1)
+
+	if nkb, ok := w.kb.(interfaces.NotifyingStorageBackend); ok {
+		n, err := nkb.Notify(notifyStop)
+		if err != nil {
+			w.log.Warn("Cannot receive notifications from %s: %s. Using only polling.", w.kb.Name(), err)
+		} else {
+			notifications = n
+		}
+	}
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			notifyStop <- true
+			w.log.Info("Stopped watching %s", w.kb.Name())
+			return
+		case <-ticker.C:
+		case _, ok := <-notifications:
+			if !ok {
+				notifications = nil
+				continue
+			}
+			time.Sleep(notifyDebounce)
+		}
+
+		events, err := w.Check()
+		if err != nil {
+			w.log.Error("Error listing keys: %s", err)
+			continue
+		}
+
+		if len(events) > 0 {
+			onEvents(events)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package keybackend
+
+import (
+	"github.com/quan-to/chevron/internal/models"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatcherCheck(t *testing.T) {
+	folder, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	kb := MakeSaveToDiskBackend(nil, folder, "key_")
+	_ = kb.SaveWithMetadata("key1", "data1", "")
+	_ = kb.SaveWithMetadata("key2", "data2", "")
+
+	w := MakeWatcher(nil, kb, time.Minute)
+
+	events, err := w.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 || events[0].Type != models.KeyEventAdded || events[1].Type != models.KeyEventAdded {
+		t.Fatalf("Expected 2 added keys got %v", events)
+	}
+
+	events, _ = w.Check()
+	if len(events) != 0 {
+		t.Fatalf("Expected no changes got %v", events)
+	}
+
+	_ = kb.SaveWithMetadata("key1", "data1", `{"password":"huebr"}`)
+	_ = kb.Delete("key2")
+	_ = kb.SaveWithMetadata("key3", "data3", "")
+
+	events, _ = w.Check()
+
+	expected := map[string]string{
+		"key1": models.KeyEventChanged,
+		"key2": models.KeyEventRemoved,
+		"key3": models.KeyEventAdded,
+	}
+
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events got %v", len(expected), events)
+	}
+
+	for _, e := range events {
+		if expected[e.Key] != e.Type {
+			t.Errorf("Expected key %s to be %s got %s", e.Key, expected[e.Key], e.Type)
+		}
+	}
+}
+
+func TestWatcherRunNotify(t *testing.T) {
+	folder, err := ioutil.TempDir("", "watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	kb := MakeSaveToDiskBackend(nil, folder, "key_")
+	_ = kb.SaveWithMetadata("key1", "data1", "")
+
+	// Long interval so only filesystem notifications trigger checks
+	w := MakeWatcher(nil, kb, time.Hour)
+	stop := make(chan bool)
+	received := make(chan []models.KeyEvent, 10)
+
+	go w.Run(stop, func(events []models.KeyEvent) {
+		received <- events
+	})
+	defer func() { stop <- true }()
+
+	// Wait the initial check
+	time.Sleep(100 * time.Millisecond)
+
+	_ = kb.SaveWithMetadata("key2", "data2", "")
+
+	select {
+	case events := <-received:
+		if len(events) != 1 || events[0].Key != "key2" || events[0].Type != models.KeyEventAdded {
+			t.Errorf("Expected key2 to be added got %v", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting key events")
+	}
+}
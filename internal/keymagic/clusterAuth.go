@@ -0,0 +1,373 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/kubernetes"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/slog"
+)
+
+const defaultClusterRequestMaxAge = time.Minute
+
+// Headers of the signed requests between the cluster peers
+const (
+	ClusterTimestampHeader = "X-Cluster-Timestamp"
+	ClusterNonceHeader     = "X-Cluster-Nonce"
+	ClusterPodHeader       = "X-Cluster-Pod"
+	ClusterSignatureHeader = "X-Cluster-Signature"
+)
+
+// ClusterAuth signs and verifies the requests between the cluster peers that exchange the encrypted key passwords.
+// The requests are signed by the master key, or by CLUSTER_KEY_FINGERPRINT, with a timestamp and a nonce against replays.
+// In Kubernetes the sender pod is also checked against the Kubernetes API
+type ClusterAuth struct {
+	sync.Mutex
+	log    slog.Instance
+	sm     interfaces.SecretsManager
+	gpg    interfaces.PGPManager
+	nonces map[string]time.Time
+	// verifyPeer checks the sender pod of a request coming from the ip
+	verifyPeer func(pod, ip string) error
+}
+
+// MakeClusterAuth creates a ClusterAuth. The master key is used if the secrets manager can sign with it
+func MakeClusterAuth(log slog.Instance, sm interfaces.SecretsManager, gpg interfaces.PGPManager) *ClusterAuth {
+	if log == nil {
+		log = slog.Scope("ClusterAuth")
+	} else {
+		log = log.SubScope("ClusterAuth")
+	}
+
+	ca := &ClusterAuth{
+		log:    log,
+		sm:     sm,
+		gpg:    gpg,
+		nonces: map[string]time.Time{},
+	}
+
+	if kubernetes.InKubernetes() {
+		ca.verifyPeer = kubernetes.VerifyPeer
+	}
+
+	if _, err := clusterKeyFingerPrint(); err != nil {
+		log.Fatal(err)
+	}
+
+	return ca
+}
+
+// clusterKeyFingerPrint returns CLUSTER_KEY_FINGERPRINT. Only a full 40 characters fingerprint is accepted,
+// since the key can be fetched from the PKS, where a shorter one could match other keys
+func clusterKeyFingerPrint() (string, error) {
+	fp := strings.ToUpper(strings.TrimSpace(config.ClusterKeyFingerPrint))
+	if fp == "" {
+		return "", nil
+	}
+
+	if _, err := hex.DecodeString(fp); err != nil || len(fp) != 40 {
+		return "", fmt.Errorf("the cluster key %s is not a 40 characters fingerprint", fp)
+	}
+
+	return fp, nil
+}
+
+func clusterRequestMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(config.ClusterRequestMaxAge)
+	if err != nil || maxAge <= 0 {
+		return defaultClusterRequestMaxAge
+	}
+
+	return maxAge
+}
+
+// clusterSignedData returns the data signed for the request. The destination host is signed so the request
+// can't be replayed to another peer
+func clusterSignedData(method, host, path, timestamp, nonce, pod string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	return []byte(strings.Join([]string{
+		"chevron-cluster-v1",
+		method,
+		strings.ToLower(host),
+		path,
+		timestamp,
+		nonce,
+		pod,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n"))
+}
+
+// clusterResponseSignedData returns the data signed for the response to the request with the specified nonce
+func clusterResponseSignedData(host, path, timestamp, requestNonce, pod string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	return []byte(strings.Join([]string{
+		"chevron-cluster-response-v1",
+		strings.ToLower(host),
+		path,
+		timestamp,
+		requestNonce,
+		pod,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n"))
+}
+
+func (ca *ClusterAuth) masterSigner() (interfaces.ClusterSigner, bool) {
+	cs, ok := ca.sm.(interfaces.ClusterSigner)
+	return cs, ok
+}
+
+// SignRequest adds the signature headers to the request with the specified body
+func (ca *ClusterAuth) SignRequest(ctx context.Context, req *http.Request, body []byte) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(nonceBytes)
+	pod := kubernetes.Hostname()
+
+	signature, err := ca.sign(ctx, clusterSignedData(req.Method, req.Host, req.URL.Path, timestamp, nonce, pod, body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(ClusterTimestampHeader, timestamp)
+	req.Header.Set(ClusterNonceHeader, nonce)
+	req.Header.Set(ClusterPodHeader, pod)
+	req.Header.Set(ClusterSignatureHeader, signature)
+
+	return nil
+}
+
+// SignResponse adds the signature headers to the response with the specified body of a verified request.
+// The response is bound to the request nonce, so it can't be replayed to another request
+func (ca *ClusterAuth) SignResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := r.Header.Get(ClusterNonceHeader)
+	pod := kubernetes.Hostname()
+
+	signature, err := ca.sign(ctx, clusterResponseSignedData(r.Host, r.URL.Path, timestamp, nonce, pod, body))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set(ClusterTimestampHeader, timestamp)
+	w.Header().Set(ClusterNonceHeader, nonce)
+	w.Header().Set(ClusterPodHeader, pod)
+	w.Header().Set(ClusterSignatureHeader, signature)
+
+	return nil
+}
+
+// sign signs the data with CLUSTER_KEY_FINGERPRINT or the master key and returns the signature encoded for the headers
+func (ca *ClusterAuth) sign(ctx context.Context, data []byte) (string, error) {
+	var signature string
+
+	clusterKey, err := clusterKeyFingerPrint()
+	if err != nil {
+		return "", err
+	}
+
+	if clusterKey != "" {
+		signature, err = ca.gpg.SignData(ctx, clusterKey, data, crypto.SHA512)
+	} else if cs, ok := ca.masterSigner(); ok {
+		signature, err = cs.SignWithMasterKey(ctx, data)
+	} else {
+		err = fmt.Errorf("there is no master key or CLUSTER_KEY_FINGERPRINT to sign")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(signature)), nil
+}
+
+// checkSignature verifies the encoded signature of the data against the cluster keys and returns the signer
+func (ca *ClusterAuth) checkSignature(ctx context.Context, data []byte, encodedSignature string) (*openpgp.Entity, error) {
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil || len(signature) == 0 {
+		return nil, fmt.Errorf("it is not signed")
+	}
+
+	keys := ca.clusterKeys(ctx)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("there is no master key or CLUSTER_KEY_FINGERPRINT to verify the signature")
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(keys, bytes.NewReader(data), strings.NewReader(tools.SignatureFix(string(signature))))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err)
+	}
+
+	return signer, nil
+}
+
+// checkTimestamp checks that the timestamp is inside of the allowed window and returns it
+func checkTimestamp(timestamp string) (time.Time, error) {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+
+	maxAge := clusterRequestMaxAge()
+	signedAt := time.Unix(unix, 0)
+	age := time.Since(signedAt)
+	if age > maxAge || age < -maxAge {
+		return time.Time{}, fmt.Errorf("the timestamp is outside of the allowed window of %s", maxAge)
+	}
+
+	return signedAt, nil
+}
+
+// clusterKeys returns the public keys allowed to sign the requests
+func (ca *ClusterAuth) clusterKeys(ctx context.Context) openpgp.EntityList {
+	keys := openpgp.EntityList{}
+
+	if cs, ok := ca.masterSigner(); ok {
+		if e := cs.GetMasterPublicKey(ctx); e != nil {
+			keys = append(keys, e)
+		}
+	}
+
+	if clusterKey, err := clusterKeyFingerPrint(); err == nil && clusterKey != "" {
+		e := ca.gpg.GetPublicKeyEntity(ctx, clusterKey)
+		// The key can be fetched from the PKS, so the full fingerprint is compared
+		if e != nil && fullFingerPrint(e) == clusterKey {
+			keys = append(keys, e)
+		}
+	}
+
+	return keys
+}
+
+// useNonce stores the nonce until it expires. Returns false if it was already used
+func (ca *ClusterAuth) useNonce(nonce string, expiration time.Time) bool {
+	ca.Lock()
+	defer ca.Unlock()
+
+	now := time.Now()
+	for n, exp := range ca.nonces {
+		if now.After(exp) {
+			delete(ca.nonces, n)
+		}
+	}
+
+	if _, ok := ca.nonces[nonce]; ok {
+		return false
+	}
+
+	ca.nonces[nonce] = expiration
+
+	return true
+}
+
+// VerifyRequest checks the signature, timestamp and nonce of the request with the specified body and,
+// in Kubernetes, that it was sent by a running peer pod. Returns the signer fingerprint
+func (ca *ClusterAuth) VerifyRequest(ctx context.Context, r *http.Request, body []byte) (string, error) {
+	log := ca.log.Tag(tools.GetRequestIDFromContext(ctx))
+
+	timestamp := r.Header.Get(ClusterTimestampHeader)
+	nonce := r.Header.Get(ClusterNonceHeader)
+	pod := r.Header.Get(ClusterPodHeader)
+
+	if r.Header.Get(ClusterSignatureHeader) == "" {
+		return "", fmt.Errorf("the request is not signed")
+	}
+
+	if len(nonce) < minKeyOperationNonceLength || len(nonce) > maxKeyOperationNonceLength {
+		return "", fmt.Errorf("the nonce should have between %d and %d characters", minKeyOperationNonceLength, maxKeyOperationNonceLength)
+	}
+
+	signedAt, err := checkTimestamp(timestamp)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := ca.checkSignature(ctx, clusterSignedData(r.Method, r.Host, r.URL.Path, timestamp, nonce, pod, body), r.Header.Get(ClusterSignatureHeader))
+	if err != nil {
+		return "", err
+	}
+
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	// Requests from the loopback are from this same pod
+	if ca.verifyPeer != nil && !net.ParseIP(ip).IsLoopback() {
+		if err := ca.verifyPeer(pod, ip); err != nil {
+			return "", fmt.Errorf("the sender is not a verified peer: %s", err)
+		}
+	}
+
+	// The nonce is kept until the request timestamp is out of the allowed window
+	if !ca.useNonce(nonce, signedAt.Add(clusterRequestMaxAge())) {
+		return "", fmt.Errorf("the nonce was already used")
+	}
+
+	signerFingerPrint := fullFingerPrint(signer)
+	log.Debug("Verified cluster request from %s (%s) signed by %s", pod, ip, signerFingerPrint)
+
+	return signerFingerPrint, nil
+}
+
+// VerifyResponse checks the signature and timestamp of the response with the specified body to the signed request and,
+// in Kubernetes, that it was sent by the running peer pod that the request was sent to
+func (ca *ClusterAuth) VerifyResponse(ctx context.Context, req *http.Request, res *http.Response, body []byte) error {
+	log := ca.log.Tag(tools.GetRequestIDFromContext(ctx))
+
+	timestamp := res.Header.Get(ClusterTimestampHeader)
+	nonce := res.Header.Get(ClusterNonceHeader)
+	pod := res.Header.Get(ClusterPodHeader)
+
+	if res.Header.Get(ClusterSignatureHeader) == "" {
+		return fmt.Errorf("the response is not signed")
+	}
+
+	requestNonce := req.Header.Get(ClusterNonceHeader)
+	if requestNonce == "" || nonce != requestNonce {
+		return fmt.Errorf("the response is not for this request")
+	}
+
+	if _, err := checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	signer, err := ca.checkSignature(ctx, clusterResponseSignedData(req.Host, req.URL.Path, timestamp, nonce, pod, body), res.Header.Get(ClusterSignatureHeader))
+	if err != nil {
+		return err
+	}
+
+	ip := req.URL.Hostname()
+
+	// Responses from the loopback are from this same pod
+	if ca.verifyPeer != nil && ip != "localhost" && !net.ParseIP(ip).IsLoopback() {
+		if err := ca.verifyPeer(pod, ip); err != nil {
+			return fmt.Errorf("the responder is not a verified peer: %s", err)
+		}
+	}
+
+	log.Debug("Verified cluster response from %s (%s) signed by %s", pod, ip, fullFingerPrint(signer))
+
+	return nil
+}
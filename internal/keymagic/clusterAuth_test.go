@@ -0,0 +1,171 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/test"
+)
+
+func TestClusterAuth(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.ClusterKeyFingerPrint = ""
+	config.ClusterRequestMaxAge = "1m"
+
+	ctx := context.Background()
+	ca := MakeClusterAuth(nil, sm, pgpMan)
+	body := []byte(`{"huebr": "encrypted"}`)
+
+	signedRequest := func(ca *ClusterAuth, body []byte) *http.Request {
+		req := httptest.NewRequest("POST", "/__internal/__postEncryptedPasswords", bytes.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:4321"
+		if err := ca.SignRequest(ctx, req, body); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	// region Master key
+	req := signedRequest(ca, body)
+
+	signer, err := ca.VerifyRequest(ctx, req, body)
+	if err != nil {
+		t.Fatalf("Expected the request to be valid got %s", err)
+	}
+
+	if !tools.CompareFingerPrint(signer, sm.GetMasterKeyFingerPrint(ctx)) {
+		t.Errorf("Expected the master key %s to be the signer got %s", sm.GetMasterKeyFingerPrint(ctx), signer)
+	}
+
+	if _, err := ca.VerifyRequest(ctx, req, body); err == nil {
+		t.Errorf("Expected the replayed request to be refused")
+	}
+	// endregion
+
+	// region Invalid requests
+	req = signedRequest(ca, body)
+	if _, err := ca.VerifyRequest(ctx, req, []byte(`{"huebr": "injected"}`)); err == nil {
+		t.Errorf("Expected the request with another body to be refused")
+	}
+
+	unsigned := httptest.NewRequest("GET", "/__internal/__getUnlockPasswords", nil)
+	if _, err := ca.VerifyRequest(ctx, unsigned, nil); err == nil {
+		t.Errorf("Expected the unsigned request to be refused")
+	}
+
+	req = signedRequest(ca, body)
+	config.ClusterRequestMaxAge = "1ns"
+	if _, err := ca.VerifyRequest(ctx, req, body); err == nil {
+		t.Errorf("Expected the expired request to be refused")
+	}
+	config.ClusterRequestMaxAge = "1m"
+
+	req = signedRequest(ca, body)
+	req.Host = "10.0.0.9:5100"
+	if _, err := ca.VerifyRequest(ctx, req, body); err == nil {
+		t.Errorf("Expected the request replayed to another host to be refused")
+	}
+	// endregion
+
+	// region Peer verification
+	ca.verifyPeer = func(pod, ip string) error {
+		if ip != "10.0.0.2" {
+			return fmt.Errorf("unknown pod")
+		}
+		return nil
+	}
+
+	req = signedRequest(ca, body)
+	req.RemoteAddr = "10.0.0.1:4321"
+	if _, err := ca.VerifyRequest(ctx, req, body); err == nil {
+		t.Errorf("Expected the request from an unknown pod to be refused")
+	}
+
+	req = signedRequest(ca, body)
+	req.RemoteAddr = "10.0.0.2:4321"
+	if _, err := ca.VerifyRequest(ctx, req, body); err != nil {
+		t.Errorf("Expected the request from a peer to be valid got %s", err)
+	}
+	// endregion
+
+	// region Signed responses
+	signedResponse := func(req *http.Request, body []byte) *http.Response {
+		w := httptest.NewRecorder()
+		if err := ca.SignResponse(ctx, w, req, body); err != nil {
+			t.Fatal(err)
+		}
+		return w.Result()
+	}
+
+	passwords := []byte(`{"0551F452ABE463A4": "encrypted"}`)
+	req = httptest.NewRequest("GET", "http://10.0.0.2:5100/__internal/__getUnlockPasswords", nil)
+	if err := ca.SignRequest(ctx, req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res := signedResponse(req, passwords)
+	if err := ca.VerifyResponse(ctx, req, res, passwords); err != nil {
+		t.Errorf("Expected the response to be valid got %s", err)
+	}
+
+	if err := ca.VerifyResponse(ctx, req, res, []byte(`{"0551F452ABE463A4": "injected"}`)); err == nil {
+		t.Errorf("Expected the response with another body to be refused")
+	}
+
+	if err := ca.VerifyResponse(ctx, req, httptest.NewRecorder().Result(), passwords); err == nil {
+		t.Errorf("Expected the unsigned response to be refused")
+	}
+
+	otherReq := httptest.NewRequest("GET", "http://10.0.0.2:5100/__internal/__getUnlockPasswords", nil)
+	if err := ca.SignRequest(ctx, otherReq, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ca.VerifyResponse(ctx, otherReq, res, passwords); err == nil {
+		t.Errorf("Expected the response replayed to another request to be refused")
+	}
+
+	unknownReq := httptest.NewRequest("GET", "http://10.0.0.1:5100/__internal/__getUnlockPasswords", nil)
+	if err := ca.SignRequest(ctx, unknownReq, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ca.VerifyResponse(ctx, unknownReq, signedResponse(unknownReq, passwords), passwords); err == nil {
+		t.Errorf("Expected the response from an unknown pod to be refused")
+	}
+	// endregion
+
+	// region Cluster key
+	config.ClusterKeyFingerPrint = test.TestKeyFingerprint
+	if _, err := clusterKeyFingerPrint(); err == nil {
+		t.Errorf("Expected the short cluster key fingerprint to be refused")
+	}
+
+	config.ClusterKeyFingerPrint = fullFingerPrint(pgpMan.GetPublicKeyEntity(ctx, test.TestKeyFingerprint))
+	clusterKeyAuth := MakeClusterAuth(nil, nil, pgpMan)
+
+	req = signedRequest(clusterKeyAuth, body)
+	signer, err = clusterKeyAuth.VerifyRequest(ctx, req, body)
+	if err != nil {
+		t.Fatalf("Expected the request signed by the cluster key to be valid got %s", err)
+	}
+
+	if !tools.CompareFingerPrint(signer, test.TestKeyFingerprint) {
+		t.Errorf("Expected the cluster key %s to be the signer got %s", test.TestKeyFingerprint, signer)
+	}
+
+	// Peers with the master key also accept the cluster key
+	req = signedRequest(clusterKeyAuth, body)
+	if _, err := ca.VerifyRequest(ctx, req, body); err != nil {
+		t.Errorf("Expected the master key peer to accept the cluster key got %s", err)
+	}
+	// endregion
+}
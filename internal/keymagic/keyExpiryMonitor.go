@@ -0,0 +1,272 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiredLevel is the alert level of expired keys, lower than any threshold
+const expiredLevel = -1
+
+// KeyExpiryMonitor checks the loaded private keys and the watch-list keys for expiration,
+// alerting once for each threshold reached by a key
+type KeyExpiryMonitor struct {
+	sync.Mutex
+	gpg        interfaces.PGPManager
+	thresholds []int
+	watchList  []string
+	webhookURL string
+	alerted    map[string]int
+	httpClient *http.Client
+	log        slog.Instance
+}
+
+// ParseKeyExpiryThresholds parses a comma separated list of days and returns it in descending order
+func ParseKeyExpiryThresholds(thresholds string) ([]int, error) {
+	days := make([]int, 0)
+
+	for _, v := range strings.Split(thresholds, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid threshold %q", v)
+		}
+
+		days = append(days, d)
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+
+	return days, nil
+}
+
+// MakeKeyExpiryMonitor creates a KeyExpiryMonitor for gpg using KeyExpiryThresholds, KeyExpiryWatchList and KeyExpiryWebhookURL
+func MakeKeyExpiryMonitor(log slog.Instance, gpg interfaces.PGPManager) (*KeyExpiryMonitor, error) {
+	if log == nil {
+		log = slog.Scope("KeyExpiry")
+	} else {
+		log = log.SubScope("KeyExpiry")
+	}
+
+	thresholds, err := ParseKeyExpiryThresholds(config.KeyExpiryThresholds)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing KEY_EXPIRY_THRESHOLDS: %s", err)
+	}
+
+	watchList := make([]string, 0)
+	for _, fp := range strings.Split(config.KeyExpiryWatchList, ",") {
+		fp = strings.TrimSpace(fp)
+		if fp != "" {
+			watchList = append(watchList, gpg.FixFingerPrint(fp))
+		}
+	}
+
+	return &KeyExpiryMonitor{
+		gpg:        gpg,
+		thresholds: thresholds,
+		watchList:  watchList,
+		webhookURL: config.KeyExpiryWebhookURL,
+		alerted:    map[string]int{},
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		log:        log,
+	}, nil
+}
+
+var keyExpiryMonitor *KeyExpiryMonitor
+var keyExpiryMonitorLock sync.Mutex
+
+// GetKeyExpiryMonitor returns the shared KeyExpiryMonitor of gpg, so the background checks and the status endpoints
+// keep the same alert state
+func GetKeyExpiryMonitor(log slog.Instance, gpg interfaces.PGPManager) (*KeyExpiryMonitor, error) {
+	keyExpiryMonitorLock.Lock()
+	defer keyExpiryMonitorLock.Unlock()
+
+	if keyExpiryMonitor == nil || keyExpiryMonitor.gpg != gpg {
+		m, err := MakeKeyExpiryMonitor(log, gpg)
+		if err != nil {
+			return nil, err
+		}
+		keyExpiryMonitor = m
+	}
+
+	return keyExpiryMonitor, nil
+}
+
+func (m *KeyExpiryMonitor) status(ctx context.Context, fingerPrint, source string, now time.Time) models.KeyExpiryStatus {
+	status := models.KeyExpiryStatus{
+		FingerPrint: fingerPrint,
+		Source:      source,
+	}
+
+	e := m.gpg.GetPublicKeyEntity(ctx, fingerPrint)
+	if e == nil {
+		status.Error = "key not found"
+		return status
+	}
+
+	ids := make([]string, 0, len(e.Identities))
+	for name := range e.Identities {
+		ids = append(ids, name)
+	}
+	sort.Strings(ids)
+
+	status.Identifier = strings.Join(ids, ", ")
+	status.KeyID, status.ExpirationTime = tools.GetKeyExpiration(e, now)
+
+	if status.ExpirationTime == nil {
+		return status
+	}
+
+	left := status.ExpirationTime.Sub(now)
+	status.DaysLeft = int(math.Floor(left.Hours() / 24))
+	status.Expired = left <= 0
+
+	if !status.Expired {
+		for _, t := range m.thresholds {
+			if left <= time.Duration(t)*24*time.Hour {
+				status.Threshold = t
+			}
+		}
+	}
+
+	return status
+}
+
+// Scan returns the expiration status of the loaded private keys and the watch-list keys without alerting
+func (m *KeyExpiryMonitor) Scan(ctx context.Context) []models.KeyExpiryStatus {
+	now := time.Now()
+	statuses := make([]models.KeyExpiryStatus, 0)
+	seen := map[string]bool{}
+
+	keys := m.gpg.GetLoadedPrivateKeys(ctx)
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].FingerPrint < keys[j].FingerPrint
+	})
+
+	for _, k := range keys {
+		seen[k.FingerPrint] = true
+		statuses = append(statuses, m.status(ctx, k.FingerPrint, models.KeyExpirySourceLoaded, now))
+	}
+
+	for _, fp := range m.watchList {
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+		statuses = append(statuses, m.status(ctx, fp, models.KeyExpirySourceWatchList, now))
+	}
+
+	return statuses
+}
+
+// Check scans the keys and alerts the ones that reached a new threshold or expired since the last check.
+// Returns the sent events
+func (m *KeyExpiryMonitor) Check(ctx context.Context) []models.KeyExpiryEvent {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	events := make([]models.KeyExpiryEvent, 0)
+	alerted := map[string]int{}
+
+	for _, status := range m.Scan(ctx) {
+		if status.Error != "" {
+			m.log.Warn("Cannot check expiration of %s key %s: %s", status.Source, status.FingerPrint, status.Error)
+			continue
+		}
+
+		level := status.Threshold
+		if status.Expired {
+			level = expiredLevel
+		}
+
+		if level == 0 {
+			continue
+		}
+
+		alerted[status.FingerPrint] = level
+
+		if last, ok := m.alerted[status.FingerPrint]; ok && last <= level {
+			continue
+		}
+
+		event := models.KeyExpiryEvent{
+			Type:   models.KeyExpiryEventExpiring,
+			Time:   now,
+			Status: status,
+		}
+
+		if status.Expired {
+			event.Type = models.KeyExpiryEventExpired
+			m.log.Warn("Key %s (%s) from %s expired at %s", status.KeyID, status.Identifier, status.Source, status.ExpirationTime)
+		} else {
+			m.log.Warn("Key %s (%s) from %s expires in %d days at %s", status.KeyID, status.Identifier, status.Source, status.DaysLeft, status.ExpirationTime)
+		}
+
+		m.notify(event)
+		events = append(events, event)
+	}
+
+	m.alerted = alerted
+
+	return events
+}
+
+func (m *KeyExpiryMonitor) notify(event models.KeyExpiryEvent) {
+	if m.webhookURL == "" {
+		return
+	}
+
+	data, _ := json.Marshal(event)
+
+	res, err := m.httpClient.Post(m.webhookURL, models.MimeJSON, bytes.NewReader(data))
+	if err != nil {
+		m.log.Error("Error calling key expiry webhook: %s", err)
+		return
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		m.log.Error("Key expiry webhook returned status %d", res.StatusCode)
+	}
+}
+
+// Run checks the keys every interval until stop receives a value
+func (m *KeyExpiryMonitor) Run(stop chan bool, interval time.Duration) {
+	m.log.Info("Checking key expiration every %s with thresholds %v days", interval, m.thresholds)
+
+	ctx := context.Background()
+	m.Check(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			m.log.Info("Stopped key expiry monitor")
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseKeyExpiryThresholds(t *testing.T) {
+	thresholds, err := ParseKeyExpiryThresholds("1, 30,7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(thresholds, []int{30, 7, 1}) {
+		t.Errorf("Expected [30 7 1] got %v", thresholds)
+	}
+
+	if _, err := ParseKeyExpiryThresholds("30,a"); err == nil {
+		t.Errorf("Expected error parsing invalid threshold")
+	}
+}
+
+func TestKeyExpiryMonitorInvalidThresholds(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.KeyExpiryThresholds = "30,a"
+
+	if _, err := MakeKeyExpiryMonitor(nil, MakePGPManager(nil, keybackend.MakeVoidBackend(), MakeKeyRingManager(nil))); err == nil {
+		t.Errorf("Expected error creating a monitor with invalid thresholds")
+	}
+}
+
+func TestKeyExpiryMonitor(t *testing.T) {
+	ctx := context.Background()
+	folder, err := ioutil.TempDir("", "keyExpiry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	cfg := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Expiring", "", "expiring@huebr.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lifetime := uint32((5*24*time.Hour + time.Hour) / time.Second)
+	for _, id := range e.Identities {
+		id.SelfSignature.KeyLifetimeSecs = &lifetime
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w, _ := armor.Encode(buf, openpgp.PrivateKeyType, nil)
+	err = e.SerializePrivate(w, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	received := make(chan models.KeyExpiryEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event models.KeyExpiryEvent
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer ts.Close()
+
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.KeyExpiryThresholds = "30,7,1"
+	config.KeyExpiryWatchList = ""
+	config.KeyExpiryWebhookURL = ts.URL
+
+	kb := keybackend.MakeSaveToDiskBackend(nil, folder, "key_")
+	gpg := MakePGPManager(nil, kb, MakeKeyRingManager(nil))
+	_, err = gpg.LoadKey(ctx, buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := MakeKeyExpiryMonitor(nil, gpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := m.Scan(ctx)
+	if len(statuses) != 1 || statuses[0].Threshold != 7 || statuses[0].DaysLeft != 5 || statuses[0].Expired {
+		t.Fatalf("Expected key expiring in 5 days with threshold 7 got %+v", statuses)
+	}
+
+	events := m.Check(ctx)
+	if len(events) != 1 || events[0].Type != models.KeyExpiryEventExpiring {
+		t.Fatalf("Expected one expiring event got %+v", events)
+	}
+
+	select {
+	case event := <-received:
+		if event.Status.FingerPrint != statuses[0].FingerPrint || event.Status.Threshold != 7 {
+			t.Errorf("Unexpected webhook event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not called")
+	}
+
+	if events := m.Check(ctx); len(events) != 0 {
+		t.Errorf("Expected the threshold to be alerted only once got %+v", events)
+	}
+}
@@ -0,0 +1,54 @@
+package keymagic
+
+import (
+	"github.com/quan-to/chevron/internal/models"
+)
+
+// keyMatchesFilter returns true if the key metadata has all labels and tags of the filter
+func keyMatchesFilter(key models.KeyInfo, filter models.KeyInfoFilter) bool {
+	if filter.OwnerTeam == "" && filter.Purpose == "" && len(filter.Tags) == 0 {
+		return true
+	}
+
+	m := key.Metadata
+	if m == nil {
+		return false
+	}
+
+	if filter.OwnerTeam != "" && filter.OwnerTeam != m.OwnerTeam {
+		return false
+	}
+
+	if filter.Purpose != "" && filter.Purpose != m.Purpose {
+		return false
+	}
+
+	for _, tag := range filter.Tags {
+		found := false
+		for _, keyTag := range m.Tags {
+			if keyTag == tag {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FilterKeys returns the keys that matches the specified filter
+func FilterKeys(keys []models.KeyInfo, filter models.KeyInfoFilter) []models.KeyInfo {
+	filtered := make([]models.KeyInfo, 0)
+
+	for _, key := range keys {
+		if keyMatchesFilter(key, filter) {
+			filtered = append(filtered, key)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,40 @@
+package keymagic
+
+import (
+	"github.com/quan-to/chevron/internal/models"
+	"testing"
+)
+
+func TestFilterKeys(t *testing.T) {
+	keys := []models.KeyInfo{
+		{FingerPrint: "A", Metadata: &models.KeyMetadata{OwnerTeam: "payments", Purpose: "signing", Tags: []string{"production", "pci"}}},
+		{FingerPrint: "B", Metadata: &models.KeyMetadata{OwnerTeam: "payments", Purpose: "encryption"}},
+		{FingerPrint: "C"},
+	}
+
+	cases := []struct {
+		filter   models.KeyInfoFilter
+		expected []string
+	}{
+		{models.KeyInfoFilter{}, []string{"A", "B", "C"}},
+		{models.KeyInfoFilter{OwnerTeam: "payments"}, []string{"A", "B"}},
+		{models.KeyInfoFilter{Purpose: "encryption"}, []string{"B"}},
+		{models.KeyInfoFilter{Tags: []string{"production", "pci"}}, []string{"A"}},
+		{models.KeyInfoFilter{OwnerTeam: "payments", Tags: []string{"staging"}}, []string{}},
+	}
+
+	for _, c := range cases {
+		filtered := FilterKeys(keys, c.filter)
+		if len(filtered) != len(c.expected) {
+			t.Errorf("Expected %v for filter %+v got %v", c.expected, c.filter, filtered)
+			continue
+		}
+
+		for i, k := range filtered {
+			if k.FingerPrint != c.expected[i] {
+				t.Errorf("Expected %v for filter %+v got %v", c.expected, c.filter, filtered)
+				break
+			}
+		}
+	}
+}
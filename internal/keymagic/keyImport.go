@@ -0,0 +1,334 @@
+package keymagic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/database"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/slog"
+)
+
+// DefaultKeyImportBatchSize is used when the batch size is not specified
+const DefaultKeyImportBatchSize = 100
+
+// importedKey is a key read from the keyring dump
+type importedKey struct {
+	index       int
+	fingerPrint string
+	armored     string
+}
+
+// KeyImporter reads binary or armored keyring dumps incrementally and adds their public keys to the PKS and the keyring
+type KeyImporter struct {
+	log     slog.Instance
+	gpg     interfaces.PGPManager
+	options models.KeyImportOptions
+}
+
+// MakeKeyImporter creates a KeyImporter. gpg is only used when importing to the keyring
+func MakeKeyImporter(log slog.Instance, gpg interfaces.PGPManager, options models.KeyImportOptions) *KeyImporter {
+	if log == nil {
+		log = slog.Scope("KeyImport")
+	} else {
+		log = log.SubScope("KeyImport")
+	}
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultKeyImportBatchSize
+	}
+
+	return &KeyImporter{
+		log:     log,
+		gpg:     gpg,
+		options: options,
+	}
+}
+
+func addKeyImportError(result *models.KeyImportResult, key importedKey, err error) {
+	if len(result.Errors) < models.MaxKeyImportErrors {
+		result.Errors = append(result.Errors, models.KeyImportError{
+			Index:       key.index,
+			FingerPrint: key.fingerPrint,
+			Error:       err.Error(),
+		})
+	}
+}
+
+func armoredPublicEntity(e *openpgp.Entity) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err := e.Serialize(w); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Import reads the keyring dump and imports its keys in batches. progress is called after each batch with the current result.
+// Unreadable keys and keys rejected by the lint policy are skipped. Returns an error if the dump cannot be read
+func (ki *KeyImporter) Import(ctx context.Context, r io.Reader, progress func(models.KeyImportResult)) (models.KeyImportResult, error) {
+	log := ki.log.Tag(tools.GetRequestIDFromContext(ctx))
+	result := models.KeyImportResult{Errors: []models.KeyImportError{}}
+
+	if !ki.options.PKS && !ki.options.KeyRing {
+		return result, fmt.Errorf("no import destination selected")
+	}
+
+	if ki.options.PKS && !config.EnableRethinkSKS {
+		return result, fmt.Errorf("the server does not have RethinkDB enabled so it cannot import to the PKS")
+	}
+
+	if ki.options.KeyRing && ki.gpg == nil {
+		return result, fmt.Errorf("no keyring to import to")
+	}
+
+	batch := make([]importedKey, 0, ki.options.BatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		ki.importBatch(ctx, batch, &result)
+		batch = batch[:0]
+
+		if progress != nil {
+			progress(result)
+		}
+	}
+
+	onKey := func(e *openpgp.Entity) error {
+		result.Read++
+		key := importedKey{
+			index:       result.Read,
+			fingerPrint: tools.ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:]),
+		}
+
+		armored, err := armoredPublicEntity(e)
+		if err == nil {
+			err = CheckKeyLintPolicy(armored)
+		}
+
+		if err != nil {
+			result.Skipped++
+			addKeyImportError(&result, key, err)
+			return nil
+		}
+
+		key.armored = armored
+		batch = append(batch, key)
+
+		if len(batch) == ki.options.BatchSize {
+			flush()
+		}
+
+		return ctx.Err()
+	}
+
+	onSkipped := func(err error) {
+		result.Read++
+		result.Skipped++
+		addKeyImportError(&result, importedKey{index: result.Read}, err)
+	}
+
+	br := bufio.NewReaderSize(r, 4096)
+	start, _ := br.Peek(1)
+
+	var err error
+
+	// Binary OpenPGP data starts with a packet tag, which always has the most significant bit set
+	if len(start) > 0 && start[0]&0x80 == 0 {
+		// Armored dumps can have many blocks. The buffered reader is reused by the armor decoder, so no data is lost between blocks
+		for err == nil {
+			var block *armor.Block
+			block, err = armor.Decode(br)
+			if err == io.EOF {
+				err = nil
+				break
+			}
+
+			if err == nil {
+				err = openpgp.ReadKeyRingFunc(block.Body, onKey, onSkipped)
+			}
+		}
+	} else {
+		err = openpgp.ReadKeyRingFunc(br, onKey, onSkipped)
+	}
+
+	flush()
+
+	if err != nil {
+		log.Error("Error reading keyring dump after %d keys: %s", result.Read, err)
+		return result, err
+	}
+
+	result.Done = true
+	log.Info("Imported keyring dump: %d read, %d added, %d updated, %d unchanged, %d skipped, %d failed",
+		result.Read, result.Added, result.Updated, result.Unchanged, result.Skipped, result.Failed)
+
+	return result, nil
+}
+
+func (ki *KeyImporter) importBatch(ctx context.Context, batch []importedKey, result *models.KeyImportResult) {
+	if ki.options.PKS {
+		ki.importPKSBatch(ctx, batch, result)
+	}
+
+	if ki.options.KeyRing {
+		ki.importKeyRingBatch(ctx, batch, result)
+	}
+}
+
+// importPKSBatch merges the keys with the stored ones and writes the batch at once.
+// The user IDs of the imported keys are verified, since the dump is provided by an administrator
+func (ki *KeyImporter) importPKSBatch(ctx context.Context, batch []importedKey, result *models.KeyImportResult) {
+	conn := database.GetConnection()
+	limits := pksKeyLimits()
+
+	fullFingerPrints := make([]string, 0, len(batch))
+	entities := make(map[int]*openpgp.Entity, len(batch))
+
+	for i, key := range batch {
+		e, err := tools.ReadKeyToEntity(key.armored)
+		if err != nil {
+			continue
+		}
+		entities[i] = e
+		fullFingerPrints = append(fullFingerPrints, fullFingerPrint(e))
+	}
+
+	stored, err := models.GetGPGKeysByFullFingerPrints(conn, fullFingerPrints)
+	if err != nil {
+		for _, key := range batch {
+			result.Failed++
+			addKeyImportError(result, key, err)
+		}
+		return
+	}
+
+	existing := map[string]*models.GPGKey{}
+	for i := range stored {
+		existing[stored[i].FullFingerPrint] = &stored[i]
+	}
+
+	var added, updated, unchanged int
+	changed := map[string]*models.GPGKey{}
+	order := make([]string, 0, len(batch))
+
+	for i, key := range batch {
+		e, ok := entities[i]
+		if !ok {
+			result.Failed++
+			addKeyImportError(result, key, fmt.Errorf("cannot read the serialized key"))
+			continue
+		}
+
+		fp := fullFingerPrint(e)
+		current := existing[fp]
+		currentKey := ""
+		if current != nil {
+			currentKey = current.AsciiArmoredPublicKey
+		}
+
+		merged, mergeResult, err := tools.MergeKeysWithLimits(currentKey, key.armored, limits)
+		if err != nil {
+			result.Failed++
+			addKeyImportError(result, key, err)
+			continue
+		}
+
+		if !mergeResult.Changed && (current == nil || len(current.PendingUserIDs) == 0) {
+			unchanged++
+			continue
+		}
+
+		var newKey models.GPGKey
+		if ki.options.VerifiedUserIDs {
+			newKey, err = keyWithSyncedUserIDs(merged, current, key.armored)
+		} else {
+			newKey, err = keyWithVerification(merged, current, false)
+		}
+
+		if err != nil {
+			result.Failed++
+			addKeyImportError(result, key, err)
+			continue
+		}
+
+		if current != nil {
+			newKey.Id = current.Id
+		}
+
+		setSyncDigest(&newKey)
+
+		if changed[fp] == nil {
+			order = append(order, fp)
+			if mergeResult.Created {
+				added++
+			} else {
+				updated++
+			}
+		}
+
+		// Repeated keys in the dump are merged with the previous ones
+		changed[fp] = &newKey
+		existing[fp] = &newKey
+	}
+
+	keys := make([]models.GPGKey, 0, len(order))
+	for _, fp := range order {
+		keys = append(keys, *changed[fp])
+	}
+
+	if err := models.SaveGPGKeys(conn, keys); err != nil {
+		ki.log.Error("Error saving %d keys: %s", len(keys), err)
+		result.Failed += len(keys)
+		result.Unchanged += unchanged
+		for _, key := range batch {
+			addKeyImportError(result, key, err)
+		}
+		return
+	}
+
+	result.Added += added
+	result.Updated += updated
+	result.Unchanged += unchanged
+}
+
+// importKeyRingBatch saves the keys in the key backend and loads them in the keyring.
+// The keys are only counted here when they are not imported to the PKS
+func (ki *KeyImporter) importKeyRingBatch(ctx context.Context, batch []importedKey, result *models.KeyImportResult) {
+	for _, key := range batch {
+		_, err := ki.gpg.LoadKey(ctx, key.armored)
+		if err == nil {
+			err = ki.gpg.SaveKey(key.fingerPrint, key.armored, nil)
+		}
+
+		if err != nil {
+			result.Failed++
+			addKeyImportError(result, key, err)
+			continue
+		}
+
+		if !ki.options.PKS {
+			result.Added++
+		}
+	}
+}
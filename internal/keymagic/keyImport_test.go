@@ -0,0 +1,92 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"strings"
+	"testing"
+)
+
+func TestKeyImporter(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.KeyLintRejectLevel = ""
+
+	ctx := context.Background()
+	cfg := &packet.Config{RSABits: 1024}
+
+	binary := bytes.NewBuffer(nil)
+	armored := ""
+	fingerPrints := make([]string, 0)
+
+	for _, name := range []string{"Import One", "Import Two", "Import Three"} {
+		e, err := openpgp.NewEntity(name, "", "import@huebr.com", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := e.Serialize(binary); err != nil {
+			t.Fatal(err)
+		}
+
+		armored += armoredPublicKey(t, e) + "\n"
+		fingerPrints = append(fingerPrints, tools.ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:]))
+	}
+
+	// region Binary dump to keyring
+	gpg := MakePGPManager(nil, keybackend.MakeVoidBackend(), MakeKeyRingManager(nil))
+	importer := MakeKeyImporter(nil, gpg, models.KeyImportOptions{KeyRing: true, BatchSize: 2})
+
+	progress := 0
+	result, err := importer.Import(ctx, bytes.NewReader(binary.Bytes()), func(models.KeyImportResult) { progress++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Done || result.Read != 3 || result.Added != 3 || result.Skipped != 0 || result.Failed != 0 {
+		t.Errorf("Expected 3 keys added got %+v", result)
+	}
+
+	if progress != 2 {
+		t.Errorf("Expected progress after each of the 2 batches got %d", progress)
+	}
+
+	for _, fp := range fingerPrints {
+		if gpg.GetPublicKeyEntity(ctx, fp) == nil {
+			t.Errorf("Expected key %s in the keyring", fp)
+		}
+	}
+	// endregion
+
+	// region Armored dump with many blocks and an invalid key
+	gpg = MakePGPManager(nil, keybackend.MakeVoidBackend(), MakeKeyRingManager(nil))
+	importer = MakeKeyImporter(nil, gpg, models.KeyImportOptions{KeyRing: true})
+
+	result, err = importer.Import(ctx, strings.NewReader(armored), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Done || result.Read != 3 || result.Added != 3 {
+		t.Errorf("Expected 3 keys added got %+v", result)
+	}
+	// endregion
+
+	// region Errors
+	if _, err := MakeKeyImporter(nil, gpg, models.KeyImportOptions{}).Import(ctx, strings.NewReader(armored), nil); err == nil {
+		t.Errorf("Expected error without an import destination")
+	}
+
+	result, err = importer.Import(ctx, strings.NewReader("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nhuebr\n-----END PGP PUBLIC KEY BLOCK-----\n"), nil)
+	if err == nil || result.Done {
+		t.Errorf("Expected error reading an invalid dump got %+v", result)
+	}
+	// endregion
+}
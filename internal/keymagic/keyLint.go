@@ -0,0 +1,75 @@
+package keymagic
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"strings"
+	"time"
+)
+
+// LintKey lints every key in the armored data with MinKeyBits and KeyLintExpiringIn,
+// flagging the ones that would be rejected by KeyLintRejectLevel. Nothing is stored.
+func LintKey(armoredKey string) ([]models.KeyLintResult, error) {
+	keys, err := tools.ReadKey(armoredKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return LintEntities(keys)
+}
+
+// LintEntities lints the specified keys with MinKeyBits and KeyLintExpiringIn, flagging the ones that would be rejected by KeyLintRejectLevel
+func LintEntities(keys openpgp.EntityList) ([]models.KeyLintResult, error) {
+	expiringIn, err := time.ParseDuration(config.KeyLintExpiringIn)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing KEY_LINT_EXPIRING_IN: %s", err)
+	}
+
+	rejectLevel := tools.KeyLintSeverityLevel(config.KeyLintRejectLevel)
+	now := time.Now()
+	results := make([]models.KeyLintResult, 0, len(keys))
+
+	for _, key := range keys {
+		result := tools.LintEntity(key, MinKeyBits, expiringIn, now)
+		result.Rejected = rejectLevel > 0 && tools.KeyLintSeverityLevel(result.Severity) >= rejectLevel
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// CheckKeyLintPolicy lints the armored key and returns an error describing the issues of the keys rejected by KeyLintRejectLevel
+func CheckKeyLintPolicy(armoredKey string) error {
+	if tools.KeyLintSeverityLevel(config.KeyLintRejectLevel) == 0 {
+		return nil
+	}
+
+	results, err := LintKey(armoredKey)
+	if err != nil {
+		return err
+	}
+
+	problems := make([]string, 0)
+	rejectLevel := tools.KeyLintSeverityLevel(config.KeyLintRejectLevel)
+
+	for _, result := range results {
+		if !result.Rejected {
+			continue
+		}
+
+		for _, issue := range result.Issues {
+			if tools.KeyLintSeverityLevel(issue.Severity) >= rejectLevel {
+				problems = append(problems, fmt.Sprintf("%s: %s", issue.KeyID, issue.Message))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("key rejected by lint policy (%s): %s", config.KeyLintRejectLevel, strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/database"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+)
+
+const defaultPKSRequestMaxAge = 5 * time.Minute
+const minKeyOperationNonceLength = 16
+const maxKeyOperationNonceLength = 128
+
+func pksRequestMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(config.PKSRequestMaxAge)
+	if err != nil || maxAge <= 0 {
+		pksLog.Error("Invalid PKS_REQUEST_MAX_AGE %q. Using %s", config.PKSRequestMaxAge, defaultPKSRequestMaxAge)
+		return defaultPKSRequestMaxAge
+	}
+
+	return maxAge
+}
+
+func fullFingerPrint(e *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+}
+
+// ParsePKSAdminKeys returns the fingerprints of PKS_ADMIN_KEYS. Only full 40 characters fingerprints are accepted,
+// since shorter ones could match other keys uploaded to the PKS
+func ParsePKSAdminKeys() ([]string, error) {
+	fps := make([]string, 0)
+
+	for _, fp := range strings.Split(config.PKSAdminKeys, ",") {
+		fp = strings.ToUpper(strings.TrimSpace(fp))
+		if fp == "" {
+			continue
+		}
+
+		if _, err := hex.DecodeString(fp); err != nil || len(fp) != 40 {
+			return nil, fmt.Errorf("PKS admin key %s is not a 40 characters fingerprint", fp)
+		}
+
+		fps = append(fps, fp)
+	}
+
+	return fps, nil
+}
+
+// pksAdminKeys returns the stored public keys of PKS_ADMIN_KEYS
+func pksAdminKeys() openpgp.EntityList {
+	conn := database.GetConnection()
+	admins := openpgp.EntityList{}
+
+	fps, err := ParsePKSAdminKeys()
+	if err != nil {
+		pksLog.Error("Ignoring PKS_ADMIN_KEYS: %s", err)
+		return admins
+	}
+
+	for _, fp := range fps {
+		key, err := models.GetGPGKeyByFingerPrint(conn, fp)
+		if err != nil {
+			pksLog.Warn("PKS admin key %s was not found in the PKS", fp)
+			continue
+		}
+
+		e, err := tools.ReadKeyToEntity(key.AsciiArmoredPublicKey)
+		if err != nil {
+			pksLog.Error("Error reading PKS admin key %s: %s", fp, err)
+			continue
+		}
+
+		if fullFingerPrint(e) != fp {
+			pksLog.Error("PKS admin key %s resolved to key %s", fp, fullFingerPrint(e))
+			continue
+		}
+
+		admins = append(admins, e)
+	}
+
+	return admins
+}
+
+// decodeKeyOperation decodes the operation of the request without checking its signature
+func decodeKeyOperation(req models.SKSSignedKeyOperation) ([]byte, models.SKSKeyOperation, error) {
+	var op models.SKSKeyOperation
+
+	data, err := base64.StdEncoding.DecodeString(req.Base64Data)
+	if err != nil {
+		return nil, op, fmt.Errorf("invalid Base64Data: %s", err)
+	}
+
+	err = json.Unmarshal(data, &op)
+	if err != nil {
+		return nil, op, fmt.Errorf("invalid operation data: %s", err)
+	}
+
+	return data, op, nil
+}
+
+// checkKeyOperation decodes the signed operation and checks that it was signed by target or by one of the admins,
+// is the expected operation and is not older than maxAge. Returns the operation and the signer fingerprint
+func checkKeyOperation(req models.SKSSignedKeyOperation, operation string, target *openpgp.Entity, admins openpgp.EntityList, maxAge time.Duration) (models.SKSKeyOperation, string, error) {
+	data, op, err := decodeKeyOperation(req)
+	if err != nil {
+		return op, "", err
+	}
+
+	keyRing := append(openpgp.EntityList{target}, admins...)
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyRing, bytes.NewReader(data), strings.NewReader(tools.SignatureFix(req.Signature)))
+	if err != nil {
+		return op, "", fmt.Errorf("invalid signature: %s", err)
+	}
+
+	if op.Operation != operation {
+		return op, "", fmt.Errorf("the signed operation is %q and not %q", op.Operation, operation)
+	}
+
+	if !tools.CompareFingerPrint(strings.ToUpper(op.FingerPrint), fullFingerPrint(target)) {
+		return op, "", fmt.Errorf("the signed fingerprint %s is not from key %s", op.FingerPrint, fullFingerPrint(target))
+	}
+
+	if len(op.Nonce) < minKeyOperationNonceLength || len(op.Nonce) > maxKeyOperationNonceLength {
+		return op, "", fmt.Errorf("the nonce should have between %d and %d characters", minKeyOperationNonceLength, maxKeyOperationNonceLength)
+	}
+
+	age := time.Since(op.Timestamp)
+	if age > maxAge || age < -maxAge {
+		return op, "", fmt.Errorf("the request timestamp %s is outside of the allowed window of %s", op.Timestamp.UTC().Format(time.RFC3339), maxAge)
+	}
+
+	return op, fullFingerPrint(signer), nil
+}
+
+// verifyKeyOperation checks the signed operation against the stored key and the PKS admin keys and consumes its nonce
+func verifyKeyOperation(ctx context.Context, req models.SKSSignedKeyOperation, operation string) (*models.GPGKey, models.SKSKeyOperation, string, error) {
+	log := pksLog.Tag(tools.GetRequestIDFromContext(ctx))
+
+	if !config.EnableRethinkSKS {
+		return nil, models.SKSKeyOperation{}, "", fmt.Errorf("the server does not have RethinkDB enabled so it cannot change keys")
+	}
+
+	_, unverified, err := decodeKeyOperation(req)
+	if err != nil {
+		return nil, unverified, "", err
+	}
+
+	if len(unverified.FingerPrint) < 16 {
+		return nil, unverified, "", fmt.Errorf("the fingerprint should have at least 16 characters")
+	}
+
+	conn := database.GetConnection()
+	key, err := models.GetGPGKeyByFingerPrint(conn, strings.ToUpper(unverified.FingerPrint))
+	if err != nil {
+		return nil, unverified, "", fmt.Errorf("key %s not found", unverified.FingerPrint)
+	}
+
+	target, err := tools.ReadKeyToEntity(key.AsciiArmoredPublicKey)
+	if err != nil {
+		return nil, models.SKSKeyOperation{}, "", err
+	}
+
+	maxAge := pksRequestMaxAge()
+	op, signer, err := checkKeyOperation(req, operation, target, pksAdminKeys(), maxAge)
+	if err != nil {
+		log.Warn("Refused %s of key %s: %s", operation, key.GetShortFingerPrint(), err)
+		return nil, op, "", err
+	}
+
+	err = models.DeleteExpiredPKSNonces(conn, time.Now())
+	if err != nil {
+		log.Error("Error removing the expired nonces: %s", err)
+	}
+
+	// The nonce is kept until the request timestamp is out of the allowed window
+	added, err := models.AddPKSNonce(conn, models.PKSNonce{
+		Id:         hashToken(op.Nonce),
+		Expiration: op.Timestamp.Add(maxAge),
+	})
+
+	if err != nil {
+		return nil, op, "", err
+	}
+
+	if !added {
+		log.Warn("Refused %s of key %s: replayed nonce", operation, key.GetShortFingerPrint())
+		return nil, op, "", fmt.Errorf("the nonce was already used")
+	}
+
+	return key, op, signer, nil
+}
+
+// auditKeyOperation records the operation in the audit entries. entry has the fields specific to the operation
+func auditKeyOperation(ctx context.Context, op models.SKSKeyOperation, fingerPrint, signer string, entry models.PKSAuditEntry) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pksLog.Tag(requestID)
+
+	entry.Operation = op.Operation
+	entry.FingerPrint = fingerPrint
+	entry.SignerFingerPrint = signer
+	entry.Nonce = op.Nonce
+	entry.RequestID = requestID
+	entry.Time = time.Now()
+
+	_, err := models.AddPKSAuditEntry(database.GetConnection(), entry)
+
+	if err != nil {
+		log.Error("Error writing the audit entry of %s of key %s by %s: %s", op.Operation, fingerPrint, signer, err)
+	}
+}
+
+// removedComponents returns an audit entry with the user IDs and subkeys of previous that are not in updated
+func removedComponents(previous, updated string) (models.PKSAuditEntry, error) {
+	entry := models.PKSAuditEntry{}
+
+	previousUserIDs, previousSubKeys, err := tools.KeyComponents(previous)
+	if err != nil {
+		return entry, err
+	}
+
+	userIDs, subKeys, err := tools.KeyComponents(updated)
+	if err != nil {
+		return entry, err
+	}
+
+	present := map[string]bool{}
+	for _, c := range append(userIDs, subKeys...) {
+		present[c] = true
+	}
+
+	for _, id := range previousUserIDs {
+		if !present[id] {
+			entry.RemovedUserIDs = append(entry.RemovedUserIDs, id)
+		}
+	}
+
+	for _, fp := range previousSubKeys {
+		if !present[fp] {
+			entry.RemovedSubKeys = append(entry.RemovedSubKeys, fp)
+		}
+	}
+
+	return entry, nil
+}
+
+// PKSRemoveKey removes the key from the PKS. The request should be signed by the key itself or by one of the PKS_ADMIN_KEYS
+func PKSRemoveKey(ctx context.Context, req models.SKSSignedKeyOperation) error {
+	log := pksLog.Tag(tools.GetRequestIDFromContext(ctx))
+	log.DebugNote("PKSRemoveKey(---)")
+
+	key, op, signer, err := verifyKeyOperation(ctx, req, models.SKSKeyOperationRemove)
+	if err != nil {
+		return err
+	}
+
+	err = key.Delete(database.GetConnection())
+	if err != nil {
+		return err
+	}
+
+	auditKeyOperation(ctx, op, key.FullFingerPrint, signer, models.PKSAuditEntry{})
+	log.Info("Key %s removed from PKS by %s", key.GetShortFingerPrint(), signer)
+
+	return nil
+}
+
+// PKSUpdateKey replaces the stored key with the signed public key, allowing to remove user IDs and subkeys.
+// The request should be signed by the key itself or by one of the PKS_ADMIN_KEYS
+func PKSUpdateKey(ctx context.Context, req models.SKSSignedKeyOperation) error {
+	log := pksLog.Tag(tools.GetRequestIDFromContext(ctx))
+	log.DebugNote("PKSUpdateKey(---)")
+
+	key, op, signer, err := verifyKeyOperation(ctx, req, models.SKSKeyOperationUpdate)
+	if err != nil {
+		return err
+	}
+
+	err = CheckKeyLintPolicy(op.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	updated, _, err := tools.MergeKeysWithLimits("", op.PublicKey, pksKeyLimits())
+	if err != nil {
+		return fmt.Errorf("invalid public key: %s", err)
+	}
+
+	// Only user IDs and subkeys can be removed, so the stored revocations are kept
+	updated, kept, err := tools.KeepRevocations(key.AsciiArmoredPublicKey, updated)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %s", err)
+	}
+
+	if kept > 0 {
+		log.Warn("The update of key %s did not have %d stored revocations. Keeping them", key.GetShortFingerPrint(), kept)
+	}
+
+	e, err := tools.ReadKeyToEntity(updated)
+	if err != nil {
+		return err
+	}
+
+	if fullFingerPrint(e) != key.FullFingerPrint {
+		return fmt.Errorf("the public key %s is not key %s", fullFingerPrint(e), key.FullFingerPrint)
+	}
+
+	// The signed request proves the ownership of the key like a verification challenge
+	newKey, err := keyWithVerification(updated, key, true)
+	if err != nil {
+		return err
+	}
+
+	newKey.Id = key.Id
+	setSyncDigest(&newKey)
+
+	removed, err := removedComponents(key.AsciiArmoredPublicKey, updated)
+	if err != nil {
+		return err
+	}
+
+	err = newKey.Save(database.GetConnection())
+	if err != nil {
+		return err
+	}
+
+	auditKeyOperation(ctx, op, key.FullFingerPrint, signer, removed)
+	log.Info("Key %s updated in PKS by %s", key.GetShortFingerPrint(), signer)
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package keymagic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+)
+
+func signKeyOperation(t *testing.T, signer *openpgp.Entity, op models.SKSKeyOperation) models.SKSSignedKeyOperation {
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := bytes.NewBuffer(nil)
+	if err := openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return models.SKSSignedKeyOperation{
+		Base64Data: base64.StdEncoding.EncodeToString(data),
+		Signature:  sig.String(),
+	}
+}
+
+func TestCheckKeyOperation(t *testing.T) {
+	cfg := &packet.Config{RSABits: 1024}
+	target, err := openpgp.NewEntity("Target", "", "target@huebr.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, err := openpgp.NewEntity("Admin", "", "admin@huebr.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := openpgp.NewEntity("Other", "", "other@huebr.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := models.SKSKeyOperation{
+		Operation:   models.SKSKeyOperationRemove,
+		FingerPrint: fullFingerPrint(target),
+		Nonce:       randomToken(),
+		Timestamp:   time.Now(),
+	}
+
+	admins := openpgp.EntityList{admin}
+
+	_, signer, err := checkKeyOperation(signKeyOperation(t, target, op), models.SKSKeyOperationRemove, target, admins, time.Minute)
+	if err != nil || signer != fullFingerPrint(target) {
+		t.Errorf("Expected operation signed by the key to be accepted got %q %v", signer, err)
+	}
+
+	_, signer, err = checkKeyOperation(signKeyOperation(t, admin, op), models.SKSKeyOperationRemove, target, admins, time.Minute)
+	if err != nil || signer != fullFingerPrint(admin) {
+		t.Errorf("Expected operation signed by the admin to be accepted got %q %v", signer, err)
+	}
+
+	refused := map[string]struct {
+		signer    *openpgp.Entity
+		op        models.SKSKeyOperation
+		operation string
+	}{
+		"other signer":      {other, op, models.SKSKeyOperationRemove},
+		"other operation":   {target, op, models.SKSKeyOperationUpdate},
+		"other fingerprint": {target, models.SKSKeyOperation{Operation: op.Operation, FingerPrint: fullFingerPrint(other), Nonce: op.Nonce, Timestamp: op.Timestamp}, models.SKSKeyOperationRemove},
+		"short nonce":       {target, models.SKSKeyOperation{Operation: op.Operation, FingerPrint: op.FingerPrint, Nonce: "123", Timestamp: op.Timestamp}, models.SKSKeyOperationRemove},
+		"old request":       {target, models.SKSKeyOperation{Operation: op.Operation, FingerPrint: op.FingerPrint, Nonce: op.Nonce, Timestamp: op.Timestamp.Add(-time.Hour)}, models.SKSKeyOperationRemove},
+	}
+
+	for name, c := range refused {
+		_, _, err = checkKeyOperation(signKeyOperation(t, c.signer, c.op), c.operation, target, admins, time.Minute)
+		if err == nil {
+			t.Errorf("Expected %s to be refused", name)
+		}
+	}
+
+	// The signed data cannot be changed
+	req := signKeyOperation(t, target, op)
+	tampered := op
+	tampered.Nonce = strings.Repeat("0", 32)
+	data, _ := json.Marshal(tampered)
+	req.Base64Data = base64.StdEncoding.EncodeToString(data)
+
+	_, _, err = checkKeyOperation(req, models.SKSKeyOperationRemove, target, admins, time.Minute)
+	if err == nil {
+		t.Errorf("Expected tampered operation to be refused")
+	}
+}
+
+func TestParsePKSAdminKeys(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.PKSAdminKeys = " 0551f452abe463a4c8ff6b8f2e3d1f5b9a0c7e21 ,"
+	fps, err := ParsePKSAdminKeys()
+	if err != nil || len(fps) != 1 || fps[0] != "0551F452ABE463A4C8FF6B8F2E3D1F5B9A0C7E21" {
+		t.Errorf("Expected one upper case fingerprint got %v (%v)", fps, err)
+	}
+
+	for _, keys := range []string{"0551F452ABE463A4", "0551F452ABE463A4C8FF6B8F2E3D1F5B9A0C7E2Z"} {
+		config.PKSAdminKeys = keys
+		if _, err := ParsePKSAdminKeys(); err == nil {
+			t.Errorf("Expected %s to be refused", keys)
+		}
+	}
+}
@@ -1,23 +1,45 @@
 package keymagic
 
 import (
+	"container/list"
 	"context"
 	"fmt"
 	"github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
 	"sync"
+	"time"
 
 	"github.com/quan-to/chevron/pkg/openpgp"
 	"github.com/quan-to/slog"
 )
 
+type cacheEntry struct {
+	fingerPrint string
+	expiresAt   time.Time
+	refreshing  bool
+}
+
+// KeyRingManager is a LRU cache of public keys fetched from the PKS.
+// Cached keys expire after KeyRingCacheTTL and are refreshed in background on the next use,
+// and fingerprints not found are cached for KeyRingNegativeCacheTTL, up to MaxKeyRingCache of them
 type KeyRingManager struct {
 	sync.Mutex
-	fingerPrints []string
-	entities     map[string]*openpgp.Entity
-	keyInfo      map[string]models.KeyInfo
-	log          slog.Instance
+	// lru has the erasable keys, the most recently used first
+	lru      *list.List
+	lruIndex map[string]*list.Element
+	entities map[string]*openpgp.Entity
+	keyInfo  map[string]models.KeyInfo
+	// notFound has the fingerprints not found, the most recently added first. All of them have the same TTL,
+	// so the oldest ones are also the first to expire
+	notFound      *list.List
+	notFoundIndex map[string]*list.Element
+	ttl           time.Duration
+	negativeTTL   time.Duration
+	stats         models.KeyRingCacheStats
+	fetchKey      func(ctx context.Context, fingerPrint string) (string, error)
+	log           slog.Instance
 }
 
 // MakeKeyRingManager creates a new instance of KeyRingManager
@@ -28,55 +50,128 @@ func MakeKeyRingManager(log slog.Instance) *KeyRingManager {
 		log = log.SubScope("KRM")
 	}
 
+	ttl, err := time.ParseDuration(config.KeyRingCacheTTL)
+	if err != nil {
+		slog.Fatal("Error parsing KEY_RING_CACHE_TTL: %s", err)
+	}
+
+	negativeTTL, err := time.ParseDuration(config.KeyRingNegativeCacheTTL)
+	if err != nil {
+		slog.Fatal("Error parsing KEY_RING_NEGATIVE_CACHE_TTL: %s", err)
+	}
+
 	return &KeyRingManager{
-		fingerPrints: make([]string, 0),
-		entities:     make(map[string]*openpgp.Entity),
-		keyInfo:      make(map[string]models.KeyInfo),
-		log:          log,
+		lru:           list.New(),
+		lruIndex:      make(map[string]*list.Element),
+		entities:      make(map[string]*openpgp.Entity),
+		keyInfo:       make(map[string]models.KeyInfo),
+		notFound:      list.New(),
+		notFoundIndex: make(map[string]*list.Element),
+		ttl:           ttl,
+		negativeTTL:   negativeTTL,
+		fetchKey:      PKSGetKey,
+		log:           log,
+	}
+}
+
+func (krm *KeyRingManager) removeNotFound(fp string) {
+	if el, ok := krm.notFoundIndex[fp]; ok {
+		krm.notFound.Remove(el)
+		delete(krm.notFoundIndex, fp)
 	}
 }
 
-func (krm *KeyRingManager) containsFp(fp string) bool {
-	for _, v := range krm.fingerPrints {
-		if v == fp {
-			return true
+// pruneNotFound removes the expired fingerprints not found and, if max is not negative,
+// the oldest ones until there are at most max of them
+func (krm *KeyRingManager) pruneNotFound(max int) {
+	now := time.Now()
+
+	for el := krm.notFound.Back(); el != nil; el = krm.notFound.Back() {
+		entry := el.Value.(*cacheEntry)
+		if now.Before(entry.expiresAt) && (max < 0 || krm.notFound.Len() <= max) {
+			break
 		}
+		krm.notFound.Remove(el)
+		delete(krm.notFoundIndex, entry.fingerPrint)
+	}
+}
+
+// addNotFound caches that the fingerprint was not found, keeping at most MaxKeyRingCache fingerprints
+func (krm *KeyRingManager) addNotFound(fp string) {
+	krm.removeNotFound(fp)
+
+	max := config.MaxKeyRingCache
+	if max > 0 {
+		krm.pruneNotFound(max - 1)
+	} else {
+		krm.pruneNotFound(-1)
 	}
 
-	return false
+	krm.notFoundIndex[fp] = krm.notFound.PushFront(&cacheEntry{
+		fingerPrint: fp,
+		expiresAt:   time.Now().Add(krm.negativeTTL),
+	})
+}
+
+func (krm *KeyRingManager) expiration() time.Time {
+	if krm.ttl <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(krm.ttl)
 }
 
 func (krm *KeyRingManager) removeFp(fp string) {
-	for i, v := range krm.fingerPrints {
-		if v == fp {
-			krm.fingerPrints = append(krm.fingerPrints[:i], krm.fingerPrints[i+1:]...)
-			delete(krm.entities, fp)
-			delete(krm.keyInfo, fp)
-			return
-		}
+	if el, ok := krm.lruIndex[fp]; ok {
+		krm.lru.Remove(el)
+		delete(krm.lruIndex, fp)
 	}
+
+	delete(krm.entities, fp)
+	delete(krm.keyInfo, fp)
 }
 
 func (krm *KeyRingManager) addFp(fp string) {
-	krm.fingerPrints = append(krm.fingerPrints, fp)
+	krm.lruIndex[fp] = krm.lru.PushFront(&cacheEntry{
+		fingerPrint: fp,
+		expiresAt:   krm.expiration(),
+	})
 }
 
 func (krm *KeyRingManager) AddKey(ctx context.Context, key *openpgp.Entity, nonErasable bool) {
+	krm.addKey(ctx, key, nonErasable, false)
+}
+
+// addKey adds the key and its subkeys to the cache. If replace is true, already cached keys are replaced and their expiration is renewed
+func (krm *KeyRingManager) addKey(ctx context.Context, key *openpgp.Entity, nonErasable, replace bool) {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := krm.log.Tag(requestID)
 	log.DebugNote("AddKey(---, %v)", nonErasable)
 	krm.Lock()
 	fp := tools.ByteFingerPrint2FP16(key.PrimaryKey.Fingerprint[:])
-	if krm.containsFp(fp) {
+	el, cached := krm.lruIndex[fp]
+	if cached && !nonErasable && !replace {
 		log.Debug("Key %s already in keyring", fp)
 		krm.Unlock()
 		return
 	}
-	if !nonErasable {
-		if len(krm.fingerPrints)+1 > config.MaxKeyRingCache {
-			lastFp := krm.fingerPrints[0]
-			log.Debug("	There are more cached keys than allowed. Removing first key %s", lastFp)
+
+	krm.removeNotFound(fp)
+
+	if cached {
+		entry := el.Value.(*cacheEntry)
+		entry.expiresAt = krm.expiration()
+		entry.refreshing = false
+		if nonErasable {
+			krm.lru.Remove(el)
+			delete(krm.lruIndex, fp)
+		}
+	} else if !nonErasable {
+		for krm.lru.Len() > 0 && krm.lru.Len()+1 > config.MaxKeyRingCache {
+			lastFp := krm.lru.Back().Value.(*cacheEntry).fingerPrint
+			log.Debug("	There are more cached keys than allowed. Removing least recently used key %s", lastFp)
 			krm.removeFp(lastFp)
+			krm.stats.Evictions++
 		}
 		krm.addFp(fp)
 	}
@@ -101,7 +196,7 @@ func (krm *KeyRingManager) AddKey(ctx context.Context, key *openpgp.Entity, nonE
 		subfp := tools.ByteFingerPrint2FP16(sub.PublicKey.Fingerprint[:])
 		subE := tools.CreateEntityForSubKey(fp, sub.PublicKey, sub.PrivateKey)
 		log.Debug("	Adding also subkey %s", subfp)
-		krm.AddKey(ctx, subE, nonErasable)
+		krm.addKey(ctx, subE, nonErasable, replace)
 	}
 }
 
@@ -113,7 +208,7 @@ func (krm *KeyRingManager) DeleteKey(ctx context.Context, fp string) error {
 	keyFound := false
 	if _, ok := krm.entities[fp]; ok {
 		log.Info("Deleting key %s from memory", fp)
-		delete(krm.entities, fp)
+		krm.removeFp(fp)
 		keyFound = true
 	}
 	krm.Unlock()
@@ -139,6 +234,24 @@ func (krm *KeyRingManager) GetCachedKeys(ctx context.Context) []models.KeyInfo {
 	return arr
 }
 
+// GetCacheStats returns the hit, miss and eviction counters of the cache
+func (krm *KeyRingManager) GetCacheStats(ctx context.Context) models.KeyRingCacheStats {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := krm.log.Tag(requestID)
+	log.DebugNote("GetCacheStats()")
+	krm.Lock()
+	defer krm.Unlock()
+
+	krm.pruneNotFound(-1)
+
+	stats := krm.stats
+	stats.Size = krm.lru.Len()
+	stats.MaxSize = config.MaxKeyRingCache
+	stats.NegativeSize = krm.notFound.Len()
+
+	return stats
+}
+
 func (krm *KeyRingManager) ContainsKey(ctx context.Context, fp string) bool {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := krm.log.Tag(requestID)
@@ -149,38 +262,131 @@ func (krm *KeyRingManager) ContainsKey(ctx context.Context, fp string) bool {
 	return krm.entities[fp] != nil
 }
 
+// fetch gets the key from the PKS, returning nil if it was not found or is invalid.
+// notFound is only true if the PKS reported that the key does not exist
+func (krm *KeyRingManager) fetch(ctx context.Context, fp string) (ent *openpgp.Entity, notFound bool) {
+	log := krm.log.Tag(tools.GetRequestIDFromContext(ctx))
+
+	asciiArmored, err := krm.fetchKey(ctx, fp)
+
+	if err == interfaces.ErrKeyNotFound {
+		return nil, true
+	}
+
+	if err != nil {
+		log.Error("Error fetching from KeyStore: %s", err)
+		return nil, false
+	}
+
+	if len(asciiArmored) == 0 {
+		return nil, true
+	}
+
+	k, err := tools.ReadKeyToEntity(asciiArmored)
+	if err != nil {
+		log.Error("Invalid key received from PKS! Error: %s", err)
+		return nil, false
+	}
+
+	return k, false
+}
+
+// refresh fetches an expired key again. If the PKS does not have it anymore it is removed from the cache,
+// and if it cannot be fetched the cached one is kept without renewing its expiration
+func (krm *KeyRingManager) refresh(ctx context.Context, fp string) {
+	log := krm.log.Tag(tools.GetRequestIDFromContext(ctx))
+	log.Debug("Refreshing expired key %s", fp)
+
+	ent, notFound := krm.fetch(ctx, fp)
+
+	krm.Lock()
+	if ent == nil {
+		if notFound {
+			log.Warn("Key %s not found in KeyStore anymore. Removing it from the cache", fp)
+			if cached := krm.entities[fp]; cached != nil {
+				for _, sub := range cached.Subkeys {
+					krm.removeFp(tools.ByteFingerPrint2FP16(sub.PublicKey.Fingerprint[:]))
+				}
+			}
+			krm.removeFp(fp)
+			if krm.negativeTTL > 0 {
+				krm.addNotFound(fp)
+			}
+		} else if el, ok := krm.lruIndex[fp]; ok {
+			log.Warn("Cannot refresh key %s. Keeping the cached one", fp)
+			el.Value.(*cacheEntry).refreshing = false
+		}
+		krm.Unlock()
+		return
+	}
+
+	if el, ok := krm.lruIndex[fp]; ok {
+		// The fetched key may not contain fp anymore if it was a removed subkey
+		entry := el.Value.(*cacheEntry)
+		entry.expiresAt = krm.expiration()
+		entry.refreshing = false
+	}
+	krm.stats.Refreshes++
+	krm.Unlock()
+
+	krm.addKey(ctx, ent, false, true)
+}
+
 func (krm *KeyRingManager) GetKey(ctx context.Context, fp string) *openpgp.Entity {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := krm.log.Tag(requestID)
 	log.DebugNote("GetKey(%s)", fp)
 	krm.Lock()
 	ent := krm.entities[fp]
-	krm.Unlock()
 
 	if ent != nil {
+		if el, ok := krm.lruIndex[fp]; ok {
+			krm.lru.MoveToFront(el)
+			entry := el.Value.(*cacheEntry)
+			if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+				krm.stats.StaleHits++
+				if !entry.refreshing {
+					entry.refreshing = true
+					go krm.refresh(context.Background(), fp)
+				}
+				krm.Unlock()
+				return ent
+			}
+		}
+		krm.stats.Hits++
+		krm.Unlock()
 		return ent
 	}
 
+	if el, ok := krm.notFoundIndex[fp]; ok {
+		if time.Now().Before(el.Value.(*cacheEntry).expiresAt) {
+			krm.stats.NegativeHits++
+			krm.Unlock()
+			log.Debug("Key %s was recently not found in KeyStore", fp)
+			return nil
+		}
+		krm.removeNotFound(fp)
+	}
+
+	krm.stats.Misses++
+	krm.Unlock()
+
 	// Try fetch SKS
 	log.Await("Key %s not found in local cache. Trying fetch KeyStore", fp)
 
-	asciiArmored, err := PKSGetKey(ctx, fp)
+	ent, notFound := krm.fetch(ctx, fp)
 
-	if err != nil {
-		log.Error("Error fetching from KeyStore: %s", err)
+	if ent == nil {
+		if notFound && krm.negativeTTL > 0 {
+			krm.Lock()
+			krm.addNotFound(fp)
+			krm.Unlock()
+		}
 		return nil
 	}
 
-	if len(asciiArmored) > 0 {
-		k, err := tools.ReadKeyToEntity(asciiArmored)
-		if err != nil {
-			log.Error("Invalid key received from PKS! Error: %s", err)
-			return nil
-		}
-		log.Info("Key %s found in PKS. Adding to local cache", fp)
-		ent = k
-		krm.AddKey(ctx, k, false)
-	}
+	log.Info("Key %s found in PKS. Adding to local cache", fp)
+	krm.AddKey(ctx, ent, false)
 
 	return ent
 }
@@ -189,5 +395,13 @@ func (krm *KeyRingManager) GetFingerPrints(ctx context.Context) []string {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := krm.log.Tag(requestID)
 	log.DebugNote("GetFingerPrints()")
-	return krm.fingerPrints
+	krm.Lock()
+	defer krm.Unlock()
+
+	fps := make([]string, 0, krm.lru.Len())
+	for el := krm.lru.Front(); el != nil; el = el.Next() {
+		fps = append(fps, el.Value.(*cacheEntry).fingerPrint)
+	}
+
+	return fps
 }
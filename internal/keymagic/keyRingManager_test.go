@@ -2,6 +2,7 @@ package keymagic
 
 import (
 	"context"
+	"fmt"
 	remote_signer "github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/database"
 	"github.com/quan-to/chevron/internal/keybackend"
@@ -12,6 +13,7 @@ import (
 	"github.com/quan-to/chevron/test"
 	"io/ioutil"
 	"testing"
+	"time"
 )
 
 func TestAddKey(t *testing.T) {
@@ -284,3 +286,168 @@ func TestGetKeyExternal(t *testing.T) {
 //        t.Error(err)
 //    }
 //}
+
+func TestKeyRingManagerCache(t *testing.T) {
+	ctx := context.Background()
+	remote_signer.PushVariables()
+	defer remote_signer.PopVariables()
+	remote_signer.MaxKeyRingCache = 2
+	remote_signer.KeyRingCacheTTL = "1h"
+	remote_signer.KeyRingNegativeCacheTTL = "1h"
+
+	pgp := MakePGPManager(nil, keybackend.MakeVoidBackend(), MakeKeyRingManager(nil))
+	pks := map[string]string{}
+	fps := make([]string, 0)
+
+	for i := 0; i < 3; i++ {
+		key, err := pgp.GenerateTestKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, _ := tools.GetFingerPrintFromKey(key)
+		pks[fp] = key
+		fps = append(fps, fp)
+	}
+
+	fetches := 0
+	krm := MakeKeyRingManager(nil)
+	krm.fetchKey = func(ctx context.Context, fingerPrint string) (string, error) {
+		fetches++
+		if key, ok := pks[fingerPrint]; ok {
+			return key, nil
+		}
+		if fingerPrint == "EEEEEEEEEEEEEEEE" {
+			return "", fmt.Errorf("connection refused")
+		}
+		return "", interfaces.ErrKeyNotFound
+	}
+
+	krm.GetKey(ctx, fps[0])
+	krm.GetKey(ctx, fps[1])
+	krm.GetKey(ctx, fps[0]) // fps[1] is now the least recently used
+	krm.GetKey(ctx, fps[2])
+
+	if !krm.ContainsKey(ctx, fps[0]) || krm.ContainsKey(ctx, fps[1]) || !krm.ContainsKey(ctx, fps[2]) {
+		t.Errorf("Expected %s to be evicted, cached %v", fps[1], krm.GetFingerPrints(ctx))
+	}
+
+	if krm.GetKey(ctx, "ABCDABCDABCDABCD") != nil || krm.GetKey(ctx, "ABCDABCDABCDABCD") != nil {
+		t.Errorf("Expected unknown key to not be found")
+	}
+
+	if fetches != 4 {
+		t.Errorf("Expected 4 fetches got %d", fetches)
+	}
+
+	stats := krm.GetCacheStats(ctx)
+	if stats.Hits != 1 || stats.Misses != 4 || stats.NegativeHits != 1 || stats.Evictions != 1 || stats.Size != 2 || stats.NegativeSize != 1 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+
+	// Expired keys are returned while refreshed
+	krm.Lock()
+	krm.lruIndex[fps[0]].Value.(*cacheEntry).expiresAt = time.Now().Add(-time.Second)
+	krm.Unlock()
+
+	if krm.GetKey(ctx, fps[0]) == nil {
+		t.Fatalf("Expected stale key to be returned")
+	}
+
+	for i := 0; i < 100 && krm.GetCacheStats(ctx).Refreshes == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats = krm.GetCacheStats(ctx)
+	if stats.StaleHits != 1 || stats.Refreshes != 1 {
+		t.Errorf("Expected the stale key to be refreshed got %+v", stats)
+	}
+
+	// Fetch errors are not cached as not found
+	krm.GetKey(ctx, "EEEEEEEEEEEEEEEE")
+	krm.GetKey(ctx, "EEEEEEEEEEEEEEEE")
+
+	if stats = krm.GetCacheStats(ctx); stats.NegativeHits != 1 || stats.NegativeSize != 1 {
+		t.Errorf("Expected the fetch error to not be cached got %+v", stats)
+	}
+
+	// Fingerprints not found are limited to MaxKeyRingCache
+	krm.GetKey(ctx, "AAAAAAAAAAAAAAAA")
+	krm.GetKey(ctx, "BBBBBBBBBBBBBBBB")
+
+	if stats = krm.GetCacheStats(ctx); stats.NegativeSize != 2 {
+		t.Errorf("Expected 2 fingerprints not found to be cached got %+v", stats)
+	}
+
+	if krm.GetKey(ctx, "BBBBBBBBBBBBBBBB"); krm.GetCacheStats(ctx).NegativeHits != 2 {
+		t.Errorf("Expected the most recent fingerprint not found to be kept")
+	}
+}
+
+func TestKeyRingManagerRefresh(t *testing.T) {
+	ctx := context.Background()
+	remote_signer.PushVariables()
+	defer remote_signer.PopVariables()
+	remote_signer.KeyRingCacheTTL = "1h"
+	remote_signer.KeyRingNegativeCacheTTL = "1h"
+
+	key := test.TestPublicKey
+	ent, err := tools.ReadKeyToEntity(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := tools.ByteFingerPrint2FP16(ent.PrimaryKey.Fingerprint[:])
+	subFp := tools.ByteFingerPrint2FP16(ent.Subkeys[0].PublicKey.Fingerprint[:])
+
+	var fetchErr error
+	fetches := 0
+	krm := MakeKeyRingManager(nil)
+	krm.fetchKey = func(ctx context.Context, fingerPrint string) (string, error) {
+		fetches++
+		if fetchErr != nil {
+			return "", fetchErr
+		}
+		return key, nil
+	}
+
+	if krm.GetKey(ctx, fp) == nil || !krm.ContainsKey(ctx, subFp) {
+		t.Fatalf("Expected key %s and subkey %s to be cached", fp, subFp)
+	}
+
+	expired := time.Now().Add(-time.Second)
+	krm.Lock()
+	krm.lruIndex[fp].Value.(*cacheEntry).expiresAt = expired
+	krm.Unlock()
+
+	// Fetch errors keep the key without renewing it
+	fetchErr = fmt.Errorf("connection refused")
+	krm.refresh(ctx, fp)
+
+	krm.Lock()
+	entry := krm.lruIndex[fp].Value.(*cacheEntry)
+	if !entry.expiresAt.Equal(expired) || entry.refreshing {
+		t.Errorf("Expected the key expiration to not be renewed got %+v", entry)
+	}
+	krm.Unlock()
+
+	if !krm.ContainsKey(ctx, fp) {
+		t.Fatalf("Expected key %s to be kept after a fetch error", fp)
+	}
+
+	// Keys not found anymore are removed and remembered as not found
+	fetchErr = interfaces.ErrKeyNotFound
+	krm.refresh(ctx, fp)
+
+	if krm.ContainsKey(ctx, fp) || krm.ContainsKey(ctx, subFp) {
+		t.Errorf("Expected key %s and subkey %s to be removed", fp, subFp)
+	}
+
+	fetches = 0
+	if krm.GetKey(ctx, fp) != nil || fetches != 0 {
+		t.Errorf("Expected key %s to be remembered as not found, got %d fetches", fp, fetches)
+	}
+
+	if stats := krm.GetCacheStats(ctx); stats.NegativeHits != 1 || stats.Refreshes != 0 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+}
@@ -0,0 +1,405 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/slog"
+)
+
+const defaultKeyServerTimeout = 10 * time.Second
+const defaultKeyServerRetryBackoff = 500 * time.Millisecond
+const defaultKeyServerBreakerCooldown = time.Minute
+
+// maxKeyServerResponseSize is used when PKS_MAX_KEY_SIZE is disabled
+const maxKeyServerResponseSize = 16 * 1024 * 1024
+
+// keyServerUpstream is an upstream HKP server with its circuit breaker
+type keyServerUpstream struct {
+	sync.Mutex
+	url       string
+	failures  int
+	openUntil time.Time
+}
+
+// KeyServerClient fetches and sends public keys to the upstream key servers in SKS_SERVERS.
+// Failed requests are retried with backoff and upstreams that keep failing are skipped for a while
+type KeyServerClient struct {
+	log              slog.Instance
+	upstreams        []*keyServerUpstream
+	httpClient       *http.Client
+	retries          int
+	backoff          time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	wkdLookup        bool
+	wkdURL           func(domain, hash, localPart string) []string
+}
+
+func parseDurationOrDefault(log slog.Instance, name, value string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Error("Invalid %s %q. Using %s", name, value, def)
+		return def
+	}
+
+	return d
+}
+
+// normalizeKeyServerURL converts hkp:// and hkps:// urls to http(s) and removes the trailing slash
+func normalizeKeyServerURL(server string) string {
+	server = strings.TrimSuffix(strings.TrimSpace(server), "/")
+
+	switch {
+	case strings.HasPrefix(server, "hkps://"):
+		return "https://" + strings.TrimPrefix(server, "hkps://")
+	case strings.HasPrefix(server, "hkp://"):
+		u, err := url.Parse("http://" + strings.TrimPrefix(server, "hkp://"))
+		if err == nil && u.Port() == "" {
+			u.Host += ":11371"
+		}
+		if err == nil {
+			return u.String()
+		}
+	}
+
+	return server
+}
+
+// wkdURLs returns the Web Key Directory advanced and direct method urls of the email
+func wkdURLs(domain, hash, localPart string) []string {
+	query := "?l=" + url.QueryEscape(localPart)
+	return []string{
+		fmt.Sprintf("https://openpgpkey.%s/.well-known/openpgpkey/%s/hu/%s%s", domain, domain, hash, query),
+		fmt.Sprintf("https://%s/.well-known/openpgpkey/hu/%s%s", domain, hash, query),
+	}
+}
+
+// MakeKeyServerClient creates a client of the upstream key servers from the configuration
+func MakeKeyServerClient(log slog.Instance) *KeyServerClient {
+	if log == nil {
+		log = slog.Scope("KeyServer")
+	} else {
+		log = log.SubScope("KeyServer")
+	}
+
+	c := &KeyServerClient{
+		log: log,
+		httpClient: &http.Client{
+			Timeout: parseDurationOrDefault(log, "SKS_TIMEOUT", config.SKSTimeout, defaultKeyServerTimeout),
+		},
+		retries:          config.SKSRetries,
+		backoff:          parseDurationOrDefault(log, "SKS_RETRY_BACKOFF", config.SKSRetryBackoff, defaultKeyServerRetryBackoff),
+		breakerThreshold: config.SKSBreakerThreshold,
+		breakerCooldown:  parseDurationOrDefault(log, "SKS_BREAKER_COOLDOWN", config.SKSBreakerCooldown, defaultKeyServerBreakerCooldown),
+		wkdLookup:        config.SKSWKDLookup,
+		wkdURL:           wkdURLs,
+	}
+
+	for _, server := range strings.Split(config.SKSServers, ",") {
+		if server = normalizeKeyServerURL(server); server != "" {
+			c.upstreams = append(c.upstreams, &keyServerUpstream{url: server})
+		}
+	}
+
+	return c
+}
+
+var keyServerClient *KeyServerClient
+var keyServerClientConfig string
+var keyServerClientLock sync.Mutex
+
+// getKeyServerClient returns the shared client, keeping the circuit breakers between calls. It is recreated if the configuration changes
+func getKeyServerClient() *KeyServerClient {
+	keyServerClientLock.Lock()
+	defer keyServerClientLock.Unlock()
+
+	cfg := fmt.Sprint(config.SKSServers, config.SKSTimeout, config.SKSRetries, config.SKSRetryBackoff,
+		config.SKSBreakerThreshold, config.SKSBreakerCooldown, config.SKSWKDLookup)
+
+	if keyServerClient == nil || cfg != keyServerClientConfig {
+		keyServerClient = MakeKeyServerClient(pksLog)
+		keyServerClientConfig = cfg
+	}
+
+	return keyServerClient
+}
+
+func (u *keyServerUpstream) available(now time.Time) bool {
+	u.Lock()
+	defer u.Unlock()
+
+	return now.After(u.openUntil)
+}
+
+func (u *keyServerUpstream) report(success bool, threshold int, cooldown time.Duration) {
+	u.Lock()
+	defer u.Unlock()
+
+	if success {
+		u.failures = 0
+		u.openUntil = time.Time{}
+		return
+	}
+
+	u.failures++
+	if threshold > 0 && u.failures >= threshold {
+		u.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+func maxKeyResponseSize() int64 {
+	if config.PKSMaxKeySize > 0 {
+		return int64(config.PKSMaxKeySize)
+	}
+
+	return maxKeyServerResponseSize
+}
+
+// do runs the request built by newRequest, retrying network errors and 5xx responses with backoff.
+// Returns the status code and body of the last response
+func (c *KeyServerClient) do(ctx context.Context, newRequest func() (*http.Request, error)) (int, []byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(c.backoff << uint(attempt-1)):
+			case <-ctx.Done():
+				return 0, nil, ctx.Err()
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		res, err := c.httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxKeyResponseSize()+1))
+		_ = res.Body.Close()
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if int64(len(body)) > maxKeyResponseSize() {
+			return 0, nil, fmt.Errorf("the response is bigger than %d bytes", maxKeyResponseSize())
+		}
+
+		if res.StatusCode >= 500 {
+			lastErr = fmt.Errorf("status %d", res.StatusCode)
+			continue
+		}
+
+		return res.StatusCode, body, nil
+	}
+
+	return 0, nil, lastErr
+}
+
+// lookup runs the HKP get operation in the upstreams in order until one returns a valid key.
+// Returns interfaces.ErrKeyNotFound only if some upstream answered without the key
+func (c *KeyServerClient) lookup(ctx context.Context, search string, valid func(armored string) bool) (string, error) {
+	if len(c.upstreams) == 0 {
+		return "", fmt.Errorf("there are no upstream key servers configured")
+	}
+
+	answered := false
+	lastErr := fmt.Errorf("all upstream key servers are failing")
+
+	now := time.Now()
+	for _, u := range c.upstreams {
+		if !u.available(now) {
+			c.log.Debug("Skipping %s because it is failing", u.url)
+			continue
+		}
+
+		lookupURL := fmt.Sprintf("%s/pks/lookup?op=get&options=mr&search=%s", u.url, url.QueryEscape(search))
+		status, body, err := c.do(ctx, func() (*http.Request, error) {
+			return http.NewRequest("GET", lookupURL, nil)
+		})
+
+		u.report(err == nil, c.breakerThreshold, c.breakerCooldown)
+
+		if err != nil {
+			c.log.Warn("Error fetching %s from %s: %s", search, u.url, err)
+			lastErr = err
+			continue
+		}
+
+		if status == http.StatusNotFound {
+			answered = true
+			continue
+		}
+
+		if status != http.StatusOK {
+			lastErr = fmt.Errorf("%s returned status %d", u.url, status)
+			continue
+		}
+
+		answered = true
+
+		if !valid(string(body)) {
+			c.log.Warn("Upstream %s returned an invalid key for %s", u.url, search)
+			continue
+		}
+
+		return string(body), nil
+	}
+
+	if !answered {
+		return "", lastErr
+	}
+
+	return "", interfaces.ErrKeyNotFound
+}
+
+// GetKey fetches the public key of the fingerprint, or of a subkey fingerprint, from the upstream key servers
+func (c *KeyServerClient) GetKey(ctx context.Context, fingerPrint string) (string, error) {
+	return c.lookup(ctx, "0x"+fingerPrint, func(armored string) bool {
+		fps, err := tools.GetFingerPrintsFromKey(armored)
+		if err != nil {
+			return false
+		}
+
+		for _, fp := range fps {
+			if tools.CompareFingerPrint(fp, fingerPrint) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+func keyHasEmail(armored, email string) bool {
+	e, err := tools.ReadKeyToEntity(armored)
+	if err != nil {
+		return false
+	}
+
+	for id := range e.Identities {
+		if strings.EqualFold(tools.UserIDEmail(id), email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetKeyByEmail fetches a public key with the email by Web Key Directory if SKS_WKD_LOOKUP is enabled,
+// and then from the upstream key servers
+func (c *KeyServerClient) GetKeyByEmail(ctx context.Context, email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", fmt.Errorf("invalid email %q", email)
+	}
+
+	if c.wkdLookup {
+		localPart, domain := email[:at], strings.ToLower(email[at+1:])
+		for _, wkdURL := range c.wkdURL(domain, tools.WKDHash(localPart), localPart) {
+			status, body, err := c.do(ctx, func() (*http.Request, error) {
+				return http.NewRequest("GET", wkdURL, nil)
+			})
+
+			if err != nil || status != http.StatusOK {
+				continue
+			}
+
+			armored, err := armorBinaryKey(body)
+			if err == nil && keyHasEmail(armored, email) {
+				return armored, nil
+			}
+		}
+	}
+
+	return c.lookup(ctx, email, func(armored string) bool {
+		return keyHasEmail(armored, email)
+	})
+}
+
+// armorBinaryKey returns the ASCII armored form of a binary public key, as served by the Web Key Directory
+func armorBinaryKey(data []byte) (string, error) {
+	if _, err := openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+
+	_, _ = w.Write(data)
+	_ = w.Close()
+
+	return buf.String(), nil
+}
+
+// PutKey sends the public key to all upstream key servers in parallel. Returns the result of each one in the SKS_SERVERS order
+func (c *KeyServerClient) PutKey(ctx context.Context, publicKey string) []models.KeyServerUploadResult {
+	results := make([]models.KeyServerUploadResult, len(c.upstreams))
+	wg := sync.WaitGroup{}
+
+	for i, u := range c.upstreams {
+		results[i].Server = u.url
+
+		if !u.available(time.Now()) {
+			results[i].Error = "the server is failing and was skipped"
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *models.KeyServerUploadResult, u *keyServerUpstream) {
+			defer wg.Done()
+
+			body := url.Values{"keytext": {publicKey}}.Encode()
+			status, _, err := c.do(ctx, func() (*http.Request, error) {
+				req, err := http.NewRequest("POST", u.url+"/pks/add", strings.NewReader(body))
+				if err == nil {
+					req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				}
+				return req, err
+			})
+
+			u.report(err == nil, c.breakerThreshold, c.breakerCooldown)
+
+			result.StatusCode = status
+			result.Success = err == nil && status == http.StatusOK
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}(&results[i], u)
+	}
+
+	wg.Wait()
+
+	for _, result := range results {
+		if !result.Success {
+			c.log.Warn("Error sending key to %s: status %d %s", result.Server, result.StatusCode, result.Error)
+		}
+	}
+
+	return results
+}
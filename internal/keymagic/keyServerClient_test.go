@@ -0,0 +1,130 @@
+package keymagic
+
+import (
+	"context"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNormalizeKeyServerURL(t *testing.T) {
+	cases := map[string]string{
+		"hkp://keyserver.ubuntu.com":     "http://keyserver.ubuntu.com:11371",
+		"hkp://keyserver.ubuntu.com:80/": "http://keyserver.ubuntu.com:80",
+		"hkps://keys.openpgp.org":        "https://keys.openpgp.org",
+		" http://pgp.mit.edu/ ":          "http://pgp.mit.edu",
+		"https://keyserver.quan.to/sks/": "https://keyserver.quan.to/sks",
+	}
+
+	for in, expected := range cases {
+		if got := normalizeKeyServerURL(in); got != expected {
+			t.Errorf("Expected %q to be normalized to %q got %q", in, expected, got)
+		}
+	}
+}
+
+func TestKeyServerClient(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	e, err := openpgp.NewEntity("Upstream", "", "upstream@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey := armoredPublicKey(t, e)
+	fp := tools.ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+
+	var failingCalls, workingCalls int32
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failingCalls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&workingCalls, 1)
+		switch r.URL.Path {
+		case "/pks/lookup":
+			if r.URL.Query().Get("search") != "0x"+fp {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = fmt.Fprint(w, pubKey)
+		case "/pks/add":
+			_ = r.ParseForm()
+			if r.PostForm.Get("keytext") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer working.Close()
+
+	config.SKSServers = failing.URL + "," + working.URL
+	config.SKSTimeout = "2s"
+	config.SKSRetries = 1
+	config.SKSRetryBackoff = "1ms"
+	config.SKSBreakerThreshold = 2
+	config.SKSBreakerCooldown = "1h"
+	config.SKSWKDLookup = false
+
+	c := MakeKeyServerClient(nil)
+	ctx := context.Background()
+
+	// region Fallback to the next upstream
+	key, err := c.GetKey(ctx, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != pubKey {
+		t.Errorf("Expected the key from the working upstream")
+	}
+
+	if atomic.LoadInt32(&failingCalls) != 2 {
+		t.Errorf("Expected the failing upstream to be tried 2 times got %d", failingCalls)
+	}
+	// endregion
+
+	// region Not found
+	if _, err := c.GetKey(ctx, "0000000000000000"); err == nil {
+		t.Errorf("Expected error for a key not found")
+	}
+	// endregion
+
+	// region Circuit breaker skips the failing upstream
+	calls := atomic.LoadInt32(&failingCalls)
+	if _, err := c.GetKey(ctx, fp); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&failingCalls) != calls {
+		t.Errorf("Expected the failing upstream to be skipped with the circuit open")
+	}
+	// endregion
+
+	// region Upload to all upstreams
+	results := c.PutKey(ctx, pubKey)
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 upload results got %d", len(results))
+	}
+
+	for _, r := range results {
+		expected := r.Server == working.URL
+		if r.Success != expected {
+			t.Errorf("Expected upload to %s success to be %v got %+v", r.Server, expected, r)
+		}
+	}
+	// endregion
+}
@@ -0,0 +1,439 @@
+package keymagic
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/database"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultPKSVerifyTTL = 24 * time.Hour
+
+var pksMailSender interfaces.MailSender
+
+// SetPKSMailSender replaces the sender of the ownership verification emails. If nil the SMTP server at SMTP_SERVER is used
+func SetPKSMailSender(ms interfaces.MailSender) {
+	pksMailSender = ms
+}
+
+func getPKSMailSender() interfaces.MailSender {
+	if pksMailSender != nil {
+		return pksMailSender
+	}
+
+	if config.SMTPServer == "" {
+		return nil
+	}
+
+	return MakeSMTPMailSender(pksLog)
+}
+
+func pksVerifyTTL() time.Duration {
+	ttl, err := time.ParseDuration(config.PKSVerifyTTL)
+	if err != nil || ttl <= 0 {
+		pksLog.Error("Invalid PKS_VERIFY_TTL %q. Using %s", config.PKSVerifyTTL, defaultPKSVerifyTTL)
+		return defaultPKSVerifyTTL
+	}
+
+	return ttl
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func keyUserIDs(asciiArmored string) ([]string, error) {
+	e, err := tools.ReadKeyToEntity(asciiArmored)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(e.Identities))
+	for id := range e.Identities {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// servedPublicKey returns the stored public key without the user IDs that were not verified
+func servedPublicKey(key models.GPGKey) string {
+	if !key.VerificationRequired {
+		return key.AsciiArmoredPublicKey
+	}
+
+	filtered, err := tools.FilterUserIDs(key.AsciiArmoredPublicKey, key.VerifiedUserIDs)
+	if err != nil {
+		pksLog.Error("Error removing the unverified user ids of key %s: %s", key.GetShortFingerPrint(), err)
+		return ""
+	}
+
+	return filtered
+}
+
+func servedKeys(keys []models.GPGKey, err error) ([]models.GPGKey, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		keys[i].AsciiArmoredPublicKey = servedPublicKey(keys[i])
+		keys[i].VerificationChallenge = ""
+		keys[i].EmailVerificationTokens = nil
+	}
+
+	return keys, nil
+}
+
+// keyWithVerification parses the merged key keeping the verification state of existing.
+// New user IDs are pending verification if PKS_VERIFY_OWNERSHIP is enabled and verified is false
+func keyWithVerification(merged string, existing *models.GPGKey, verified bool) (models.GPGKey, error) {
+	if !config.PKSVerifyOwnership {
+		return models.AsciiArmored2GPGKey(merged)
+	}
+
+	ids, err := keyUserIDs(merged)
+	if err != nil {
+		return models.GPGKey{}, err
+	}
+
+	previous := map[string]bool{}
+	if existing != nil {
+		previousIDs := existing.VerifiedUserIDs
+		if !existing.VerificationRequired {
+			// Keys added before the verification was enabled keep their user ids
+			previousIDs, err = keyUserIDs(existing.AsciiArmoredPublicKey)
+			if err != nil {
+				return models.GPGKey{}, err
+			}
+		}
+
+		for _, id := range previousIDs {
+			previous[id] = true
+		}
+	}
+
+	verifiedIDs := make([]string, 0, len(ids))
+	pendingIDs := make([]string, 0)
+
+	for _, id := range ids {
+		if verified || previous[id] {
+			verifiedIDs = append(verifiedIDs, id)
+		} else {
+			pendingIDs = append(pendingIDs, id)
+		}
+	}
+
+	key, err := models.AsciiArmored2GPGKeyWithUserIDs(merged, verifiedIDs)
+	if err != nil {
+		return key, err
+	}
+
+	key.VerificationRequired = true
+	key.VerifiedUserIDs = verifiedIDs
+	key.PendingUserIDs = pendingIDs
+	key.EmailVerificationTokens = make([]models.EmailVerificationToken, 0)
+
+	if existing != nil && len(pendingIDs) > 0 {
+		key.VerificationChallenge = existing.VerificationChallenge
+		key.VerificationChallengeExpiration = existing.VerificationChallengeExpiration
+		key.EmailVerificationTokens = existing.EmailVerificationTokens
+	}
+
+	return key, nil
+}
+
+// keyWithSyncedUserIDs parses the merged key like keyWithVerification, also verifying the user IDs of synced.
+// Only used for the PKS_SYNC_VERIFIED_PEERS, which are trusted to serve user IDs already verified by them
+func keyWithSyncedUserIDs(merged string, existing *models.GPGKey, synced string) (models.GPGKey, error) {
+	if !config.PKSVerifyOwnership {
+		return models.AsciiArmored2GPGKey(merged)
+	}
+
+	syncedIDs, err := keyUserIDs(synced)
+	if err != nil {
+		return models.GPGKey{}, err
+	}
+
+	previous := models.GPGKey{}
+	if existing != nil {
+		previous = *existing
+		if !existing.VerificationRequired {
+			previous.VerifiedUserIDs, err = keyUserIDs(existing.AsciiArmoredPublicKey)
+			if err != nil {
+				return models.GPGKey{}, err
+			}
+		}
+	}
+
+	previous.VerificationRequired = true
+	previous.VerifiedUserIDs = append(append([]string{}, previous.VerifiedUserIDs...), syncedIDs...)
+
+	return keyWithVerification(merged, &previous, false)
+}
+
+// newVerificationChallenge sets a new ownership verification challenge in the key, keeping the current one if it did not expire
+func newVerificationChallenge(key *models.GPGKey) models.KeyVerificationChallenge {
+	if key.VerificationChallenge == "" || time.Now().After(key.VerificationChallengeExpiration) {
+		expiration := time.Now().Add(pksVerifyTTL()).Truncate(time.Second)
+		key.VerificationChallenge = fmt.Sprintf("Chevron PKS ownership verification of %s\nNonce: %s\nExpiration: %s\n",
+			key.FullFingerPrint, randomToken(), expiration.UTC().Format(time.RFC3339))
+		key.VerificationChallengeExpiration = expiration
+	}
+
+	return models.KeyVerificationChallenge{
+		FingerPrint:    key.FullFingerPrint,
+		Challenge:      key.VerificationChallenge,
+		Expiration:     key.VerificationChallengeExpiration,
+		PendingUserIDs: key.PendingUserIDs,
+	}
+}
+
+// markVerified moves the pending user ids accepted by verify to the verified ones and indexes them
+func markVerified(key *models.GPGKey, verify func(id string) bool) error {
+	pendingIDs := make([]string, 0, len(key.PendingUserIDs))
+
+	for _, id := range key.PendingUserIDs {
+		if verify(id) {
+			key.VerifiedUserIDs = append(key.VerifiedUserIDs, id)
+		} else {
+			pendingIDs = append(pendingIDs, id)
+		}
+	}
+
+	sort.Strings(key.VerifiedUserIDs)
+	key.PendingUserIDs = pendingIDs
+
+	indexed, err := models.AsciiArmored2GPGKeyWithUserIDs(key.AsciiArmoredPublicKey, key.VerifiedUserIDs)
+	if err != nil {
+		return err
+	}
+
+	key.Names = indexed.Names
+	key.Emails = indexed.Emails
+	key.KeyUids = indexed.KeyUids
+
+	if len(pendingIDs) == 0 {
+		key.VerificationChallenge = ""
+		key.VerificationChallengeExpiration = time.Time{}
+		key.EmailVerificationTokens = make([]models.EmailVerificationToken, 0)
+	}
+
+	setSyncDigest(key)
+
+	return nil
+}
+
+func verificationResult(key *models.GPGKey) models.KeyVerificationResult {
+	return models.KeyVerificationResult{
+		FingerPrint:     key.FullFingerPrint,
+		VerifiedUserIDs: key.VerifiedUserIDs,
+		PendingUserIDs:  key.PendingUserIDs,
+	}
+}
+
+// getPendingKey returns the stored key of the fingerprint if it has user ids pending verification
+func getPendingKey(fingerPrint string) (*models.GPGKey, error) {
+	if !config.PKSVerifyOwnership {
+		return nil, fmt.Errorf("the key ownership verification is disabled")
+	}
+
+	conn := database.GetConnection()
+	key, err := models.GetGPGKeyByFingerPrint(conn, fingerPrint)
+	if err != nil {
+		return nil, fmt.Errorf("key %s not found", fingerPrint)
+	}
+
+	if len(key.PendingUserIDs) == 0 {
+		return nil, fmt.Errorf("key %s does not have user ids pending verification", fingerPrint)
+	}
+
+	return key, nil
+}
+
+// PKSRequestChallenge returns the challenge that should be signed by the key to verify its pending user ids
+func PKSRequestChallenge(ctx context.Context, fingerPrint string) (models.KeyVerificationChallenge, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pksLog.Tag(requestID)
+	log.DebugNote("PKSRequestChallenge(%s)", fingerPrint)
+
+	key, err := getPendingKey(fingerPrint)
+	if err != nil {
+		return models.KeyVerificationChallenge{}, err
+	}
+
+	challenge := newVerificationChallenge(key)
+
+	err = key.Save(database.GetConnection())
+	if err != nil {
+		return models.KeyVerificationChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// PKSVerifyChallenge verifies all the pending user ids of the key if signature is a valid signature of its challenge
+func PKSVerifyChallenge(ctx context.Context, fingerPrint, signature string) (models.KeyVerificationResult, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pksLog.Tag(requestID)
+	log.DebugNote("PKSVerifyChallenge(%s, ---)", fingerPrint)
+
+	key, err := getPendingKey(fingerPrint)
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	if key.VerificationChallenge == "" || time.Now().After(key.VerificationChallengeExpiration) {
+		return models.KeyVerificationResult{}, fmt.Errorf("there is no valid challenge for key %s", fingerPrint)
+	}
+
+	e, err := tools.ReadKeyToEntity(key.AsciiArmoredPublicKey)
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	_, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{e}, strings.NewReader(key.VerificationChallenge), strings.NewReader(tools.SignatureFix(signature)))
+	if err != nil {
+		log.Warn("Invalid challenge signature for key %s: %s", key.GetShortFingerPrint(), err)
+		return models.KeyVerificationResult{}, fmt.Errorf("invalid challenge signature: %s", err)
+	}
+
+	err = markVerified(key, func(string) bool { return true })
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	err = key.Save(database.GetConnection())
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	log.Info("Ownership of key %s verified by challenge", key.GetShortFingerPrint())
+
+	return verificationResult(key), nil
+}
+
+// PKSRequestEmailVerification sends a token to the email to verify the pending user ids with that email
+func PKSRequestEmailVerification(ctx context.Context, fingerPrint, email string) error {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pksLog.Tag(requestID)
+	log.DebugNote("PKSRequestEmailVerification(%s, %s)", fingerPrint, email)
+
+	ms := getPKSMailSender()
+	if ms == nil {
+		return fmt.Errorf("the email verification is disabled")
+	}
+
+	key, err := getPendingKey(fingerPrint)
+	if err != nil {
+		return err
+	}
+
+	pending := false
+	for _, id := range key.PendingUserIDs {
+		if strings.EqualFold(tools.UserIDEmail(id), email) {
+			pending = true
+			// The email from the key is used
+			email = tools.UserIDEmail(id)
+			break
+		}
+	}
+
+	if !pending {
+		return fmt.Errorf("key %s does not have user ids with email %s pending verification", fingerPrint, email)
+	}
+
+	token := randomToken()
+	tokens := []models.EmailVerificationToken{{
+		Email:      email,
+		TokenHash:  hashToken(token),
+		Expiration: time.Now().Add(pksVerifyTTL()),
+	}}
+
+	for _, t := range key.EmailVerificationTokens {
+		if !strings.EqualFold(t.Email, email) && time.Now().Before(t.Expiration) {
+			tokens = append(tokens, t)
+		}
+	}
+
+	key.EmailVerificationTokens = tokens
+
+	err = key.Save(database.GetConnection())
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("The key %s with the email %s was published to the Chevron Public Key Store.\n\n"+
+		"If you own this key, confirm your email sending the fingerprint and the token below to /sks/verify/email/confirm:\n\n"+
+		"FingerPrint: %s\nToken: %s\n\nIf you did not publish this key, ignore this email.\n", key.GetShortFingerPrint(), email, key.FullFingerPrint, token)
+
+	return ms.SendMail(ctx, email, "Verify your key "+key.GetShortFingerPrint(), body)
+}
+
+// PKSConfirmEmailVerification verifies the pending user ids with the email of the token
+func PKSConfirmEmailVerification(ctx context.Context, fingerPrint, token string) (models.KeyVerificationResult, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pksLog.Tag(requestID)
+	log.DebugNote("PKSConfirmEmailVerification(%s, ---)", fingerPrint)
+
+	key, err := getPendingKey(fingerPrint)
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	tokenHash := hashToken(token)
+	email := ""
+	tokens := make([]models.EmailVerificationToken, 0, len(key.EmailVerificationTokens))
+
+	for _, t := range key.EmailVerificationTokens {
+		if t.TokenHash == tokenHash && time.Now().Before(t.Expiration) {
+			email = t.Email
+		} else {
+			tokens = append(tokens, t)
+		}
+	}
+
+	if email == "" {
+		return models.KeyVerificationResult{}, fmt.Errorf("invalid or expired token")
+	}
+
+	key.EmailVerificationTokens = tokens
+
+	err = markVerified(key, func(id string) bool {
+		return strings.EqualFold(tools.UserIDEmail(id), email)
+	})
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	err = key.Save(database.GetConnection())
+	if err != nil {
+		return models.KeyVerificationResult{}, err
+	}
+
+	log.Info("Email %s of key %s verified", email, key.GetShortFingerPrint())
+
+	return verificationResult(key), nil
+}
@@ -0,0 +1,147 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"github.com/quan-to/chevron/test"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func armoredPublicKey(t *testing.T, e *openpgp.Entity) string {
+	buf := bytes.NewBuffer(nil)
+	w, _ := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	return buf.String()
+}
+
+func TestKeyWithVerification(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.PKSVerifyOwnership = true
+
+	cfg := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Verify", "", "verify@huebr.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubKey := armoredPublicKey(t, e)
+
+	// region New key is pending
+	key, err := keyWithVerification(pubKey, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !key.VerificationRequired || len(key.VerifiedUserIDs) != 0 || !reflect.DeepEqual(key.PendingUserIDs, []string{"Verify <verify@huebr.com>"}) {
+		t.Fatalf("Expected the user id to be pending got %+v", key)
+	}
+
+	if len(key.Emails) != 0 || len(key.Names) != 0 {
+		t.Errorf("Expected pending user ids not to be indexed got %v %v", key.Names, key.Emails)
+	}
+
+	served, err := tools.ReadKeyToEntity(servedPublicKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(served.Identities) != 0 {
+		t.Errorf("Expected pending user ids not to be served")
+	}
+	// endregion
+	// region Challenge
+	key.FullFingerPrint = tools.ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+	challenge := newVerificationChallenge(&key)
+
+	if !strings.Contains(challenge.Challenge, key.FullFingerPrint) || !reflect.DeepEqual(challenge.PendingUserIDs, key.PendingUserIDs) {
+		t.Errorf("Unexpected challenge %+v", challenge)
+	}
+
+	if again := newVerificationChallenge(&key); again.Challenge != challenge.Challenge {
+		t.Errorf("Expected the valid challenge to be kept")
+	}
+	// endregion
+	// region Verify
+	err = markVerified(&key, func(string) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key.PendingUserIDs) != 0 || !reflect.DeepEqual(key.Emails, []string{"verify@huebr.com"}) || key.VerificationChallenge != "" {
+		t.Errorf("Expected the user id to be verified and indexed got %+v", key)
+	}
+
+	served, err = tools.ReadKeyToEntity(servedPublicKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(served.Identities) != 1 {
+		t.Errorf("Expected verified user id to be served")
+	}
+	// endregion
+	// region Trusted and legacy keys
+	trusted, err := keyWithVerification(pubKey, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trusted.PendingUserIDs) != 0 || len(trusted.VerifiedUserIDs) != 1 {
+		t.Errorf("Expected trusted key to be verified got %+v", trusted)
+	}
+
+	legacy, err := keyWithVerification(pubKey, &models.GPGKey{AsciiArmoredPublicKey: pubKey}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(legacy.PendingUserIDs) != 0 {
+		t.Errorf("Expected user ids of keys added before the verification to be kept got %+v", legacy)
+	}
+	// endregion
+}
+
+func TestSMTPMailSender(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	server, err := test.RunSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	config.SMTPServer = server.Addr
+	config.SMTPFrom = "chevron@huebr.com"
+
+	ms := MakeSMTPMailSender(nil)
+
+	err = ms.SendMail(context.Background(), "verify@huebr.com", "Verify", "Token: huebr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-server.Messages
+
+	if !strings.Contains(msg, "To: verify@huebr.com") || !strings.Contains(msg, "Token: huebr") {
+		t.Errorf("Unexpected message %s", msg)
+	}
+
+	if err := ms.SendMail(context.Background(), "verify@huebr.com\r\nBcc: x@huebr.com", "Verify", ""); err == nil {
+		t.Errorf("Expected error for header injection")
+	}
+}
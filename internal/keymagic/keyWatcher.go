@@ -0,0 +1,218 @@
+package keymagic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"sync"
+	"time"
+)
+
+// KeyWatcher keeps the keys loaded in a PGPManager in sync with its key backend.
+// New keys are loaded, removed keys are unloaded and changed keys are reloaded.
+// Keys whose key material did not change (like metadata updates or our own writes) keep their unlocked private keys
+type KeyWatcher struct {
+	sync.Mutex
+	sm           interfaces.SecretsManager
+	gpg          interfaces.PGPManager
+	kb           interfaces.StorageBackend
+	watcher      *keybackend.Watcher
+	fingerPrints map[string]string
+	digests      map[string]string
+	log          slog.Instance
+}
+
+// MakeKeyWatcher creates a KeyWatcher that applies the changes of kb to gpg. kb should be the same backend used by gpg.
+// If sm is not nil, reloaded keys are unlocked again with its stored passwords
+func MakeKeyWatcher(log slog.Instance, sm interfaces.SecretsManager, gpg interfaces.PGPManager, kb interfaces.StorageBackend, interval time.Duration) *KeyWatcher {
+	if log == nil {
+		log = slog.Scope("KeyWatcher")
+	} else {
+		log = log.SubScope("KeyWatcher")
+	}
+
+	return &KeyWatcher{
+		sm:           sm,
+		gpg:          gpg,
+		kb:           kb,
+		watcher:      keybackend.MakeWatcher(log, kb, interval),
+		fingerPrints: map[string]string{},
+		digests:      map[string]string{},
+		log:          log,
+	}
+}
+
+// Run watches the key backend until stop receives a value
+func (kw *KeyWatcher) Run(stop chan bool) {
+	kw.seed()
+	kw.watcher.Run(stop, func(events []models.KeyEvent) {
+		kw.apply(events)
+	})
+}
+
+// seed records the key material digests of the keys already stored in the backend, like the ones loaded by LoadKeys,
+// so their first metadata change does not reload them
+func (kw *KeyWatcher) seed() {
+	keys, err := kw.kb.List()
+	if err != nil {
+		kw.log.Error("Error listing keys: %s", err)
+		return
+	}
+
+	for _, key := range keys {
+		_, _, fp, digest, err := kw.read(key)
+		if err != nil {
+			kw.log.Error("Error reading key %s: %s", key, err)
+			continue
+		}
+
+		kw.Lock()
+		kw.fingerPrints[key] = fp
+		kw.digests[key] = digest
+		kw.Unlock()
+	}
+}
+
+// apply applies the key events to gpg, which also removes the unloaded keys from its KeyRingManager.
+// Returns the applied events
+func (kw *KeyWatcher) apply(events []models.KeyEvent) []models.KeyEvent {
+	ctx := context.Background()
+	applied := make([]models.KeyEvent, 0, len(events))
+
+	for _, event := range events {
+		var err error
+
+		switch event.Type {
+		case models.KeyEventRemoved:
+			event.FingerPrint = kw.fingerPrint(event.Key)
+			kw.gpg.UnloadKey(ctx, event.FingerPrint)
+			kw.Lock()
+			delete(kw.fingerPrints, event.Key)
+			delete(kw.digests, event.Key)
+			kw.Unlock()
+		case models.KeyEventChanged:
+			event.FingerPrint, err = kw.reload(ctx, event.Key)
+		case models.KeyEventAdded:
+			event.FingerPrint, err = kw.load(ctx, event.Key)
+		}
+
+		if err != nil {
+			kw.log.Error("Error loading key %s: %s", event.Key, err)
+			continue
+		}
+
+		kw.log.Info("Key %s (%s) %s", event.Key, event.FingerPrint, event.Type)
+		applied = append(applied, event)
+	}
+
+	return applied
+}
+
+// fingerPrint returns the fingerprint of the key stored as key. Keys that were not loaded by the watcher are stored by their fingerprint
+func (kw *KeyWatcher) fingerPrint(key string) string {
+	kw.Lock()
+	defer kw.Unlock()
+
+	if fp, ok := kw.fingerPrints[key]; ok {
+		return fp
+	}
+
+	return kw.gpg.FixFingerPrint(key)
+}
+
+// read reads the key from the key backend returning its armored data, metadata, fingerprint and key material digest
+func (kw *KeyWatcher) read(key string) (keyData, metadata, fp, digest string, err error) {
+	keyData, metadata, err = kw.kb.Read(key)
+	if err != nil {
+		return "", "", "", "", err
+	}
+
+	if config.KeysBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(keyData)
+		if err != nil {
+			return "", "", "", "", err
+		}
+		keyData = string(b)
+	}
+
+	fp, err = tools.GetFingerPrintFromKey(keyData)
+	if err != nil {
+		return "", "", "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(keyData))
+
+	return keyData, metadata, fp, hex.EncodeToString(sum[:]), nil
+}
+
+func (kw *KeyWatcher) load(ctx context.Context, key string) (string, error) {
+	if config.OnDemandKeyLoad {
+		kw.log.Debug("On Demand Key load enabled. Key %s will be loaded when used.", key)
+		return kw.fingerPrint(key), nil
+	}
+
+	keyData, metadata, fp, digest, err := kw.read(key)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = kw.gpg.LoadKeyWithMetadata(ctx, keyData, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	kw.Lock()
+	kw.fingerPrints[key] = fp
+	kw.digests[key] = digest
+	kw.Unlock()
+
+	return fp, nil
+}
+
+// reload applies a change of the stored key. The key is only unloaded if its key material changed,
+// so a metadata update does not lock a key that was unlocked by the cluster passwords or /gpg/unlockKey
+func (kw *KeyWatcher) reload(ctx context.Context, key string) (string, error) {
+	previousFp := kw.fingerPrint(key)
+
+	keyData, metadata, fp, digest, err := kw.read(key)
+	if err != nil {
+		return "", err
+	}
+
+	kw.Lock()
+	previousDigest, known := kw.digests[key]
+	kw.Unlock()
+
+	if known && previousDigest == digest && tools.CompareFingerPrint(previousFp, fp) {
+		kw.log.Debug("Key material of %s did not change. Updating its metadata", key)
+		_, err = kw.gpg.LoadKeyWithMetadata(ctx, keyData, metadata)
+		return fp, err
+	}
+
+	kw.gpg.UnloadKey(ctx, previousFp)
+
+	if !config.OnDemandKeyLoad {
+		_, err = kw.gpg.LoadKeyWithMetadata(ctx, keyData, metadata)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	kw.Lock()
+	kw.fingerPrints[key] = fp
+	kw.digests[key] = digest
+	kw.Unlock()
+
+	if kw.sm != nil {
+		kw.sm.UnlockLocalKeys(ctx, kw.gpg)
+	}
+
+	return fp, nil
+}
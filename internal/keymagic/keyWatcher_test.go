@@ -0,0 +1,150 @@
+package keymagic
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/test"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyWatcher(t *testing.T) {
+	ctx := context.Background()
+	folder, err := ioutil.TempDir("", "keyWatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	kb := keybackend.MakeSaveToDiskBackend(nil, folder, "key_")
+	gpg := MakePGPManager(nil, kb, MakeKeyRingManager(nil))
+	kw := MakeKeyWatcher(nil, nil, gpg, kb, time.Minute)
+
+	// Initial state
+	kw.apply(mustCheck(t, kw))
+
+	key, err := gpg.GeneratePGPKey(ctx, "HUE", test.TestKeyFingerprint, gpg.MinKeyBits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp, _ := tools.GetFingerPrintFromKey(key)
+	keyData := key
+	if config.KeysBase64Encoded {
+		keyData = base64.StdEncoding.EncodeToString([]byte(key))
+	}
+
+	_ = kb.SaveWithMetadata(fp, keyData, "")
+	e := mustApply(t, kw)
+	if e.Type != models.KeyEventAdded || e.FingerPrint != fp {
+		t.Fatalf("Expected key %s to be added got %+v", fp, e)
+	}
+
+	if gpg.GetPrivateKeyInfo(ctx, fp) == nil || !gpg.IsKeyLocked(fp) {
+		t.Fatalf("Expected key %s to be loaded and locked", fp)
+	}
+
+	// Adding the password should reload the key unlocked
+	_ = kb.SaveWithMetadata(fp, keyData, `{"password":"`+test.TestKeyFingerprint+`"}`)
+	e = mustApply(t, kw)
+	if e.Type != models.KeyEventChanged || e.FingerPrint != fp {
+		t.Fatalf("Expected key %s to be changed got %+v", fp, e)
+	}
+
+	if gpg.IsKeyLocked(fp) {
+		t.Fatalf("Expected key %s to be unlocked", fp)
+	}
+
+	// Changing only the metadata should keep the key unlocked
+	_ = kb.SaveWithMetadata(fp, keyData, `{"OwnerTeam":"security"}`)
+	e = mustApply(t, kw)
+	if e.Type != models.KeyEventChanged || e.FingerPrint != fp {
+		t.Fatalf("Expected key %s to be changed got %+v", fp, e)
+	}
+
+	if gpg.IsKeyLocked(fp) {
+		t.Fatalf("Expected key %s to be kept unlocked after a metadata change", fp)
+	}
+
+	_ = kb.Delete(fp)
+	e = mustApply(t, kw)
+	if e.Type != models.KeyEventRemoved || e.FingerPrint != fp {
+		t.Fatalf("Expected key %s to be removed got %+v", fp, e)
+	}
+
+	if gpg.GetPrivateKeyInfo(ctx, fp) != nil {
+		t.Errorf("Expected key %s to be unloaded", fp)
+	}
+}
+
+// mustApply applies the changes of the key backend and returns the only applied event
+func TestKeyWatcherStartupKeys(t *testing.T) {
+	ctx := context.Background()
+	folder, err := ioutil.TempDir("", "keyWatcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	kb := keybackend.MakeSaveToDiskBackend(nil, folder, "key_")
+	gpg := MakePGPManager(nil, kb, MakeKeyRingManager(nil))
+
+	key, err := gpg.GeneratePGPKey(ctx, "HUE", test.TestKeyFingerprint, gpg.MinKeyBits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp, _ := tools.GetFingerPrintFromKey(key)
+	keyData := key
+	if config.KeysBase64Encoded {
+		keyData = base64.StdEncoding.EncodeToString([]byte(key))
+	}
+
+	// The key is stored and unlocked before the watcher starts, like by LoadKeys and the cluster passwords
+	_ = kb.SaveWithMetadata(fp, keyData, "")
+	gpg.LoadKeys(ctx)
+
+	err = gpg.UnlockKey(ctx, fp, test.TestKeyFingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kw := MakeKeyWatcher(nil, nil, gpg, kb, time.Minute)
+	// Like Run, the keys stored before the first check are not applied
+	kw.seed()
+	mustCheck(t, kw)
+
+	_ = kb.SaveWithMetadata(fp, keyData, `{"OwnerTeam":"security"}`)
+	e := mustApply(t, kw)
+	if e.Type != models.KeyEventChanged || e.FingerPrint != fp {
+		t.Fatalf("Expected key %s to be changed got %+v", fp, e)
+	}
+
+	if gpg.IsKeyLocked(fp) {
+		t.Errorf("Expected the key loaded at startup to be kept unlocked after a metadata change")
+	}
+}
+
+func mustApply(t *testing.T, kw *KeyWatcher) models.KeyEvent {
+	applied := kw.apply(mustCheck(t, kw))
+	if len(applied) != 1 {
+		t.Fatalf("Expected one applied event got %+v", applied)
+	}
+
+	return applied[0]
+}
+
+func mustCheck(t *testing.T, kw *KeyWatcher) []models.KeyEvent {
+	events, err := kw.watcher.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
@@ -0,0 +1,86 @@
+package keymagic
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+)
+
+const wrappedKeyFilename = "data-key.bin"
+
+type pgpKeyWrapper struct {
+	gpg         interfaces.PGPManager
+	fingerPrint string
+	log         slog.Instance
+}
+
+// MakeKeyWrapper creates a KeyWrapper that wraps data keys with the specified armored private key
+func MakeKeyWrapper(log slog.Instance, armoredKey, password string) (interfaces.KeyWrapper, error) {
+	if log == nil {
+		log = slog.Scope("KeyWrapper")
+	} else {
+		log = log.SubScope("KeyWrapper")
+	}
+
+	ctx := context.Background()
+
+	fp, err := tools.GetFingerPrintFromKey(armoredKey)
+	if err != nil {
+		return nil, err
+	}
+
+	gpg := MakePGPManager(log, keybackend.MakeVoidBackend(), MakeKeyRingManager(log))
+
+	n, err := gpg.LoadKey(ctx, armoredKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if n == 0 {
+		return nil, fmt.Errorf("the specified key doesnt have any private keys inside")
+	}
+
+	err = gpg.UnlockKey(ctx, fp, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pgpKeyWrapper{
+		gpg:         gpg,
+		fingerPrint: fp,
+		log:         log,
+	}, nil
+}
+
+// WrapKey encrypts the specified data key with the wrapper key
+func (kw *pgpKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) (string, error) {
+	return wrapKey(ctx, kw.gpg, kw.fingerPrint, dataKey)
+}
+
+// UnwrapKey decrypts a data key previously wrapped by WrapKey
+func (kw *pgpKeyWrapper) UnwrapKey(ctx context.Context, wrappedKey string) ([]byte, error) {
+	return unwrapKey(ctx, kw.gpg, wrappedKey)
+}
+
+// WrapperID returns the fingerprint of the wrapper key
+func (kw *pgpKeyWrapper) WrapperID(ctx context.Context) string {
+	return kw.fingerPrint
+}
+
+func wrapKey(ctx context.Context, gpg interfaces.PGPManager, fingerPrint string, dataKey []byte) (string, error) {
+	return gpg.Encrypt(ctx, wrappedKeyFilename, fingerPrint, dataKey, config.SMEncryptedDataOnly)
+}
+
+func unwrapKey(ctx context.Context, gpg interfaces.PGPManager, wrappedKey string) ([]byte, error) {
+	g, err := gpg.Decrypt(ctx, wrappedKey, config.SMEncryptedDataOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(g.Base64Data)
+}
@@ -0,0 +1,74 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/test"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSecretsManagerWrapKey(t *testing.T) {
+	ctx := context.Background()
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+
+	wrapped, err := sm.WrapKey(ctx, dataKey)
+	if err != nil {
+		t.Fatalf("Error wrapping key: %s", err)
+	}
+
+	if bytes.Contains([]byte(wrapped), dataKey) {
+		t.Fatalf("Wrapped key contains the plain data key")
+	}
+
+	unwrapped, err := sm.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		t.Fatalf("Error unwrapping key: %s", err)
+	}
+
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Expected unwrapped key to be %q got %q", dataKey, unwrapped)
+	}
+
+	if sm.WrapperID(ctx) != sm.GetMasterKeyFingerPrint(ctx) {
+		t.Errorf("Expected wrapper id to be the master key fingerprint")
+	}
+}
+
+func TestMakeKeyWrapper(t *testing.T) {
+	ctx := context.Background()
+	keyData, err := ioutil.ReadFile(config.MasterGPGKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kw, err := MakeKeyWrapper(nil, string(keyData), test.TestKeyPassword)
+	if err != nil {
+		t.Fatalf("Error creating key wrapper: %s", err)
+	}
+
+	if kw.WrapperID(ctx) != test.TestKeyFingerprint {
+		t.Errorf("Expected wrapper id %s got %s", test.TestKeyFingerprint, kw.WrapperID(ctx))
+	}
+
+	// The master key used in tests is the same, so both wrappers should be interchangeable
+	wrapped, err := kw.WrapKey(ctx, []byte("huebr"))
+	if err != nil {
+		t.Fatalf("Error wrapping key: %s", err)
+	}
+
+	unwrapped, err := sm.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		t.Fatalf("Error unwrapping key: %s", err)
+	}
+
+	if string(unwrapped) != "huebr" {
+		t.Errorf("Expected unwrapped key to be huebr got %s", string(unwrapped))
+	}
+
+	_, err = MakeKeyWrapper(nil, string(keyData), "wrong password")
+	if err == nil {
+		t.Errorf("Expected error creating a key wrapper with an invalid password")
+	}
+}
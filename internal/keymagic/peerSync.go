@@ -0,0 +1,620 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/database"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/slog"
+)
+
+// MaxPKSSyncKeys is the maximum number of keys requested from a peer at once
+const MaxPKSSyncKeys = 100
+
+const defaultPKSSyncTimeout = 30 * time.Second
+
+var pksSyncBucketPrefix = regexp.MustCompile(`^[0-9A-F]{2}$`)
+
+// pksSyncDigest returns the digest of the served key compared by the PKS peers, or an empty string if it cannot be computed
+func pksSyncDigest(key models.GPGKey) string {
+	digest, err := tools.KeyDigest(servedPublicKey(key))
+	if err != nil {
+		pksLog.Error("Error computing the digest of key %s: %s", key.GetShortFingerPrint(), err)
+		return ""
+	}
+
+	return digest
+}
+
+// setSyncDigest updates the sync digest of the key. Should be called before saving every change of the served key
+func setSyncDigest(key *models.GPGKey) {
+	key.SyncDigest = pksSyncDigest(*key)
+}
+
+// pksFillSyncDigests computes and stores the sync digests of the keys stored before they were added
+func pksFillSyncDigests(keys []models.GPGKey) {
+	conn := database.GetConnection()
+	index := map[string]int{}
+	missing := make([]string, 0)
+
+	for i, key := range keys {
+		if key.SyncDigest == "" {
+			index[key.FullFingerPrint] = i
+			missing = append(missing, key.FullFingerPrint)
+		}
+	}
+
+	for i := 0; i < len(missing); i += MaxPKSSyncKeys {
+		end := i + MaxPKSSyncKeys
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		stored, err := models.GetGPGKeysByFullFingerPrints(conn, missing[i:end])
+		if err != nil {
+			pksLog.Error("Error fetching the keys without sync digest: %s", err)
+			return
+		}
+
+		for _, key := range stored {
+			digest := pksSyncDigest(key)
+			if digest == "" {
+				continue
+			}
+
+			if err := models.SetGPGKeySyncDigest(conn, key.Id, digest); err != nil {
+				pksLog.Error("Error saving the sync digest of key %s: %s", key.GetShortFingerPrint(), err)
+			}
+
+			keys[index[key.FullFingerPrint]].SyncDigest = digest
+		}
+	}
+}
+
+// pksSyncEntries returns the digests of the served keys with the fingerprint starting with prefix, ordered by fingerprint
+func pksSyncEntries(prefix string) ([]models.PKSSyncEntry, error) {
+	keys, err := models.FetchKeysForSync(database.GetConnection(), prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	pksFillSyncDigests(keys)
+
+	entries := make([]models.PKSSyncEntry, 0, len(keys))
+
+	for _, key := range keys {
+		if key.SyncDigest == "" {
+			continue
+		}
+
+		entries = append(entries, models.PKSSyncEntry{
+			FingerPrint: key.FullFingerPrint,
+			Digest:      key.SyncDigest,
+		})
+	}
+
+	return entries, nil
+}
+
+// pksSyncBuckets groups the entries ordered by fingerprint in buckets and returns the digest of each bucket
+func pksSyncBuckets(entries []models.PKSSyncEntry) map[string]string {
+	hashes := map[string]string{}
+
+	for start := 0; start < len(entries); {
+		bucket := models.PKSSyncBucketOf(entries[start].FingerPrint)
+		h := sha256.New()
+
+		end := start
+		for ; end < len(entries) && models.PKSSyncBucketOf(entries[end].FingerPrint) == bucket; end++ {
+			_, _ = fmt.Fprintf(h, "%s:%s\n", entries[end].FingerPrint, entries[end].Digest)
+		}
+
+		hashes[bucket] = hex.EncodeToString(h.Sum(nil))
+		start = end
+	}
+
+	return hashes
+}
+
+// pksLocalSyncBuckets returns the stored digests of the buckets of keys in the PKS. Only the digests of the buckets
+// with keys changed since they were stored are computed again
+func pksLocalSyncBuckets() (models.PKSSyncBuckets, error) {
+	conn := database.GetConnection()
+	result := models.PKSSyncBuckets{Buckets: map[string]string{}}
+
+	stored, err := models.FetchPKSSyncBuckets(conn)
+	if err != nil {
+		return result, err
+	}
+
+	buckets := map[string]models.PKSSyncBucket{}
+	for _, b := range stored {
+		buckets[b.Id] = b
+	}
+
+	for i := 0; i < 1<<(4*models.PKSSyncBucketPrefixLength); i++ {
+		id := fmt.Sprintf("%0*X", models.PKSSyncBucketPrefixLength, i)
+
+		b, ok := buckets[id]
+		if !ok || b.Stale() {
+			if !ok {
+				b = models.PKSSyncBucket{Id: id}
+			}
+
+			entries, err := pksSyncEntries(id)
+			if err != nil {
+				return result, err
+			}
+
+			b.Digest = pksSyncBuckets(entries)[id]
+			b.Keys = len(entries)
+
+			if err := models.SetPKSSyncBucketDigest(conn, b); err != nil {
+				return result, err
+			}
+		}
+
+		if b.Keys > 0 {
+			result.Buckets[id] = b.Digest
+			result.Keys += b.Keys
+		}
+	}
+
+	return result, nil
+}
+
+// PKSSyncBuckets returns the digests of the buckets of keys in the PKS to be compared by the PKS peers
+func PKSSyncBuckets() (models.PKSSyncBuckets, error) {
+	pksLog.DebugNote("PKSSyncBuckets()")
+	if !config.EnableRethinkSKS {
+		return models.PKSSyncBuckets{}, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve sync")
+	}
+
+	return pksLocalSyncBuckets()
+}
+
+// PKSSyncBucket returns the digests of the keys in the bucket
+func PKSSyncBucket(prefix string) ([]models.PKSSyncEntry, error) {
+	pksLog.DebugNote("PKSSyncBucket(%s)", prefix)
+	if !config.EnableRethinkSKS {
+		return nil, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve sync")
+	}
+
+	prefix = strings.ToUpper(prefix)
+	if !pksSyncBucketPrefix.MatchString(prefix) {
+		return nil, fmt.Errorf("invalid bucket %q", prefix)
+	}
+
+	return pksSyncEntries(prefix)
+}
+
+// PKSSyncKeys returns the served public keys with the full fingerprints. Keys not found are ignored
+func PKSSyncKeys(fingerPrints []string) ([]string, error) {
+	pksLog.DebugNote("PKSSyncKeys(%d)", len(fingerPrints))
+	if !config.EnableRethinkSKS {
+		return nil, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve sync")
+	}
+
+	if len(fingerPrints) > MaxPKSSyncKeys {
+		return nil, fmt.Errorf("at most %d keys can be requested at once", MaxPKSSyncKeys)
+	}
+
+	keys := make([]string, 0, len(fingerPrints))
+	if len(fingerPrints) == 0 {
+		return keys, nil
+	}
+
+	for i, fp := range fingerPrints {
+		fingerPrints[i] = strings.ToUpper(fp)
+	}
+
+	stored, err := models.GetGPGKeysByFullFingerPrints(database.GetConnection(), fingerPrints)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range stored {
+		if served := servedPublicKey(key); served != "" {
+			keys = append(keys, served)
+		}
+	}
+
+	return keys, nil
+}
+
+// pksKeyRemoved returns true if the last signed operation of the key removed it from the PKS
+func pksKeyRemoved(fingerPrint string) bool {
+	entries, err := models.GetPKSAuditEntries(database.GetConnection(), fingerPrint)
+	if err != nil || len(entries) == 0 {
+		return false
+	}
+
+	return entries[len(entries)-1].Operation == models.SKSKeyOperationRemove
+}
+
+// pksRemovedComponents returns the user IDs and subkeys removed by the updateKey operations in the audit entries
+// that are not in the stored key, since they can be added again after being removed
+func pksRemovedComponents(entries []models.PKSAuditEntry, stored string) (userIDs, subKeys []string, err error) {
+	present := map[string]bool{}
+	if stored != "" {
+		storedUserIDs, storedSubKeys, err := tools.KeyComponents(stored)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, c := range append(storedUserIDs, storedSubKeys...) {
+			present[c] = true
+		}
+	}
+
+	for _, entry := range entries {
+		for _, id := range entry.RemovedUserIDs {
+			if !present[id] {
+				userIDs = append(userIDs, id)
+			}
+		}
+
+		for _, fp := range entry.RemovedSubKeys {
+			if !present[fp] {
+				subKeys = append(subKeys, fp)
+			}
+		}
+	}
+
+	return userIDs, subKeys, nil
+}
+
+// pksWithoutRemovedComponents returns the key pulled from a peer without the user IDs and subkeys removed
+// from the stored key by signed updateKey operations, since the peers do not know about the removal
+func pksWithoutRemovedComponents(pubKey, fingerPrint, stored string) (string, error) {
+	entries, err := models.GetPKSAuditEntries(database.GetConnection(), fingerPrint)
+	if err != nil {
+		return "", err
+	}
+
+	userIDs, subKeys, err := pksRemovedComponents(entries, stored)
+	if err != nil {
+		return "", err
+	}
+
+	if len(userIDs) == 0 && len(subKeys) == 0 {
+		return pubKey, nil
+	}
+
+	return tools.RemoveComponents(pubKey, userIDs, subKeys)
+}
+
+// PKSPeerSync pulls the missing and updated keys from the PKS_SYNC_PEERS into the PKS.
+// The keys of each peer are compared by bucket digests and only the keys of the buckets that differ are listed
+type PKSPeerSync struct {
+	sync.Mutex
+	log        slog.Instance
+	peers      []string
+	verified   map[string]bool
+	httpClient *http.Client
+	status     map[string]models.PKSPeerSyncStatus
+	// settled has the remote and local digests of the buckets of each peer whose last merge did not change any key
+	settled map[string]string
+	// localBuckets and localEntries return the local bucket digests and the entries of a bucket
+	localBuckets func() (models.PKSSyncBuckets, error)
+	localEntries func(prefix string) ([]models.PKSSyncEntry, error)
+}
+
+// MakePKSPeerSync creates a PKSPeerSync for the PKS_SYNC_PEERS
+func MakePKSPeerSync(log slog.Instance) *PKSPeerSync {
+	if log == nil {
+		log = slog.Scope("PeerSync")
+	} else {
+		log = log.SubScope("PeerSync")
+	}
+
+	s := &PKSPeerSync{
+		log: log,
+		httpClient: &http.Client{
+			Timeout: parseDurationOrDefault(log, "PKS_SYNC_TIMEOUT", config.PKSSyncTimeout, defaultPKSSyncTimeout),
+		},
+		status:       map[string]models.PKSPeerSyncStatus{},
+		verified:     map[string]bool{},
+		settled:      map[string]string{},
+		localBuckets: pksLocalSyncBuckets,
+		localEntries: pksSyncEntries,
+	}
+
+	for _, peer := range strings.Split(config.PKSSyncPeers, ",") {
+		if peer = strings.TrimSuffix(strings.TrimSpace(peer), "/"); peer != "" {
+			s.peers = append(s.peers, peer)
+			s.status[peer] = models.PKSPeerSyncStatus{Peer: peer}
+		}
+	}
+
+	for _, peer := range strings.Split(config.PKSSyncVerifiedPeers, ",") {
+		if peer = strings.TrimSuffix(strings.TrimSpace(peer), "/"); peer != "" {
+			s.verified[peer] = true
+		}
+	}
+
+	return s
+}
+
+var pksPeerSync *PKSPeerSync
+var pksPeerSyncLock sync.Mutex
+
+// GetPKSPeerSync returns the shared PKSPeerSync, keeping the status of the peers between calls
+func GetPKSPeerSync() *PKSPeerSync {
+	pksPeerSyncLock.Lock()
+	defer pksPeerSyncLock.Unlock()
+
+	if pksPeerSync == nil {
+		pksPeerSync = MakePKSPeerSync(pksLog)
+	}
+
+	return pksPeerSync
+}
+
+// Status returns the status of the last synchronization with each peer
+func (s *PKSPeerSync) Status() []models.PKSPeerSyncStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	status := make([]models.PKSPeerSyncStatus, 0, len(s.peers))
+	for _, peer := range s.peers {
+		status = append(status, s.status[peer])
+	}
+
+	return status
+}
+
+func (s *PKSPeerSync) setStatus(status models.PKSPeerSyncStatus) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.status[status.Peer] = status
+}
+
+func (s *PKSPeerSync) request(ctx context.Context, method, url string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", models.MimeJSON)
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned status %d", method, url, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// Sync pulls the missing and updated keys from each peer and returns their status
+func (s *PKSPeerSync) Sync(ctx context.Context) []models.PKSPeerSyncStatus {
+	local, err := s.localBuckets()
+	if err != nil {
+		s.log.Error("Error listing the keys to sync: %s", err)
+		now := time.Now()
+		for _, peer := range s.peers {
+			status := s.peerStatus(peer)
+			status.LastSync = &now
+			status.Error = fmt.Sprintf("error listing the local keys: %s", err)
+			s.setStatus(status)
+		}
+		return s.Status()
+	}
+
+	for _, peer := range s.peers {
+		s.setStatus(s.syncPeer(ctx, peer, local.Buckets))
+	}
+
+	return s.Status()
+}
+
+func (s *PKSPeerSync) peerStatus(peer string) models.PKSPeerSyncStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	return models.PKSPeerSyncStatus{
+		Peer:        peer,
+		LastSuccess: s.status[peer].LastSuccess,
+	}
+}
+
+// settledKey returns the key of the bucket of the peer in settled
+func settledKey(peer, bucket string) string {
+	return peer + " " + bucket
+}
+
+// isSettled returns true if the last merge of the bucket of the peer with the same remote and local digests did not change any key
+func (s *PKSPeerSync) isSettled(peer, bucket, remoteDigest, localDigest string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.settled[settledKey(peer, bucket)] == remoteDigest+" "+localDigest
+}
+
+func (s *PKSPeerSync) setSettled(peer, bucket, remoteDigest, localDigest string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.settled[settledKey(peer, bucket)] = remoteDigest + " " + localDigest
+}
+
+// syncPeer pulls the keys of the peer with digests different from the local ones. Buckets whose last merge
+// did not change any key are not pulled again until the remote or local digest changes
+func (s *PKSPeerSync) syncPeer(ctx context.Context, peer string, buckets map[string]string) (status models.PKSPeerSyncStatus) {
+	start := time.Now()
+	status = s.peerStatus(peer)
+	status.LastSync = &start
+
+	defer func() {
+		status.Duration = time.Since(start).String()
+	}()
+
+	var remote models.PKSSyncBuckets
+	if err := s.request(ctx, "GET", peer+"/sks/sync/buckets", nil, &remote); err != nil {
+		s.log.Error("Error syncing with %s: %s", peer, err)
+		status.Error = err.Error()
+		return status
+	}
+
+	mode := pksAddSynced
+	if s.verified[peer] {
+		mode = pksAddSyncedVerified
+	}
+
+	remoteBuckets := make([]string, 0, len(remote.Buckets))
+	for bucket := range remote.Buckets {
+		remoteBuckets = append(remoteBuckets, bucket)
+	}
+
+	sort.Strings(remoteBuckets)
+
+	for _, bucket := range remoteBuckets {
+		digest := remote.Buckets[bucket]
+		if buckets[bucket] == digest {
+			continue
+		}
+
+		status.DifferentBuckets++
+
+		if s.isSettled(peer, bucket, digest, buckets[bucket]) {
+			status.SettledBuckets++
+			continue
+		}
+
+		var entries []models.PKSSyncEntry
+		if err := s.request(ctx, "GET", peer+"/sks/sync/bucket?prefix="+url.QueryEscape(bucket), nil, &entries); err != nil {
+			s.log.Error("Error syncing with %s: %s", peer, err)
+			status.Error = err.Error()
+			return status
+		}
+
+		localEntries, err := s.localEntries(bucket)
+		if err != nil {
+			s.log.Error("Error listing the keys of bucket %s: %s", bucket, err)
+			status.Error = fmt.Sprintf("error listing the local keys: %s", err)
+			return status
+		}
+
+		local := map[string]string{}
+		for _, e := range localEntries {
+			local[e.FingerPrint] = e.Digest
+		}
+
+		var pull []string
+
+		for _, e := range entries {
+			localDigest, exists := local[e.FingerPrint]
+			if localDigest == e.Digest {
+				continue
+			}
+
+			if !exists && pksKeyRemoved(e.FingerPrint) {
+				s.log.Debug("Skipping key %s from %s because it was removed", e.FingerPrint, peer)
+				continue
+			}
+
+			pull = append(pull, e.FingerPrint)
+		}
+
+		sort.Strings(pull)
+
+		updated, failed := status.UpdatedKeys, status.FailedKeys
+
+		for i := 0; i < len(pull); i += MaxPKSSyncKeys {
+			end := i + MaxPKSSyncKeys
+			if end > len(pull) {
+				end = len(pull)
+			}
+
+			var keys []string
+			if err := s.request(ctx, "POST", peer+"/sks/sync/keys", models.SKSSyncKeys{FingerPrints: pull[i:end]}, &keys); err != nil {
+				s.log.Error("Error syncing with %s: %s", peer, err)
+				status.Error = err.Error()
+				return status
+			}
+
+			for _, key := range keys {
+				result, err := pksAddKey(ctx, key, mode)
+				if err != nil {
+					s.log.Error("Error adding key pulled from %s: %s", peer, err)
+					status.FailedKeys++
+					continue
+				}
+
+				status.PulledKeys++
+				if result.Changed {
+					status.UpdatedKeys++
+				}
+			}
+		}
+
+		if status.UpdatedKeys == updated && status.FailedKeys == failed {
+			s.log.Debug("Bucket %s of %s did not change any key. Not pulling it again until it changes", bucket, peer)
+			s.setSettled(peer, bucket, digest, buckets[bucket])
+		}
+	}
+
+	if status.FailedKeys > 0 {
+		status.Error = fmt.Sprintf("%d keys could not be added", status.FailedKeys)
+	}
+
+	now := time.Now()
+	status.LastSuccess = &now
+
+	if status.DifferentBuckets > status.SettledBuckets {
+		s.log.Info("Synced with %s: %d different buckets, %d keys pulled, %d updated, %d failed",
+			peer, status.DifferentBuckets-status.SettledBuckets, status.PulledKeys, status.UpdatedKeys, status.FailedKeys)
+	}
+
+	return status
+}
+
+// Run syncs with the peers every interval until stop receives a value
+func (s *PKSPeerSync) Run(stop chan bool, interval time.Duration) {
+	s.log.Info("Syncing keys with %v every %s", s.peers, interval)
+
+	ctx := context.Background()
+	s.Sync(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			s.log.Info("Stopped peer sync")
+			return
+		case <-ticker.C:
+			s.Sync(ctx)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package keymagic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPKSSyncBuckets(t *testing.T) {
+	entries := []models.PKSSyncEntry{
+		{FingerPrint: "00AA", Digest: "1"},
+		{FingerPrint: "00BB", Digest: "2"},
+		{FingerPrint: "FFAA", Digest: "3"},
+	}
+
+	buckets := pksSyncBuckets(entries)
+
+	if len(buckets) != 2 || buckets["00"] == "" || buckets["FF"] == "" || buckets["00"] == buckets["FF"] {
+		t.Fatalf("Expected 2 different buckets got %v", buckets)
+	}
+
+	entries[1].Digest = "4"
+	changed := pksSyncBuckets(entries)
+
+	if changed["00"] == buckets["00"] || changed["FF"] != buckets["FF"] {
+		t.Errorf("Expected only the bucket of the changed key to change got %v and %v", buckets, changed)
+	}
+}
+
+func TestPKSRemovedComponents(t *testing.T) {
+	e, err := openpgp.NewEntity("Removed", "", "removed@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := armoredPublicKey(t, e)
+	subKey := tools.ByteFingerPrint2FP16(e.Subkeys[0].PublicKey.Fingerprint[:])
+
+	entries := []models.PKSAuditEntry{
+		{Operation: models.SKSKeyOperationUpdate, RemovedUserIDs: []string{"Old <old@huebr.com>"}, RemovedSubKeys: []string{"0123456789ABCDEF"}},
+		// Added again after being removed
+		{Operation: models.SKSKeyOperationUpdate, RemovedUserIDs: []string{"Removed <removed@huebr.com>"}, RemovedSubKeys: []string{subKey}},
+	}
+
+	userIDs, subKeys, err := pksRemovedComponents(entries, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(userIDs, []string{"Old <old@huebr.com>"}) || !reflect.DeepEqual(subKeys, []string{"0123456789ABCDEF"}) {
+		t.Errorf("Expected only the components not in the stored key got %v and %v", userIDs, subKeys)
+	}
+}
+
+func TestKeyWithSyncedUserIDs(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.PKSVerifyOwnership = true
+
+	cfg := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Local", "", "local@huebr.com", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := armoredPublicKey(t, e)
+
+	existing, err := keyWithVerification(local, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer has another user ID, verified by it
+	uid := packet.NewUserId("Peer", "", "peer@huebr.com")
+	sig := &packet.Signature{
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         cfg.Hash(),
+		CreationTime: cfg.Now(),
+		IssuerKeyId:  &e.PrivateKey.KeyId,
+	}
+
+	if err := sig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(e.Identities, "Local <local@huebr.com>")
+	e.Identities[uid.Id] = &openpgp.Identity{Name: uid.Id, UserId: uid, SelfSignature: sig}
+	synced := armoredPublicKey(t, e)
+
+	merged, _, err := tools.MergeKeys(local, synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := keyWithSyncedUserIDs(merged, &existing, synced)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(key.VerifiedUserIDs, []string{"Peer <peer@huebr.com>"}) || !reflect.DeepEqual(key.PendingUserIDs, []string{"Local <local@huebr.com>"}) {
+		t.Errorf("Expected only the synced user id to be verified got %v verified and %v pending", key.VerifiedUserIDs, key.PendingUserIDs)
+	}
+}
+
+func TestPKSPeerSync(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	buckets := map[string]string{"00": "digest"}
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sks/sync/buckets" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(models.PKSSyncBuckets{Buckets: buckets, Keys: 1})
+	}))
+	defer peer.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	config.PKSSyncPeers = fmt.Sprintf("%s/, %s", peer.URL, failing.URL)
+	config.PKSSyncVerifiedPeers = peer.URL + "/"
+	config.PKSSyncTimeout = "2s"
+
+	s := MakePKSPeerSync(nil)
+	ctx := context.Background()
+
+	if len(s.peers) != 2 || s.peers[0] != peer.URL {
+		t.Fatalf("Expected 2 peers got %v", s.peers)
+	}
+
+	if !s.verified[peer.URL] || s.verified[failing.URL] {
+		t.Errorf("Expected only %s to be verified got %v", peer.URL, s.verified)
+	}
+
+	// region Same buckets
+	status := s.syncPeer(ctx, peer.URL, buckets)
+
+	if status.Error != "" || status.LastSuccess == nil || status.DifferentBuckets != 0 || status.PulledKeys != 0 {
+		t.Errorf("Expected successful sync without changes got %+v", status)
+	}
+
+	s.setStatus(status)
+	// endregion
+
+	// region Failing peer
+	status = s.syncPeer(ctx, failing.URL, buckets)
+
+	if status.Error == "" || status.LastSuccess != nil || status.LastSync == nil {
+		t.Errorf("Expected failed sync got %+v", status)
+	}
+
+	s.setStatus(status)
+	// endregion
+
+	// region Status
+	all := s.Status()
+
+	if len(all) != 2 || all[0].Peer != peer.URL || all[0].LastSuccess == nil || all[1].Error == "" {
+		t.Errorf("Unexpected status %+v", all)
+	}
+	// endregion
+}
+
+func TestPKSPeerSyncSettledBuckets(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	entries := []models.PKSSyncEntry{{FingerPrint: "00AA", Digest: "1"}}
+	bucketRequests := 0
+
+	// The peer computes the bucket digest differently, but has the same keys
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sks/sync/buckets":
+			_ = json.NewEncoder(w).Encode(models.PKSSyncBuckets{Buckets: map[string]string{"00": "remote"}, Keys: 1})
+		case "/sks/sync/bucket":
+			bucketRequests++
+			_ = json.NewEncoder(w).Encode(entries)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer peer.Close()
+
+	config.PKSSyncPeers = peer.URL
+	config.PKSSyncTimeout = "2s"
+
+	s := MakePKSPeerSync(nil)
+	s.localEntries = func(prefix string) ([]models.PKSSyncEntry, error) {
+		return entries, nil
+	}
+
+	ctx := context.Background()
+	local := map[string]string{"00": "local"}
+
+	status := s.syncPeer(ctx, peer.URL, local)
+
+	if status.Error != "" || status.DifferentBuckets != 1 || status.SettledBuckets != 0 || bucketRequests != 1 {
+		t.Fatalf("Expected the different bucket to be pulled got %+v and %d requests", status, bucketRequests)
+	}
+
+	status = s.syncPeer(ctx, peer.URL, local)
+
+	if status.Error != "" || status.DifferentBuckets != 1 || status.SettledBuckets != 1 || bucketRequests != 1 {
+		t.Errorf("Expected the settled bucket not to be pulled again got %+v and %d requests", status, bucketRequests)
+	}
+
+	// The local bucket changed, so it is pulled again
+	local["00"] = "changed"
+	status = s.syncPeer(ctx, peer.URL, local)
+
+	if status.SettledBuckets != 0 || bucketRequests != 2 {
+		t.Errorf("Expected the changed bucket to be pulled again got %+v and %d requests", status, bucketRequests)
+	}
+}
@@ -44,6 +44,12 @@ import (
 
 const MinKeyBits = 2048 // Should be safe until we have decent Quantum Computers
 
+// storedKeyMetadata is the json metadata stored with the keys in the key backend
+type storedKeyMetadata struct {
+	Password string `json:"password,omitempty"`
+	models.KeyMetadata
+}
+
 type pgpManager struct {
 	sync.Mutex
 	KeysBase64Encoded    bool
@@ -52,6 +58,7 @@ type pgpManager struct {
 	entities             map[string]*openpgp.Entity
 	fp8to16              map[string]string
 	subKeyToKey          map[string]string
+	metadata             map[string]*models.KeyMetadata
 	krm                  interfaces.KeyRingManager
 	kbkend               interfaces.StorageBackend
 	log                  slog.Instance
@@ -77,6 +84,7 @@ func MakePGPManager(log slog.Instance, keyBackend interfaces.StorageBackend, krm
 		entities:             make(map[string]*openpgp.Entity),
 		fp8to16:              make(map[string]string),
 		subKeyToKey:          make(map[string]string),
+		metadata:             make(map[string]*models.KeyMetadata),
 		krm:                  krm,
 		log:                  log,
 	}
@@ -125,7 +133,7 @@ func (pm *pgpManager) LoadKeys(ctx context.Context) {
 				keyData = string(b)
 			}
 
-			kl, err := pm.LoadKeyWithMetadata(ctx, keyData, m)
+			kl, err := pm.loadKeyWithMetadata(ctx, keyData, m)
 			if err != nil {
 				log.Error("Error decoding key %s: %s", file, err)
 				continue
@@ -140,10 +148,18 @@ func (pm *pgpManager) LoadKeys(ctx context.Context) {
 
 // LoadKeyWithMetadata loads a armored ascii key with the specified json metadata
 func (pm *pgpManager) LoadKeyWithMetadata(ctx context.Context, armoredKey, metadata string) (int, error) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	return pm.loadKeyWithMetadata(ctx, armoredKey, metadata)
+}
+
+// loadKeyWithMetadata loads a armored ascii key with the specified json metadata. Should be called with the lock held
+func (pm *pgpManager) loadKeyWithMetadata(ctx context.Context, armoredKey, metadata string) (int, error) {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pm.log.Tag(requestID)
 	log.DebugNote("LoadKeyWithMetadata(---, ---)")
-	n, err := pm.LoadKey(ctx, armoredKey)
+	n, err := pm.loadKey(ctx, armoredKey)
 
 	if err != nil {
 		return n, err
@@ -156,15 +172,17 @@ func (pm *pgpManager) LoadKeyWithMetadata(ctx context.Context, armoredKey, metad
 	}
 
 	if metadata != "" {
-		var meta map[string]string
+		var meta storedKeyMetadata
 		err = json.Unmarshal([]byte(metadata), &meta)
 		if err != nil {
 			log.Warn("Error decoding metadata: %s", err)
 			return n, nil
 		}
 
-		if meta["password"] != "" {
-			err = pm.unlockKey(ctx, fp, meta["password"])
+		pm.metadata[fp] = &meta.KeyMetadata
+
+		if meta.Password != "" {
+			err = pm.unlockKey(ctx, fp, meta.Password)
 			if err != nil {
 				log.Error("Cannot unlock key %s using metadata: %s", fp, err)
 				return n, nil
@@ -187,6 +205,14 @@ func (pm *pgpManager) SetKeysBase64Encoded(k bool) {
 
 // LoadKey loads a armored ascii key
 func (pm *pgpManager) LoadKey(ctx context.Context, armoredKey string) (int, error) {
+	pm.Lock()
+	defer pm.Unlock()
+
+	return pm.loadKey(ctx, armoredKey)
+}
+
+// loadKey loads a armored ascii key. Should be called with the lock held
+func (pm *pgpManager) loadKey(ctx context.Context, armoredKey string) (int, error) {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pm.log.Tag(requestID)
 	log.DebugNote("LoadKey(---)")
@@ -265,7 +291,7 @@ func (pm *pgpManager) IsKeyLocked(fp string) bool {
 
 func (pm *pgpManager) unlockKey(ctx context.Context, fp, password string) error {
 	fp = pm.sanitizeFingerprint(fp)
-	_ = pm.LoadKeyFromKB(ctx, fp)
+	_ = pm.loadKeyFromKB(ctx, fp)
 
 	ent := pm.entities[fp]
 
@@ -328,7 +354,16 @@ func (pm *pgpManager) UnlockKey(ctx context.Context, fp, password string) error
 	return pm.unlockKey(ctx, fp, password)
 }
 
+// LoadKeyFromKB loads the specified key from the key backend if it is not loaded yet
 func (pm *pgpManager) LoadKeyFromKB(ctx context.Context, fingerPrint string) error {
+	pm.Lock()
+	defer pm.Unlock()
+
+	return pm.loadKeyFromKB(ctx, fingerPrint)
+}
+
+// loadKeyFromKB loads the specified key from the key backend if it is not loaded yet. Should be called with the lock held
+func (pm *pgpManager) loadKeyFromKB(ctx context.Context, fingerPrint string) error {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pm.log.Tag(requestID)
 	log.Info("Loading key %s", fingerPrint)
@@ -351,7 +386,7 @@ func (pm *pgpManager) LoadKeyFromKB(ctx context.Context, fingerPrint string) err
 		keyData = string(b)
 	}
 
-	_, err = pm.LoadKeyWithMetadata(ctx, keyData, m)
+	_, err = pm.loadKeyWithMetadata(ctx, keyData, m)
 	if err != nil {
 		return err
 	}
@@ -364,6 +399,9 @@ func (pm *pgpManager) GetPrivateKeyInfo(ctx context.Context, fingerPrint string)
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pm.log.Tag(requestID)
 	log.DebugNote("GetPrivateKeyInfo(%s)", fingerPrint)
+	pm.Lock()
+	defer pm.Unlock()
+
 	for k, e := range pm.entities {
 		v := e.PrivateKey
 		if v == nil {
@@ -378,6 +416,7 @@ func (pm *pgpManager) GetPrivateKeyInfo(ctx context.Context, fingerPrint string)
 				Bits:                  int(z),
 				ContainsPrivateKey:    true,
 				PrivateKeyIsDecrypted: pm.decryptedPrivateKeys[k] != nil,
+				Metadata:              pm.metadata[k],
 			}
 		}
 	}
@@ -390,6 +429,9 @@ func (pm *pgpManager) GetLoadedPrivateKeys(ctx context.Context) []models.KeyInfo
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pm.log.Tag(requestID)
 	log.DebugNote("GetLoadedPrivateKeys()")
+	pm.Lock()
+	defer pm.Unlock()
+
 	keyInfos := make([]models.KeyInfo, 0)
 
 	for k, e := range pm.entities {
@@ -405,6 +447,7 @@ func (pm *pgpManager) GetLoadedPrivateKeys(ctx context.Context) []models.KeyInfo
 			Bits:                  int(z),
 			ContainsPrivateKey:    true,
 			PrivateKeyIsDecrypted: pm.decryptedPrivateKeys[k] != nil,
+			Metadata:              pm.metadata[k],
 		}
 		keyInfos = append(keyInfos, keyInfo)
 	}
@@ -415,6 +458,9 @@ func (pm *pgpManager) GetLoadedPrivateKeys(ctx context.Context) []models.KeyInfo
 // GetLoadedKeys returns the information for all keys in PGP Manager
 func (pm *pgpManager) GetLoadedKeys() []models.KeyInfo {
 	pm.log.DebugNote("GetLoadedKeys()")
+	pm.Lock()
+	defer pm.Unlock()
+
 	keyInfos := make([]models.KeyInfo, 0)
 
 	for k, e := range pm.entities {
@@ -425,6 +471,7 @@ func (pm *pgpManager) GetLoadedKeys() []models.KeyInfo {
 			Bits:                  int(z),
 			ContainsPrivateKey:    e.PrivateKey != nil,
 			PrivateKeyIsDecrypted: pm.decryptedPrivateKeys[k] != nil,
+			Metadata:              pm.metadata[k],
 		}
 		keyInfos = append(keyInfos, keyInfo)
 	}
@@ -434,7 +481,13 @@ func (pm *pgpManager) GetLoadedKeys() []models.KeyInfo {
 
 // SaveKey saves the specified key in PGP Manager Key Backend
 func (pm *pgpManager) SaveKey(fingerPrint, armoredData string, password interface{}) error {
-	pm.log.DebugNote("SaveKey(%s, %s, ---)", fingerPrint, tools.TruncateFieldForDisplay(armoredData))
+	return pm.SaveKeyWithMetadata(fingerPrint, armoredData, password, nil)
+}
+
+// SaveKeyWithMetadata saves the specified key with its metadata in PGP Manager Key Backend.
+// If the metadata does not have a creation time, the current time is used
+func (pm *pgpManager) SaveKeyWithMetadata(fingerPrint, armoredData string, password interface{}, metadata *models.KeyMetadata) error {
+	pm.log.DebugNote("SaveKeyWithMetadata(%s, %s, ---, ---)", fingerPrint, tools.TruncateFieldForDisplay(armoredData))
 	filename := fmt.Sprintf("%s.key", fingerPrint)
 	if pm.KeysBase64Encoded {
 		filename = fmt.Sprintf("%s.b64", fingerPrint)
@@ -451,10 +504,25 @@ func (pm *pgpManager) SaveKey(fingerPrint, armoredData string, password interfac
 		data = []byte(base64.StdEncoding.EncodeToString(data))
 	}
 	metadataJson := ""
-	if password != nil {
-		metadata := map[string]string{}
-		metadata["password"] = password.(string)
-		mj, _ := json.Marshal(metadata)
+	if password != nil || metadata != nil {
+		meta := storedKeyMetadata{}
+		if password != nil {
+			meta.Password = password.(string)
+		}
+		if metadata != nil {
+			meta.KeyMetadata = *metadata
+			if meta.CreatedAt == nil {
+				now := time.Now()
+				meta.CreatedAt = &now
+			}
+			pm.Lock()
+			if fp := pm.sanitizeFingerprint(fingerPrint); fp != "" {
+				m := meta.KeyMetadata
+				pm.metadata[fp] = &m
+			}
+			pm.Unlock()
+		}
+		mj, _ := json.Marshal(meta)
 		metadataJson = string(mj)
 	}
 
@@ -497,6 +565,50 @@ func (pm *pgpManager) DeleteKey(ctx context.Context, fingerPrint string) error {
 	return nil
 }
 
+// UnloadKey removes the specified key and its subkeys from the memory without touching the key backend
+func (pm *pgpManager) UnloadKey(ctx context.Context, fingerPrint string) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pm.log.Tag(requestID)
+	log.DebugNote("UnloadKey(%s)", fingerPrint)
+
+	pm.Lock()
+	fingerPrint = pm.sanitizeFingerprint(fingerPrint)
+	if fingerPrint == "" {
+		pm.Unlock()
+		return
+	}
+
+	fps := []string{fingerPrint}
+
+	if ent := pm.entities[fingerPrint]; ent != nil {
+		for _, sub := range ent.Subkeys {
+			fps = append(fps, tools.ByteFingerPrint2FP16(sub.PublicKey.Fingerprint[:]))
+		}
+	}
+
+	for subKeyFp, fp := range pm.subKeyToKey {
+		if fp == fingerPrint {
+			delete(pm.subKeyToKey, subKeyFp)
+		}
+	}
+
+	for _, fp := range fps {
+		delete(pm.decryptedPrivateKeys, fp)
+		delete(pm.entities, fp)
+	}
+
+	delete(pm.keyIdentity, fingerPrint)
+	delete(pm.fp8to16, fingerPrint[8:])
+	delete(pm.metadata, fingerPrint)
+	pm.Unlock()
+
+	for _, fp := range fps {
+		_ = pm.krm.DeleteKey(ctx, fp)
+	}
+
+	log.Info("Unloaded key %s from memory", fingerPrint)
+}
+
 // SignData signs the specified data with a unlocked private key
 func (pm *pgpManager) SignData(ctx context.Context, fingerPrint string, data []byte, hashAlgorithm crypto.Hash) (string, error) {
 	requestID := tools.GetRequestIDFromContext(ctx)
@@ -564,16 +676,13 @@ func (pm *pgpManager) GetPublicKeyEntity(ctx context.Context, fingerPrint string
 		subMaster := pm.subKeyToKey[fingerPrint]
 		if len(subMaster) > 0 {
 			ent = pm.entities[subMaster]
+			pm.entities[fingerPrint] = ent
 		} else {
-			// Try PKS
+			// Try PKS. Not stored in entities so it is refreshed by the KeyRingManager
 			ent = pm.krm.GetKey(ctx, fingerPrint)
 		}
 	}
 
-	if ent != nil {
-		pm.entities[fingerPrint] = ent
-	}
-
 	return ent
 }
 
@@ -597,7 +706,7 @@ func (pm *pgpManager) GetPublicKey(ctx context.Context, fingerPrint string) *pac
 		subMaster := pm.subKeyToKey[fingerPrint]
 		if len(subMaster) > 0 {
 			ent = pm.entities[subMaster]
-			pubKey = ent.PrimaryKey
+			pm.entities[fingerPrint] = ent
 			log.Note("Found as master key %s", fingerPrint)
 		} else {
 			// Try PKS
@@ -613,7 +722,6 @@ func (pm *pgpManager) GetPublicKey(ctx context.Context, fingerPrint string) *pac
 
 	if ent != nil {
 		pubKey = ent.PrimaryKey
-		pm.entities[fingerPrint] = ent
 	}
 
 	return pubKey
@@ -831,7 +939,7 @@ func (pm *pgpManager) VerifySignature(ctx context.Context, data []byte, signatur
 	log := pm.log.Tag(requestID)
 	log.DebugNote("VerifySignature(---, %s)", tools.TruncateFieldForDisplay(signature))
 	var issuerKeyId uint64
-	var publicKey *packet.PublicKey
+	var entity *openpgp.Entity
 	var fingerprint string
 
 	signature = tools.SignatureFix(signature)
@@ -846,7 +954,6 @@ func (pm *pgpManager) VerifySignature(ctx context.Context, data []byte, signatur
 	}
 
 	reader := packet.NewReader(block.Body)
-	publicKey = nil
 	foundSignatureFingerprints := make([]string, 0)
 
 	for {
@@ -875,19 +982,19 @@ func (pm *pgpManager) VerifySignature(ctx context.Context, data []byte, signatur
 		}
 
 		if len(fingerprint) == 16 {
-			publicKey = pm.GetPublicKey(ctx, fingerprint)
-			if publicKey != nil {
+			entity = pm.GetPublicKeyEntity(ctx, fingerprint)
+			if entity != nil {
 				break
 			}
 		}
 	}
 
-	if publicKey == nil {
+	if entity == nil {
 		return false, fmt.Errorf("cannot find public key for any of these signatures: %s", strings.Join(foundSignatureFingerprints, ", "))
 	}
 
 	keyRing := make(openpgp.EntityList, 1)
-	keyRing[0] = pm.entities[fingerprint]
+	keyRing[0] = entity
 
 	dr := bytes.NewReader(data)
 	sr := strings.NewReader(signature)
@@ -1029,17 +1136,11 @@ func (pm *pgpManager) Encrypt(ctx context.Context, filename, fingerPrint string,
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pm.log.Tag(requestID)
 	log.DebugNote("Encrypt(%s, %s, ---, %v)", filename, fingerPrint, dataOnly)
-	var pubKey = pm.GetPublicKey(ctx, fingerPrint)
+	entity := pm.GetPublicKeyEntity(ctx, fingerPrint)
 
-	if pubKey == nil {
+	if entity == nil {
 		return "", fmt.Errorf("no public key for %s", fingerPrint)
 	}
-	fingerPrint = tools.ByteFingerPrint2FP16(pubKey.Fingerprint[:])
-	var entity *openpgp.Entity
-
-	pm.Lock()
-	entity = pm.entities[fingerPrint]
-	pm.Unlock()
 
 	buf := bytes.NewBuffer(nil)
 
@@ -1103,14 +1204,20 @@ func (pm *pgpManager) Encrypt(ctx context.Context, filename, fingerPrint string,
 	return buf.String(), nil
 }
 
-// Decrypt decrypts data using any available unlocked private key
-func (pm *pgpManager) Decrypt(ctx context.Context, data string, dataOnly bool) (*models.GPGDecryptedData, error) {
-	requestID := tools.GetRequestIDFromContext(ctx)
-	log := pm.log.Tag(requestID)
-	log.DebugNote("Decrypt(%s, %v)", tools.TruncateFieldForDisplay(data), dataOnly)
-	var err error
+// decryptionKey is an unlocked private key of a recipient of the data, with its entity and the subkey entity
+type decryptionKey struct {
+	decv        *packet.PrivateKey
+	ent, subent *openpgp.Entity
+}
+
+func (k decryptionKey) fingerPrint() string {
+	return tools.IssuerKeyIdToFP16(k.ent.PrimaryKey.KeyId)
+}
+
+// decryptionKeys returns the unlocked private keys of the recipients of the data, in the order of the recipients
+func (pm *pgpManager) decryptionKeys(ctx context.Context, data string, dataOnly bool) ([]decryptionKey, error) {
 	var fps []string
-	ret := &models.GPGDecryptedData{}
+	var err error
 
 	if dataOnly {
 		fps, err = tools.GetFingerPrintsFromEncryptedMessageRaw(data)
@@ -1126,41 +1233,98 @@ func (pm *pgpManager) Decrypt(ctx context.Context, data string, dataOnly bool) (
 		return nil, fmt.Errorf("no encrypted payloads found")
 	}
 
-	var decv *packet.PrivateKey
-	var ent openpgp.Entity
-	var subent *openpgp.Entity
-
 	pm.LoadKeys(ctx)
 
+	var keys []decryptionKey
+
 	pm.Lock()
 	for _, v := range fps {
 		// Try directly
-		_ = pm.LoadKeyFromKB(ctx, v)
-		decv = pm.decryptedPrivateKeys[v]
-		if decv != nil {
-			ent = *pm.entities[v]
-			break
+		_ = pm.loadKeyFromKB(ctx, v)
+		if decv := pm.decryptedPrivateKeys[v]; decv != nil {
+			keys = append(keys, decryptionKey{decv: decv, ent: pm.entities[v]})
+			continue
 		}
 
 		// Try subkeys
 		subKeyMaster := pm.subKeyToKey[v]
 		if len(subKeyMaster) > 0 {
-			_ = pm.LoadKeyFromKB(ctx, subKeyMaster)
+			_ = pm.loadKeyFromKB(ctx, subKeyMaster)
 			// Check if it is decrypted
-			decv = pm.decryptedPrivateKeys[subKeyMaster]
-			if decv != nil {
-				ent = *pm.entities[subKeyMaster]
-				subent = pm.entities[v]
-				break
+			if decv := pm.decryptedPrivateKeys[subKeyMaster]; decv != nil {
+				keys = append(keys, decryptionKey{decv: decv, ent: pm.entities[subKeyMaster], subent: pm.entities[v]})
 			}
 		}
 	}
 	pm.Unlock()
 
-	if decv == nil {
+	if len(keys) == 0 {
 		return nil, fmt.Errorf("no unlocked key for decrypting packet")
 	}
 
+	return keys, nil
+}
+
+// GetDecryptionKeys returns the fingerprints of the unlocked private keys of the recipients of the data
+func (pm *pgpManager) GetDecryptionKeys(ctx context.Context, data string, dataOnly bool) ([]string, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pm.log.Tag(requestID)
+	log.DebugNote("GetDecryptionKeys(%s, %v)", tools.TruncateFieldForDisplay(data), dataOnly)
+
+	keys, err := pm.decryptionKeys(ctx, data, dataOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	fps := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fps = append(fps, key.fingerPrint())
+	}
+
+	return fps, nil
+}
+
+// Decrypt decrypts data using any available unlocked private key
+func (pm *pgpManager) Decrypt(ctx context.Context, data string, dataOnly bool) (*models.GPGDecryptedData, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pm.log.Tag(requestID)
+	log.DebugNote("Decrypt(%s, %v)", tools.TruncateFieldForDisplay(data), dataOnly)
+
+	keys, err := pm.decryptionKeys(ctx, data, dataOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return pm.decrypt(data, dataOnly, keys[0])
+}
+
+// DecryptWithKey decrypts data using only the unlocked private key of the fingerprint
+func (pm *pgpManager) DecryptWithKey(ctx context.Context, data string, dataOnly bool, fingerPrint string) (*models.GPGDecryptedData, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pm.log.Tag(requestID)
+	log.DebugNote("DecryptWithKey(%s, %v, %s)", tools.TruncateFieldForDisplay(data), dataOnly, fingerPrint)
+
+	keys, err := pm.decryptionKeys(ctx, data, dataOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if key.fingerPrint() == fingerPrint {
+			return pm.decrypt(data, dataOnly, key)
+		}
+	}
+
+	return nil, fmt.Errorf("the key %s is not an unlocked recipient of the data", fingerPrint)
+}
+
+func (pm *pgpManager) decrypt(data string, dataOnly bool, key decryptionKey) (*models.GPGDecryptedData, error) {
+	ret := &models.GPGDecryptedData{}
+	decv, entity, subent := key.decv, key.ent, key.subent
+
+	// The private key is set in a copy of the entity
+	ent := *entity
+
 	keyRing := make(openpgp.EntityList, 1)
 	ent.PrivateKey = decv
 	keyRing[0] = &ent
@@ -1217,3 +1381,11 @@ func (pm *pgpManager) GetCachedKeys(ctx context.Context) []models.KeyInfo {
 	log.DebugNote("GetCachedKeys()")
 	return pm.krm.GetCachedKeys(ctx)
 }
+
+// GetCacheStats returns the hit, miss and eviction counters of the public key cache
+func (pm *pgpManager) GetCacheStats(ctx context.Context) models.KeyRingCacheStats {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pm.log.Tag(requestID)
+	log.DebugNote("GetCacheStats()")
+	return pm.krm.GetCacheStats(ctx)
+}
@@ -6,8 +6,12 @@ import (
 	"encoding/base64"
 	"github.com/quan-to/chevron/internal/tools"
 	"io/ioutil"
+	"os"
+	"sync"
 	"testing"
 
+	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/test"
 )
 
@@ -78,6 +82,32 @@ func TestDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecryptWithKey(t *testing.T) {
+	ctx := context.Background()
+	fps, err := pgpMan.GetDecryptionKeys(ctx, test.TestDecryptDataAscii, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fps) != 1 {
+		t.Fatalf("Expected one decryption key got %v", fps)
+	}
+
+	g, err := pgpMan.DecryptWithKey(ctx, test.TestDecryptDataAscii, false, fps[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gd, _ := base64.StdEncoding.DecodeString(g.Base64Data)
+	if g.FingerPrint != fps[0] || string(gd) != test.TestSignatureData {
+		t.Errorf("Expected decryption with %s got %s and %q", fps[0], g.FingerPrint, string(gd))
+	}
+
+	if _, err := pgpMan.DecryptWithKey(ctx, test.TestDecryptDataAscii, false, "FFFFFFFFFFFFFFFF"); err == nil {
+		t.Errorf("Expected error decrypting with a key that is not a recipient")
+	}
+}
+
 func TestDecryptRaw(t *testing.T) {
 	ctx := context.Background()
 	b, err := ioutil.ReadFile("../../test/data/testraw.gpg")
@@ -178,6 +208,85 @@ func TestGenerateKey(t *testing.T) {
 	}
 }
 
+func TestUnloadKey(t *testing.T) {
+	ctx := context.Background()
+	key, err := pgpMan.GeneratePGPKey(ctx, "HUE", test.TestKeyFingerprint, pgpMan.MinKeyBits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pgpMan.LoadKeyWithMetadata(ctx, key, `{"password":"`+test.TestKeyFingerprint+`"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp, _ := tools.GetFingerPrintFromKey(key)
+
+	if pgpMan.IsKeyLocked(fp) {
+		t.Fatalf("Expected key %s to be unlocked", fp)
+	}
+
+	pgpMan.UnloadKey(ctx, fp)
+
+	if pgpMan.GetPrivateKeyInfo(ctx, fp) != nil {
+		t.Errorf("Expected key %s to be unloaded", fp)
+	}
+
+	if pgpMan.krm.ContainsKey(ctx, fp) {
+		t.Errorf("Expected key %s to be removed from KRM", fp)
+	}
+
+	if !pgpMan.IsKeyLocked(fp) {
+		t.Errorf("Expected key %s to not be unlocked anymore", fp)
+	}
+}
+
+func TestLoadKeyWithMetadataConcurrent(t *testing.T) {
+	ctx := context.Background()
+	folder, err := ioutil.TempDir("", "pgpman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	pm := MakePGPManager(nil, keybackend.MakeSaveToDiskBackend(nil, folder, "key_"), MakeKeyRingManager(nil)).(*pgpManager)
+
+	key, err := pm.GeneratePGPKey(ctx, "HUE", test.TestKeyFingerprint, pm.MinKeyBits())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp, _ := tools.GetFingerPrintFromKey(key)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if _, err := pm.LoadKeyWithMetadata(ctx, key, `{"OwnerTeam":"loaded"}`); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := pm.SaveKeyWithMetadata(fp, key, nil, &models.KeyMetadata{OwnerTeam: "saved"}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = pm.GetLoadedKeys()
+			_ = pm.GetPrivateKeyInfo(ctx, fp)
+		}()
+	}
+	wg.Wait()
+
+	info := pm.GetPrivateKeyInfo(ctx, fp)
+	if info == nil || info.Metadata == nil {
+		t.Fatalf("Expected key %s to be loaded with metadata", fp)
+	}
+}
+
 // endregion
 // region Benchmarks
 func BenchmarkSign(b *testing.B) {
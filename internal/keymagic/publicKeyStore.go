@@ -3,10 +3,13 @@ package keymagic
 import (
 	"context"
 	"fmt"
+	"strings"
+
 	"github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/database"
 	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/interfaces"
 
 	"github.com/quan-to/slog"
 )
@@ -18,24 +21,82 @@ func PKSGetKey(ctx context.Context, fingerPrint string) (string, error) {
 	log := pksLog.Tag(requestID)
 	log.DebugNote("PKSGetKey(%s)", fingerPrint)
 	if !config.EnableRethinkSKS {
-		return GetSKSKey(fingerPrint)
+		return GetSKSKeyWithContext(ctx, fingerPrint)
 	}
 
 	conn := database.GetConnection()
 	v, err := models.GetGPGKeyByFingerPrint(conn, fingerPrint)
 
 	if v != nil {
-		return v.AsciiArmoredPublicKey, nil
+		return servedPublicKey(*v), nil
+	}
+
+	if err != nil && err != models.ErrGPGKeyNotFound {
+		return "", err
+	}
+
+	if config.PKSUpstreamLookup {
+		return pksFetchUpstreamKey(ctx, fingerPrint)
+	}
+
+	return "", interfaces.ErrKeyNotFound
+}
+
+// pksFetchUpstreamKey fetches the key from the upstream key servers and stores it in the PKS like an added key
+func pksFetchUpstreamKey(ctx context.Context, fingerPrint string) (string, error) {
+	key, err := GetSKSKeyWithContext(ctx, fingerPrint)
+	if err != nil {
+		return "", err
+	}
+
+	return pksStoreUpstreamKey(ctx, key)
+}
+
+func pksStoreUpstreamKey(ctx context.Context, key string) (string, error) {
+	log := pksLog.Tag(tools.GetRequestIDFromContext(ctx))
+
+	result, err := PKSAddKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("Stored key %s fetched from the upstream key servers", result.FingerPrint)
+
+	v, err := models.GetGPGKeyByFingerPrint(database.GetConnection(), result.FingerPrint)
+	if err != nil {
+		return "", err
+	}
+
+	return servedPublicKey(*v), nil
+}
+
+// PKSFetchKeyByEmail fetches a key with the email from the upstream key servers, or by Web Key Directory with SKS_WKD_LOOKUP.
+// With the internal PKS the key is only fetched if PKS_UPSTREAM_LOOKUP is enabled, and is stored in the PKS
+func PKSFetchKeyByEmail(ctx context.Context, email string) (string, error) {
+	log := pksLog.Tag(tools.GetRequestIDFromContext(ctx))
+	log.DebugNote("PKSFetchKeyByEmail(%s)", email)
+
+	if config.EnableRethinkSKS && !config.PKSUpstreamLookup {
+		return "", fmt.Errorf("the upstream lookup is disabled")
+	}
+
+	key, err := getKeyServerClient().GetKeyByEmail(ctx, email)
+	if err != nil {
+		return "", err
 	}
 
-	return "", err
+	if !config.EnableRethinkSKS {
+		return key, nil
+	}
+
+	return pksStoreUpstreamKey(ctx, key)
 }
 
 func PKSSearchByName(name string, pageStart, pageEnd int) ([]models.GPGKey, error) {
 	pksLog.DebugNote("PKSSearchByName(%s, %d, %d)", name, pageStart, pageEnd)
 	if config.EnableRethinkSKS {
 		conn := database.GetConnection()
-		return models.SearchGPGKeyByName(conn, name, pageStart, pageEnd)
+		return servedKeys(models.SearchGPGKeyByName(conn, name, pageStart, pageEnd))
 	}
 	return nil, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve search")
 }
@@ -44,7 +105,7 @@ func PKSSearchByFingerPrint(fingerPrint string, pageStart, pageEnd int) ([]model
 	pksLog.DebugNote("PKSSearchByFingerPrint(%s, %d, %d)", fingerPrint, pageStart, pageEnd)
 	if config.EnableRethinkSKS {
 		conn := database.GetConnection()
-		return models.SearchGPGKeyByFingerPrint(conn, fingerPrint, pageStart, pageEnd)
+		return servedKeys(models.SearchGPGKeyByFingerPrint(conn, fingerPrint, pageStart, pageEnd))
 	}
 	return nil, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve search")
 }
@@ -53,7 +114,7 @@ func PKSSearchByEmail(email string, pageStart, pageEnd int) ([]models.GPGKey, er
 	pksLog.DebugNote("PKSSearchByEmail(%s, %d, %d)", email, pageStart, pageEnd)
 	if config.EnableRethinkSKS {
 		conn := database.GetConnection()
-		return models.SearchGPGKeyByEmail(conn, email, pageStart, pageEnd)
+		return servedKeys(models.SearchGPGKeyByEmail(conn, email, pageStart, pageEnd))
 	}
 	return nil, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve search")
 }
@@ -62,55 +123,196 @@ func PKSSearch(value string, pageStart, pageEnd int) ([]models.GPGKey, error) {
 	pksLog.DebugNote("PKSSearch(%s, %d, %d)", value, pageStart, pageEnd)
 	if config.EnableRethinkSKS {
 		conn := database.GetConnection()
-		return models.SearchGPGKeyByValue(conn, value, pageStart, pageEnd)
+		return servedKeys(models.SearchGPGKeyByValue(conn, value, pageStart, pageEnd))
 	}
 	return nil, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve search")
 }
 
+// PKSSearchKeys returns a page of the keys matching the search filters
+func PKSSearchKeys(search models.GPGKeySearch) (models.GPGKeySearchResult, error) {
+	pksLog.DebugNote("PKSSearchKeys(%+v)", search)
+	if !config.EnableRethinkSKS {
+		return models.GPGKeySearchResult{}, fmt.Errorf("the server does not have RethinkDB enabled so it cannot serve search")
+	}
+
+	conn := database.GetConnection()
+	result, err := models.SearchGPGKeys(conn, search)
+	if err != nil {
+		return result, err
+	}
+
+	result.Keys, err = servedKeys(result.Keys, nil)
+
+	return result, err
+}
+
+// PKSAdd adds or updates the public key in the Public Key Store. Returns "OK" on success and "NOK" on error
 func PKSAdd(ctx context.Context, pubKey string) string {
+	_, err := PKSAddKey(ctx, pubKey)
+	if err != nil {
+		return "NOK"
+	}
+
+	return "OK"
+}
+
+// PKSAddKey adds the public key to the Public Key Store. If the key already exists,
+// the new user IDs, subkeys and signatures are merged into the stored one.
+// With PKS_VERIFY_OWNERSHIP the new user IDs are pending until verified and a challenge is returned
+func PKSAddKey(ctx context.Context, pubKey string) (models.KeyMergeResult, error) {
+	return pksAddKey(ctx, pubKey, pksAddUnverified)
+}
+
+// PKSAddVerifiedKey adds the public key to the Public Key Store like PKSAddKey, but with all user IDs verified.
+// Should only be used when the ownership of the key was already proven, like when adding its private key
+func PKSAddVerifiedKey(ctx context.Context, pubKey string) (models.KeyMergeResult, error) {
+	return pksAddKey(ctx, pubKey, pksAddVerified)
+}
+
+// pksKeyLimits returns the certificate flooding limits from the PKS configuration
+func pksKeyLimits() tools.KeyLimits {
+	var trusted []string
+	for _, fp := range strings.Split(config.PKSTrustedCertifiers, ",") {
+		if fp = strings.TrimSpace(fp); fp != "" {
+			trusted = append(trusted, fp)
+		}
+	}
+
+	return tools.KeyLimits{
+		MaxSize:             config.PKSMaxKeySize,
+		MaxUserIDs:          config.PKSMaxUserIDs,
+		MaxCertifications:   config.PKSMaxCertifications,
+		StripCertifications: config.PKSStripCertifications,
+		TrustedCertifiers:   trusted,
+		StripUserAttributes: config.PKSStripUserAttributes,
+	}
+}
+
+// pksAddMode is how the user IDs of the keys added to the PKS are verified
+type pksAddMode int
+
+const (
+	pksAddUnverified pksAddMode = iota
+	pksAddVerified
+	// pksAddSynced adds the keys pulled from the PKS peers without the components removed locally. New user IDs are pending verification
+	pksAddSynced
+	// pksAddSyncedVerified adds the keys pulled from the PKS_SYNC_VERIFIED_PEERS like pksAddSynced, verifying their user IDs
+	pksAddSyncedVerified
+)
+
+func pksAddKey(ctx context.Context, pubKey string, mode pksAddMode) (models.KeyMergeResult, error) {
 	requestID := tools.GetRequestIDFromContext(ctx)
 	log := pksLog.Tag(requestID)
-	log.DebugNote("PKSAdd(---)")
+	log.DebugNote("PKSAddKey(---, %d)", mode)
 	if config.EnableRethinkSKS {
 		conn := database.GetConnection()
 		key, err := models.AsciiArmored2GPGKey(pubKey)
 		if err != nil {
 			log.Debug("PKSAdd Error: %s", err)
-			return "NOK"
+			return models.KeyMergeResult{}, err
 		}
 
 		keys, err := models.SearchGPGKeyByFingerPrint(conn, key.FullFingerPrint, 0, 1)
 
 		if err != nil {
 			log.Debug("PKSAdd Error: %s", err)
-			return "NOK"
+			return models.KeyMergeResult{}, err
 		}
 
+		var existing *models.GPGKey
+		existingKey := ""
 		if len(keys) > 0 {
-			log.Info("Tried to add key %s to PKS but already exists.", key.GetShortFingerPrint())
-			return "OK"
+			existing = &keys[0]
+			existingKey = existing.AsciiArmoredPublicKey
+		}
+
+		if mode == pksAddSynced || mode == pksAddSyncedVerified {
+			pubKey, err = pksWithoutRemovedComponents(pubKey, key.FullFingerPrint, existingKey)
+			if err != nil {
+				log.Debug("PKSAdd Error: %s", err)
+				return models.KeyMergeResult{}, err
+			}
+		}
+
+		merged, result, err := tools.MergeKeysWithLimits(existingKey, pubKey, pksKeyLimits())
+		if err != nil {
+			log.Debug("PKSAdd Error: %s", err)
+			return result, err
+		}
+
+		if result.DroppedUserIDs > 0 || result.DroppedUserAttributes > 0 || result.DroppedCertifications > 0 {
+			log.Warn("Key %s exceeds the PKS limits. Dropped %d user IDs, %d user attributes and %d certifications",
+				key.GetShortFingerPrint(), result.DroppedUserIDs, result.DroppedUserAttributes, result.DroppedCertifications)
+		}
+
+		if !result.Changed && (mode == pksAddUnverified || existing == nil || len(existing.PendingUserIDs) == 0) {
+			log.Info("Tried to add key %s to PKS but already exists without changes.", key.GetShortFingerPrint())
+			return result, nil
+		}
+
+		if mode == pksAddSyncedVerified {
+			key, err = keyWithSyncedUserIDs(merged, existing, pubKey)
+		} else {
+			key, err = keyWithVerification(merged, existing, mode == pksAddVerified)
+		}
+
+		if err != nil {
+			log.Debug("PKSAdd Error: %s", err)
+			return result, err
+		}
+
+		if len(key.PendingUserIDs) > 0 {
+			challenge := newVerificationChallenge(&key)
+			result.Challenge = &challenge
+			log.Info("Key %s has user IDs pending verification: %v", key.GetShortFingerPrint(), key.PendingUserIDs)
 		}
 
-		log.Info("Adding public key %s to PKS", key.GetShortFingerPrint())
+		if result.Created {
+			log.Info("Adding public key %s to PKS", key.GetShortFingerPrint())
+		} else {
+			log.Info("Updating public key %s in PKS", key.GetShortFingerPrint())
+		}
+
+		setSyncDigest(&key)
 		_, _, err = models.AddGPGKey(conn, key)
 
 		if err != nil {
 			log.Debug("PKSAdd Error: %s", err)
-			return "NOK"
+			return result, err
 		}
 
-		return "OK"
+		return result, nil
 	}
 
-	res, err := PutSKSKey(pubKey)
-
-	if err != nil {
+	if config.PKSMaxKeySize > 0 && len(pubKey) > config.PKSMaxKeySize {
+		err := fmt.Errorf("key has %d bytes which is more than the maximum of %d bytes", len(pubKey), config.PKSMaxKeySize)
 		log.Debug("PKSAdd Error: %s", err)
+		return models.KeyMergeResult{}, err
+	}
+
+	results := PutSKSKeyWithContext(ctx, pubKey)
+
+	// The external key servers merge the key themselves and do not report the changes
+	result := models.KeyMergeResult{
+		Changed:      true,
+		AddedUserIDs: []string{},
+		AddedSubKeys: []string{},
+		Upstreams:    results,
+	}
+
+	accepted := false
+	for _, r := range results {
+		accepted = accepted || r.Success
+	}
+
+	if !accepted {
+		log.Debug("PKSAdd Error: no upstream key server accepted the key")
+		return result, fmt.Errorf("no upstream key server accepted the key")
 	}
 
-	if res {
-		return "OK"
+	if e, err := tools.ReadKeyToEntity(pubKey); err == nil {
+		result.FingerPrint = tools.ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
 	}
 
-	return "NOK"
+	return result, nil
 }
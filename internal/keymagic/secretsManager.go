@@ -4,13 +4,16 @@ package keymagic
 
 import (
 	"context"
+	"crypto"
 	"encoding/base64"
 	"fmt"
 	config "github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/keybackend"
+	"github.com/quan-to/chevron/internal/kubernetes"
 	"github.com/quan-to/chevron/internal/tools"
 	"github.com/quan-to/chevron/internal/vaultManager"
 	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/chevron/pkg/openpgp"
 	"io/ioutil"
 	"path"
 	"strings"
@@ -42,6 +45,10 @@ func MakeSecretsManager(log slog.Instance) interfaces.SecretsManager {
 
 	if config.VaultStorage {
 		kb = vaultManager.MakeVaultManager(log, "__master__")
+	} else if config.S3Storage {
+		kb = keybackend.MakeS3Backend(log, "__master__")
+	} else if config.KubernetesStorage {
+		kb = kubernetes.MakeSecretsBackend(log, "__master__")
 	} else {
 		kb = keybackend.MakeSaveToDiskBackend(log, path.Dir(config.MasterGPGKeyPath), "__master__")
 	}
@@ -229,3 +236,44 @@ func (sm *secretsManager) GetMasterKeyFingerPrint(ctx context.Context) string {
 	log.DebugNote("GetMasterKeyFingerPrint()")
 	return sm.masterKeyFingerPrint
 }
+
+// WrapKey encrypts the specified data key with the master key
+func (sm *secretsManager) WrapKey(ctx context.Context, dataKey []byte) (string, error) {
+	if sm.amIUseless {
+		return "", fmt.Errorf("master key not loaded")
+	}
+
+	return wrapKey(ctx, sm.gpg, sm.masterKeyFingerPrint, dataKey)
+}
+
+// UnwrapKey decrypts a data key previously wrapped by WrapKey
+func (sm *secretsManager) UnwrapKey(ctx context.Context, wrappedKey string) ([]byte, error) {
+	if sm.amIUseless {
+		return nil, fmt.Errorf("master key not loaded")
+	}
+
+	return unwrapKey(ctx, sm.gpg, wrappedKey)
+}
+
+// WrapperID returns the fingerprint of the master key
+func (sm *secretsManager) WrapperID(ctx context.Context) string {
+	return sm.masterKeyFingerPrint
+}
+
+// SignWithMasterKey returns an armored detached signature of the data made with the master key
+func (sm *secretsManager) SignWithMasterKey(ctx context.Context, data []byte) (string, error) {
+	if sm.amIUseless {
+		return "", fmt.Errorf("master key not loaded")
+	}
+
+	return sm.gpg.SignData(ctx, sm.masterKeyFingerPrint, data, crypto.SHA512)
+}
+
+// GetMasterPublicKey returns the public key of the master key
+func (sm *secretsManager) GetMasterPublicKey(ctx context.Context) *openpgp.Entity {
+	if sm.amIUseless {
+		return nil
+	}
+
+	return sm.gpg.GetPublicKeyEntity(ctx, sm.masterKeyFingerPrint)
+}
@@ -1,36 +1,39 @@
 package keymagic
 
 import (
+	"context"
 	"fmt"
-	"github.com/quan-to/chevron/internal/config"
-	"io/ioutil"
-	"net/http"
-	"net/url"
+
+	"github.com/quan-to/chevron/internal/models"
 )
 
+// GetSKSKey fetches the public key from the upstream key servers in SKS_SERVERS
 func GetSKSKey(fingerPrint string) (string, error) {
-	response, err := http.Get(fmt.Sprintf("%s/pks/lookup?op=get&options=mr&search=0x%s", config.SKSServer, fingerPrint))
-
-	if err != nil {
-		return "", err
-	}
-
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return "", err
-	}
-	_ = response.Body.Close()
+	return GetSKSKeyWithContext(context.Background(), fingerPrint)
+}
 
-	return string(contents), nil
+// GetSKSKeyWithContext fetches the public key from the upstream key servers in SKS_SERVERS
+func GetSKSKeyWithContext(ctx context.Context, fingerPrint string) (string, error) {
+	return getKeyServerClient().GetKey(ctx, fingerPrint)
 }
 
+// PutSKSKey sends the public key to all upstream key servers in SKS_SERVERS. Returns true if any of them accepted it
 func PutSKSKey(publicKey string) (bool, error) {
-	response, err := http.PostForm(config.SKSServer, url.Values{"keytext": {publicKey}})
+	results := PutSKSKeyWithContext(context.Background(), publicKey)
+	if len(results) == 0 {
+		return false, fmt.Errorf("there are no upstream key servers configured")
+	}
 
-	if err != nil {
-		return false, err
+	for _, result := range results {
+		if result.Success {
+			return true, nil
+		}
 	}
 
-	_ = response.Body.Close()
-	return response.StatusCode == http.StatusOK, nil
+	return false, nil
+}
+
+// PutSKSKeyWithContext sends the public key to all upstream key servers in SKS_SERVERS and returns the result of each one
+func PutSKSKeyWithContext(ctx context.Context, publicKey string) []models.KeyServerUploadResult {
+	return getKeyServerClient().PutKey(ctx, publicKey)
 }
@@ -0,0 +1,83 @@
+package keymagic
+
+import (
+	"context"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailSender sends emails through the SMTP server at SMTP_SERVER
+type SMTPMailSender struct {
+	server   string
+	from     string
+	username string
+	password string
+	log      slog.Instance
+}
+
+// MakeSMTPMailSender creates a SMTPMailSender using SMTP_SERVER, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func MakeSMTPMailSender(log slog.Instance) *SMTPMailSender {
+	if log == nil {
+		log = slog.Scope("SMTP")
+	} else {
+		log = log.SubScope("SMTP")
+	}
+
+	return &SMTPMailSender{
+		server:   config.SMTPServer,
+		from:     config.SMTPFrom,
+		username: config.SMTPUsername,
+		password: config.SMTPPassword,
+		log:      log,
+	}
+}
+
+func (s *SMTPMailSender) SendMail(ctx context.Context, to, subject, body string) error {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := s.log.Tag(requestID)
+	log.DebugNote("SendMail(%s, %s, ---)", to, subject)
+
+	if s.server == "" {
+		return fmt.Errorf("no SMTP server configured")
+	}
+
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid recipient or subject")
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.server)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+
+	log.Await("Sending email to %s", to)
+	err := smtp.SendMail(s.server, auth, s.from, []string{to}, []byte(msg))
+	if err != nil {
+		log.Error("Error sending email to %s: %s", to, err)
+		return err
+	}
+	log.Done("Email sent to %s", to)
+
+	return nil
+}
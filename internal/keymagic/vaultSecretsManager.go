@@ -0,0 +1,148 @@
+// +build !js,!wasm
+
+package keymagic
+
+import (
+	"context"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/internal/vaultManager"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"sync"
+)
+
+// vaultSecretsManager is a SecretsManager that uses Vault Transit Engine instead of a local master key
+type vaultSecretsManager struct {
+	sync.Mutex
+	encryptedPasswords map[string]string
+	vm                 *vaultManager.VaultManager
+	keyName            string
+	log                slog.Instance
+}
+
+// MakeVaultSecretsManager creates an instance of a secrets manager backed by Vault Transit Engine
+func MakeVaultSecretsManager(log slog.Instance) interfaces.SecretsManager {
+	if log == nil {
+		log = slog.Scope("VaultSM")
+	} else {
+		log = log.SubScope("VaultSM")
+	}
+
+	vm := vaultManager.MakeVaultManager(log, "")
+	if vm == nil {
+		log.Fatal("Error creating vault client for transit engine")
+	}
+
+	err := vm.EnsureTransitKey(config.VaultTransitKey)
+	if err != nil {
+		log.Fatal("Error checking transit key %s: %s", config.VaultTransitKey, err)
+	}
+
+	log.Info("Using Vault Transit Key %s/keys/%s", config.VaultTransitPath, config.VaultTransitKey)
+
+	return &vaultSecretsManager{
+		encryptedPasswords: map[string]string{},
+		vm:                 vm,
+		keyName:            config.VaultTransitKey,
+		log:                log,
+	}
+}
+
+// PutKeyPassword stores the password for the specified key fingerprint encrypted with the transit key
+func (sm *vaultSecretsManager) PutKeyPassword(ctx context.Context, fingerPrint, password string) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := sm.log.Tag(requestID)
+	log.DebugNote("PutKeyPassword(%s, ---)", fingerPrint)
+
+	encPass, err := sm.vm.TransitEncrypt(sm.keyName, []byte(password))
+	if err != nil {
+		log.Error("Error saving key %s password: %s", fingerPrint, err)
+		return
+	}
+
+	sm.Lock()
+	defer sm.Unlock()
+
+	log.Info("Saving password for key %s", fingerPrint)
+	sm.encryptedPasswords[fingerPrint] = encPass
+}
+
+// PutEncryptedPassword stores in memory a transit key encrypted password for the specified fingerprint
+func (sm *vaultSecretsManager) PutEncryptedPassword(ctx context.Context, fingerPrint, encryptedPassword string) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := sm.log.Tag(requestID)
+	log.DebugNote("PutEncryptedPassword(%s, ---)", fingerPrint)
+
+	sm.Lock()
+	defer sm.Unlock()
+
+	sm.encryptedPasswords[fingerPrint] = encryptedPassword
+}
+
+// GetPasswords returns a list of transit key encrypted passwords stored in memory
+func (sm *vaultSecretsManager) GetPasswords(ctx context.Context) map[string]string {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := sm.log.Tag(requestID)
+	log.DebugNote("GetPasswords()")
+	pss := make(map[string]string) // Force copy
+
+	for fp, pass := range sm.encryptedPasswords {
+		pss[fp] = pass
+	}
+
+	return pss
+}
+
+// UnlockLocalKeys unlocks the local private keys using memory stored transit key encrypted passwords
+func (sm *vaultSecretsManager) UnlockLocalKeys(ctx context.Context, gpg interfaces.PGPManager) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := sm.log.Tag(requestID)
+	log.DebugNote("UnlockLocalKeys(---)")
+
+	sm.Lock()
+	passwords := sm.GetPasswords(ctx)
+	sm.Unlock()
+
+	for fp, pass := range passwords {
+		if !gpg.IsKeyLocked(fp) {
+			continue
+		}
+
+		log.Info("Unlocking key %s", fp)
+		password, err := sm.vm.TransitDecrypt(sm.keyName, pass)
+		if err != nil {
+			log.Error("Error decrypting password for key %s: %s", fp, err)
+			continue
+		}
+
+		err = gpg.UnlockKey(ctx, fp, string(password))
+		if err != nil {
+			log.Error("Error unlocking key %s: %s", fp, err)
+		}
+	}
+}
+
+// GetMasterKeyFingerPrint returns the identifier of the transit key, since there is no local master key
+func (sm *vaultSecretsManager) GetMasterKeyFingerPrint(ctx context.Context) string {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := sm.log.Tag(requestID)
+	log.DebugNote("GetMasterKeyFingerPrint()")
+	return sm.WrapperID(ctx)
+}
+
+// WrapKey encrypts the specified data key with the transit key
+func (sm *vaultSecretsManager) WrapKey(ctx context.Context, dataKey []byte) (string, error) {
+	return sm.vm.TransitEncrypt(sm.keyName, dataKey)
+}
+
+// UnwrapKey decrypts a data key previously wrapped by WrapKey
+func (sm *vaultSecretsManager) UnwrapKey(ctx context.Context, wrappedKey string) ([]byte, error) {
+	return sm.vm.TransitDecrypt(sm.keyName, wrappedKey)
+}
+
+// WrapperID returns the transit key path
+func (sm *vaultSecretsManager) WrapperID(ctx context.Context) string {
+	return fmt.Sprintf("vault-transit:%s/keys/%s", config.VaultTransitPath, sm.keyName)
+}
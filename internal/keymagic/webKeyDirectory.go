@@ -0,0 +1,113 @@
+package keymagic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const wkdSearchPageSize = 100
+
+// IsWKDDomain returns true if the domain is served through the Web Key Directory (WKD_DOMAINS)
+func IsWKDDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return false
+	}
+
+	for _, d := range strings.Split(config.WKDDomains, ",") {
+		if strings.TrimSpace(d) == domain {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wkdResolve returns the emails of the domain whose local part has the Web Key Directory hash, with their keys.
+// If localPart matches the hash only its email is searched, otherwise all the emails of the domain are checked
+func wkdResolve(domain, hash, localPart string) (map[string][]models.GPGKey, error) {
+	search := "(?i)@" + regexp.QuoteMeta(domain) + "$"
+	if localPart != "" && tools.WKDHash(localPart) == hash {
+		search = "(?i)^" + regexp.QuoteMeta(localPart+"@"+domain) + "$"
+	}
+
+	found := map[string][]models.GPGKey{}
+	seen := map[string]bool{}
+
+	for pageStart := 0; ; pageStart += wkdSearchPageSize {
+		keys, err := PKSSearchByEmail(search, pageStart, pageStart+wkdSearchPageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			for _, email := range key.Emails {
+				at := strings.LastIndex(email, "@")
+				if at < 0 || !strings.EqualFold(email[at+1:], domain) || tools.WKDHash(email[:at]) != hash {
+					continue
+				}
+				email = strings.ToLower(email)
+				if seen[email+key.FullFingerPrint] {
+					continue
+				}
+				seen[email+key.FullFingerPrint] = true
+				found[email] = append(found[email], key)
+			}
+		}
+
+		if len(keys) < wkdSearchPageSize {
+			break
+		}
+	}
+
+	return found, nil
+}
+
+// WKDGetKey returns the binary public keys served by the Web Key Directory for the domain and local part hash.
+// Only the user IDs with the requested email are included. Returns nil if there is no key
+func WKDGetKey(ctx context.Context, domain, hash, localPart string) ([]byte, error) {
+	requestID := tools.GetRequestIDFromContext(ctx)
+	log := pksLog.Tag(requestID)
+	log.DebugNote("WKDGetKey(%s, %s, %s)", domain, hash, localPart)
+
+	if !IsWKDDomain(domain) {
+		return nil, fmt.Errorf("domain %s is not served by the web key directory", domain)
+	}
+
+	found, err := wkdResolve(strings.ToLower(domain), hash, localPart)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, 0, len(found))
+	for email := range found {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+
+	buf := bytes.NewBuffer(nil)
+
+	for _, email := range emails {
+		for _, key := range found[email] {
+			data, err := tools.ExportKeyForEmail(key.AsciiArmoredPublicKey, email)
+			if err != nil {
+				log.Warn("Cannot export key %s for %s: %s", key.GetShortFingerPrint(), email, err)
+				continue
+			}
+			buf.Write(data)
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil, nil
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,6 @@
+package kubernetes
+
+type ListMetadata struct {
+	ResourceVersion string `json:"resourceVersion"`
+	Continue        string `json:"continue"`
+}
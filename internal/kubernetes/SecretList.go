@@ -0,0 +1,6 @@
+package kubernetes
+
+type SecretList struct {
+	Metadata ListMetadata `json:"metadata"`
+	Items    []Secret     `json:"items"`
+}
@@ -2,6 +2,9 @@ package kubernetes
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"github.com/quan-to/chevron/internal/config"
@@ -13,7 +16,16 @@ import (
 
 const sleepInterval = 1 * 60 * 1000
 
-func KubeRoutine(stopSig chan bool) {
+// RequestSigner signs the requests to the cluster peers and verifies their responses
+type RequestSigner interface {
+	// SignRequest adds the signature headers to the request with the specified body
+	SignRequest(ctx context.Context, req *http.Request, body []byte) error
+	// VerifyResponse checks the signature headers of the response with the specified body to the signed request
+	VerifyResponse(ctx context.Context, req *http.Request, res *http.Response, body []byte) error
+}
+
+// KubeRoutine fetches the encrypted key passwords of the peer pods periodically, signing the requests with the signer
+func KubeRoutine(stopSig chan bool, signer RequestSigner) {
 	if !inKubernetes {
 		kubeLog.Error("Tried to start KubeRoutine, but not in Kubernetes! Skipping...")
 		return
@@ -41,7 +53,7 @@ func KubeRoutine(stopSig chan bool) {
 		}
 
 		kubeLog.Info("Checking for other remote-signer nodes...")
-		kubeFunc()
+		kubeFunc(signer)
 		kubeLog.Info("Sleeping for %d ms", sleepInterval)
 		time.Sleep(time.Millisecond * sleepInterval)
 	}
@@ -49,30 +61,155 @@ func KubeRoutine(stopSig chan bool) {
 	kubeLog.Info("Kubernetes Routine Stopped")
 }
 
-func kubeFunc() {
+// clusterScheme returns the scheme of the peer URLs. The server only serves HTTPS when TLS_CERT_FILE is set
+func clusterScheme() string {
+	if config.TLSCertFile != "" {
+		return "https"
+	}
+
+	return "http"
+}
+
+// clusterTLSConfig returns the TLS configuration to reach the peers, sending CLUSTER_TLS_CERT_FILE as client certificate.
+// The peers are reached by their pod IP, so their certificate chain is verified against CLUSTER_TLS_CA_FILE without the host name
+func clusterTLSConfig() (*tls.Config, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+
+	if config.ClusterTLSCAFile != "" {
+		data, err := ioutil.ReadFile(config.ClusterTLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", config.ClusterTLSCAFile)
+		}
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The default verification also checks the host name, so it is replaced by VerifyPeerCertificate
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("the peer did not send a certificate")
+			}
+
+			certs := make([]*x509.Certificate, 0, len(rawCerts))
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+
+			return err
+		},
+	}
+
+	if config.ClusterTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClusterTLSCertFile, config.ClusterTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the cluster client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// clusterHTTPClient returns the client of the requests to the peers
+func clusterHTTPClient() (*http.Client, error) {
+	if clusterScheme() != "https" {
+		return http.DefaultClient, nil
+	}
+
+	cfg, err := clusterTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: cfg},
+		Timeout:   time.Minute,
+	}, nil
+}
+
+// doSigned sends a request signed by the signer and returns the status and body of the response.
+// If verify is set, the response should also be signed
+func doSigned(client *http.Client, signer RequestSigner, method, url string, body []byte, verify bool) (int, []byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := signer.SignRequest(context.Background(), req, body); err != nil {
+		return 0, nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+
+	if verify && res.StatusCode == http.StatusOK {
+		if err := signer.VerifyResponse(context.Background(), req, res, data); err != nil {
+			return res.StatusCode, nil, fmt.Errorf("invalid response: %s", err)
+		}
+	}
+
+	return res.StatusCode, data, nil
+}
+
+func kubeFunc(signer RequestSigner) {
+	client, err := clusterHTTPClient()
+	if err != nil {
+		kubeLog.Error("Error creating the cluster client: %s", err)
+		return
+	}
+
+	scheme := clusterScheme()
 	pods := Pods()
-	myId := Me().Metadata.UID
 	kubeLog.Info("There are %d pods (including me). Fetching encrypted passwords...", len(pods))
 	passwordCount := 0
 	for _, pod := range pods {
-		if pod.Metadata.UID == myId {
+		if !IsPeer(pod) {
 			continue
 		}
 		if pod.Status.Phase != Running {
 			continue
 		}
 
-		getURL := fmt.Sprintf("http://%s:%d/remoteSigner/__internal/__getUnlockPasswords", pod.Status.PodIP, config.HttpPort)
-		postURL := fmt.Sprintf("http://localhost:%d/remoteSigner/__internal/__postEncryptedPasswords", config.HttpPort)
+		getURL := fmt.Sprintf("%s://%s:%d/remoteSigner/__internal/__getUnlockPasswords", scheme, pod.Status.PodIP, config.HttpPort)
+		postURL := fmt.Sprintf("%s://localhost:%d/remoteSigner/__internal/__postEncryptedPasswords", scheme, config.HttpPort)
 
-		res, err := http.Get(getURL)
-		if err != nil {
-			kubeLog.Error("Error fetching unlock passwords from %s: %s", pod.Status.PodIP, err)
-			continue
+		status, data, err := doSigned(client, signer, "GET", getURL, nil, true)
+		if err == nil && status != http.StatusOK {
+			err = fmt.Errorf("status %d: %s", status, string(data))
 		}
 
-		data, err := ioutil.ReadAll(res.Body)
-
 		if err != nil {
 			kubeLog.Error("Error fetching unlock passwords from %s: %s", pod.Status.PodIP, err)
 			continue
@@ -89,23 +226,13 @@ func kubeFunc() {
 		passwordCount += len(passwords)
 		kubeLog.Info("Received %d passwords from %s", len(passwords), pod.Status.PodIP)
 		if len(passwords) > 0 {
-			req, err := http.NewRequest("POST", postURL, bytes.NewBuffer(data))
-
+			status, body, err := doSigned(client, signer, "POST", postURL, data, false)
 			if err != nil {
 				kubeLog.Error("Error sending unlock passwords from %s: %s", pod.Status.PodIP, err)
 				continue
 			}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
-			}
-
-			body, _ := ioutil.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-
-			if resp.StatusCode != 200 {
+			if status != 200 {
 				kubeLog.Error("Error posting passwords: %s", string(body))
 				continue
 			}
@@ -117,5 +244,5 @@ func kubeFunc() {
 	}
 
 	kubeLog.Info("Received %d passwords from %d pods. Triggering Local Unlock", passwordCount, len(pods))
-	_, _ = http.Get(fmt.Sprintf("http://localhost:%d/remoteSigner/__internal/__triggerKeyUnlock", config.HttpPort))
+	_, _, _ = doSigned(client, signer, "GET", fmt.Sprintf("%s://localhost:%d/remoteSigner/__internal/__triggerKeyUnlock", scheme, config.HttpPort), nil, false)
 }
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+)
+
+type testRequestSigner struct{}
+
+func (s *testRequestSigner) SignRequest(ctx context.Context, req *http.Request, body []byte) error {
+	req.Header.Set("X-Cluster-Signature", "huebr")
+	return nil
+}
+
+func (s *testRequestSigner) VerifyResponse(ctx context.Context, req *http.Request, res *http.Response, body []byte) error {
+	return nil
+}
+
+func TestDoSignedTLS(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	dir, err := ioutil.TempDir("", "chevron-cluster-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// region Cluster client certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chevron-cluster"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	config.ClusterTLSCertFile = path.Join(dir, "cluster.pem")
+	config.ClusterTLSKeyFile = path.Join(dir, "cluster.key")
+	_ = ioutil.WriteFile(config.ClusterTLSCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(config.ClusterTLSKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	// endregion
+
+	// region Peer requiring client certificates
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Cluster-Signature") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte("OK"))
+	}))
+	srv.TLS = &tls.Config{ClientCAs: clientCAs, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	config.TLSCertFile = path.Join(dir, "server.pem")
+	config.ClusterTLSCAFile = path.Join(dir, "ca.pem")
+	_ = ioutil.WriteFile(config.ClusterTLSCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+	// endregion
+
+	if clusterScheme() != "https" {
+		t.Fatalf("Expected https with TLS_CERT_FILE got %s", clusterScheme())
+	}
+
+	client, err := clusterHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &testRequestSigner{}
+
+	// The peers are reached by the pod IP or localhost, which may not be in their certificate
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/remoteSigner/__internal/__getUnlockPasswords"
+	status, data, err := doSigned(client, signer, "GET", url, nil, true)
+	if err != nil || status != http.StatusOK || string(data) != "OK" {
+		t.Fatalf("Expected the signed request over TLS to succeed got %d %q %v", status, string(data), err)
+	}
+
+	// region Unknown peer certificate
+	config.ClusterTLSCAFile = config.ClusterTLSCertFile
+
+	client, err = clusterHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := doSigned(client, signer, "GET", url, nil, true); err == nil {
+		t.Errorf("Expected the peer certificate not issued by CLUSTER_TLS_CA_FILE to be refused")
+	}
+	// endregion
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/quan-to/chevron/internal/tools"
 	"github.com/quan-to/slog"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
 )
@@ -130,3 +132,71 @@ func Me() Pod {
 func InKubernetes() bool {
 	return inKubernetes
 }
+
+// GetPod returns the pod with the specified name in the current namespace
+func GetPod(name string) (*Pod, error) {
+	status, data, err := doWithToken(makeKubeClient(), "GET", fmt.Sprintf("%s/%s", podURL(), url.PathEscape(name)), currentKubeToken, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("error fetching pod %s: status %d", name, status)
+	}
+
+	var pod Pod
+	if err := json.Unmarshal(data, &pod); err != nil {
+		return nil, err
+	}
+
+	return &pod, nil
+}
+
+// IsPeer returns if the pod is another pod of the same deployment as this one, which has the same labels except the pod template hash
+func IsPeer(pod Pod) bool {
+	mine := Me().Metadata.Labels
+
+	if pod.Metadata.UID == "" || pod.Metadata.UID == Me().Metadata.UID {
+		return false
+	}
+
+	for k, v := range mine {
+		if k != "pod-template-hash" && pod.Metadata.Labels[k] != v {
+			return false
+		}
+	}
+
+	for k := range pod.Metadata.Labels {
+		if _, ok := mine[k]; !ok && k != "pod-template-hash" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// VerifyPeer checks against the Kubernetes API that the pod is a running peer with the specified IP
+func VerifyPeer(name, ip string) error {
+	if name == "" {
+		return fmt.Errorf("no pod name")
+	}
+
+	pod, err := GetPod(name)
+	if err != nil {
+		return err
+	}
+
+	if !IsPeer(*pod) {
+		return fmt.Errorf("the pod %s is not a peer", name)
+	}
+
+	if pod.Status.Phase != Running {
+		return fmt.Errorf("the pod %s is not running", name)
+	}
+
+	if pod.Status.PodIP != ip {
+		return fmt.Errorf("the pod %s has the ip %s and not %s", name, pod.Status.PodIP, ip)
+	}
+
+	return nil
+}
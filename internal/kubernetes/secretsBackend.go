@@ -0,0 +1,346 @@
+package kubernetes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+const (
+	secretNamePrefix    = "chevron-key-"
+	secretDataField     = "data"
+	storageLabel        = "chevron.quan.to/storage"
+	storageLabelValue   = "keys"
+	prefixLabel         = "chevron.quan.to/prefix"
+	keyAnnotation       = "chevron.quan.to/key"
+	metadataAnnotation  = "chevron.quan.to/metadata"
+	secretListPageLimit = 100
+)
+
+const kubernetesAPIURL = "https://kubernetes.default.svc"
+
+// secretWrite is the subset of a Secret that is sent to the API server
+type secretWrite struct {
+	Kind       string            `json:"kind"`
+	APIVersion string            `json:"apiVersion"`
+	Metadata   secretWriteMeta   `json:"metadata"`
+	Type       string            `json:"type"`
+	Data       map[string][]byte `json:"data"`
+}
+
+type secretWriteMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+type secretsBackend struct {
+	sync.Mutex
+	apiURL           string
+	namespace        string
+	token            string
+	prefix           string
+	client           *http.Client
+	resourceVersions map[string]string
+	log              slog.Instance
+}
+
+// MakeSecretsBackend creates an instance of a StorageBackend that stores keys as Kubernetes Secrets
+// in the current namespace using the pod service account
+func MakeSecretsBackend(log slog.Instance, prefix string) interfaces.StorageBackend {
+	if !inKubernetes {
+		kubeLog.Warn("Kubernetes Secrets storage selected, but not running in Kubernetes!")
+	}
+
+	return makeSecretsBackend(log, kubernetesAPIURL, currentNamespace, currentKubeToken, prefix, makeKubeClient())
+}
+
+func makeSecretsBackend(log slog.Instance, apiURL, namespace, token, prefix string, client *http.Client) *secretsBackend {
+	if log == nil {
+		log = slog.Scope("secretsBackend")
+	} else {
+		log = log.SubScope("secretsBackend")
+	}
+
+	log.Info("Initialized secretsBackend on namespace %s with prefix %s", namespace, prefix)
+
+	return &secretsBackend{
+		apiURL:           apiURL,
+		namespace:        namespace,
+		token:            token,
+		prefix:           prefix,
+		client:           client,
+		resourceVersions: map[string]string{},
+		log:              log,
+	}
+}
+
+func (s *secretsBackend) Name() string {
+	return "Kubernetes Secrets StorageBackend"
+}
+
+func (s *secretsBackend) Path() string {
+	return fmt.Sprintf("kubernetes://%s/secrets?labelSelector=%s", s.namespace, s.labelSelector())
+}
+
+// secretName returns a valid DNS-1123 name for the key. The real key name is stored in the keyAnnotation
+func (s *secretsBackend) secretName(key string) string {
+	h := sha256.Sum256([]byte(s.prefix + key))
+	return secretNamePrefix + hex.EncodeToString(h[:20])
+}
+
+// prefixLabelValue returns the value of prefixLabel. Prefixes can have characters not allowed in label values
+func (s *secretsBackend) prefixLabelValue() string {
+	h := sha256.Sum256([]byte(s.prefix))
+	return hex.EncodeToString(h[:8])
+}
+
+func (s *secretsBackend) labelSelector() string {
+	return fmt.Sprintf("%s=%s,%s=%s", storageLabel, storageLabelValue, prefixLabel, s.prefixLabelValue())
+}
+
+func (s *secretsBackend) secretsURL() string {
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", s.apiURL, s.namespace)
+}
+
+func (s *secretsBackend) secretURL(name string) string {
+	return fmt.Sprintf("%s/%s", s.secretsURL(), name)
+}
+
+func (s *secretsBackend) getSecret(name string) (*Secret, error) {
+	status, body, err := doWithToken(s.client, "GET", s.secretURL(name), s.token, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == http.StatusNotFound {
+		s.Lock()
+		delete(s.resourceVersions, name)
+		s.Unlock()
+		return nil, interfaces.ErrKeyNotFound
+	}
+
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
+	}
+
+	var secret Secret
+	err = json.Unmarshal(body, &secret)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	s.resourceVersions[name] = secret.Metadata.ResourceVersion
+	s.Unlock()
+
+	return &secret, nil
+}
+
+// putSecret creates the secret or, if it was already read / written by this instance, updates it
+// using the last known resourceVersion so changes made by someone else are never overwritten.
+// Secrets that were never read by this instance (for example after a restart) are only created, never replaced.
+// If create is true, it only creates the secret. Returns ErrKeyConflict if it was created or modified by someone else
+func (s *secretsBackend) putSecret(key, data, metadata string, create bool) error {
+	name := s.secretName(key)
+
+	s.Lock()
+	resourceVersion, known := s.resourceVersions[name]
+	s.Unlock()
+
+	if create {
+		resourceVersion, known = "", false
+	}
+
+	secret := secretWrite{
+		Kind:       "Secret",
+		APIVersion: "v1",
+		Metadata: secretWriteMeta{
+			Name:            name,
+			Namespace:       s.namespace,
+			ResourceVersion: resourceVersion,
+			Labels: map[string]string{
+				storageLabel: storageLabelValue,
+				prefixLabel:  s.prefixLabelValue(),
+			},
+			Annotations: map[string]string{
+				keyAnnotation:      key,
+				metadataAnnotation: metadata,
+			},
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{
+			secretDataField: []byte(data),
+		},
+	}
+
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	method, target, expected := "POST", s.secretsURL(), http.StatusCreated
+	if known {
+		method, target, expected = "PUT", s.secretURL(name), http.StatusOK
+	}
+
+	status, resBody, err := doWithToken(s.client, method, target, s.token, body)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusConflict {
+		s.Lock()
+		delete(s.resourceVersions, name)
+		s.Unlock()
+		s.log.Warn("Secret %s for key %s was created or modified by someone else. Read it again before saving", name, key)
+		return interfaces.ErrKeyConflict
+	}
+
+	if status != expected && status != http.StatusOK {
+		return statusError(status, resBody)
+	}
+
+	var saved Secret
+	err = json.Unmarshal(resBody, &saved)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.resourceVersions[name] = saved.Metadata.ResourceVersion
+	s.Unlock()
+
+	return nil
+}
+
+// Save stores the key in a Secret. Since the whole Secret is replaced, any previous metadata is cleared
+func (s *secretsBackend) Save(key, data string) error {
+	return s.SaveWithMetadata(key, data, "")
+}
+
+func (s *secretsBackend) SaveWithMetadata(key, data, metadata string) error {
+	s.log.DebugAwait("Saving to secret %s", s.secretName(key))
+
+	err := s.putSecret(key, data, metadata, false)
+	if err != nil {
+		s.log.ErrorDone("Error saving to secret %s: %s", s.secretName(key), err)
+	}
+
+	return err
+}
+
+// Create stores the key in a new Secret only if it does not exist. Returns ErrKeyConflict if it already exists
+func (s *secretsBackend) Create(key, data string) error {
+	s.log.DebugAwait("Creating secret %s", s.secretName(key))
+
+	err := s.putSecret(key, data, "", true)
+	if err != nil && err != interfaces.ErrKeyConflict {
+		s.log.ErrorDone("Error creating secret %s: %s", s.secretName(key), err)
+	}
+
+	return err
+}
+
+func (s *secretsBackend) Delete(key string) error {
+	name := s.secretName(key)
+	s.log.DebugAwait("Deleting secret %s", name)
+
+	status, body, err := doWithToken(s.client, "DELETE", s.secretURL(name), s.token, nil)
+	if err != nil {
+		s.log.ErrorDone("Error deleting secret %s: %s", name, err)
+		return err
+	}
+
+	if status == http.StatusNotFound {
+		s.log.ErrorDone("Error deleting secret %s: secret does not exist to delete", name)
+		return interfaces.ErrKeyNotFound
+	}
+
+	if status != http.StatusOK && status != http.StatusAccepted {
+		err = statusError(status, body)
+		s.log.ErrorDone("Error deleting secret %s: %s", name, err)
+		return err
+	}
+
+	s.Lock()
+	delete(s.resourceVersions, name)
+	s.Unlock()
+
+	return nil
+}
+
+func (s *secretsBackend) Read(key string) (data string, metadata string, err error) {
+	name := s.secretName(key)
+	s.log.DebugAwait("Reading from secret %s", name)
+
+	secret, err := s.getSecret(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(secret.Data[secretDataField]), secret.Metadata.Annotations[metadataAnnotation], nil
+}
+
+func (s *secretsBackend) List() ([]string, error) {
+	keys := make([]string, 0)
+	cont := ""
+
+	for {
+		q := url.Values{
+			"labelSelector": {s.labelSelector()},
+			"limit":         {fmt.Sprintf("%d", secretListPageLimit)},
+		}
+
+		if cont != "" {
+			q.Set("continue", cont)
+		}
+
+		status, body, err := doWithToken(s.client, "GET", s.secretsURL()+"?"+q.Encode(), s.token, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if status != http.StatusOK {
+			return nil, statusError(status, body)
+		}
+
+		var list SecretList
+		err = json.Unmarshal(body, &list)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range list.Items {
+			key, ok := v.Metadata.Annotations[keyAnnotation]
+			if ok {
+				keys = append(keys, key)
+			}
+		}
+
+		if list.Metadata.Continue == "" {
+			break
+		}
+
+		cont = list.Metadata.Continue
+	}
+
+	return keys, nil
+}
+
+func statusError(code int, body []byte) error {
+	var status Status
+	if json.Unmarshal(body, &status) == nil && status.Message != "" {
+		return fmt.Errorf("kubernetes api error [%d]: %s", code, status.Message)
+	}
+
+	return fmt.Errorf("kubernetes api error [%d]: %s", code, string(body))
+}
@@ -0,0 +1,257 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/quan-to/chevron/pkg/interfaces"
+)
+
+const testNamespace = "chevron-test"
+const testToken = "huebr"
+
+// fakeAPIServer is a minimal in-memory Kubernetes API server that only knows about secrets
+type fakeAPIServer struct {
+	sync.Mutex
+	secrets map[string]Secret
+	version int
+}
+
+func (f *fakeAPIServer) writeStatus(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(Status{Kind: "Status", Status: "Failure", Message: message, Code: code})
+}
+
+func (f *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		f.writeStatus(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	base := "/api/v1/namespaces/" + testNamespace + "/secrets"
+	if !strings.HasPrefix(r.URL.Path, base) {
+		f.writeStatus(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, base), "/")
+	current, exists := f.secrets[name]
+
+	switch {
+	case r.Method == "GET" && name == "":
+		f.list(w, r)
+	case r.Method == "GET":
+		if !exists {
+			f.writeStatus(w, http.StatusNotFound, "secrets \""+name+"\" not found")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(current)
+	case r.Method == "POST" || r.Method == "PUT":
+		var secret Secret
+		data, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &secret)
+
+		if _, found := f.secrets[secret.Metadata.Name]; r.Method == "POST" && found {
+			f.writeStatus(w, http.StatusConflict, "secrets \""+secret.Metadata.Name+"\" already exists")
+			return
+		}
+
+		if r.Method == "PUT" && (!exists || current.Metadata.ResourceVersion != secret.Metadata.ResourceVersion) {
+			f.writeStatus(w, http.StatusConflict, "the object has been modified")
+			return
+		}
+
+		f.version++
+		secret.Metadata.ResourceVersion = strconv.Itoa(f.version)
+		f.secrets[secret.Metadata.Name] = secret
+
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(secret)
+	case r.Method == "DELETE":
+		if !exists {
+			f.writeStatus(w, http.StatusNotFound, "secrets \""+name+"\" not found")
+			return
+		}
+		delete(f.secrets, name)
+		_ = json.NewEncoder(w).Encode(current)
+	}
+}
+
+// list returns one secret per page to exercise the continue token
+func (f *fakeAPIServer) list(w http.ResponseWriter, r *http.Request) {
+	selector := map[string]string{}
+	for _, v := range strings.Split(r.URL.Query().Get("labelSelector"), ",") {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 {
+			selector[kv[0]] = kv[1]
+		}
+	}
+
+	names := make([]string, 0)
+	for name, secret := range f.secrets {
+		matches := true
+		for k, v := range selector {
+			if secret.Metadata.Labels[k] != v {
+				matches = false
+			}
+		}
+		if matches {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	start, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+
+	list := SecretList{Items: []Secret{}}
+	if start < len(names) {
+		list.Items = append(list.Items, f.secrets[names[start]])
+	}
+	if start+1 < len(names) {
+		list.Metadata.Continue = strconv.Itoa(start + 1)
+	}
+
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+func makeTestSecretsBackend() (*fakeAPIServer, *httptest.Server, func(prefix string) *secretsBackend) {
+	f := &fakeAPIServer{secrets: map[string]Secret{}}
+	server := httptest.NewServer(f)
+
+	return f, server, func(prefix string) *secretsBackend {
+		return makeSecretsBackend(nil, server.URL, testNamespace, testToken, prefix, server.Client())
+	}
+}
+
+func TestSecretsBackend(t *testing.T) {
+	f, server, makeBackend := makeTestSecretsBackend()
+	defer server.Close()
+
+	sb := makeBackend("key_")
+
+	err := sb.SaveWithMetadata("ABCDEF0123456789", "private key", `{"password":"huebr"}`)
+	if err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	err = sb.Save("0016A9CA870AFA59", "other key")
+	if err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	// Keys with other prefixes should not be listed
+	err = makeBackend("__master__").Save("ABCDEF0123456789", "master key")
+	if err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+
+	if len(f.secrets) != 3 {
+		t.Fatalf("Expected 3 secrets got %d", len(f.secrets))
+	}
+
+	for name, secret := range f.secrets {
+		if !strings.HasPrefix(name, secretNamePrefix) || strings.ToLower(name) != name {
+			t.Errorf("Invalid secret name %s", name)
+		}
+		if secret.Metadata.Labels[storageLabel] != storageLabelValue {
+			t.Errorf("Expected secret %s to have label %s", name, storageLabel)
+		}
+	}
+
+	data, metadata, err := sb.Read("ABCDEF0123456789")
+	if err != nil {
+		t.Fatalf("Error reading: %s", err)
+	}
+
+	if data != "private key" || metadata != `{"password":"huebr"}` {
+		t.Errorf("Unexpected data / metadata read: %q / %q", data, metadata)
+	}
+
+	keys, err := sb.List()
+	if err != nil {
+		t.Fatalf("Error listing: %s", err)
+	}
+
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "0016A9CA870AFA59" || keys[1] != "ABCDEF0123456789" {
+		t.Errorf("Expected to list [0016A9CA870AFA59 ABCDEF0123456789] got %v", keys)
+	}
+
+	err = sb.Delete("ABCDEF0123456789")
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+
+	_, _, err = sb.Read("ABCDEF0123456789")
+	if err == nil {
+		t.Errorf("Expected error reading deleted key")
+	}
+
+	err = sb.Delete("ABCDEF0123456789")
+	if err != interfaces.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound deleting non existent key got %v", err)
+	}
+}
+
+func TestSecretsBackendOptimisticConcurrency(t *testing.T) {
+	_, server, makeBackend := makeTestSecretsBackend()
+	defer server.Close()
+
+	sb1 := makeBackend("key_")
+	sb2 := makeBackend("key_")
+
+	err := sb1.Save("key", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sb2 never saw the secret (like after a restart), so it cannot replace it without reading it first
+	err = sb2.Save("key", "other data")
+	if err != interfaces.ErrKeyConflict {
+		t.Fatalf("Expected ErrKeyConflict overwriting an unseen secret got %v", err)
+	}
+
+	_, _, err = sb2.Read("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sb2.Save("key", "other data")
+	if err != nil {
+		t.Fatalf("Error overwriting a read secret: %s", err)
+	}
+
+	// sb1 resourceVersion is now outdated
+	err = sb1.Save("key", "stale data")
+	if err == nil {
+		t.Fatalf("Expected error overwriting a secret modified by someone else")
+	}
+
+	data, _, _ := sb1.Read("key")
+	if data != "other data" {
+		t.Errorf("Expected other data got %s", data)
+	}
+
+	// Create never replaces, even a known secret
+	err = sb1.Create("key", "created data")
+	if err != interfaces.ErrKeyConflict {
+		t.Errorf("Expected ErrKeyConflict creating an existing secret got %v", err)
+	}
+
+	err = sb1.Create("new", "created data")
+	if err != nil {
+		t.Errorf("Error creating a new secret: %s", err)
+	}
+}
@@ -1,10 +1,14 @@
 package kubernetes
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"github.com/quan-to/chevron/internal/config"
 	"io/ioutil"
 	"net/http"
+	"path"
 )
 
 func getWithToken(url, token string) (string, error) {
@@ -26,3 +30,44 @@ func getWithToken(url, token string) (string, error) {
 
 	return string(bodyText), err
 }
+
+// makeKubeClient returns a http client that trusts the service account CA unless IgnoreKubernetesCA is set
+func makeKubeClient() *http.Client {
+	tlsConfig := &tls.Config{}
+
+	if config.IgnoreKubernetesCA {
+		// skipcq: GSC-G402
+		tlsConfig.InsecureSkipVerify = true
+	} else if ca, err := ioutil.ReadFile(path.Join(ServiceAccountPath, "ca.crt")); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+}
+
+func doWithToken(client *http.Client, method, url, token string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+
+	return res.StatusCode, data, err
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// APIKey is the information of a token with API scopes, without the token value
+type APIKey struct {
+	// ID identifies the token without revealing it
+	ID          string
+	UserName    string
+	FullName    string
+	FingerPrint string
+	Scopes      []string
+	CreatedAt   time.Time
+	Expiration  time.Time
+}
+
+// APIKeyID returns the identifier of the token in the API key listings
+func APIKeyID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:8])
+}
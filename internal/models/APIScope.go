@@ -0,0 +1,116 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// APIScopeAll allows every core endpoint with any key
+	APIScopeAll = "*"
+	// APIScopeSign allows /gpg/sign and /gpg/signQuanto
+	APIScopeSign = "sign"
+	// APIScopeDecrypt allows /gpg/decrypt and /fieldCipher/decipher
+	APIScopeDecrypt = "decrypt"
+	// APIScopeEncrypt allows /gpg/encrypt and /fieldCipher/cipher
+	APIScopeEncrypt = "encrypt"
+	// APIScopeVerify allows /gpg/verifySignature and /gpg/verifySignatureQuanto
+	APIScopeVerify = "verify"
+	// APIScopeKeyRing allows reading the keyring in /keyRing
+	APIScopeKeyRing = "keyRing"
+	// APIScopeKeys allows generating, unlocking, adding, deleting and restoring private keys
+	APIScopeKeys = "keys"
+)
+
+// apiKeyScopes are the scopes that can be restricted to a key fingerprint
+var apiKeyScopes = map[string]bool{
+	APIScopeSign:    true,
+	APIScopeDecrypt: true,
+}
+
+var apiScopes = map[string]bool{
+	APIScopeAll:     true,
+	APIScopeSign:    true,
+	APIScopeDecrypt: true,
+	APIScopeEncrypt: true,
+	APIScopeVerify:  true,
+	APIScopeKeyRing: true,
+	APIScopeKeys:    true,
+}
+
+// apiScopeFingerPrint is the fingerprint of a scope, with at least 16 characters since short key IDs are not unique
+var apiScopeFingerPrint = regexp.MustCompile(`^[0-9A-F]{16,40}$`)
+
+// APIScope is a permission of an API key, like sign or sign:0551F452ABE463A4
+type APIScope struct {
+	// Group is the endpoint group
+	Group string
+	// FingerPrint restricts the scope to a key. Empty allows any key
+	FingerPrint string
+}
+
+// ParseAPIScope parses a scope in the group or group:fingerprint format
+func ParseAPIScope(scope string) (APIScope, error) {
+	parts := strings.SplitN(strings.TrimSpace(scope), ":", 2)
+	s := APIScope{Group: parts[0]}
+
+	if !apiScopes[s.Group] {
+		return s, fmt.Errorf("unknown scope %q", scope)
+	}
+
+	if len(parts) == 2 {
+		if !apiKeyScopes[s.Group] {
+			return s, fmt.Errorf("the scope %q cannot be restricted to a key", s.Group)
+		}
+
+		s.FingerPrint = strings.ToUpper(parts[1])
+		if !apiScopeFingerPrint.MatchString(s.FingerPrint) {
+			return s, fmt.Errorf("invalid fingerprint in scope %q", scope)
+		}
+	}
+
+	return s, nil
+}
+
+// ParseAPIScopes parses a list of scopes
+func ParseAPIScopes(scopes []string) ([]APIScope, error) {
+	parsed := make([]APIScope, 0, len(scopes))
+
+	for _, scope := range scopes {
+		s, err := ParseAPIScope(scope)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, s)
+	}
+
+	return parsed, nil
+}
+
+// String returns the scope in the group or group:fingerprint format
+func (s APIScope) String() string {
+	if s.FingerPrint == "" {
+		return s.Group
+	}
+
+	return s.Group + ":" + s.FingerPrint
+}
+
+// Allows returns if the scope allows the endpoint group
+func (s APIScope) Allows(group string) bool {
+	return s.Group == APIScopeAll || s.Group == group
+}
+
+// AllowsKey returns if the scope allows the key of the fingerprint. Unless the scope allows any key,
+// the fingerprint should have at least 16 characters and end with the fingerprint of the scope
+func (s APIScope) AllowsKey(fingerPrint string) bool {
+	if s.FingerPrint == "" {
+		return true
+	}
+
+	fingerPrint = strings.ToUpper(strings.TrimSpace(fingerPrint))
+
+	// Short key IDs are not unique, so they cannot be authorized by the end of a longer fingerprint
+	return len(fingerPrint) >= 16 && strings.HasSuffix(fingerPrint, s.FingerPrint)
+}
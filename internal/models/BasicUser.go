@@ -7,6 +7,7 @@ type BasicUser struct {
 	Username    string
 	FullName    string
 	CreatedAt   time.Time
+	Scopes      []string
 }
 
 func (bu *BasicUser) GetFullName() string {
@@ -32,3 +33,7 @@ func (bu *BasicUser) GetFingerPrint() string {
 func (bu *BasicUser) GetToken() string {
 	return ""
 }
+
+func (bu *BasicUser) GetScopes() []string {
+	return bu.Scopes
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// EmailVerificationToken is a pending email ownership verification of a key stored in the PKS. Only the token hash is stored
+type EmailVerificationToken struct {
+	Email      string
+	TokenHash  string
+	Expiration time.Time
+}
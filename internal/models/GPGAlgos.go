@@ -1,5 +1,11 @@
 package models
 
+import (
+	"fmt"
+
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+)
+
 const (
 	GPG_MD5       = 1
 	GPG_SHA1      = 2
@@ -9,3 +15,22 @@ const (
 	GPG_SHA512    = 10
 	GPG_SHA224    = 11
 )
+
+var pubKeyAlgoNames = map[packet.PublicKeyAlgorithm]string{
+	packet.PubKeyAlgoRSA:            "RSA",
+	packet.PubKeyAlgoRSAEncryptOnly: "RSA (Encrypt Only)",
+	packet.PubKeyAlgoRSASignOnly:    "RSA (Sign Only)",
+	packet.PubKeyAlgoElGamal:        "ElGamal",
+	packet.PubKeyAlgoDSA:            "DSA",
+	packet.PubKeyAlgoECDH:           "ECDH",
+	packet.PubKeyAlgoECDSA:          "ECDSA",
+}
+
+// PubKeyAlgoName returns the display name of the public key algorithm
+func PubKeyAlgoName(algo packet.PublicKeyAlgorithm) string {
+	if name, ok := pubKeyAlgoNames[algo]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown (%d)", algo)
+}
@@ -2,11 +2,16 @@ package models
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
 	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+	"regexp"
 	"strings"
+	"time"
 )
 
 const DefaultValue = -1
@@ -16,6 +21,45 @@ const DefaultPageEnd = 100
 var GPGKeyTableInit = TableInitStruct{
 	TableName:    "gpgKey",
 	TableIndexes: []string{"FullFingerPrint", "Names", "Emails", "Subkeys"},
+	FunctionIndexes: []TableFunctionIndex{
+		{
+			// Long key IDs of the primary key and subkeys
+			Name:     "KeyIDs",
+			Function: func(row r.Term) interface{} { return row.Field("Subkeys") },
+			Multi:    true,
+		},
+		{
+			Name: "ShortKeyIDs",
+			Function: func(row r.Term) interface{} {
+				return row.Field("Subkeys").Map(func(id r.Term) interface{} { return id.Slice(8) })
+			},
+			Multi: true,
+		},
+		// Lower case names and emails for the prefix searches
+		{
+			Name: "LowerNames",
+			Function: func(row r.Term) interface{} {
+				return row.Field("Names").Default([]interface{}{}).Map(func(v r.Term) interface{} { return v.Downcase() })
+			},
+			Multi: true,
+		},
+		{
+			Name: "LowerEmails",
+			Function: func(row r.Term) interface{} {
+				return row.Field("Emails").Default([]interface{}{}).Map(func(v r.Term) interface{} { return v.Downcase() })
+			},
+			Multi: true,
+		},
+		// Compound indexes with the id for stable cursor pagination
+		{
+			Name:     "FingerPrintID",
+			Function: func(row r.Term) interface{} { return []interface{}{row.Field("FullFingerPrint"), row.Field("id")} },
+		},
+		{
+			Name:     "CreationTimeID",
+			Function: func(row r.Term) interface{} { return []interface{}{row.Field("CreationTime"), row.Field("id")} },
+		},
+	},
 }
 
 type GPGKey struct {
@@ -28,6 +72,34 @@ type GPGKey struct {
 	Subkeys                []string
 	AsciiArmoredPublicKey  string
 	AsciiArmoredPrivateKey string
+	// Search fields, filled from the primary key
+	Algorithm      string
+	CreationTime   time.Time
+	ExpirationTime *time.Time
+	Revoked        bool
+	// VerificationRequired is true if only the VerifiedUserIDs are indexed and served (PKS_VERIFY_OWNERSHIP)
+	VerificationRequired            bool
+	VerifiedUserIDs                 []string
+	PendingUserIDs                  []string
+	VerificationChallenge           string
+	VerificationChallengeExpiration time.Time
+	EmailVerificationTokens         []EmailVerificationToken
+	// SyncDigest is the digest of the served public key compared by the PKS peers. Empty for keys stored before it was added
+	SyncDigest string
+}
+
+// readGPGKeys reads all the keys of the cursor. Each document is decoded into a new GPGKey,
+// since the fields missing in the next document would not be overwritten
+func readGPGKeys(res *r.Cursor) ([]GPGKey, error) {
+	results := make([]GPGKey, 0)
+	var gpgKey GPGKey
+
+	for res.Next(&gpgKey) {
+		results = append(results, gpgKey)
+		gpgKey = GPGKey{}
+	}
+
+	return results, res.Err()
 }
 
 func (key *GPGKey) GetShortFingerPrint() string {
@@ -35,17 +107,29 @@ func (key *GPGKey) GetShortFingerPrint() string {
 }
 
 func (key *GPGKey) Save(conn *r.Session) error {
-	return r.Table(GPGKeyTableInit.TableName).
+	err := r.Table(GPGKeyTableInit.TableName).
 		Get(key.Id).
 		Update(key).
 		Exec(conn)
+
+	if err != nil {
+		return err
+	}
+
+	return touchPKSSyncBuckets(conn, key.FullFingerPrint)
 }
 
 func (key *GPGKey) Delete(conn *r.Session) error {
-	return r.Table(GPGKeyTableInit.TableName).
+	err := r.Table(GPGKeyTableInit.TableName).
 		Get(key.Id).
 		Delete().
 		Exec(conn)
+
+	if err != nil {
+		return err
+	}
+
+	return touchPKSSyncBuckets(conn, key.FullFingerPrint)
 }
 
 func AddGPGKey(conn *r.Session, data GPGKey) (string, bool, error) {
@@ -62,7 +146,7 @@ func AddGPGKey(conn *r.Session, data GPGKey) (string, bool, error) {
 
 	var gpgKey GPGKey
 
-	if existing.Next(gpgKey) {
+	if existing.Next(&gpgKey) {
 		// Update
 		_, err := r.Table(GPGKeyTableInit.TableName).
 			Get(gpgKey.Id).
@@ -73,7 +157,7 @@ func AddGPGKey(conn *r.Session, data GPGKey) (string, bool, error) {
 			return "", false, err
 		}
 
-		return gpgKey.Id, false, err
+		return gpgKey.Id, false, touchPKSSyncBuckets(conn, data.FullFingerPrint)
 	} else {
 		// Create
 		wr, err := r.Table(GPGKeyTableInit.TableName).
@@ -83,10 +167,32 @@ func AddGPGKey(conn *r.Session, data GPGKey) (string, bool, error) {
 		if err != nil {
 			return "", false, err
 		}
-		return wr.GeneratedKeys[0], true, err
+		return wr.GeneratedKeys[0], true, touchPKSSyncBuckets(conn, data.FullFingerPrint)
 	}
 }
 
+// SaveGPGKeys inserts the keys, replacing the stored ones with the same id
+func SaveGPGKeys(conn *r.Session, keys []GPGKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := r.Table(GPGKeyTableInit.TableName).
+		Insert(keys, r.InsertOpts{Conflict: "replace"}).
+		RunWrite(conn)
+
+	if err != nil {
+		return err
+	}
+
+	fingerPrints := make([]string, len(keys))
+	for i, key := range keys {
+		fingerPrints[i] = key.FullFingerPrint
+	}
+
+	return touchPKSSyncBuckets(conn, fingerPrints...)
+}
+
 func FetchKeysWithoutSubKeys(conn *r.Session) ([]GPGKey, error) {
 	res, err := r.Table(GPGKeyTableInit.TableName).
 		Filter(r.Row.HasFields("Subkeys").Not().Or(r.Row.Field("Subkeys").Count().Eq(0))).
@@ -99,16 +205,80 @@ func FetchKeysWithoutSubKeys(conn *r.Session) ([]GPGKey, error) {
 
 	defer res.Close()
 
-	results := make([]GPGKey, 0)
-	var gpgKey GPGKey
+	return readGPGKeys(res)
+}
 
-	for res.Next(&gpgKey) {
-		results = append(results, gpgKey)
+// FetchKeysWithoutSearchFields returns the keys stored before the search fields were added
+func FetchKeysWithoutSearchFields(conn *r.Session) ([]GPGKey, error) {
+	res, err := r.Table(GPGKeyTableInit.TableName).
+		Filter(r.Row.HasFields("CreationTime").Not()).
+		CoerceTo("array").
+		Run(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Close()
+
+	return readGPGKeys(res)
+}
+
+// FetchKeysForSync returns the keys with the full fingerprint starting with prefix ordered by fingerprint,
+// with only the fingerprint and the sync digest. An empty prefix returns all keys
+func FetchKeysForSync(conn *r.Session, prefix string) ([]GPGKey, error) {
+	q := r.Table(GPGKeyTableInit.TableName)
+
+	if prefix != "" {
+		q = q.Between(prefix, prefix+"\uffff", r.BetweenOpts{Index: "FullFingerPrint"})
+	}
+
+	res, err := q.OrderBy(r.OrderByOpts{Index: "FullFingerPrint"}).
+		Pluck("id", "FullFingerPrint", "SyncDigest").
+		Run(conn)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return results, nil
+	defer res.Close()
+
+	return readGPGKeys(res)
 }
 
+// SetGPGKeySyncDigest updates the sync digest of the key with the id
+func SetGPGKeySyncDigest(conn *r.Session, id, digest string) error {
+	return r.Table(GPGKeyTableInit.TableName).
+		Get(id).
+		Update(map[string]interface{}{"SyncDigest": digest}).
+		Exec(conn)
+}
+
+// GetGPGKeysByFullFingerPrints returns the keys with the full fingerprints
+func GetGPGKeysByFullFingerPrints(conn *r.Session, fingerPrints []string) ([]GPGKey, error) {
+	ids := make([]interface{}, len(fingerPrints))
+	for i, fp := range fingerPrints {
+		ids[i] = fp
+	}
+
+	res, err := r.Table(GPGKeyTableInit.TableName).
+		GetAll(ids...).
+		OptArgs(r.GetAllOpts{Index: "FullFingerPrint"}).
+		CoerceTo("array").
+		Run(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Close()
+
+	return readGPGKeys(res)
+}
+
+// ErrGPGKeyNotFound is returned by GetGPGKeyByFingerPrint when there is no key with the fingerprint
+var ErrGPGKeyNotFound = fmt.Errorf("not found")
+
 func GetGPGKeyByFingerPrint(conn *r.Session, fingerPrint string) (*GPGKey, error) {
 	res, err := r.Table(GPGKeyTableInit.TableName).
 		Filter(r.Row.Field("FullFingerPrint").Match(fmt.Sprintf("%s$", fingerPrint)).
@@ -131,7 +301,7 @@ func GetGPGKeyByFingerPrint(conn *r.Session, fingerPrint string) (*GPGKey, error
 		return &gpgKey, nil
 	}
 
-	return nil, fmt.Errorf("not found")
+	return nil, ErrGPGKeyNotFound
 }
 
 func SearchGPGKeyByEmail(conn *r.Session, email string, pageStart, pageEnd int) ([]GPGKey, error) {
@@ -147,6 +317,7 @@ func SearchGPGKeyByEmail(conn *r.Session, email string, pageStart, pageEnd int)
 		return r.Match(email)
 	}
 	res, err := r.Table(GPGKeyTableInit.TableName).
+		OrderBy(r.OrderByOpts{Index: "FingerPrintID"}).
 		Filter(func(r r.Term) interface{} {
 			return r.Field("Emails").
 				Filter(filterEmailList).
@@ -162,14 +333,7 @@ func SearchGPGKeyByEmail(conn *r.Session, email string, pageStart, pageEnd int)
 	}
 
 	defer res.Close()
-	results := make([]GPGKey, 0)
-	var gpgKey GPGKey
-
-	for res.Next(&gpgKey) {
-		results = append(results, gpgKey)
-	}
-
-	return results, nil
+	return readGPGKeys(res)
 }
 
 func SearchGPGKeyByFingerPrint(conn *r.Session, fingerPrint string, pageStart, pageEnd int) ([]GPGKey, error) {
@@ -182,6 +346,7 @@ func SearchGPGKeyByFingerPrint(conn *r.Session, fingerPrint string, pageStart, p
 	}
 
 	res, err := r.Table(GPGKeyTableInit.TableName).
+		OrderBy(r.OrderByOpts{Index: "FingerPrintID"}).
 		Filter(r.Row.Field("FullFingerPrint").Match(fmt.Sprintf("%s$", fingerPrint)).
 			Or(r.Row.HasFields("Subkeys").And(r.Row.Field("Subkeys").Filter(func(p r.Term) interface{} {
 				return p.Match(fmt.Sprintf("%s$", fingerPrint))
@@ -195,14 +360,7 @@ func SearchGPGKeyByFingerPrint(conn *r.Session, fingerPrint string, pageStart, p
 	}
 
 	defer res.Close()
-	results := make([]GPGKey, 0)
-	var gpgKey GPGKey
-
-	for res.Next(&gpgKey) {
-		results = append(results, gpgKey)
-	}
-
-	return results, nil
+	return readGPGKeys(res)
 }
 
 func SearchGPGKeyByValue(conn *r.Session, value string, pageStart, pageEnd int) ([]GPGKey, error) {
@@ -228,6 +386,7 @@ func SearchGPGKeyByValue(conn *r.Session, value string, pageStart, pageEnd int)
 	}
 
 	res, err := r.Table(GPGKeyTableInit.TableName).
+		OrderBy(r.OrderByOpts{Index: "FingerPrintID"}).
 		Filter(filterSub).
 		Slice(pageStart, pageEnd).
 		CoerceTo("array").
@@ -239,14 +398,7 @@ func SearchGPGKeyByValue(conn *r.Session, value string, pageStart, pageEnd int)
 
 	defer res.Close()
 
-	results := make([]GPGKey, 0)
-	var gpgKey GPGKey
-
-	for res.Next(&gpgKey) {
-		results = append(results, gpgKey)
-	}
-
-	return results, nil
+	return readGPGKeys(res)
 }
 
 func SearchGPGKeyByName(conn *r.Session, name string, pageStart, pageEnd int) ([]GPGKey, error) {
@@ -262,6 +414,7 @@ func SearchGPGKeyByName(conn *r.Session, name string, pageStart, pageEnd int) ([
 		return r.Match(name)
 	}
 	res, err := r.Table(GPGKeyTableInit.TableName).
+		OrderBy(r.OrderByOpts{Index: "FingerPrintID"}).
 		Filter(func(r r.Term) interface{} {
 			return r.Field("Names").
 				Filter(filterNames).
@@ -278,17 +431,47 @@ func SearchGPGKeyByName(conn *r.Session, name string, pageStart, pageEnd int) ([
 
 	defer res.Close()
 
-	results := make([]GPGKey, 0)
-	var gpgKey GPGKey
+	return readGPGKeys(res)
+}
 
-	for res.Next(&gpgKey) {
-		results = append(results, gpgKey)
+func AsciiArmored2GPGKey(asciiArmored string) (GPGKey, error) {
+	return asciiArmored2GPGKey(asciiArmored, nil)
+}
+
+// AsciiArmored2GPGKeyWithUserIDs parses the key indexing only the specified user IDs in the Names, Emails and KeyUids
+func AsciiArmored2GPGKeyWithUserIDs(asciiArmored string, userIDs []string) (GPGKey, error) {
+	filter := map[string]bool{}
+	for _, id := range userIDs {
+		filter[id] = true
 	}
 
-	return results, nil
+	return asciiArmored2GPGKey(asciiArmored, filter)
 }
 
-func AsciiArmored2GPGKey(asciiArmored string) (GPGKey, error) {
+// keyExpirationTime returns the expiration of the primary key from the primary user ID self signature, or nil if it does not expire
+func keyExpirationTime(entity *openpgp.Entity) *time.Time {
+	var sig *packet.Signature
+
+	for _, id := range entity.Identities {
+		if id.SelfSignature == nil {
+			continue
+		}
+
+		if sig == nil || (id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId) {
+			sig = id.SelfSignature
+		}
+	}
+
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return nil
+	}
+
+	t := entity.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+
+	return &t
+}
+
+func asciiArmored2GPGKey(asciiArmored string, userIDs map[string]bool) (GPGKey, error) {
 	var key GPGKey
 	reader := bytes.NewBuffer([]byte(asciiArmored))
 	z, err := openpgp.ReadArmoredKeyRing(reader)
@@ -309,6 +492,10 @@ func AsciiArmored2GPGKey(asciiArmored string) (GPGKey, error) {
 			KeyUids:               make([]GPGKeyUid, 0),
 			KeyBits:               int(keyBits),
 			Subkeys:               make([]string, 0),
+			Algorithm:             PubKeyAlgoName(pubKey.PubKeyAlgo),
+			CreationTime:          pubKey.CreationTime,
+			ExpirationTime:        keyExpirationTime(entity),
+			Revoked:               len(entity.Revocations) > 0,
 		}
 
 		fp := strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]))
@@ -319,7 +506,11 @@ func AsciiArmored2GPGKey(asciiArmored string) (GPGKey, error) {
 			key.Subkeys = append(key.Subkeys, fp[len(fp)-16:])
 		}
 
-		for _, v := range entity.Identities {
+		for id, v := range entity.Identities {
+			if userIDs != nil && !userIDs[id] {
+				continue
+			}
+
 			z := GPGKeyUid{
 				Name:        v.UserId.Name,
 				Email:       v.UserId.Email,
@@ -343,3 +534,301 @@ func AsciiArmored2GPGKey(asciiArmored string) (GPGKey, error) {
 
 	return key, fmt.Errorf("cannot parse GPG Key")
 }
+
+type gpgKeySearchCursor struct {
+	Sort         string
+	FingerPrint  string
+	CreationTime time.Time
+	Id           string
+}
+
+func (c gpgKeySearchCursor) key() []interface{} {
+	if c.Sort == GPGKeySearchSortCreationTime {
+		return []interface{}{c.CreationTime, c.Id}
+	}
+
+	return []interface{}{c.FingerPrint, c.Id}
+}
+
+func encodeGPGKeySearchCursor(sort string, key GPGKey) string {
+	data, _ := json.Marshal(gpgKeySearchCursor{
+		Sort:         sort,
+		FingerPrint:  key.FullFingerPrint,
+		CreationTime: key.CreationTime,
+		Id:           key.Id,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeGPGKeySearchCursor(cursor, sort string) (*gpgKeySearchCursor, error) {
+	var c gpgKeySearchCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+
+	if err != nil || c.Sort != sort || c.Id == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// gpgKeySearchKeyID returns the key ID index and the key ID of a short (8) or long (16) key ID or a fingerprint
+func gpgKeySearchKeyID(keyID string) (string, string, error) {
+	keyID = strings.ToUpper(keyID)
+	if len(keyID) > 16 {
+		keyID = keyID[len(keyID)-16:]
+	}
+
+	switch len(keyID) {
+	case 8:
+		return "ShortKeyIDs", keyID, nil
+	case 16:
+		return "KeyIDs", keyID, nil
+	}
+
+	return "", "", fmt.Errorf("the key id should have 8 or 16 characters or be a fingerprint")
+}
+
+// isGPGKeySearchKeyID returns true if the value is a key ID or a fingerprint
+func isGPGKeySearchKeyID(value string) bool {
+	_, err := hex.DecodeString(value)
+	return err == nil && (len(value) == 8 || len(value) == 16 || len(value) == 40)
+}
+
+// gpgKeySearchPrefix returns the keys with a value in the lower case index starting with the lower case prefix
+func gpgKeySearchPrefix(table r.Term, index, prefix string) r.Term {
+	prefix = strings.ToLower(prefix)
+	return table.Between(prefix, prefix+"\uffff", r.BetweenOpts{Index: index})
+}
+
+// gpgKeySearchCandidates returns the keys that can match the name, email, value or key ID of the search using
+// their indexes, or false if the search does not have any of them
+func gpgKeySearchCandidates(table r.Term, search GPGKeySearch) (r.Term, bool, error) {
+	switch {
+	case search.KeyID != "":
+		index, keyID, err := gpgKeySearchKeyID(search.KeyID)
+		return table.GetAllByIndex(index, keyID), true, err
+	case search.Email != "":
+		return gpgKeySearchPrefix(table, "LowerEmails", search.Email), true, nil
+	case search.Name != "":
+		return gpgKeySearchPrefix(table, "LowerNames", search.Name), true, nil
+	case search.Value != "":
+		candidates := gpgKeySearchPrefix(table, "LowerNames", search.Value).
+			Union(gpgKeySearchPrefix(table, "LowerEmails", search.Value))
+
+		if isGPGKeySearchKeyID(search.Value) {
+			index, keyID, _ := gpgKeySearchKeyID(search.Value)
+			candidates = candidates.Union(table.GetAllByIndex(index, keyID))
+		}
+
+		return candidates, true, nil
+	}
+
+	return r.Term{}, false, nil
+}
+
+// gpgKeySearchFilter returns the filter of all the search fields. The fields used by the indexes are filtered again,
+// since only one of them is used to get the candidates
+func gpgKeySearchFilter(search GPGKeySearch) func(row r.Term) interface{} {
+	return func(row r.Term) interface{} {
+		conditions := []interface{}{true}
+
+		hasPrefix := func(field, prefix string) r.Term {
+			pattern := "^" + regexp.QuoteMeta(strings.ToLower(prefix))
+			return row.Field(field).Default([]interface{}{}).Contains(func(v r.Term) interface{} { return v.Downcase().Match(pattern) })
+		}
+
+		hasKeyID := func(keyID string) r.Term {
+			_, keyID, _ = gpgKeySearchKeyID(keyID)
+			return row.Field("Subkeys").Default([]interface{}{}).Contains(func(v r.Term) interface{} { return v.Match(keyID + "$") })
+		}
+
+		if search.Value != "" {
+			value := hasPrefix("Emails", search.Value).Or(hasPrefix("Names", search.Value))
+			if isGPGKeySearchKeyID(search.Value) {
+				value = value.Or(hasKeyID(search.Value))
+			}
+			conditions = append(conditions, value)
+		}
+
+		if search.Name != "" {
+			conditions = append(conditions, hasPrefix("Names", search.Name))
+		}
+
+		if search.Email != "" {
+			conditions = append(conditions, hasPrefix("Emails", search.Email))
+		}
+
+		if search.KeyID != "" {
+			conditions = append(conditions, hasKeyID(search.KeyID))
+		}
+
+		if search.CreatedAfter != nil {
+			conditions = append(conditions, row.Field("CreationTime").Ge(*search.CreatedAfter))
+		}
+
+		if search.CreatedBefore != nil {
+			conditions = append(conditions, row.Field("CreationTime").Lt(*search.CreatedBefore))
+		}
+
+		expiration := row.Field("ExpirationTime").Default(nil)
+
+		if search.ExpiresAfter != nil {
+			conditions = append(conditions, r.Branch(expiration.Eq(nil), true, expiration.Gt(*search.ExpiresAfter)))
+		}
+
+		if search.ExpiresBefore != nil {
+			conditions = append(conditions, r.Branch(expiration.Eq(nil), false, expiration.Lt(*search.ExpiresBefore)))
+		}
+
+		if search.Algorithm != "" {
+			conditions = append(conditions, row.Field("Algorithm").Default("").Match("(?i)^"+regexp.QuoteMeta(search.Algorithm)+"$"))
+		}
+
+		if search.Revoked != nil {
+			conditions = append(conditions, row.Field("Revoked").Default(false).Eq(*search.Revoked))
+		}
+
+		return r.And(conditions...)
+	}
+}
+
+// SearchGPGKeys returns a page of the keys matching the search sorted by the search field and id.
+// The key ID and prefix lookups and the sorting use the table indexes
+func SearchGPGKeys(conn *r.Session, search GPGKeySearch) (GPGKeySearchResult, error) {
+	result := GPGKeySearchResult{Keys: make([]GPGKey, 0)}
+
+	if search.Sort == "" {
+		search.Sort = GPGKeySearchSortFingerPrint
+	}
+
+	var index, field string
+	switch search.Sort {
+	case GPGKeySearchSortFingerPrint:
+		index, field = "FingerPrintID", "FullFingerPrint"
+	case GPGKeySearchSortCreationTime:
+		index, field = "CreationTimeID", "CreationTime"
+	default:
+		return result, fmt.Errorf("invalid sort %q", search.Sort)
+	}
+
+	if search.Limit <= 0 {
+		search.Limit = DefaultGPGKeySearchLimit
+	}
+
+	if search.Limit > MaxGPGKeySearchLimit {
+		search.Limit = MaxGPGKeySearchLimit
+	}
+
+	var cursor *gpgKeySearchCursor
+	if search.Cursor != "" {
+		var err error
+		cursor, err = decodeGPGKeySearchCursor(search.Cursor, search.Sort)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	filter := gpgKeySearchFilter(search)
+	table := r.Table(GPGKeyTableInit.TableName)
+
+	var matches, page r.Term
+
+	candidates, indexed, err := gpgKeySearchCandidates(table, search)
+	if err != nil {
+		return result, err
+	}
+
+	if indexed {
+		// The prefix and key ID lookups return few keys, so they are sorted in memory
+		matches = candidates.Distinct().Filter(filter)
+		page = matches
+
+		if cursor != nil {
+			page = page.Filter(func(row r.Term) interface{} {
+				key := r.Expr([]interface{}{row.Field(field), row.Field("id")})
+				if search.Descending {
+					return key.Lt(cursor.key())
+				}
+				return key.Gt(cursor.key())
+			})
+		}
+
+		if search.Descending {
+			page = page.OrderBy(r.Desc(field), r.Desc("id"))
+		} else {
+			page = page.OrderBy(r.Asc(field), r.Asc("id"))
+		}
+	} else {
+		var lower, upper interface{} = r.MinVal, r.MaxVal
+
+		if search.Sort == GPGKeySearchSortCreationTime {
+			if search.CreatedAfter != nil {
+				lower = []interface{}{*search.CreatedAfter, r.MinVal}
+			}
+
+			if search.CreatedBefore != nil {
+				upper = []interface{}{*search.CreatedBefore, r.MinVal}
+			}
+		}
+
+		matches = table.Between(lower, upper, r.BetweenOpts{Index: index}).Filter(filter)
+
+		opts := r.BetweenOpts{Index: index}
+		if cursor != nil {
+			if search.Descending {
+				upper = cursor.key()
+			} else {
+				lower = cursor.key()
+				opts.LeftBound = "open"
+			}
+		}
+
+		order := r.Asc(index)
+		if search.Descending {
+			order = r.Desc(index)
+		}
+
+		page = table.Between(lower, upper, opts).OrderBy(r.OrderByOpts{Index: order}).Filter(filter)
+	}
+
+	// Counting needs all the matches, so it is only done when asked
+	if search.CountTotal {
+		res, err := matches.Count().Run(conn)
+		if err != nil {
+			return result, err
+		}
+
+		total := 0
+		err = res.One(&total)
+		_ = res.Close()
+		if err != nil {
+			return result, err
+		}
+
+		result.Total = &total
+	}
+
+	res, err := page.Limit(search.Limit + 1).Run(conn)
+	if err != nil {
+		return result, err
+	}
+
+	defer res.Close()
+
+	result.Keys, err = readGPGKeys(res)
+	if err != nil {
+		return result, err
+	}
+
+	if len(result.Keys) > search.Limit {
+		result.Keys = result.Keys[:search.Limit]
+		result.NextCursor = encodeGPGKeySearchCursor(search.Sort, result.Keys[search.Limit-1])
+	}
+
+	return result, nil
+}
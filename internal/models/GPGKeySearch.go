@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	GPGKeySearchSortFingerPrint  = "fingerPrint"
+	GPGKeySearchSortCreationTime = "creationTime"
+
+	DefaultGPGKeySearchLimit = 100
+	MaxGPGKeySearchLimit     = 1000
+)
+
+// GPGKeySearch are the filters, sorting and cursor of a key search. Empty fields match any key
+type GPGKeySearch struct {
+	// Value is a case insensitive prefix of the names or emails, or a key ID or fingerprint
+	Value string
+	// Name and Email are case insensitive prefixes of the names and emails
+	Name  string
+	Email string
+	// KeyID is the short (8) or long (16) key ID, or the fingerprint, of the primary key or of a subkey
+	KeyID         string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// ExpiresAfter also matches the keys that do not expire
+	ExpiresAfter  *time.Time
+	ExpiresBefore *time.Time
+	Algorithm     string
+	Revoked       *bool
+	// Sort is GPGKeySearchSortFingerPrint (default) or GPGKeySearchSortCreationTime
+	Sort       string
+	Descending bool
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	Limit  int
+	// CountTotal counts the keys matching the filters in all pages, which reads all of them
+	CountTotal bool
+}
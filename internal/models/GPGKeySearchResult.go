@@ -0,0 +1,10 @@
+package models
+
+// GPGKeySearchResult is a page of a key search
+type GPGKeySearchResult struct {
+	Keys []GPGKey
+	// Total is the number of keys matching the filters in all pages. Only set if CountTotal was requested
+	Total *int `json:",omitempty"`
+	// NextCursor is the cursor of the next page, empty in the last page
+	NextCursor string
+}
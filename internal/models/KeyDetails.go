@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// KeyDetails is the parsed information of a public or private key
+type KeyDetails struct {
+	FingerPrint           string
+	KeyID                 string
+	Algorithm             string
+	Bits                  int
+	CreationTime          time.Time
+	ExpirationTime        *time.Time
+	CanSign               bool
+	CanEncrypt            bool
+	CanCertify            bool
+	Revoked               bool
+	Revocations           []KeySignatureDetails
+	ContainsPrivateKey    bool
+	PrivateKeyIsDecrypted bool
+	SubKeys               []SubKeyDetails
+	UserIDs               []UserIDDetails
+}
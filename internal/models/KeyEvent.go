@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+const (
+	KeyEventAdded   = "added"
+	KeyEventChanged = "changed"
+	KeyEventRemoved = "removed"
+)
+
+// KeyEvent is a change of a key stored in a key backend
+type KeyEvent struct {
+	Type        string
+	Key         string
+	FingerPrint string
+	Time        time.Time
+}
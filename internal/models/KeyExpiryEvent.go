@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+const (
+	KeyExpiryEventExpiring = "key_expiring"
+	KeyExpiryEventExpired  = "key_expired"
+)
+
+// KeyExpiryEvent is sent to the key expiry webhook when a key reaches a threshold or expires
+type KeyExpiryEvent struct {
+	Type   string
+	Time   time.Time
+	Status KeyExpiryStatus
+}
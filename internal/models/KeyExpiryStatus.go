@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	KeyExpirySourceLoaded    = "loaded"
+	KeyExpirySourceWatchList = "watchlist"
+)
+
+// KeyExpiryStatus is the expiration status of a key checked by the key expiry monitor
+type KeyExpiryStatus struct {
+	FingerPrint string
+	// KeyID is the fingerprint of the key or subkey that expires first
+	KeyID      string `json:",omitempty"`
+	Identifier string `json:",omitempty"`
+	// Source is where the key was found, loaded for the loaded private keys and watchlist for the watch-list keys
+	Source         string
+	ExpirationTime *time.Time `json:",omitempty"`
+	DaysLeft       int
+	Expired        bool
+	// Threshold is the smallest threshold in days reached by the key, 0 if none was reached
+	Threshold int
+	Error     string `json:",omitempty"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// KeyImportError is a key of a keyring dump that could not be imported
+type KeyImportError struct {
+	// Index is the position of the key in the dump, starting at 1
+	Index       int
+	FingerPrint string `json:",omitempty"`
+	Error       string
+}
@@ -0,0 +1,14 @@
+package models
+
+// KeyImportOptions selects where the keys of a keyring dump are imported
+type KeyImportOptions struct {
+	// PKS adds the keys to the internal PKS, merging them with the stored ones
+	PKS bool
+	// KeyRing saves the keys in the key backend and loads them in the keyring
+	KeyRing bool
+	// BatchSize is the number of keys written to the database at once
+	BatchSize int
+	// VerifiedUserIDs marks the imported user IDs as verified with PKS_VERIFY_OWNERSHIP. Otherwise they are pending.
+	// Should only be set for administrators
+	VerifiedUserIDs bool
+}
@@ -0,0 +1,21 @@
+package models
+
+// MaxKeyImportErrors is the maximum number of errors reported by a key import
+const MaxKeyImportErrors = 100
+
+// KeyImportResult is the progress of a keyring dump import
+type KeyImportResult struct {
+	// Read is the number of keys found in the dump, including the skipped ones
+	Read      int
+	Added     int
+	Updated   int
+	Unchanged int
+	// Skipped is the number of unreadable or unsupported keys and keys rejected by the lint policy
+	Skipped int
+	Failed  int
+	// Errors has the first MaxKeyImportErrors skipped or failed keys
+	Errors []KeyImportError `json:",omitempty"`
+	Done   bool
+	// Error is the error that stopped reading the dump
+	Error string `json:",omitempty"`
+}
@@ -6,4 +6,5 @@ type KeyInfo struct {
 	Bits                  int
 	ContainsPrivateKey    bool
 	PrivateKeyIsDecrypted bool
+	Metadata              *KeyMetadata
 }
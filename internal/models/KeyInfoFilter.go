@@ -0,0 +1,9 @@
+package models
+
+// KeyInfoFilter selects keys by their metadata labels. Empty fields match any key
+type KeyInfoFilter struct {
+	OwnerTeam string
+	Purpose   string
+	// Tags are the tags that the key should have (all of them)
+	Tags []string
+}
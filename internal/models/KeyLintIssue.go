@@ -0,0 +1,15 @@
+package models
+
+const (
+	KeyLintInfo    = "info"
+	KeyLintWarning = "warning"
+	KeyLintError   = "error"
+)
+
+// KeyLintIssue is a quality problem found in a key or in one of its subkeys
+type KeyLintIssue struct {
+	Code     string
+	Severity string
+	KeyID    string
+	Message  string
+}
@@ -0,0 +1,11 @@
+package models
+
+// KeyLintResult is the result of linting a key
+type KeyLintResult struct {
+	FingerPrint string
+	// Severity is the highest severity of the issues, empty if there are no issues
+	Severity string
+	// Rejected is true if the key would be rejected on import by the configured lint policy
+	Rejected bool
+	Issues   []KeyLintIssue
+}
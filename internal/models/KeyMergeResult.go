@@ -0,0 +1,23 @@
+package models
+
+// KeyMergeResult reports what changed when a public key was added to the Public Key Store
+type KeyMergeResult struct {
+	FingerPrint           string
+	Created               bool
+	Changed               bool
+	AddedUserIDs          []string
+	AddedSubKeys          []string
+	AddedCertifications   int
+	UpdatedSelfSignatures int
+	AddedRevocations      int
+	// Dropped are the user IDs, user attributes and third-party certifications removed by the PKS limits
+	DroppedUserIDs        int
+	DroppedUserAttributes int
+	DroppedCertifications int
+	// DroppedInvalidSignatures are the signatures claiming to be made by the key itself that failed verification
+	DroppedInvalidSignatures int
+	// Challenge is set when there are user IDs pending ownership verification
+	Challenge *KeyVerificationChallenge
+	// Upstreams are the results of sending the key to the upstream key servers when the internal PKS is disabled
+	Upstreams []KeyServerUploadResult `json:",omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// KeyMetadata is the information stored alongside a private key in the key backend
+type KeyMetadata struct {
+	OwnerTeam     string     `json:",omitempty"`
+	Purpose       string     `json:",omitempty"`
+	CreatedBy     string     `json:",omitempty"`
+	CreatedAt     *time.Time `json:",omitempty"`
+	ExpiresAt     *time.Time `json:",omitempty"`
+	RotationDueAt *time.Time `json:",omitempty"`
+	Tags          []string   `json:",omitempty"`
+}
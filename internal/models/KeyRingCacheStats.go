@@ -0,0 +1,17 @@
+package models
+
+// KeyRingCacheStats is the usage of the public key cache of the KeyRingManager
+type KeyRingCacheStats struct {
+	// Size is the number of erasable cached keys, MaxSize is the maximum before the least recently used are evicted
+	Size    int
+	MaxSize int
+	// NegativeSize is the number of fingerprints cached as not found
+	NegativeSize int
+	Hits         uint64
+	// StaleHits are hits of expired keys, returned while they are refreshed in background
+	StaleHits    uint64
+	NegativeHits uint64
+	Misses       uint64
+	Refreshes    uint64
+	Evictions    uint64
+}
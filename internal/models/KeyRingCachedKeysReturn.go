@@ -0,0 +1,7 @@
+package models
+
+// KeyRingCachedKeysReturn is the list of cached keys with the cache usage
+type KeyRingCachedKeysReturn struct {
+	Keys  []KeyInfo
+	Stats KeyRingCacheStats
+}
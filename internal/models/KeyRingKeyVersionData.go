@@ -0,0 +1,6 @@
+package models
+
+type KeyRingKeyVersionData struct {
+	FingerPrint string
+	Version     int
+}
@@ -0,0 +1,7 @@
+package models
+
+type KeyRingKeyVersionReturn struct {
+	FingerPrint string
+	Version     int
+	PrivateKey  string
+}
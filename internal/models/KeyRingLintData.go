@@ -0,0 +1,5 @@
+package models
+
+type KeyRingLintData struct {
+	Key string
+}
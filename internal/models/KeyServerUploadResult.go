@@ -0,0 +1,9 @@
+package models
+
+// KeyServerUploadResult is the result of sending a public key to an upstream key server
+type KeyServerUploadResult struct {
+	Server     string
+	Success    bool
+	StatusCode int
+	Error      string `json:",omitempty"`
+}
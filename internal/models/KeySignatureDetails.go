@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// KeySignatureDetails is the parsed information of a certification or revocation signature over a key
+type KeySignatureDetails struct {
+	IssuerKeyID      string
+	Type             string
+	Hash             string
+	CreationTime     time.Time
+	RevocationReason string
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// KeyVerificationChallenge is the text that should be signed by the key owner to verify the pending user IDs
+type KeyVerificationChallenge struct {
+	FingerPrint    string
+	Challenge      string
+	Expiration     time.Time
+	PendingUserIDs []string
+}
@@ -0,0 +1,8 @@
+package models
+
+// KeyVerificationResult is the ownership verification status of the user IDs of a key stored in the PKS
+type KeyVerificationResult struct {
+	FingerPrint     string
+	VerifiedUserIDs []string
+	PendingUserIDs  []string
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type KeyVersion struct {
+	Version      int
+	CreatedTime  time.Time
+	DeletionTime *time.Time
+	Destroyed    bool
+	Current      bool
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+var PKSAuditEntryTableInit = TableInitStruct{
+	TableName:    "pksAudit",
+	TableIndexes: []string{"FingerPrint", "Time"},
+}
+
+// PKSAuditEntry is a record of a signed operation that changed a key in the PKS
+type PKSAuditEntry struct {
+	Id                string `rethinkdb:"id,omitempty"`
+	Operation         string
+	FingerPrint       string
+	SignerFingerPrint string
+	Nonce             string
+	RequestID         string
+	Time              time.Time
+	// RemovedUserIDs and RemovedSubKeys are the components removed by updateKey operations, so they are not synced back from the peers
+	RemovedUserIDs []string
+	RemovedSubKeys []string
+}
+
+func AddPKSAuditEntry(conn *r.Session, entry PKSAuditEntry) (string, error) {
+	wr, err := r.Table(PKSAuditEntryTableInit.TableName).
+		Insert(entry).
+		RunWrite(conn)
+
+	if err != nil {
+		return "", err
+	}
+
+	return wr.GeneratedKeys[0], nil
+}
+
+// GetPKSAuditEntries returns the audit entries of the key fingerprint ordered by time
+func GetPKSAuditEntries(conn *r.Session, fingerPrint string) ([]PKSAuditEntry, error) {
+	res, err := r.Table(PKSAuditEntryTableInit.TableName).
+		GetAllByIndex("FingerPrint", fingerPrint).
+		OrderBy("Time").
+		CoerceTo("array").
+		Run(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Close()
+
+	entries := make([]PKSAuditEntry, 0)
+	err = res.All(&entries)
+
+	return entries, err
+}
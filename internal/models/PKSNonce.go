@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+var PKSNonceTableInit = TableInitStruct{
+	TableName:    "pksNonces",
+	TableIndexes: []string{"Expiration"},
+}
+
+// PKSNonce is a nonce already used by a signed PKS request. Only the nonce hash is stored
+type PKSNonce struct {
+	Id         string `rethinkdb:"id"`
+	Expiration time.Time
+}
+
+// AddPKSNonce stores the nonce. Returns false if the nonce was already used
+func AddPKSNonce(conn *r.Session, nonce PKSNonce) (bool, error) {
+	_, err := r.Table(PKSNonceTableInit.TableName).
+		Insert(nonce).
+		RunWrite(conn)
+
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate primary key") {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteExpiredPKSNonces removes the nonces that expired before the specified time
+func DeleteExpiredPKSNonces(conn *r.Session, before time.Time) error {
+	return r.Table(PKSNonceTableInit.TableName).
+		Between(r.MinVal, before, r.BetweenOpts{Index: "Expiration"}).
+		Delete().
+		Exec(conn)
+}
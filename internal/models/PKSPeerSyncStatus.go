@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PKSPeerSyncStatus is the result of the last synchronization with a PKS peer
+type PKSPeerSyncStatus struct {
+	Peer        string
+	LastSync    *time.Time `json:",omitempty"`
+	LastSuccess *time.Time `json:",omitempty"`
+	Duration    string     `json:",omitempty"`
+	// DifferentBuckets is the number of buckets with a digest different from the local one
+	DifferentBuckets int
+	// SettledBuckets is the number of DifferentBuckets not pulled again because their last merge did not change any key
+	SettledBuckets int
+	PulledKeys     int
+	UpdatedKeys    int
+	FailedKeys     int
+	Error          string `json:",omitempty"`
+}
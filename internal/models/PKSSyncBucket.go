@@ -0,0 +1,98 @@
+package models
+
+import (
+	r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+)
+
+// PKSSyncBucketPrefixLength is the number of fingerprint characters that define the bucket of a key, giving 256 buckets
+const PKSSyncBucketPrefixLength = 2
+
+var PKSSyncBucketTableInit = TableInitStruct{
+	TableName:    "pksSyncBuckets",
+	TableIndexes: []string{},
+}
+
+// PKSSyncBucket is the stored digest of a bucket of keys compared by the PKS peers. The Version is increased on every
+// change of the keys in the bucket, and the Digest is only valid if it was computed for the current Version
+type PKSSyncBucket struct {
+	Id     string `rethinkdb:"id"`
+	Digest string
+	// Keys is the number of keys in the bucket with a digest
+	Keys          int
+	Version       int
+	DigestVersion int
+}
+
+// Stale returns true if the keys of the bucket changed after its digest was computed
+func (b PKSSyncBucket) Stale() bool {
+	return b.Version != b.DigestVersion
+}
+
+// PKSSyncBucketOf returns the bucket of the key fingerprint
+func PKSSyncBucketOf(fingerPrint string) string {
+	if len(fingerPrint) < PKSSyncBucketPrefixLength {
+		return fingerPrint
+	}
+
+	return fingerPrint[:PKSSyncBucketPrefixLength]
+}
+
+// FetchPKSSyncBuckets returns all the stored bucket digests
+func FetchPKSSyncBuckets(conn *r.Session) ([]PKSSyncBucket, error) {
+	res, err := r.Table(PKSSyncBucketTableInit.TableName).
+		CoerceTo("array").
+		Run(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Close()
+
+	buckets := make([]PKSSyncBucket, 0)
+	err = res.All(&buckets)
+
+	return buckets, err
+}
+
+// SetPKSSyncBucketDigest stores the digest computed for the version of the bucket. The digest is not stored
+// if the bucket changed since that version
+func SetPKSSyncBucketDigest(conn *r.Session, bucket PKSSyncBucket) error {
+	bucket.DigestVersion = bucket.Version
+
+	return r.Table(PKSSyncBucketTableInit.TableName).
+		Get(bucket.Id).
+		Replace(func(old r.Term) interface{} {
+			return r.Branch(old.Eq(nil).Or(old.Field("Version").Eq(bucket.Version)), bucket, old)
+		}).
+		Exec(conn)
+}
+
+// touchPKSSyncBuckets increases the version of the buckets of the fingerprints, so their digests are computed again
+func touchPKSSyncBuckets(conn *r.Session, fingerPrints ...string) error {
+	touched := map[string]bool{}
+
+	for _, fp := range fingerPrints {
+		bucket := PKSSyncBucketOf(fp)
+		if bucket == "" || touched[bucket] {
+			continue
+		}
+
+		touched[bucket] = true
+
+		err := r.Table(PKSSyncBucketTableInit.TableName).
+			Get(bucket).
+			Replace(func(old r.Term) interface{} {
+				return r.Branch(old.Eq(nil),
+					map[string]interface{}{"id": bucket, "Version": 1},
+					old.Merge(map[string]interface{}{"Version": old.Field("Version").Add(1)}))
+			}).
+			Exec(conn)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,7 @@
+package models
+
+// PKSSyncBuckets has the digest of each bucket of keys in the PKS. Keys are grouped in buckets by the start of their fingerprint
+type PKSSyncBuckets struct {
+	Buckets map[string]string
+	Keys    int
+}
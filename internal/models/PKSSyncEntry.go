@@ -0,0 +1,7 @@
+package models
+
+// PKSSyncEntry is the digest of a key in the PKS, used to find the keys that differ between peers
+type PKSSyncEntry struct {
+	FingerPrint string
+	Digest      string
+}
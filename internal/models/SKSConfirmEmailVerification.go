@@ -0,0 +1,6 @@
+package models
+
+type SKSConfirmEmailVerification struct {
+	FingerPrint string
+	Token       string
+}
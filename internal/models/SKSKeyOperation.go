@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	SKSKeyOperationRemove = "removeKey"
+	SKSKeyOperationUpdate = "updateKey"
+)
+
+// SKSKeyOperation is the signed content of the requests that remove or update a key in the PKS
+type SKSKeyOperation struct {
+	Operation   string
+	FingerPrint string
+	// PublicKey is the new public key of updateKey operations
+	PublicKey string
+	// Nonce is an unique random value of each request. Used nonces are refused to prevent replays
+	Nonce     string
+	Timestamp time.Time
+}
@@ -0,0 +1,5 @@
+package models
+
+type SKSRequestChallenge struct {
+	FingerPrint string
+}
@@ -0,0 +1,6 @@
+package models
+
+type SKSRequestEmailVerification struct {
+	FingerPrint string
+	Email       string
+}
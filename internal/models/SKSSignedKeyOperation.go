@@ -0,0 +1,8 @@
+package models
+
+// SKSSignedKeyOperation is a SKSKeyOperation in JSON signed by the key itself or by a PKS admin key
+type SKSSignedKeyOperation struct {
+	Base64Data string
+	// Signature is the armored detached signature of the decoded Base64Data
+	Signature string
+}
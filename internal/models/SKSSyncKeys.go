@@ -0,0 +1,5 @@
+package models
+
+type SKSSyncKeys struct {
+	FingerPrints []string
+}
@@ -0,0 +1,7 @@
+package models
+
+type SKSVerifyChallenge struct {
+	FingerPrint string
+	// Signature is the armored detached signature of the challenge
+	Signature string
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SubKeyDetails is the parsed information of a subkey
+type SubKeyDetails struct {
+	FingerPrint    string
+	KeyID          string
+	Algorithm      string
+	Bits           int
+	CreationTime   time.Time
+	ExpirationTime *time.Time
+	CanSign        bool
+	CanEncrypt     bool
+	CanCertify     bool
+	Revoked        bool
+}
@@ -0,0 +1,36 @@
+package models
+
+import "strings"
+
+// TLSIdentity is a client identity mapped from a client certificate
+type TLSIdentity struct {
+	// Identity is the SPIFFE ID of the certificate or else its subject
+	Identity string
+	// Keys are the fingerprints of the keys the client can use, or "*" for all keys
+	Keys []string
+	// Admin allows the client to use the administrative endpoints
+	Admin bool
+}
+
+// CanUseKey returns if the identity can use the key of the fingerprint. Unless the identity can use all keys,
+// the fingerprint should have at least 16 characters and end with one of the identity keys
+func (i TLSIdentity) CanUseKey(fingerPrint string) bool {
+	fingerPrint = strings.ToUpper(strings.TrimSpace(fingerPrint))
+	if fingerPrint == "" {
+		return false
+	}
+
+	for _, key := range i.Keys {
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if key == "*" {
+			return true
+		}
+
+		// Short key IDs are not unique, so they cannot be authorized by the end of a longer fingerprint
+		if key != "" && len(fingerPrint) >= 16 && strings.HasSuffix(fingerPrint, key) {
+			return true
+		}
+	}
+
+	return false
+}
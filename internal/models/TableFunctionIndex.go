@@ -0,0 +1,10 @@
+package models
+
+import r "gopkg.in/rethinkdb/rethinkdb-go.v6"
+
+// TableFunctionIndex is a RethinkDB index created from a function, like compound and multi indexes
+type TableFunctionIndex struct {
+	Name     string
+	Function func(row r.Term) interface{}
+	Multi    bool
+}
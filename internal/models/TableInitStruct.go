@@ -1,6 +1,7 @@
 package models
 
 type TableInitStruct struct {
-	TableName    string
-	TableIndexes []string
+	TableName       string
+	TableIndexes    []string
+	FunctionIndexes []TableFunctionIndex
 }
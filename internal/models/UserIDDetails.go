@@ -0,0 +1,13 @@
+package models
+
+// UserIDDetails is the parsed information of a key user ID and its signatures
+type UserIDDetails struct {
+	UserID            string
+	Name              string
+	Email             string
+	Comment           string
+	Primary           bool
+	SelfSignatureHash string
+	Revoked           bool
+	Certifications    []KeySignatureDetails
+}
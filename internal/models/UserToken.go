@@ -20,6 +20,7 @@ type UserToken struct {
 	Token       string
 	CreatedAt   time.Time
 	Expiration  time.Time
+	Scopes      []string
 }
 
 func (ut *UserToken) GetUsername() string {
@@ -46,6 +47,10 @@ func (ut *UserToken) GetFingerPrint() string {
 	return ut.FingerPrint
 }
 
+func (ut *UserToken) GetScopes() []string {
+	return ut.Scopes
+}
+
 func AddUserToken(conn *r.Session, ut *UserToken) (string, error) {
 	wr, err := r.Table(UserTokenTableInit.TableName).
 		Insert(ut).
@@ -96,6 +101,26 @@ func GetUserToken(conn *r.Session, token string) (ut *UserToken, err error) {
 	return nil, fmt.Errorf("not found")
 }
 
+// GetScopedUserTokens returns the unexpired tokens with API scopes
+func GetScopedUserTokens(conn *r.Session) ([]UserToken, error) {
+	res, err := r.Table(UserTokenTableInit.TableName).
+		Filter(r.Row.Field("Expiration").Gt(time.Now()).And(r.Row.Field("Scopes").Default([]interface{}{}).Count().Gt(0))).
+		OrderBy("CreatedAt").
+		CoerceTo("array").
+		Run(conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Close()
+
+	tokens := make([]UserToken, 0)
+	err = res.All(&tokens)
+
+	return tokens, err
+}
+
 func InvalidateUserTokens(conn *r.Session) (int, error) {
 	wr, err := r.Table(UserTokenTableInit.TableName).
 		Filter(r.Row.Field("Expiration").Lt(time.Now())).
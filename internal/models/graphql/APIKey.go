@@ -0,0 +1,37 @@
+package graphql
+
+import "github.com/graphql-go/graphql"
+
+var GraphQLAPIKey = graphql.NewObject(graphql.ObjectConfig{
+	Name: "APIKey",
+	Fields: graphql.Fields{
+		"ID": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Identifier of the API key used to revoke it. It is not the token",
+		},
+		"UserName": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Name of the user this token belongs",
+		},
+		"FullName": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Full name of the user",
+		},
+		"FingerPrint": &graphql.Field{
+			Type:        graphql.String,
+			Description: "FingerPrint of the key the token gives access to",
+		},
+		"Scopes": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "API scopes of the token, like sign or sign:0551F452ABE463A4",
+		},
+		"CreatedAt": &graphql.Field{
+			Type:        graphql.DateTime,
+			Description: "When the token was created",
+		},
+		"Expiration": &graphql.Field{
+			Type:        graphql.DateTime,
+			Description: "When the token expires",
+		},
+	},
+})
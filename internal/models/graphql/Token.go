@@ -8,6 +8,7 @@ type Token struct {
 	UserFullName          string
 	Expiration            int64
 	ExpirationDateTimeISO string
+	Scopes                []string
 }
 
 var GraphQLToken = graphql.NewObject(graphql.ObjectConfig{
@@ -33,5 +34,9 @@ var GraphQLToken = graphql.NewObject(graphql.ObjectConfig{
 			Type:        graphql.String,
 			Description: "Full name of the user",
 		},
+		"Scopes": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "API scopes of the token, like sign or sign:0551F452ABE463A4",
+		},
 	},
 })
@@ -1,7 +1,9 @@
 package models
 
 const (
-	MimeJSON = "application/json"
-	MimeText = "text/plain"
-	MimeHTML = "text/html"
+	MimeJSON        = "application/json"
+	MimeText        = "text/plain"
+	MimeHTML        = "text/html"
+	MimeOctetStream = "application/octet-stream"
+	MimeNDJSON      = "application/x-ndjson"
 )
@@ -27,6 +27,11 @@ var RootManagementQuery = graphql.NewObject(graphql.ObjectConfig{
 			Type:    graphql.String,
 			Resolve: resolveWhoAmI,
 		},
+		"APIKeys": &graphql.Field{
+			Type:        graphql.NewList(mgql.GraphQLAPIKey),
+			Description: "Unexpired tokens with API scopes. Only for the administrator",
+			Resolve:     resolveAPIKeys,
+		},
 	},
 })
 
@@ -94,6 +99,10 @@ var RootManagementMutation = graphql.NewObject(graphql.ObjectConfig{
 					Type:        graphql.String,
 					Description: "FingerPrint of the key to give access to. Defaults to Agent Default",
 				},
+				"scopes": &graphql.ArgumentConfig{
+					Type:        graphql.NewList(graphql.String),
+					Description: "API scopes that allow the token to be used as an API key in the /gpg, /keyRing and /fieldCipher endpoints, like sign or sign:0551F452ABE463A4",
+				},
 				"expiresAfter": &graphql.ArgumentConfig{
 					Type:        graphql.Int,
 					Description: "Number of seconds since creation when the generated token will expire. If 0, defaults to server default.",
@@ -111,6 +120,16 @@ var RootManagementMutation = graphql.NewObject(graphql.ObjectConfig{
 			},
 			Resolve: resolveInvalidateToken,
 		},
+		"RevokeAPIKey": &graphql.Field{
+			Type: graphql.String,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type:        graphql.NewNonNull(graphql.String),
+					Description: "The ID of the API key to be revoked, from the APIKeys query",
+				},
+			},
+			Resolve: resolveRevokeAPIKey,
+		},
 	},
 })
 
@@ -266,6 +285,19 @@ func resolveGenerateToken(p graphql.ResolveParams) (i interface{}, e error) {
 		fingerPrint = config.AgentKeyFingerPrint
 	}
 
+	var scopes []string
+
+	if p.Args["scopes"] != nil {
+		for _, scope := range p.Args["scopes"].([]interface{}) {
+			scopes = append(scopes, scope.(string))
+		}
+
+		if _, err := models.ParseAPIScopes(scopes); err != nil {
+			e := QuantoError.New(QuantoError.InvalidFieldData, "scopes", err.Error(), nil)
+			return nil, e.ToFormattedError()
+		}
+	}
+
 	expiration := 0
 
 	if p.Args["expiresAfter"] != nil {
@@ -281,6 +313,7 @@ func resolveGenerateToken(p graphql.ResolveParams) (i interface{}, e error) {
 		Username:    username,
 		FullName:    fullname,
 		CreatedAt:   time.Now(),
+		Scopes:      scopes,
 	}
 
 	exp := bu.GetCreatedAt().Add(time.Duration(expiration) * time.Second)
@@ -297,6 +330,7 @@ func resolveGenerateToken(p graphql.ResolveParams) (i interface{}, e error) {
 		UserFullName:          fullname,
 		Expiration:            exp.UnixNano() / 1e6, // ms
 		ExpirationDateTimeISO: exp.Format(time.RFC3339),
+		Scopes:                scopes,
 	}, nil
 }
 
@@ -311,3 +345,46 @@ func resolveInvalidateToken(p graphql.ResolveParams) (i interface{}, e error) {
 
 	return "OK", nil
 }
+
+func resolveAPIKeys(p graphql.ResolveParams) (i interface{}, e error) {
+	lu := p.Context.Value(LoggedUserKey).(interfaces.UserData)
+	if lu == nil {
+		e := QuantoError.New(QuantoError.PermissionDenied, "proxyToken", "You need to be logged in to use this query", nil)
+		return nil, e.ToFormattedError()
+	}
+
+	if lu.GetUsername() != "admin" {
+		e := QuantoError.New(QuantoError.PermissionDenied, "username", "Only the administrator can list API keys", nil)
+		return nil, e.ToFormattedError()
+	}
+
+	tm := p.Context.Value(TokenManagerKey).(interfaces.TokenManager)
+
+	return tm.ListAPIKeys(), nil
+}
+
+func resolveRevokeAPIKey(p graphql.ResolveParams) (i interface{}, e error) {
+	lu := p.Context.Value(LoggedUserKey).(interfaces.UserData)
+	if lu == nil {
+		e := QuantoError.New(QuantoError.PermissionDenied, "proxyToken", "You need to be logged in to use this query", nil)
+		return nil, e.ToFormattedError()
+	}
+
+	if lu.GetUsername() != "admin" {
+		e := QuantoError.New(QuantoError.PermissionDenied, "username", "Only the administrator can revoke API keys", nil)
+		return nil, e.ToFormattedError()
+	}
+
+	tm := p.Context.Value(TokenManagerKey).(interfaces.TokenManager)
+	id := p.Args["id"].(string)
+
+	err := tm.RevokeAPIKey(id)
+	if err != nil {
+		e := QuantoError.New(QuantoError.NotFound, "id", "API key not found", err.Error())
+		return "NOK", e.ToFormattedError()
+	}
+
+	amGqlLog.Info("Revoked API key %s", id)
+
+	return "OK", nil
+}
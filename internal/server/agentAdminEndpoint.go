@@ -115,6 +115,11 @@ func (admin *AgentAdmin) handleGraphQL(w http.ResponseWriter, r *http.Request) {
 		}
 
 		user := admin.tm.GetUserData(token)
+		if isAPIKey(user) {
+			PermissionDenied("proxyToken", "API keys cannot be used as proxyToken", w, r, log)
+			return
+		}
+
 		ctx = context.WithValue(ctx, agent.LoggedUserKey, user)
 	}
 	admin.handler.ContextHandler(ctx, &gi, r)
@@ -139,6 +139,11 @@ func (proxy *AgentProxy) defaultHandler(w http.ResponseWriter, r *http.Request)
 				PermissionDenied("proxyToken", "Please check if your proxyToken is valid", w, r, log)
 				return
 			}
+
+			if isAPIKey(proxy.tm.GetUserData(token)) {
+				PermissionDenied("proxyToken", "API keys cannot be used as proxyToken", w, r, log)
+				return
+			}
 		}
 
 		fingerPrint := config.AgentKeyFingerPrint
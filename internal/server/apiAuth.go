@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+)
+
+type apiAuthContextKey string
+
+// ctxAPIScopes is the context key of the scopes of the API key that allow the requested endpoint
+const ctxAPIScopes apiAuthContextKey = "apiScopes"
+
+// isAPIKey returns if the user of the token is an API key with scopes. API keys can only be used in the API_AUTH
+// endpoints, so they are refused by the agent, agent admin and key import endpoints
+func isAPIKey(user interfaces.UserData) bool {
+	su, ok := user.(interfaces.ScopedUserData)
+	return ok && len(su.GetScopes()) > 0
+}
+
+// apiScopePath is the scope required by the endpoints with the path prefix
+type apiScopePath struct {
+	prefix string
+	scope  string
+}
+
+// apiScopePaths are matched in order, so the specific endpoints come before their groups.
+// Paths in /gpg and /fieldCipher without a specific scope require the * scope
+var apiScopePaths = []apiScopePath{
+	{"/gpg/sign", models.APIScopeSign},
+	{"/gpg/signQuanto", models.APIScopeSign},
+	{"/gpg/decrypt", models.APIScopeDecrypt},
+	{"/fieldCipher/decipher", models.APIScopeDecrypt},
+	{"/gpg/encrypt", models.APIScopeEncrypt},
+	{"/fieldCipher/cipher", models.APIScopeEncrypt},
+	{"/gpg/verifySignature", models.APIScopeVerify},
+	{"/gpg/verifySignatureQuanto", models.APIScopeVerify},
+	{"/gpg/generateKey", models.APIScopeKeys},
+	{"/gpg/unlockKey", models.APIScopeKeys},
+	{"/keyRing/addPrivateKey", models.APIScopeKeys},
+	{"/keyRing/deletePrivateKey", models.APIScopeKeys},
+	{"/keyRing/versions", models.APIScopeKeys},
+	{"/keyRing", models.APIScopeKeyRing},
+	{"/gpg", models.APIScopeAll},
+	{"/fieldCipher", models.APIScopeAll},
+}
+
+// apiScopeForPath returns the scope required by the path and if it requires authentication
+func apiScopeForPath(path string) (string, bool) {
+	path = strings.TrimPrefix(path, "/remoteSigner")
+
+	for _, p := range apiScopePaths {
+		if hasPathPrefix(path, []string{p.prefix}) {
+			return p.scope, true
+		}
+	}
+
+	return "", false
+}
+
+// apiKeyFromRequest returns the API key of the Authorization bearer or X-API-Key header
+func apiKeyFromRequest(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	return r.Header.Get("X-API-Key")
+}
+
+// APIAuthMiddleware requires an API key of the token manager with a scope that allows the requested endpoint.
+// The scopes are stored in the request context to check the keys used by the endpoint
+func APIAuthMiddleware(log slog.Instance, tm interfaces.TokenManager) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			group, protected := apiScopeForPath(r.URL.Path)
+			if !protected {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := apiKeyFromRequest(r)
+			if token == "" || tm.Verify(token) != nil {
+				InitHTTPTimer(log, r)
+				PermissionDenied("Authorization", "A valid API key is required", w, r, log)
+				return
+			}
+
+			var scopes []string
+			user := tm.GetUserData(token)
+			if su, ok := user.(interfaces.ScopedUserData); ok {
+				scopes = su.GetScopes()
+			}
+
+			parsed, err := models.ParseAPIScopes(scopes)
+			if err != nil {
+				log.Error("The API key of %s has invalid scopes: %s", user.GetUsername(), err)
+			}
+
+			allowed := make([]models.APIScope, 0, len(parsed))
+			for _, scope := range parsed {
+				if scope.Allows(group) {
+					allowed = append(allowed, scope)
+				}
+			}
+
+			if len(allowed) == 0 {
+				InitHTTPTimer(log, r)
+				PermissionDenied("Authorization", fmt.Sprintf("The API key does not have the %s scope", group), w, r, log)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxAPIScopes, allowed)))
+		})
+	}
+}
+
+// keyAllowed returns if the client of the request can use the key, checking the identity of the client certificate
+// when TLS_CLIENT_CA_FILE is set and the API key scopes when API_AUTH is enabled
+func keyAllowed(r *http.Request, fingerPrint string) bool {
+	if config.TLSClientCAFile != "" {
+		identity, ok := r.Context().Value(ctxTLSIdentity).(models.TLSIdentity)
+		if !ok || !identity.CanUseKey(fingerPrint) {
+			return false
+		}
+	}
+
+	if config.APIAuth {
+		scopes, _ := r.Context().Value(ctxAPIScopes).([]models.APIScope)
+		for _, scope := range scopes {
+			if scope.AllowsKey(fingerPrint) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/agent"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+)
+
+func TestAPIAuthMiddleware(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.APIAuth = true
+	config.TLSClientCAFile = ""
+
+	tm := agent.MakeMemoryTokenManager(nil)
+
+	addKey := func(scopes ...string) string {
+		return tm.AddUserWithExpiration(&models.BasicUser{
+			Username:  "billing",
+			CreatedAt: time.Now(),
+			Scopes:    scopes,
+		}, 3600)
+	}
+
+	signer := addKey("sign:0551F452ABE463A4", "keyRing")
+	admin := addKey("*")
+	legacy := addKey()
+
+	r := mux.NewRouter()
+	r.Use(APIAuthMiddleware(log, tm))
+	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.URL.Query().Get("key"); key != "" && !keyAllowed(r, key) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	check := func(path, header, token string, expected int) {
+		req := httptest.NewRequest("POST", path, nil)
+		if token != "" {
+			req.Header.Set(header, token)
+		}
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("Expected status %d for %s got %d", expected, path, rr.Code)
+		}
+	}
+
+	// region Public and protected endpoints
+	check("/pks/lookup", "", "", http.StatusOK)
+	check("/gpg/sign", "", "", http.StatusBadRequest)
+	check("/gpg/sign", "Authorization", "Bearer huebr", http.StatusBadRequest)
+	check("/remoteSigner/keyRing/getKey", "X-API-Key", legacy, http.StatusBadRequest)
+	// endregion
+
+	// region Scopes
+	check("/gpg/sign?key=0551F452ABE463A4", "Authorization", "Bearer "+signer, http.StatusOK)
+	check("/remoteSigner/gpg/signQuanto?key=0551F452ABE463A4", "X-API-Key", signer, http.StatusOK)
+	check("/gpg/sign?key=FFFFFFFFFFFFFFFF", "Authorization", "Bearer "+signer, http.StatusForbidden)
+	check("/gpg/sign?key=ABE463A4", "Authorization", "Bearer "+signer, http.StatusForbidden)
+	check("/keyRing/getKey", "Authorization", "Bearer "+signer, http.StatusOK)
+	check("/keyRing/addPrivateKey", "Authorization", "Bearer "+signer, http.StatusBadRequest)
+	check("/gpg/decrypt", "Authorization", "Bearer "+signer, http.StatusBadRequest)
+	check("/fieldCipher/decipher?key=FFFFFFFFFFFFFFFF", "Authorization", "Bearer "+admin, http.StatusOK)
+	check("/keyRing/versions/rollback", "Authorization", "Bearer "+admin, http.StatusOK)
+	// endregion
+
+	// region Revoked key
+	_ = tm.InvalidateToken(signer)
+	check("/gpg/sign?key=0551F452ABE463A4", "Authorization", "Bearer "+signer, http.StatusBadRequest)
+	// endregion
+
+	// region Listed and revoked by ID
+	keys := tm.ListAPIKeys()
+	if len(keys) != 1 || keys[0].ID != models.APIKeyID(admin) || keys[0].ID == admin {
+		t.Fatalf("Expected only the admin API key without the token got %+v", keys)
+	}
+
+	if err := tm.RevokeAPIKey(keys[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	check("/fieldCipher/decipher", "Authorization", "Bearer "+admin, http.StatusBadRequest)
+
+	if err := tm.RevokeAPIKey(keys[0].ID); err == nil {
+		t.Errorf("Expected error revoking an unknown API key")
+	}
+	// endregion
+}
+
+func TestAPIKeyAsProxyToken(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.AgentBypassLogin = false
+
+	tm := agent.MakeMemoryTokenManager(nil)
+	apiKey := tm.AddUser(&models.BasicUser{Username: "admin", CreatedAt: time.Now(), Scopes: []string{"*"}})
+
+	r := mux.NewRouter()
+	MakeAgentProxy(nil, nil, tm).AddHandlers(r.PathPrefix("/agent").Subrouter())
+	MakeAgentAdmin(nil, tm, nil).AddHandlers(r.PathPrefix("/agentAdmin").Subrouter())
+	MakeKeyImportEndpoint(nil, nil, tm).AttachHandlers(r.PathPrefix("/keyImport").Subrouter())
+
+	for _, path := range []string{"/agent", "/agentAdmin", "/keyImport"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader("{}"))
+		req.Header.Set("proxyToken", apiKey)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s got %d", http.StatusBadRequest, path, rr.Code)
+		}
+	}
+}
+
+func TestParseAPIScope(t *testing.T) {
+	valid := []string{"*", "sign", "sign:0551f452abe463a4", "decrypt:0551F452ABE463A4", "keys"}
+	for _, scope := range valid {
+		if _, err := models.ParseAPIScope(scope); err != nil {
+			t.Errorf("Expected %q to be valid got %s", scope, err)
+		}
+	}
+
+	invalid := []string{"", "admin", "keys:0551F452ABE463A4", "sign:huebr", "sign:ABE463A4"}
+	for _, scope := range invalid {
+		if _, err := models.ParseAPIScope(scope); err == nil {
+			t.Errorf("Expected %q to be invalid", scope)
+		}
+	}
+}
@@ -63,7 +63,28 @@ func (ge *GPGEndpoint) decrypt(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	decrypted, err := ge.gpg.Decrypt(ctx, data.AsciiArmoredData, data.DataOnly)
+	// The key is picked from the unlocked recipients the client can use
+	fingerPrints, err := ge.gpg.GetDecryptionKeys(ctx, data.AsciiArmoredData, data.DataOnly)
+
+	if err != nil {
+		InvalidFieldData("Decryption", fmt.Sprintf("Error decrypting data: %s", err.Error()), w, r, log)
+		return
+	}
+
+	fingerPrint := ""
+	for _, fp := range fingerPrints {
+		if keyAllowed(r, fp) {
+			fingerPrint = fp
+			break
+		}
+	}
+
+	if fingerPrint == "" {
+		PermissionDenied("AsciiArmoredData", fmt.Sprintf("The client cannot decrypt with the keys %s", strings.Join(fingerPrints, ", ")), w, r, log)
+		return
+	}
+
+	decrypted, err := ge.gpg.DecryptWithKey(ctx, data.AsciiArmoredData, data.DataOnly, fingerPrint)
 
 	if err != nil {
 		InvalidFieldData("Decryption", fmt.Sprintf("Error decrypting data: %s", err.Error()), w, r, log)
@@ -224,6 +245,11 @@ func (ge *GPGEndpoint) sign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !keyAllowed(r, data.FingerPrint) {
+		PermissionDenied("FingerPrint", fmt.Sprintf("The client cannot sign with the key %s", data.FingerPrint), w, r, log)
+		return
+	}
+
 	signature, err := ge.gpg.SignData(ctx, data.FingerPrint, bytes, crypto.SHA512)
 
 	if err != nil {
@@ -260,6 +286,11 @@ func (ge *GPGEndpoint) signQuanto(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !keyAllowed(r, data.FingerPrint) {
+		PermissionDenied("FingerPrint", fmt.Sprintf("The client cannot sign with the key %s", data.FingerPrint), w, r, log)
+		return
+	}
+
 	signature, err := ge.gpg.SignData(ctx, data.FingerPrint, bytes, crypto.SHA512)
 
 	if err != nil {
@@ -3,9 +3,20 @@ package server
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/internal/models/HKP"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"html/template"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/quan-to/slog"
@@ -24,25 +35,237 @@ func operationGet(ctx context.Context, options, searchData string, machineReadab
 
 	results, err := keymagic.PKSSearch(searchData, 0, 1)
 
+	if err == nil && len(results) > 0 {
+		return results[0].AsciiArmoredPublicKey, nil
+	}
+
+	// Keys searched by email can be fetched from the upstream key servers
+	if strings.Contains(searchData, "@") {
+		k, _ := keymagic.PKSFetchKeyByEmail(ctx, searchData)
+		if k != "" {
+			return k, nil
+		}
+	}
+
 	if err != nil {
 		return "", nil
 	}
 
-	if len(results) > 0 {
-		return results[0].AsciiArmoredPublicKey, nil
+	return "", errors.New("not found")
+}
+
+// hkpIndexPageSize is the number of keys in each page of the human readable index
+const hkpIndexPageSize = 20
+
+var hexRegex = regexp.MustCompile("^[0-9A-Fa-f]+$")
+
+// hkpIndexKey is a key parsed for the index and vindex output
+type hkpIndexKey struct {
+	KeyID   string
+	Algo    packet.PublicKeyAlgorithm
+	Details models.KeyDetails
+	Expired bool
+	UIDs    []models.UserIDDetails
+}
+
+var hkpIndexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Search results for '{{.Search}}'</title></head>
+<body>
+<h1>Search results for '{{.Search}}'</h1>
+{{range .Keys}}<pre>
+<strong>pub</strong>  {{.Details.Algorithm}} {{.Details.Bits}}/<a href="?op=get&amp;search=0x{{.Details.FingerPrint}}">{{.KeyID}}</a> {{date .Details.CreationTime}}{{if .Details.Revoked}} <strong>[revoked]</strong>{{end}}{{if .Expired}} <strong>[expired]</strong>{{end}}
+{{range .UIDs}}<strong>uid</strong>  {{.UserID}}{{if .Revoked}} <strong>[revoked]</strong>{{end}}
+{{if $.Verbose}}{{range .Certifications}}     sig  {{.IssuerKeyID}} {{date .CreationTime}} {{.Type}}
+{{end}}{{end}}{{end}}</pre>
+{{else}}<p>No keys found</p>
+{{end}}<p>{{if .PreviousPage}}<a href="{{.PreviousPage}}">Previous</a> {{end}}{{if .NextPage}}<a href="{{.NextPage}}">Next</a>{{end}}</p>
+</body>
+</html>
+`))
+
+// hkpEscape escapes the colons, percents and non-printable characters of a machine readable index field
+func hkpEscape(s string) string {
+	var sb strings.Builder
+
+	for _, b := range []byte(s) {
+		if b == ':' || b == '%' || b < 0x20 || b >= 0x7F {
+			sb.WriteString(fmt.Sprintf("%%%02X", b))
+		} else {
+			sb.WriteByte(b)
+		}
 	}
 
-	return "", errors.New("not found")
+	return sb.String()
+}
+
+func hkpFlags(revoked, expired bool) string {
+	flags := ""
+	if revoked {
+		flags += "r"
+	}
+	if expired {
+		flags += "e"
+	}
+
+	return flags
+}
+
+// hkpSearch searches the PKS by key id or fingerprint if searchData starts with 0x, otherwise by name or email
+func hkpSearch(searchData string, exactMatch bool, pageStart, pageEnd int) ([]models.GPGKey, error) {
+	if strings.HasPrefix(searchData, "0x") {
+		fingerPrint := strings.ToUpper(searchData[2:])
+		if !hexRegex.MatchString(fingerPrint) {
+			return nil, errors.New("not found")
+		}
+		return keymagic.PKSSearchByFingerPrint(fingerPrint, pageStart, pageEnd)
+	}
+
+	if exactMatch {
+		if addr, err := mail.ParseAddress(searchData); err == nil {
+			// Name <email> searches are matched by the email
+			searchData = addr.Address
+		}
+		return keymagic.PKSSearch("(?i)^"+regexp.QuoteMeta(searchData)+"$", pageStart, pageEnd)
+	}
+
+	return keymagic.PKSSearch("(?i)"+regexp.QuoteMeta(searchData), pageStart, pageEnd)
 }
 
-func operationIndex(options, searchData string, machineReadable, noModification, showFingerPrint, exactMatch bool) (string, error) {
-	//hkpLog.Warn("Index(%s, %s, %v, %v, %v, %v) ==> Not Implemented", options, searchData, machineReadable, noModification, showFingerPrint, exactMatch)
-	return "", errors.New("not implemented")
+func parseHKPIndexKeys(keys []models.GPGKey, showFingerPrint bool) []hkpIndexKey {
+	now := time.Now()
+	indexKeys := make([]hkpIndexKey, 0, len(keys))
+
+	for _, key := range keys {
+		e, err := tools.ReadKeyToEntity(key.AsciiArmoredPublicKey)
+		if err != nil {
+			continue
+		}
+
+		d := tools.GetKeyDetails(e)
+		k := hkpIndexKey{
+			KeyID:   d.KeyID,
+			Algo:    e.PrimaryKey.PubKeyAlgo,
+			Details: d,
+			Expired: d.ExpirationTime != nil && d.ExpirationTime.Before(now),
+			UIDs:    d.UserIDs,
+		}
+
+		if showFingerPrint {
+			k.KeyID = d.FingerPrint
+		}
+
+		indexKeys = append(indexKeys, k)
+	}
+
+	return indexKeys
 }
 
-func operationVIndex(options, searchData string, machineReadable, noModification, showFingerPrint, exactMatch bool) (string, error) {
-	//hkpLog.Warn("VIndex(%s, %s, %v, %v, %v, %v) ==> Not Implemented", options, searchData, machineReadable, noModification, showFingerPrint, exactMatch)
-	return "", errors.New("not implemented")
+// machineReadableIndex returns the keys in the HKP machine readable index format. The format is the same for index and vindex
+func machineReadableIndex(keys []hkpIndexKey) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("info:1:%d\n", len(keys)))
+
+	for _, k := range keys {
+		expiration := ""
+		if k.Details.ExpirationTime != nil {
+			expiration = strconv.FormatInt(k.Details.ExpirationTime.Unix(), 10)
+		}
+
+		sb.WriteString(fmt.Sprintf("pub:%s:%d:%d:%d:%s:%s\n", k.KeyID, k.Algo, k.Details.Bits, k.Details.CreationTime.Unix(), expiration, hkpFlags(k.Details.Revoked, k.Expired)))
+
+		for _, uid := range k.UIDs {
+			sb.WriteString(fmt.Sprintf("uid:%s:::%s\n", hkpEscape(uid.UserID), hkpFlags(uid.Revoked, false)))
+		}
+	}
+
+	return sb.String()
+}
+
+func hkpPageLink(searchData, op string, showFingerPrint, exactMatch bool, page int) string {
+	q := url.Values{}
+	q.Set("op", op)
+	q.Set("search", searchData)
+	q.Set("page", strconv.Itoa(page))
+
+	if showFingerPrint {
+		q.Set("fingerprint", "on")
+	}
+
+	if exactMatch {
+		q.Set("exact", "on")
+	}
+
+	return "?" + q.Encode()
+}
+
+// index runs the index or vindex operation. Machine readable output has up to DefaultPageEnd keys, the human readable is paginated
+func index(op, searchData string, machineReadable, showFingerPrint, exactMatch bool, page int) (string, error) {
+	if searchData == "" {
+		return "", errors.New("not found")
+	}
+
+	verbose := op == HKP.OperationVindex
+	pageStart := models.DefaultPageStart
+	pageEnd := models.DefaultPageEnd
+
+	if !machineReadable {
+		if page < 1 {
+			page = 1
+		}
+		pageStart = (page - 1) * hkpIndexPageSize
+		pageEnd = pageStart + hkpIndexPageSize + 1 // One more to know if there is a next page
+	}
+
+	results, err := hkpSearch(searchData, exactMatch, pageStart, pageEnd)
+	if err != nil {
+		return "", err
+	}
+
+	if machineReadable {
+		if len(results) == 0 {
+			return "", errors.New("not found")
+		}
+
+		return machineReadableIndex(parseHKPIndexKeys(results, showFingerPrint)), nil
+	}
+
+	hasNext := len(results) > hkpIndexPageSize
+	if hasNext {
+		results = results[:hkpIndexPageSize]
+	}
+
+	data := map[string]interface{}{
+		"Search":  searchData,
+		"Verbose": verbose,
+		"Keys":    parseHKPIndexKeys(results, showFingerPrint),
+	}
+
+	if page > 1 {
+		data["PreviousPage"] = hkpPageLink(searchData, op, showFingerPrint, exactMatch, page-1)
+	}
+
+	if hasNext {
+		data["NextPage"] = hkpPageLink(searchData, op, showFingerPrint, exactMatch, page+1)
+	}
+
+	var sb strings.Builder
+	err = hkpIndexTemplate.Execute(&sb, data)
+
+	return sb.String(), err
+}
+
+func operationIndex(options, searchData string, machineReadable, noModification, showFingerPrint, exactMatch bool, page int) (string, error) {
+	return index(HKP.OperationIndex, searchData, machineReadable, showFingerPrint, exactMatch, page)
+}
+
+func operationVIndex(options, searchData string, machineReadable, noModification, showFingerPrint, exactMatch bool, page int) (string, error) {
+	return index(HKP.OperationVindex, searchData, machineReadable, showFingerPrint, exactMatch, page)
 }
 
 func hkpLookup(log slog.Instance, w http.ResponseWriter, r *http.Request) {
@@ -56,8 +279,18 @@ func hkpLookup(log slog.Instance, w http.ResponseWriter, r *http.Request) {
 	mr := q.Get("mr") == "true" || q.Get("mr") == "1"
 	nm := q.Get("nm") == "true" || q.Get("nm") == "1"
 	fingerPrint := q.Get("fingerprint") == "on"
-	exact := q.Get("exact") != ""
+	exact := q.Get("exact") == "on" || q.Get("exact") == "true" || q.Get("exact") == "1"
 	search := q.Get("search")
+	page, _ := strconv.Atoi(q.Get("page"))
+
+	for _, option := range strings.Split(options, ",") {
+		switch option {
+		case "mr":
+			mr = true
+		case "nm":
+			nm = true
+		}
+	}
 
 	result := ""
 	var err error
@@ -80,7 +313,7 @@ func hkpLookup(log slog.Instance, w http.ResponseWriter, r *http.Request) {
 			"fingerPrint": fingerPrint,
 			"exact":       exact,
 		}).Await("Running operation Index")
-		result, err = operationIndex(options, search, mr, nm, fingerPrint, exact)
+		result, err = operationIndex(options, search, mr, nm, fingerPrint, exact, page)
 	case HKP.OperationVindex:
 		log.WithFields(map[string]interface{}{
 			"options":     options,
@@ -90,7 +323,7 @@ func hkpLookup(log slog.Instance, w http.ResponseWriter, r *http.Request) {
 			"fingerPrint": fingerPrint,
 			"exact":       exact,
 		}).Await("Running operation Vindex")
-		result, err = operationVIndex(options, search, mr, nm, fingerPrint, exact)
+		result, err = operationVIndex(options, search, mr, nm, fingerPrint, exact, page)
 	}
 
 	log.Done("Finished operation")
@@ -114,6 +347,14 @@ func hkpLookup(log slog.Instance, w http.ResponseWriter, r *http.Request) {
 		panic("Unknown operation")
 	}
 
+	if op == HKP.OperationIndex || op == HKP.OperationVindex {
+		if mr {
+			w.Header().Set("Content-Type", models.MimeText)
+		} else {
+			w.Header().Set("Content-Type", models.MimeHTML)
+		}
+	}
+
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(result))
 	LogExit(log, r, http.StatusOK, len(result))
@@ -124,6 +365,14 @@ func hkpAdd(log slog.Instance, w http.ResponseWriter, r *http.Request) {
 	log = wrapLogWithRequestID(log.SubScope("HKP"), r)
 
 	InitHTTPTimer(log, r)
+
+	if !keyUploadThrottle.Allow(r) {
+		TooManyRequests("Too many keys uploaded. Please try again later", w, r, log)
+		return
+	}
+
+	limitKeyUploadBody(w, r)
+
 	log.Await("Parsing Form Fields")
 	err := r.ParseForm()
 	log.Done("Parsed")
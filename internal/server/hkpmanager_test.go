@@ -6,6 +6,7 @@ import (
 	"fmt"
 	config "github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/internal/models/HKP"
 	"github.com/quan-to/chevron/internal/tools"
 	"github.com/quan-to/chevron/pkg/QuantoError"
@@ -13,6 +14,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -146,29 +148,66 @@ func TestLookup(t *testing.T) {
 	// TODO: Extended tests when full implementation of lookup is made
 	// endregion
 	// region Operation VIndex
-	output, errObj, err = MakeHKPLookup(HKP.OperationVindex, "", "", "", "", "")
+	output, errObj, err = MakeHKPLookup(HKP.OperationVindex, "true", "", "on", "", "0x"+test.TestKeyFingerprint)
 	errorDie(err, t)
 
-	if errObj == nil {
-		fmt.Printf("Output: %s\n", output)
-		errorDie(fmt.Errorf("expected error object return, got nil"), t)
+	if errObj != nil {
+		errorDie(fmt.Errorf("expected error object to be nil got %v", errObj), t)
+	}
+
+	if !strings.HasPrefix(output, "info:1:1\n") || !strings.Contains(output, "uid:"+test.TestKeyName) {
+		errorDie(fmt.Errorf("expected machine readable index of %s got %s", test.TestKeyFingerprint, output), t)
+	}
+
+	output, errObj, err = MakeHKPLookup(HKP.OperationVindex, "", "", "", "", test.TestKeyEmail)
+	errorDie(err, t)
+
+	if errObj != nil {
+		errorDie(fmt.Errorf("expected error object to be nil got %v", errObj), t)
 	}
 
-	if errObj.ErrorCode != QuantoError.NotImplemented { // TODO FIX-ME when implemented
-		errorDie(fmt.Errorf("expected error code %s got %s", QuantoError.NotImplemented, errObj.ErrorCode), t)
+	if !strings.Contains(output, "<html>") || !strings.Contains(output, test.TestKeyFingerprint) {
+		errorDie(fmt.Errorf("expected html index of %s got %s", test.TestKeyFingerprint, output), t)
 	}
 	// endregion
 	// region Operation Index
-	output, errObj, err = MakeHKPLookup(HKP.OperationIndex, "", "", "", "", "")
+	output, errObj, err = MakeHKPLookup(HKP.OperationIndex, "true", "", "", "on", test.TestKeyEmail)
 	errorDie(err, t)
 
-	if errObj == nil {
-		fmt.Printf("Output: %s\n", output)
-		errorDie(fmt.Errorf("expected error object return, got nil"), t)
+	if errObj != nil {
+		errorDie(fmt.Errorf("expected error object to be nil got %v", errObj), t)
 	}
 
-	if errObj.ErrorCode != QuantoError.NotImplemented { // TODO FIX-ME when implemented
-		errorDie(fmt.Errorf("expected error code %s got %s", QuantoError.NotImplemented, errObj.ErrorCode), t)
+	if !strings.Contains(output, "pub:"+test.TestKeyFingerprint+":") {
+		errorDie(fmt.Errorf("expected exact match of %s got %s", test.TestKeyEmail, output), t)
+	}
+
+	_, errObj, err = MakeHKPLookup(HKP.OperationIndex, "true", "", "", "on", "jon@huebr")
+	errorDie(err, t)
+
+	if errObj == nil || errObj.ErrorCode != QuantoError.NotFound {
+		errorDie(fmt.Errorf("expected error code %s got %v", QuantoError.NotFound, errObj), t)
 	}
 	// endregion
 }
+
+func TestHKPMachineReadableIndex(t *testing.T) {
+	e, err := tools.ReadKeyToEntity(test.TestPublicKey)
+	errorDie(err, t)
+
+	d := tools.GetKeyDetails(e)
+	output := machineReadableIndex([]hkpIndexKey{{
+		KeyID:   d.FingerPrint,
+		Algo:    e.PrimaryKey.PubKeyAlgo,
+		Details: d,
+		UIDs: []models.UserIDDetails{
+			{UserID: "Jon: 100% <jon@huebr.com>", Revoked: true},
+		},
+	}})
+
+	expected := fmt.Sprintf("info:1:1\npub:%s:1:%d:%d::\nuid:Jon%%3A 100%%25 <jon@huebr.com>:::r\n", d.FingerPrint, d.Bits, d.CreationTime.Unix())
+
+	if output != expected {
+		errorDie(fmt.Errorf("expected %q got %q", expected, output), t)
+	}
+}
@@ -113,6 +113,11 @@ func InternalServerError(message string, data interface{}, w http.ResponseWriter
 	WriteJSON(QuantoError.New(QuantoError.InternalServerError, "server", message, data), 500, w, r, logI)
 }
 
+// TooManyRequests helper method to return an operation limit exceeded error to http client
+func TooManyRequests(message string, w http.ResponseWriter, r *http.Request, logI slog.Instance) {
+	WriteJSON(QuantoError.New(QuantoError.OperationLimitExceeded, "server", message, nil), http.StatusTooManyRequests, w, r, logI)
+}
+
 // LogExit does the logging of the exit call inside a HTTP Request
 func LogExit(slog slog.Instance, r *http.Request, statusCode int, bodyLength int) {
 	hts := r.Header.Get(httpInternalTimestamp)
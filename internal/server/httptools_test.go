@@ -47,6 +47,15 @@ func TestWriteJSON(test *testing.T) {
 			500,
 			"{\"errorCode\":\"INTERNAL_SERVER_ERROR\",\"errorField\":\"server\",\"message\":\"There was an internal server error.\",\"errorData\":\"Unexpected Error\",\"stackTrace\":\"\"}",
 		},
+		{
+			"Test Too Many Requests Error Response",
+			func(w http.ResponseWriter) {
+				QuantoError.DisableStackTrace()
+				TooManyRequests("slow down", w, req, log)
+			},
+			429,
+			"{\"errorCode\":\"OPERATION_LIMIT_EXCEEDED\",\"errorField\":\"server\",\"message\":\"slow down\",\"errorData\":null,\"stackTrace\":\"\"}",
+		},
 		{
 			"Test Not Implemented Error Response",
 			func(w http.ResponseWriter) {
@@ -2,8 +2,12 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/internal/keymagic"
 	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/pkg/interfaces"
+	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -13,9 +17,13 @@ import (
 type InternalEndpoint struct {
 	sm  interfaces.SecretsManager
 	gpg interfaces.PGPManager
+	ca  *keymagic.ClusterAuth
 	log slog.Instance
 }
 
+// maxInternalBodySize is the maximum size of the encrypted passwords posted by a peer
+const maxInternalBodySize = 16 * 1024 * 1024
+
 // MakeInternalEndpoint creates an instance to handle internal control endpoints such as key password data
 func MakeInternalEndpoint(log slog.Instance, sm interfaces.SecretsManager, gpg interfaces.PGPManager) *InternalEndpoint {
 	if log == nil {
@@ -27,10 +35,34 @@ func MakeInternalEndpoint(log slog.Instance, sm interfaces.SecretsManager, gpg i
 	return &InternalEndpoint{
 		sm:  sm,
 		gpg: gpg,
+		ca:  keymagic.MakeClusterAuth(log, sm, gpg),
 		log: log,
 	}
 }
 
+// verifyPeer reads the body of the request and checks that it was signed by a cluster peer. Writes the error if not
+func (ie *InternalEndpoint) verifyPeer(w http.ResponseWriter, r *http.Request, log slog.Instance) ([]byte, bool) {
+	var body []byte
+	var err error
+
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxInternalBodySize))
+		if err != nil {
+			InvalidFieldData("body", err.Error(), w, r, log)
+			return nil, false
+		}
+	}
+
+	_, err = ie.ca.VerifyRequest(wrapContextWithRequestID(r), r, body)
+	if err != nil {
+		log.Warn("Refused %s from %s: %s", r.URL.Path, remoteIP(r), err)
+		PermissionDenied("signature", fmt.Sprintf("Only the cluster peers can use this endpoint: %s", err), w, r, log)
+		return nil, false
+	}
+
+	return body, true
+}
+
 func (ie *InternalEndpoint) AttachHandlers(r *mux.Router) {
 	r.HandleFunc("/__triggerKeyUnlock", ie.triggerKeyUnlock)
 	r.HandleFunc("/__getUnlockPasswords", ie.getUnlockPasswords).Methods("GET")
@@ -48,6 +80,10 @@ func (ie *InternalEndpoint) triggerKeyUnlock(w http.ResponseWriter, r *http.Requ
 		}
 	}()
 
+	if _, ok := ie.verifyPeer(w, r, log); !ok {
+		return
+	}
+
 	ie.sm.UnlockLocalKeys(ctx, ie.gpg)
 
 	w.Header().Set("Content-Type", models.MimeText)
@@ -67,10 +103,20 @@ func (ie *InternalEndpoint) getUnlockPasswords(w http.ResponseWriter, r *http.Re
 		}
 	}()
 
+	if _, ok := ie.verifyPeer(w, r, log); !ok {
+		return
+	}
+
 	passwords := ie.sm.GetPasswords(ctx)
 
 	bodyData, _ := json.Marshal(passwords)
 
+	// The peer only posts the passwords of a response signed by the cluster keys
+	if err := ie.ca.SignResponse(ctx, w, r, bodyData); err != nil {
+		InternalServerError("There was an error signing the response", err.Error(), w, r, log)
+		return
+	}
+
 	w.Header().Set("Content-Type", models.MimeJSON)
 	w.WriteHeader(200)
 	n, _ := w.Write(bodyData)
@@ -82,18 +128,24 @@ func (ie *InternalEndpoint) postUnlockPasswords(w http.ResponseWriter, r *http.R
 	log := wrapLogWithRequestID(ie.log, r)
 	InitHTTPTimer(log, r)
 
-	var passwords map[string]string
-
-	if !UnmarshalBodyOrDie(&passwords, w, r, log) {
-		return
-	}
-
 	defer func() {
 		if rec := recover(); rec != nil {
 			CatchAllError(rec, w, r, log)
 		}
 	}()
 
+	body, ok := ie.verifyPeer(w, r, log)
+	if !ok {
+		return
+	}
+
+	var passwords map[string]string
+
+	if err := json.Unmarshal(body, &passwords); err != nil {
+		InvalidFieldData("body", err.Error(), w, r, log)
+		return
+	}
+
 	for k, v := range passwords {
 		ie.sm.PutEncryptedPassword(ctx, k, v)
 	}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	remote_signer "github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keymagic"
 	"github.com/quan-to/chevron/pkg/QuantoError"
 	"github.com/quan-to/chevron/test"
 	"io/ioutil"
@@ -44,6 +45,12 @@ func TestUnlockLocalKeys(t *testing.T) {
 }
 */
 
+func signClusterRequest(req *http.Request, body []byte, t *testing.T) {
+	req.RemoteAddr = "127.0.0.1:4321"
+	err := keymagic.MakeClusterAuth(nil, sm, gpg).SignRequest(context.Background(), req, body)
+	errorDie(err, t)
+}
+
 func TestGetUnlockPasswords(t *testing.T) {
 	ctx := context.Background()
 	filename := fmt.Sprintf("key-password-utf8-%s.txt", test.TestKeyFingerprint)
@@ -60,6 +67,7 @@ func TestGetUnlockPasswords(t *testing.T) {
 	req, err := http.NewRequest("GET", "/__internal/__getUnlockPasswords", nil)
 
 	errorDie(err, t)
+	signClusterRequest(req, nil, t)
 
 	res := executeRequest(req)
 
@@ -119,6 +127,7 @@ func TestPostUnlockPassword(t *testing.T) {
 	req, err := http.NewRequest("POST", "/__internal/__postEncryptedPasswords", r)
 
 	errorDie(err, t)
+	signClusterRequest(req, d, t)
 
 	res := executeRequest(req)
 
@@ -147,6 +156,7 @@ func TestTriggerKeyUnlock(t *testing.T) {
 	req, err := http.NewRequest("POST", "/__internal/__triggerKeyUnlock", nil)
 
 	errorDie(err, t)
+	signClusterRequest(req, nil, t)
 
 	res := executeRequest(req)
 
@@ -167,3 +177,42 @@ func TestTriggerKeyUnlock(t *testing.T) {
 
 	// TODO: Check if the key was really unlocked
 }
+
+func TestUnsignedClusterRequest(t *testing.T) {
+	// region Unsigned
+	req, err := http.NewRequest("GET", "/__internal/__getUnlockPasswords", nil)
+	errorDie(err, t)
+
+	res := executeRequest(req)
+
+	errObj, err := ReadErrorObject(res.Body)
+	errorDie(err, t)
+
+	if errObj.ErrorCode != QuantoError.PermissionDenied {
+		t.Errorf("Expected %s for the unsigned request got %s", QuantoError.PermissionDenied, errObj.ErrorCode)
+	}
+	// endregion
+
+	// region Replayed
+	d, _ := json.Marshal(map[string]string{"0000000000000000": "injected"})
+
+	req, err = http.NewRequest("POST", "/__internal/__postEncryptedPasswords", bytes.NewReader(d))
+	errorDie(err, t)
+	signClusterRequest(req, d, t)
+
+	res = executeRequest(req)
+	if res.Code != 200 {
+		t.Fatalf("Expected the signed request to be accepted got %d", res.Code)
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(d))
+	res = executeRequest(req)
+
+	errObj, err = ReadErrorObject(res.Body)
+	errorDie(err, t)
+
+	if errObj.ErrorCode != QuantoError.PermissionDenied {
+		t.Errorf("Expected %s for the replayed request got %s", QuantoError.PermissionDenied, errObj.ErrorCode)
+	}
+	// endregion
+}
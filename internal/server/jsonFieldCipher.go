@@ -106,6 +106,11 @@ func (jfc *JFCEndpoint) decipher(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	if !keyAllowed(r, data.KeyFingerprint) {
+		PermissionDenied("keyFingerprint", fmt.Sprintf("The client cannot decipher with the key %s", data.KeyFingerprint), w, r, log)
+		return
+	}
+
 	keys := jfc.gpg.GetPrivate(ctx, data.KeyFingerprint)
 	if len(keys) == 0 {
 		NotFound("keyFingerprint", fmt.Sprintf("There is no such key %s or its not decrypted.", data.KeyFingerprint), w, r, log)
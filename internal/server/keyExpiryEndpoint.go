@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"net/http"
+	"strings"
+)
+
+type KeyExpiryEndpoint struct {
+	monitor    *keymagic.KeyExpiryMonitor
+	monitorErr error
+	log        slog.Instance
+}
+
+// MakeKeyExpiryEndpoint creates an instance of the key expiration status endpoints using the shared KeyExpiryMonitor of gpg.
+// An invalid monitor configuration is returned by the endpoints instead of stopping the server
+func MakeKeyExpiryEndpoint(log slog.Instance, gpg interfaces.PGPManager) *KeyExpiryEndpoint {
+	if log == nil {
+		log = slog.Scope("KeyExpiryEndpoint")
+	} else {
+		log = log.SubScope("KeyExpiryEndpoint")
+	}
+
+	monitor, err := keymagic.GetKeyExpiryMonitor(log, gpg)
+	if err != nil {
+		log.Error("Key expiry endpoints are disabled: %s", err)
+	}
+
+	return &KeyExpiryEndpoint{
+		monitor:    monitor,
+		monitorErr: err,
+		log:        log,
+	}
+}
+
+func (kee *KeyExpiryEndpoint) AttachHandlers(r *mux.Router) {
+	r.HandleFunc("", kee.getStatus).Methods("GET")
+	r.HandleFunc("/", kee.getStatus).Methods("GET")
+	r.HandleFunc("/metrics", kee.getMetrics).Methods("GET")
+}
+
+func (kee *KeyExpiryEndpoint) getStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(kee.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if kee.monitorErr != nil {
+		InternalServerError("The key expiry monitor is not configured correctly", kee.monitorErr.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(kee.monitor.Scan(ctx), 200, w, r, log)
+}
+
+// getMetrics returns the key expiration status in Prometheus text format
+func (kee *KeyExpiryEndpoint) getMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(kee.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if kee.monitorErr != nil {
+		InternalServerError("The key expiry monitor is not configured correctly", kee.monitorErr.Error(), w, r, log)
+		return
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("# HELP chevron_key_expiration_timestamp_seconds Expiration time of the key or subkey that expires first\n")
+	sb.WriteString("# TYPE chevron_key_expiration_timestamp_seconds gauge\n")
+
+	statuses := kee.monitor.Scan(ctx)
+
+	for _, s := range statuses {
+		if s.ExpirationTime != nil {
+			sb.WriteString(fmt.Sprintf("chevron_key_expiration_timestamp_seconds{fingerprint=%q,keyid=%q,source=%q} %d\n", s.FingerPrint, s.KeyID, s.Source, s.ExpirationTime.Unix()))
+		}
+	}
+
+	sb.WriteString("# HELP chevron_key_expired If the key is expired\n")
+	sb.WriteString("# TYPE chevron_key_expired gauge\n")
+
+	for _, s := range statuses {
+		expired := 0
+		if s.Expired {
+			expired = 1
+		}
+		sb.WriteString(fmt.Sprintf("chevron_key_expired{fingerprint=%q,source=%q} %d\n", s.FingerPrint, s.Source, expired))
+	}
+
+	sb.WriteString("# HELP chevron_key_expiry_threshold_days Smallest expiration alert threshold reached by the key, 0 if none\n")
+	sb.WriteString("# TYPE chevron_key_expiry_threshold_days gauge\n")
+
+	for _, s := range statuses {
+		sb.WriteString(fmt.Sprintf("chevron_key_expiry_threshold_days{fingerprint=%q,source=%q} %d\n", s.FingerPrint, s.Source, s.Threshold))
+	}
+
+	w.Header().Set("Content-Type", models.MimeText)
+	w.WriteHeader(200)
+	n, _ := w.Write([]byte(sb.String()))
+	LogExit(log, r, 200, n)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/QuantoError"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestKeyExpiryStatus(t *testing.T) {
+	// region Test Key Expiry Status
+	req, err := http.NewRequest("GET", "/keyExpiry", nil)
+	errorDie(err, t)
+
+	res := executeRequest(req)
+
+	d, err := ioutil.ReadAll(res.Body)
+	errorDie(err, t)
+
+	if res.Code != 200 {
+		var errObj QuantoError.ErrorObject
+		err := json.Unmarshal(d, &errObj)
+		errorDie(err, t)
+		errorDie(fmt.Errorf(errObj.Message), t)
+	}
+
+	var statuses []models.KeyExpiryStatus
+	err = json.Unmarshal(d, &statuses)
+	errorDie(err, t)
+
+	if len(statuses) == 0 {
+		errorDie(fmt.Errorf("expected the loaded private keys to be reported"), t)
+	}
+
+	for _, s := range statuses {
+		if s.Source != models.KeyExpirySourceLoaded || s.Error != "" {
+			errorDie(fmt.Errorf("expected only loaded keys without errors got %+v", s), t)
+		}
+	}
+	// endregion
+	// region Test Key Expiry Metrics
+	req, err = http.NewRequest("GET", "/keyExpiry/metrics", nil)
+	errorDie(err, t)
+
+	res = executeRequest(req)
+
+	d, err = ioutil.ReadAll(res.Body)
+	errorDie(err, t)
+
+	if res.Code != 200 || !strings.Contains(string(d), fmt.Sprintf("chevron_key_expired{fingerprint=%q", statuses[0].FingerPrint)) {
+		errorDie(fmt.Errorf("expected metrics of key %s got %s", statuses[0].FingerPrint, string(d)), t)
+	}
+	// endregion
+}
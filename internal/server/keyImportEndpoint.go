@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"net/http"
+	"strconv"
+)
+
+type KeyImportEndpoint struct {
+	gpg interfaces.PGPManager
+	tm  interfaces.TokenManager
+	log slog.Instance
+}
+
+// MakeKeyImportEndpoint creates an instance of the keyring dump import endpoint
+func MakeKeyImportEndpoint(log slog.Instance, gpg interfaces.PGPManager, tm interfaces.TokenManager) *KeyImportEndpoint {
+	if log == nil {
+		log = slog.Scope("KeyImport")
+	} else {
+		log = log.SubScope("KeyImport")
+	}
+
+	return &KeyImportEndpoint{
+		gpg: gpg,
+		tm:  tm,
+		log: log,
+	}
+}
+
+func (kie *KeyImportEndpoint) AttachHandlers(r *mux.Router) {
+	r.HandleFunc("", kie.importKeys).Methods("POST")
+	r.HandleFunc("/", kie.importKeys).Methods("POST")
+}
+
+// importKeys imports the binary or armored keyring dump in the body, streaming the progress as JSON lines.
+// Requires the proxyToken of the admin user, so the imported user IDs are verified
+func (kie *KeyImportEndpoint) importKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(kie.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	token := r.Header.Get("proxyToken")
+	if token == "" || kie.tm.Verify(token) != nil {
+		PermissionDenied("proxyToken", "Please check if your proxyToken is valid", w, r, log)
+		return
+	}
+
+	if user := kie.tm.GetUserData(token); user == nil || isAPIKey(user) || user.GetUsername() != "admin" {
+		PermissionDenied("proxyToken", "Only the admin can import keys", w, r, log)
+		return
+	}
+
+	q := r.URL.Query()
+	options := models.KeyImportOptions{
+		PKS:             q.Get("pks") != "false",
+		KeyRing:         q.Get("keyRing") == "true",
+		VerifiedUserIDs: true,
+	}
+
+	if v := q.Get("batchSize"); v != "" {
+		batchSize, err := strconv.Atoi(v)
+		if err != nil || batchSize <= 0 {
+			InvalidFieldData("batchSize", "The batch size should be a positive number", w, r, log)
+			return
+		}
+		options.BatchSize = batchSize
+	}
+
+	log.Info("Importing keyring dump for %s", kie.tm.GetUserData(token).GetUsername())
+
+	written := 0
+	started := false
+	flusher, _ := w.(http.Flusher)
+
+	writeLine := func(result models.KeyImportResult) {
+		if !started {
+			w.Header().Set("Content-Type", models.MimeNDJSON)
+			w.WriteHeader(200)
+			started = true
+		}
+
+		data, _ := json.Marshal(result)
+		n, _ := w.Write(append(data, '\n'))
+		written += n
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	importer := keymagic.MakeKeyImporter(log, kie.gpg, options)
+	result, err := importer.Import(ctx, r.Body, func(progress models.KeyImportResult) {
+		// The errors are only sent in the final result
+		progress.Errors = nil
+		writeLine(progress)
+	})
+
+	if err != nil && !started && result.Read == 0 {
+		InvalidFieldData("body", err.Error(), w, r, log)
+		return
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	writeLine(result)
+	LogExit(log, r, 200, written)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/agent"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func adminToken(t *testing.T) string {
+	payload := map[string]interface{}{
+		"query": "mutation Login($username: String!, $password: String!) { Login(username: $username, password: $password) { Value }}",
+		"variables": map[string]interface{}{
+			"username": "admin",
+			"password": "admin",
+		},
+		"operationName": "Login",
+		"_timestamp":    time.Now().Nanosecond() / 1000,
+		"_timeUniqueId": fmt.Sprintf("%v-agent", time.Now().Nanosecond()/1000),
+	}
+
+	d, _ := json.Marshal(payload)
+	req, err := http.NewRequest("POST", "/agentAdmin", bytes.NewReader(d))
+	errorDie(err, t)
+
+	res := executeRequest(req)
+	if res.Code != 200 {
+		errorDie(fmt.Errorf("expected status 200 from login got %d: %s", res.Code, res.Body.String()), t)
+	}
+
+	var data struct {
+		Data struct {
+			Login struct {
+				Value string
+			}
+		}
+	}
+
+	errorDie(json.NewDecoder(res.Body).Decode(&data), t)
+
+	return data.Data.Login.Value
+}
+
+func TestKeyImport(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.EnableRethinkSKS = true
+
+	dump := bytes.NewBuffer(nil)
+	for _, name := range []string{"Bulk One", "Bulk Two", "Bulk Three"} {
+		e, err := openpgp.NewEntity(name, "", "bulk@huebr.com", &packet.Config{RSABits: 1024})
+		errorDie(err, t)
+		errorDie(e.Serialize(dump), t)
+	}
+
+	// region Test Permission
+	req, err := http.NewRequest("POST", "/keyImport", bytes.NewReader(dump.Bytes()))
+	errorDie(err, t)
+
+	if res := executeRequest(req); res.Code != 400 {
+		errorDie(fmt.Errorf("expected status 400 without proxyToken got %d", res.Code), t)
+	}
+	// endregion
+
+	// region Test Import
+	token := adminToken(t)
+
+	importDump := func() models.KeyImportResult {
+		req, err := http.NewRequest("POST", "/keyImport?batchSize=2", bytes.NewReader(dump.Bytes()))
+		errorDie(err, t)
+		req.Header.Set("proxyToken", token)
+
+		res := executeRequest(req)
+		if res.Code != 200 {
+			errorDie(fmt.Errorf("expected status 200 got %d: %s", res.Code, res.Body.String()), t)
+		}
+
+		var last models.KeyImportResult
+		lines := 0
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			errorDie(json.Unmarshal(scanner.Bytes(), &last), t)
+			lines++
+		}
+
+		// 2 batches and the final result
+		if lines != 3 || !last.Done {
+			errorDie(fmt.Errorf("expected 3 lines ending with the final result got %d %+v", lines, last), t)
+		}
+
+		return last
+	}
+
+	result := importDump()
+	if result.Read != 3 || result.Added != 3 || result.Failed != 0 {
+		errorDie(fmt.Errorf("expected 3 keys added got %+v", result), t)
+	}
+
+	// The keys are merged with the stored ones
+	result = importDump()
+	if result.Read != 3 || result.Unchanged != 3 {
+		errorDie(fmt.Errorf("expected 3 unchanged keys got %+v", result), t)
+	}
+
+	if keys := searchSKSByEmail("bulk@huebr.com", t); len(keys) != 3 {
+		errorDie(fmt.Errorf("expected 3 imported keys in the PKS got %d", len(keys)), t)
+	}
+	// endregion
+}
+
+func TestKeyImportPermission(t *testing.T) {
+	tm := agent.MakeMemoryTokenManager(nil)
+	user := tm.AddUser(&models.BasicUser{Username: "billing", CreatedAt: time.Now()})
+
+	r := mux.NewRouter()
+	MakeKeyImportEndpoint(nil, nil, tm).AttachHandlers(r.PathPrefix("/keyImport").Subrouter())
+
+	for _, token := range []string{"", "huebr", user} {
+		req := httptest.NewRequest("POST", "/keyImport", bytes.NewReader([]byte("dump")))
+		if token != "" {
+			req.Header.Set("proxyToken", token)
+		}
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for token %q got %d", http.StatusBadRequest, token, rr.Code)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/quan-to/chevron/internal/server/pages"
 	"github.com/quan-to/chevron/internal/tools"
 	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/chevron/pkg/openpgp"
 	"github.com/quan-to/slog"
 	"net/http"
 )
@@ -36,6 +37,9 @@ func MakeKeyRingEndpoint(log slog.Instance, sm interfaces.SecretsManager, gpg in
 
 func (kre *KeyRingEndpoint) AttachHandlers(r *mux.Router) {
 	r.HandleFunc("/getKey", kre.getKey).Methods("GET")
+	r.HandleFunc("/keyDetails", kre.getKeyDetails).Methods("GET")
+	r.HandleFunc("/lint", kre.lintLoadedKey).Methods("GET")
+	r.HandleFunc("/lint", kre.lintKey).Methods("POST")
 	r.HandleFunc("/cachedKeys", kre.getCachedKeys).Methods("GET")
 	r.HandleFunc("/privateKeys", kre.getLoadedPrivateKeys).Methods("GET")
 	r.HandleFunc("/addPrivateKey", kre.addPrivateKey).Methods("POST")
@@ -71,6 +75,89 @@ func (kre *KeyRingEndpoint) getKey(w http.ResponseWriter, r *http.Request) {
 	LogExit(log, r, 200, n)
 }
 
+func (kre *KeyRingEndpoint) getKeyDetails(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(kre.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	fingerPrint := r.URL.Query().Get("fingerPrint")
+
+	ent := kre.gpg.GetPublicKeyEntity(ctx, fingerPrint)
+
+	if ent == nil {
+		NotFound("fingerPrint", fmt.Sprintf("Key with fingerPrint %s was not found", fingerPrint), w, r, log)
+		return
+	}
+
+	details := tools.GetKeyDetails(ent)
+
+	if details.ContainsPrivateKey {
+		details.PrivateKeyIsDecrypted = !kre.gpg.IsKeyLocked(details.KeyID)
+	}
+
+	WriteJSON(details, 200, w, r, log)
+}
+
+func (kre *KeyRingEndpoint) lintLoadedKey(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(kre.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	fingerPrint := r.URL.Query().Get("fingerPrint")
+
+	ent := kre.gpg.GetPublicKeyEntity(ctx, fingerPrint)
+
+	if ent == nil {
+		NotFound("fingerPrint", fmt.Sprintf("Key with fingerPrint %s was not found", fingerPrint), w, r, log)
+		return
+	}
+
+	results, err := keymagic.LintEntities(openpgp.EntityList{ent})
+	if err != nil {
+		InternalServerError(fmt.Sprintf("Error linting key: %s", err), nil, w, r, log)
+		return
+	}
+
+	WriteJSON(results, 200, w, r, log)
+}
+
+// lintKey lints the keys in the payload without loading or storing them
+func (kre *KeyRingEndpoint) lintKey(w http.ResponseWriter, r *http.Request) {
+	var data models.KeyRingLintData
+	log := wrapLogWithRequestID(kre.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	results, err := keymagic.LintKey(data.Key)
+	if err != nil {
+		InvalidFieldData("Key", fmt.Sprintf("Cannot read the key: %s", err), w, r, log)
+		return
+	}
+
+	WriteJSON(results, 200, w, r, log)
+}
+
 func (kre *KeyRingEndpoint) getCachedKeys(w http.ResponseWriter, r *http.Request) {
 	ctx := wrapContextWithRequestID(r)
 	log := wrapLogWithRequestID(kre.log, r)
@@ -83,8 +170,16 @@ func (kre *KeyRingEndpoint) getCachedKeys(w http.ResponseWriter, r *http.Request
 	}()
 
 	cachedKeys := kre.gpg.GetCachedKeys(ctx)
+	var data interface{} = cachedKeys
+
+	if r.URL.Query().Get("stats") == "true" {
+		data = models.KeyRingCachedKeysReturn{
+			Keys:  cachedKeys,
+			Stats: kre.gpg.GetCacheStats(ctx),
+		}
+	}
 
-	bodyData, err := json.Marshal(cachedKeys)
+	bodyData, err := json.Marshal(data)
 
 	if err != nil {
 		log.Error("Error getting cached keys: %s", err)
@@ -109,7 +204,13 @@ func (kre *KeyRingEndpoint) getLoadedPrivateKeys(w http.ResponseWriter, r *http.
 		}
 	}()
 
-	privateKeys := kre.gpg.GetLoadedPrivateKeys(ctx)
+	q := r.URL.Query()
+
+	privateKeys := keymagic.FilterKeys(kre.gpg.GetLoadedPrivateKeys(ctx), models.KeyInfoFilter{
+		OwnerTeam: q.Get("ownerTeam"),
+		Purpose:   q.Get("purpose"),
+		Tags:      q["tag"],
+	})
 
 	bodyData, err := json.Marshal(privateKeys)
 
@@ -182,6 +283,12 @@ func (kre *KeyRingEndpoint) addPrivateKey(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	err = keymagic.CheckKeyLintPolicy(data.EncryptedPrivateKey)
+	if err != nil {
+		InvalidFieldData("EncryptedPrivateKey", err.Error(), w, r, log)
+		return
+	}
+
 	n, _ := kre.gpg.LoadKey(ctx, data.EncryptedPrivateKey) // Error never happens here due GetFingerPrintFromKey
 
 	if n == 0 {
@@ -202,12 +309,17 @@ func (kre *KeyRingEndpoint) addPrivateKey(w http.ResponseWriter, r *http.Request
 
 	pubKey, _ := kre.gpg.GetPublicKeyASCII(ctx, fp)
 
+	// The private key proves the ownership of the user ids
 	log.Info("Adding public key for %s on PKS", fp)
-	res := keymagic.PKSAdd(ctx, pubKey)
-	log.Info("PKS Add Key: %s", res)
+	_, err = keymagic.PKSAddVerifiedKey(ctx, pubKey)
+	if err != nil {
+		log.Error("PKS Add Key: %s", err)
+	} else {
+		log.Info("PKS Add Key: OK")
+	}
 
 	if data.SaveToDisk {
-		err = kre.gpg.SaveKey(fingerPrint, data.EncryptedPrivateKey, data.Password)
+		err = kre.gpg.SaveKeyWithMetadata(fingerPrint, data.EncryptedPrivateKey, data.Password, data.Metadata)
 		if err != nil {
 			log.Error("Error saving key: %s", err)
 			InternalServerError("There was an error saving your key to disk.", data, w, r, log)
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
 	"github.com/quan-to/chevron/pkg/QuantoError"
 	"github.com/quan-to/chevron/test"
 	"io/ioutil"
@@ -58,6 +59,104 @@ func TestKREGetKey(t *testing.T) {
 	// endregion
 }
 
+func TestKREGetKeyDetails(t *testing.T) {
+	// region Test Get Key Details
+	req, err := http.NewRequest("GET", "/keyRing/keyDetails", nil)
+	errorDie(err, t)
+	q := req.URL.Query()
+	q.Add("fingerPrint", test.TestKeyFingerprint)
+	req.URL.RawQuery = q.Encode()
+
+	res := executeRequest(req)
+
+	d, err := ioutil.ReadAll(res.Body)
+	errorDie(err, t)
+
+	if res.Code != 200 {
+		var errObj QuantoError.ErrorObject
+		err := json.Unmarshal(d, &errObj)
+		errorDie(err, t)
+		errorDie(fmt.Errorf(errObj.Message), t)
+	}
+
+	var details models.KeyDetails
+	err = json.Unmarshal(d, &details)
+	errorDie(err, t)
+
+	if details.KeyID != test.TestKeyFingerprint || !details.ContainsPrivateKey {
+		errorDie(fmt.Errorf("expected private key %s details got %+v", test.TestKeyFingerprint, details), t)
+	}
+
+	if len(details.UserIDs) == 0 || details.UserIDs[0].Email != test.TestKeyEmail {
+		errorDie(fmt.Errorf("expected user id with email %s got %+v", test.TestKeyEmail, details.UserIDs), t)
+	}
+	// endregion
+	// region Test Inexistent Key Details
+	req, err = http.NewRequest("GET", "/keyRing/keyDetails", nil)
+	errorDie(err, t)
+	q = req.URL.Query()
+	q.Add("fingerPrint", "WOLOLO")
+	req.URL.RawQuery = q.Encode()
+
+	res = executeRequest(req)
+
+	errObj, err := ReadErrorObject(res.Body)
+	errorDie(err, t)
+
+	if errObj.ErrorCode != QuantoError.NotFound {
+		errorDie(fmt.Errorf("expected error code %s got %s", QuantoError.NotFound, errObj.ErrorCode), t)
+	}
+	// endregion
+}
+
+func TestKRELintKey(t *testing.T) {
+	// region Test Lint Key
+	body, _ := json.Marshal(models.KeyRingLintData{
+		Key: test.TestPublicKey,
+	})
+
+	req, err := http.NewRequest("POST", "/keyRing/lint", bytes.NewReader(body))
+	errorDie(err, t)
+
+	res := executeRequest(req)
+
+	d, err := ioutil.ReadAll(res.Body)
+	errorDie(err, t)
+
+	if res.Code != 200 {
+		var errObj QuantoError.ErrorObject
+		err := json.Unmarshal(d, &errObj)
+		errorDie(err, t)
+		errorDie(fmt.Errorf(errObj.Message), t)
+	}
+
+	var results []models.KeyLintResult
+	err = json.Unmarshal(d, &results)
+	errorDie(err, t)
+
+	if len(results) != 1 || results[0].FingerPrint != test.TestKeyFingerprint || results[0].Rejected {
+		errorDie(fmt.Errorf("expected lint result of %s got %+v", test.TestKeyFingerprint, results), t)
+	}
+	// endregion
+	// region Test Lint Invalid Key
+	body, _ = json.Marshal(models.KeyRingLintData{
+		Key: "WOLOLO",
+	})
+
+	req, err = http.NewRequest("POST", "/keyRing/lint", bytes.NewReader(body))
+	errorDie(err, t)
+
+	res = executeRequest(req)
+
+	errObj, err := ReadErrorObject(res.Body)
+	errorDie(err, t)
+
+	if errObj.ErrorCode != QuantoError.InvalidFieldData {
+		errorDie(fmt.Errorf("expected error code %s got %s", QuantoError.InvalidFieldData, errObj.ErrorCode), t)
+	}
+	// endregion
+}
+
 func TestKREGetCachedKeys(t *testing.T) {
 	// region Test Get Cached Keys
 	req, err := http.NewRequest("GET", "/keyRing/cachedKeys", nil)
@@ -134,6 +233,71 @@ func TestKREGetLoadedPrivateKeys(t *testing.T) {
 	// endregion
 }
 
+func TestKREPrivateKeysMetadataFilter(t *testing.T) {
+	key, err := gpg.GenerateTestKey()
+	errorDie(err, t)
+
+	fp, err := tools.GetFingerPrintFromKey(key)
+	errorDie(err, t)
+
+	// region Add Private Key with Metadata
+	payload := models.KeyRingAddPrivateKeyData{
+		EncryptedPrivateKey: key,
+		SaveToDisk:          true,
+		Password:            "1234",
+		Metadata: &models.KeyMetadata{
+			OwnerTeam: "payments",
+			Purpose:   "signing",
+			Tags:      []string{"production"},
+		},
+	}
+
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest("POST", "/keyRing/addPrivateKey", bytes.NewReader(body))
+	errorDie(err, t)
+
+	res := executeRequest(req)
+
+	if res.Code != 200 {
+		errObj, err := ReadErrorObject(res.Body)
+		errorDie(err, t)
+		errorDie(fmt.Errorf(errObj.Message), t)
+	}
+	// endregion
+	// region Filter Private Keys
+	filterKeys := func(query string) []models.KeyInfo {
+		req, err := http.NewRequest("GET", "/keyRing/privateKeys?"+query, nil)
+		errorDie(err, t)
+
+		res := executeRequest(req)
+		d, err := ioutil.ReadAll(res.Body)
+		errorDie(err, t)
+
+		var keyInfo []models.KeyInfo
+		err = json.Unmarshal(d, &keyInfo)
+		errorDie(err, t)
+
+		return keyInfo
+	}
+
+	keys := filterKeys("ownerTeam=payments&purpose=signing&tag=production")
+	if len(keys) != 1 || keys[0].FingerPrint != fp {
+		errorDie(fmt.Errorf("expected only key %s to be returned, got %v", fp, keys), t)
+	}
+
+	if keys[0].Metadata == nil || keys[0].Metadata.CreatedAt == nil {
+		errorDie(fmt.Errorf("expected key %s metadata to have a creation time", fp), t)
+	}
+
+	for _, v := range filterKeys("ownerTeam=payments&tag=staging") {
+		if v.FingerPrint == fp {
+			errorDie(fmt.Errorf("expected key %s to not match tag staging", fp), t)
+		}
+	}
+	// endregion
+}
+
 func TestKREAddPrivateKey(t *testing.T) {
 	ctx := context.Background()
 	key, err := gpg.GenerateTestKey()
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/interfaces"
+	"github.com/quan-to/slog"
+	"net/http"
+	"strconv"
+)
+
+type KeyVersionsEndpoint struct {
+	kb  interfaces.VersionedStorageBackend
+	gpg interfaces.PGPManager
+	log slog.Instance
+}
+
+// MakeKeyVersionsEndpoint creates an instance of the stored key versions management endpoints.
+// If kb is nil, all calls returns NotImplemented
+func MakeKeyVersionsEndpoint(log slog.Instance, kb interfaces.VersionedStorageBackend, gpg interfaces.PGPManager) *KeyVersionsEndpoint {
+	if log == nil {
+		log = slog.Scope("KeyVersions")
+	} else {
+		log = log.SubScope("KeyVersions")
+	}
+
+	return &KeyVersionsEndpoint{
+		kb:  kb,
+		gpg: gpg,
+		log: log,
+	}
+}
+
+func (kve *KeyVersionsEndpoint) AttachHandlers(r *mux.Router) {
+	r.HandleFunc("/list", kve.listVersions).Methods("GET")
+	r.HandleFunc("/read", kve.readVersion).Methods("GET")
+	r.HandleFunc("/undelete", kve.undelete).Methods("POST")
+	r.HandleFunc("/rollback", kve.rollback).Methods("POST")
+}
+
+func (kve *KeyVersionsEndpoint) isVersioned() bool {
+	return kve.kb != nil && kve.kb.IsVersioned()
+}
+
+func (kve *KeyVersionsEndpoint) listVersions(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(kve.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if !kve.isVersioned() {
+		NotImplemented(w, r, log)
+		return
+	}
+
+	fingerPrint := kve.gpg.FixFingerPrint(r.URL.Query().Get("fingerPrint"))
+
+	versions, err := kve.kb.ListVersions(fingerPrint)
+	if err != nil {
+		NotFound("fingerPrint", fmt.Sprintf("Key with fingerPrint %s was not found: %s", fingerPrint, err), w, r, log)
+		return
+	}
+
+	WriteJSON(versions, 200, w, r, log)
+}
+
+func (kve *KeyVersionsEndpoint) readVersion(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(kve.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if !kve.isVersioned() {
+		NotImplemented(w, r, log)
+		return
+	}
+
+	q := r.URL.Query()
+	fingerPrint := kve.gpg.FixFingerPrint(q.Get("fingerPrint"))
+
+	version, err := strconv.Atoi(q.Get("version"))
+	if err != nil || version <= 0 {
+		InvalidFieldData("version", "A version number greater than zero should be specified", w, r, log)
+		return
+	}
+
+	data, _, err := kve.kb.ReadVersion(fingerPrint, version)
+	if err != nil {
+		NotFound("version", fmt.Sprintf("Version %d of key %s was not found: %s", version, fingerPrint, err), w, r, log)
+		return
+	}
+
+	if config.KeysBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			InternalServerError("Error decoding the stored key", nil, w, r, log)
+			return
+		}
+		data = string(b)
+	}
+
+	WriteJSON(models.KeyRingKeyVersionReturn{
+		FingerPrint: fingerPrint,
+		Version:     version,
+		PrivateKey:  data,
+	}, 200, w, r, log)
+}
+
+func (kve *KeyVersionsEndpoint) undelete(w http.ResponseWriter, r *http.Request) {
+	kve.restore(w, r, "undelete")
+}
+
+func (kve *KeyVersionsEndpoint) rollback(w http.ResponseWriter, r *http.Request) {
+	kve.restore(w, r, "rollback")
+}
+
+// restore runs the specified restore operation and reloads the restored key in the PGP Manager
+func (kve *KeyVersionsEndpoint) restore(w http.ResponseWriter, r *http.Request, operation string) {
+	var data models.KeyRingKeyVersionData
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(kve.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if !kve.isVersioned() {
+		NotImplemented(w, r, log)
+		return
+	}
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	if data.Version <= 0 {
+		InvalidFieldData("Version", "A version number greater than zero should be specified", w, r, log)
+		return
+	}
+
+	fingerPrint := kve.gpg.FixFingerPrint(data.FingerPrint)
+
+	var err error
+	if operation == "undelete" {
+		err = kve.kb.Undelete(fingerPrint, data.Version)
+	} else {
+		err = kve.kb.Rollback(fingerPrint, data.Version)
+	}
+
+	if err != nil {
+		log.Error("Error running %s of key %s version %d: %s", operation, fingerPrint, data.Version, err)
+		InternalServerError(fmt.Sprintf("There was an error running %s: %s", operation, err), data, w, r, log)
+		return
+	}
+
+	keyData, metadata, err := kve.kb.Read(fingerPrint)
+	if err == nil && config.KeysBase64Encoded {
+		var b []byte
+		b, err = base64.StdEncoding.DecodeString(keyData)
+		keyData = string(b)
+	}
+
+	if err == nil {
+		_, err = kve.gpg.LoadKeyWithMetadata(ctx, keyData, metadata)
+	}
+
+	if err != nil {
+		// The version is restored in the backend, it will be loaded on next restart
+		log.Warn("Cannot reload restored key %s: %s", fingerPrint, err)
+	}
+
+	WriteJSON(models.GPGDeletePrivateKeyReturn{
+		Status: "OK",
+	}, 200, w, r, log)
+}
@@ -15,6 +15,16 @@ import (
 	"net/http"
 )
 
+// versionedKeyBackend returns the vault key backend if it can be used for key versioning
+func versionedKeyBackend(vm *vaultManager.VaultManager) interfaces.VersionedStorageBackend {
+	if vm == nil || config.EncryptedStorage {
+		// Encrypted storage obfuscates the key names
+		return nil
+	}
+
+	return vm
+}
+
 // GenRemoteSignerServerMux generates a remote signer HTTP Router
 func GenRemoteSignerServerMux(slog slog.Instance, sm interfaces.SecretsManager, gpg interfaces.PGPManager) *mux.Router {
 	var vm *vaultManager.VaultManager
@@ -28,13 +38,17 @@ func GenRemoteSignerServerMux(slog slog.Instance, sm interfaces.SecretsManager,
 	ie := MakeInternalEndpoint(log, sm, gpg)
 	te := MakeTestsEndpoint(log, vm)
 	kre := MakeKeyRingEndpoint(log, sm, gpg)
+	kve := MakeKeyVersionsEndpoint(log, versionedKeyBackend(vm), gpg)
+	kee := MakeKeyExpiryEndpoint(log, gpg)
 	sks := MakeSKSEndpoint(log, sm, gpg)
+	wkd := MakeWKDEndpoint(log)
 	tm := agent.MakeTokenManager(log)
 	am := agent.MakeAuthManager(log)
 	ap := MakeAgentProxy(log, gpg, tm)
 	sGql := MakeStaticGraphiQL(log)
 	agentAdmin := MakeAgentAdmin(log, tm, am)
 	jfc := MakeJFCEndpoint(log, sm, gpg)
+	kie := MakeKeyImportEndpoint(log, gpg, tm)
 
 	if ge == nil || ie == nil || te == nil || kre == nil || sks == nil || tm == nil || am == nil || ap == nil || agentAdmin == nil {
 		slog.Error("One or more services has not been initialized.")
@@ -51,23 +65,45 @@ func GenRemoteSignerServerMux(slog slog.Instance, sm interfaces.SecretsManager,
 	}
 
 	r := mux.NewRouter()
+
+	if config.TLSClientCAFile != "" {
+		tlsm, err := GetTLSManager(log)
+		if err != nil {
+			slog.Fatal("Error loading TLS configuration: %s", err)
+		}
+		r.Use(tlsm.Middleware(log))
+	}
+
+	if config.APIAuth {
+		r.Use(APIAuthMiddleware(log, tm))
+	}
+
 	// Add for /
 	AddHKPEndpoints(log, r.PathPrefix("/pks").Subrouter())
 	ge.AttachHandlers(r.PathPrefix("/gpg").Subrouter())
 	ie.AttachHandlers(r.PathPrefix("/__internal").Subrouter())
 	te.AttachHandlers(r.PathPrefix("/tests").Subrouter())
+	kve.AttachHandlers(r.PathPrefix("/keyRing/versions").Subrouter())
 	kre.AttachHandlers(r.PathPrefix("/keyRing").Subrouter())
+	kee.AttachHandlers(r.PathPrefix("/keyExpiry").Subrouter())
 	sks.AttachHandlers(r.PathPrefix("/sks").Subrouter())
 	jfc.AttachHandlers(r.PathPrefix("/fieldCipher").Subrouter())
+	kie.AttachHandlers(r.PathPrefix("/keyImport").Subrouter())
 
 	// Add for /remoteSigner
 	AddHKPEndpoints(log, r.PathPrefix("/remoteSigner/pks").Subrouter())
 	ge.AttachHandlers(r.PathPrefix("/remoteSigner/gpg").Subrouter())
 	ie.AttachHandlers(r.PathPrefix("/remoteSigner/__internal").Subrouter())
 	te.AttachHandlers(r.PathPrefix("/remoteSigner/tests").Subrouter())
+	kve.AttachHandlers(r.PathPrefix("/remoteSigner/keyRing/versions").Subrouter())
 	kre.AttachHandlers(r.PathPrefix("/remoteSigner/keyRing").Subrouter())
+	kee.AttachHandlers(r.PathPrefix("/remoteSigner/keyExpiry").Subrouter())
 	sks.AttachHandlers(r.PathPrefix("/remoteSigner/sks").Subrouter())
 	jfc.AttachHandlers(r.PathPrefix("/remoteSigner/fieldCipher").Subrouter())
+	kie.AttachHandlers(r.PathPrefix("/remoteSigner/keyImport").Subrouter())
+
+	// Web Key Directory, only at the well-known location
+	wkd.AttachHandlers(r.PathPrefix("/.well-known/openpgpkey").Subrouter())
 
 	// Agent
 	ap.AddHandlers(r.PathPrefix("/agent").Subrouter())
@@ -94,31 +130,11 @@ func RunRemoteSignerServer(slog slog.Instance, sm interfaces.SecretsManager, gpg
 
 	r := GenRemoteSignerServerMux(slog, sm, gpg)
 
-	listenAddr := fmt.Sprintf("0.0.0.0:%d", config.HttpPort)
-
-	srv := &http.Server{
-		Addr:    listenAddr,
-		Handler: r, // Pass our instance of gorilla/mux in.
+	stopChannel, err := serveRemoteSigner(slog, r)
+	if err != nil {
+		slog.Fatal("Error starting server: %s", err)
 	}
 
-	stopChannel := make(chan bool)
-
-	go func() {
-		<-stopChannel
-		slog.Info("Received STOP. Closing server")
-		_ = srv.Close()
-		stopChannel <- true
-	}()
-
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			slog.Error(err)
-		}
-		slog.Info("HTTP Server Closed")
-	}()
-
-	slog.Info("Remote Signer is now listening at %s", listenAddr)
-
 	return stopChannel
 }
 
@@ -162,6 +178,16 @@ func RunRemoteSignerServerSingleKey(slog slog.Instance, sm interfaces.SecretsMan
 
 	r := GenRemoteSignerServerMux(slog, sm, gpg)
 
+	stopChannel, err := serveRemoteSigner(slog, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return stopChannel, nil
+}
+
+// serveRemoteSigner serves the router at HTTP_PORT asynchronously, with TLS if TLS_CERT_FILE is set, and returns a stop channel
+func serveRemoteSigner(slog slog.Instance, r *mux.Router) (chan bool, error) {
 	listenAddr := fmt.Sprintf("0.0.0.0:%d", config.HttpPort)
 
 	srv := &http.Server{
@@ -169,23 +195,52 @@ func RunRemoteSignerServerSingleKey(slog slog.Instance, sm interfaces.SecretsMan
 		Handler: r, // Pass our instance of gorilla/mux in.
 	}
 
+	var tm *TLSManager
+	if config.TLSCertFile != "" {
+		var err error
+		tm, err = GetTLSManager(slog)
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = tm.TLSConfig()
+	}
+
 	stopChannel := make(chan bool)
+	reloadStop := make(chan bool, 1)
+
+	if tm != nil {
+		go tm.Run(reloadStop, tm.ReloadInterval())
+	}
 
 	go func() {
 		<-stopChannel
 		slog.Info("Received STOP. Closing server")
+		if tm != nil {
+			reloadStop <- true
+		}
 		_ = srv.Close()
 		stopChannel <- true
 	}()
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil {
+		var err error
+		if tm != nil {
+			// The certificate is provided by the TLS configuration so it can be reloaded
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil {
 			slog.Error(err)
 		}
 		slog.Info("HTTP Server Closed")
 	}()
 
-	slog.Info("Remote Signer is now listening at %s", listenAddr)
+	if tm != nil {
+		slog.Info("Remote Signer is now listening with TLS at %s", listenAddr)
+	} else {
+		slog.Info("Remote Signer is now listening at %s", listenAddr)
+	}
 
 	return stopChannel, nil
 }
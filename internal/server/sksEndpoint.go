@@ -7,7 +7,10 @@ import (
 	"github.com/quan-to/chevron/internal/models"
 	"github.com/quan-to/chevron/pkg/interfaces"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/quan-to/slog"
@@ -27,6 +30,10 @@ func MakeSKSEndpoint(log slog.Instance, sm interfaces.SecretsManager, gpg interf
 		log = log.SubScope("SKS")
 	}
 
+	if _, err := keymagic.ParsePKSAdminKeys(); err != nil {
+		log.Fatal("Error parsing PKS_ADMIN_KEYS: %s", err)
+	}
+
 	return &SKSEndpoint{
 		sm:  sm,
 		gpg: gpg,
@@ -40,7 +47,18 @@ func (sks *SKSEndpoint) AttachHandlers(r *mux.Router) {
 	r.HandleFunc("/searchByFingerPrint", sks.searchByFingerPrint).Methods("GET")
 	r.HandleFunc("/searchByEmail", sks.searchByEmail).Methods("GET")
 	r.HandleFunc("/search", sks.search).Methods("GET")
+	r.HandleFunc("/searchKeys", sks.searchKeys).Methods("GET")
 	r.HandleFunc("/addKey", sks.addKey).Methods("POST")
+	r.HandleFunc("/removeKey", sks.removeKey).Methods("POST")
+	r.HandleFunc("/updateKey", sks.updateKey).Methods("POST")
+	r.HandleFunc("/sync/buckets", sks.syncBuckets).Methods("GET")
+	r.HandleFunc("/sync/bucket", sks.syncBucket).Methods("GET")
+	r.HandleFunc("/sync/keys", sks.syncKeys).Methods("POST")
+	r.HandleFunc("/sync/status", sks.syncStatus).Methods("GET")
+	r.HandleFunc("/verify", sks.verifyChallenge).Methods("POST")
+	r.HandleFunc("/verify/challenge", sks.requestChallenge).Methods("POST")
+	r.HandleFunc("/verify/email", sks.requestEmailVerification).Methods("POST")
+	r.HandleFunc("/verify/email/confirm", sks.confirmEmailVerification).Methods("POST")
 }
 
 func (sks *SKSEndpoint) getKey(w http.ResponseWriter, r *http.Request) {
@@ -270,13 +288,115 @@ func (sks *SKSEndpoint) search(w http.ResponseWriter, r *http.Request) {
 	LogExit(log, r, 200, n)
 }
 
+// parseGPGKeySearch reads the search filters from the query. Returns the invalid field if any
+func parseGPGKeySearch(q url.Values) (models.GPGKeySearch, string, error) {
+	search := models.GPGKeySearch{
+		Value:     q.Get("valueData"),
+		Name:      q.Get("name"),
+		Email:     q.Get("email"),
+		KeyID:     q.Get("keyId"),
+		Algorithm: q.Get("algorithm"),
+		Sort:      q.Get("sort"),
+		Cursor:    q.Get("cursor"),
+	}
+
+	times := map[string]**time.Time{
+		"createdAfter":  &search.CreatedAfter,
+		"createdBefore": &search.CreatedBefore,
+		"expiresAfter":  &search.ExpiresAfter,
+		"expiresBefore": &search.ExpiresBefore,
+	}
+
+	for field, target := range times {
+		if v := q.Get(field); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return search, field, fmt.Errorf("expected a RFC3339 time")
+			}
+			*target = &t
+		}
+	}
+
+	if v := q.Get("revoked"); v != "" {
+		revoked, err := strconv.ParseBool(v)
+		if err != nil {
+			return search, "revoked", fmt.Errorf("expected true or false")
+		}
+		search.Revoked = &revoked
+	}
+
+	if v := q.Get("total"); v != "" {
+		total, err := strconv.ParseBool(v)
+		if err != nil {
+			return search, "total", fmt.Errorf("expected true or false")
+		}
+		search.CountTotal = total
+	}
+
+	switch search.Sort {
+	case "", models.GPGKeySearchSortFingerPrint, models.GPGKeySearchSortCreationTime:
+	default:
+		return search, "sort", fmt.Errorf("expected %s or %s", models.GPGKeySearchSortFingerPrint, models.GPGKeySearchSortCreationTime)
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		search.Descending = true
+	default:
+		return search, "order", fmt.Errorf("expected asc or desc")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || limit <= 0 || limit > models.MaxGPGKeySearchLimit {
+			return search, "limit", fmt.Errorf("expected a number between 1 and %d", models.MaxGPGKeySearchLimit)
+		}
+		search.Limit = int(limit)
+	}
+
+	return search, "", nil
+}
+
+func (sks *SKSEndpoint) searchKeys(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	search, field, err := parseGPGKeySearch(r.URL.Query())
+	if err != nil {
+		InvalidFieldData(field, err.Error(), w, r, log)
+		return
+	}
+
+	result, err := keymagic.PKSSearchKeys(search)
+	if err != nil {
+		InvalidFieldData("search", err.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(result, 200, w, r, log)
+}
+
 func (sks *SKSEndpoint) addKey(w http.ResponseWriter, r *http.Request) {
 	ctx := wrapContextWithRequestID(r)
 	log := wrapLogWithRequestID(sks.log, r)
 	InitHTTPTimer(log, r)
 
+	if !keyUploadThrottle.Allow(r) {
+		TooManyRequests("Too many keys uploaded. Please try again later", w, r, log)
+		return
+	}
+
 	var data models.SKSAddKey
 
+	limitKeyUploadBody(w, r)
+
 	if !UnmarshalBodyOrDie(&data, w, r, log) {
 		return
 	}
@@ -287,15 +407,278 @@ func (sks *SKSEndpoint) addKey(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	status := keymagic.PKSAdd(ctx, data.PublicKey)
+	err := keymagic.CheckKeyLintPolicy(data.PublicKey)
+	if err != nil {
+		InvalidFieldData("PublicKey", err.Error(), w, r, log)
+		return
+	}
+
+	result, err := keymagic.PKSAddKey(ctx, data.PublicKey)
+
+	if err != nil && len(result.Upstreams) > 0 {
+		InternalServerError(err.Error(), result.Upstreams, w, r, log)
+		return
+	}
 
-	if status != "OK" {
+	if err != nil {
 		InvalidFieldData("PublicKey", "Invalid Public Key specified. Check if its in ASCII Armored Format", w, r, log)
 		return
 	}
 
+	// Clients asking for JSON receive what changed in the stored key
+	if strings.Contains(r.Header.Get("Accept"), models.MimeJSON) {
+		WriteJSON(result, 200, w, r, log)
+		return
+	}
+
+	w.Header().Set("Content-Type", models.MimeText)
+	w.WriteHeader(200)
+	n, _ := w.Write([]byte("OK"))
+	LogExit(log, r, 200, n)
+}
+
+func (sks *SKSEndpoint) removeKey(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	var data models.SKSSignedKeyOperation
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	err := keymagic.PKSRemoveKey(ctx, data)
+	if err != nil {
+		PermissionDenied("Signature", err.Error(), w, r, log)
+		return
+	}
+
+	w.Header().Set("Content-Type", models.MimeText)
+	w.WriteHeader(200)
+	n, _ := w.Write([]byte("OK"))
+	LogExit(log, r, 200, n)
+}
+
+func (sks *SKSEndpoint) updateKey(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	if !keyUploadThrottle.Allow(r) {
+		TooManyRequests("Too many keys uploaded. Please try again later", w, r, log)
+		return
+	}
+
+	var data models.SKSSignedKeyOperation
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	err := keymagic.PKSUpdateKey(ctx, data)
+	if err != nil {
+		PermissionDenied("Signature", err.Error(), w, r, log)
+		return
+	}
+
+	w.Header().Set("Content-Type", models.MimeText)
+	w.WriteHeader(200)
+	n, _ := w.Write([]byte("OK"))
+	LogExit(log, r, 200, n)
+}
+
+func (sks *SKSEndpoint) requestChallenge(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	var data models.SKSRequestChallenge
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	challenge, err := keymagic.PKSRequestChallenge(ctx, data.FingerPrint)
+	if err != nil {
+		InvalidFieldData("FingerPrint", err.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(challenge, 200, w, r, log)
+}
+
+func (sks *SKSEndpoint) verifyChallenge(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	var data models.SKSVerifyChallenge
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	result, err := keymagic.PKSVerifyChallenge(ctx, data.FingerPrint, data.Signature)
+	if err != nil {
+		InvalidFieldData("Signature", err.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(result, 200, w, r, log)
+}
+
+func (sks *SKSEndpoint) requestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	var data models.SKSRequestEmailVerification
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	err := keymagic.PKSRequestEmailVerification(ctx, data.FingerPrint, data.Email)
+	if err != nil {
+		InvalidFieldData("Email", err.Error(), w, r, log)
+		return
+	}
+
 	w.Header().Set("Content-Type", models.MimeText)
 	w.WriteHeader(200)
 	n, _ := w.Write([]byte("OK"))
 	LogExit(log, r, 200, n)
 }
+
+func (sks *SKSEndpoint) confirmEmailVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	var data models.SKSConfirmEmailVerification
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	result, err := keymagic.PKSConfirmEmailVerification(ctx, data.FingerPrint, data.Token)
+	if err != nil {
+		InvalidFieldData("Token", err.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(result, 200, w, r, log)
+}
+
+func (sks *SKSEndpoint) syncBuckets(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	buckets, err := keymagic.PKSSyncBuckets()
+	if err != nil {
+		InternalServerError(err.Error(), nil, w, r, log)
+		return
+	}
+
+	WriteJSON(buckets, 200, w, r, log)
+}
+
+func (sks *SKSEndpoint) syncBucket(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	entries, err := keymagic.PKSSyncBucket(r.URL.Query().Get("prefix"))
+	if err != nil {
+		InvalidFieldData("prefix", err.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(entries, 200, w, r, log)
+}
+
+func (sks *SKSEndpoint) syncKeys(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	var data models.SKSSyncKeys
+
+	if !UnmarshalBodyOrDie(&data, w, r, log) {
+		return
+	}
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	keys, err := keymagic.PKSSyncKeys(data.FingerPrints)
+	if err != nil {
+		InvalidFieldData("FingerPrints", err.Error(), w, r, log)
+		return
+	}
+
+	WriteJSON(keys, 200, w, r, log)
+}
+
+func (sks *SKSEndpoint) syncStatus(w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(sks.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	WriteJSON(keymagic.GetPKSPeerSync().Status(), 200, w, r, log)
+}
@@ -3,15 +3,25 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/quan-to/chevron/internal/config"
 	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
 	"github.com/quan-to/chevron/pkg/QuantoError"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
 	"github.com/quan-to/chevron/test"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSKSGetKey(t *testing.T) {
@@ -199,6 +209,31 @@ func TestAddKey(t *testing.T) {
 		errorDie(fmt.Errorf("expected OK got %s", string(d)), t)
 	}
 	// endregion
+	// region Test Add Existing Key With Report
+	r = bytes.NewReader(body)
+
+	req, err = http.NewRequest("POST", "/sks/addKey", r)
+
+	errorDie(err, t)
+
+	req.Header.Set("Accept", models.MimeJSON)
+
+	res = executeRequest(req)
+
+	var result models.KeyMergeResult
+
+	err = json.NewDecoder(res.Body).Decode(&result)
+
+	errorDie(err, t)
+
+	if result.FingerPrint != test.TestKeyFingerprint {
+		errorDie(fmt.Errorf("expected fingerprint %s got %s", test.TestKeyFingerprint, result.FingerPrint), t)
+	}
+
+	if result.Changed {
+		errorDie(fmt.Errorf("expected no changes got %+v", result), t)
+	}
+	// endregion
 	// region Test Add Invalid Key
 	payload.PublicKey = "huebrbrbrbrbr"
 	body, _ = json.Marshal(payload)
@@ -219,3 +254,461 @@ func TestAddKey(t *testing.T) {
 	}
 	// endregion
 }
+
+func postSKSVerification(endpoint string, payload, out interface{}, t *testing.T) {
+	body, _ := json.Marshal(payload)
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	errorDie(err, t)
+	req.Header.Set("Accept", models.MimeJSON)
+
+	res := executeRequest(req)
+
+	d, err := ioutil.ReadAll(res.Body)
+	errorDie(err, t)
+
+	if res.Code != 200 {
+		errorDie(fmt.Errorf("expected status 200 from %s got %d: %s", endpoint, res.Code, string(d)), t)
+	}
+
+	if out != nil {
+		errorDie(json.Unmarshal(d, out), t)
+	}
+}
+
+func searchSKSByEmail(email string, t *testing.T) []models.GPGKey {
+	req, err := http.NewRequest("GET", "/sks/searchByEmail?email="+email, nil)
+	errorDie(err, t)
+
+	res := executeRequest(req)
+
+	var keys []models.GPGKey
+	errorDie(json.NewDecoder(res.Body).Decode(&keys), t)
+
+	return keys
+}
+
+func TestSKSKeyVerification(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.EnableRethinkSKS = true
+	config.PKSVerifyOwnership = true
+
+	cfg := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Verify", "", "verify@huebr.com", cfg)
+	errorDie(err, t)
+
+	armorKey := func() string {
+		buf := bytes.NewBuffer(nil)
+		w, _ := armor.Encode(buf, openpgp.PublicKeyType, nil)
+		errorDie(e.Serialize(w), t)
+		_ = w.Close()
+		return buf.String()
+	}
+
+	fp := tools.ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+
+	// region Test Add Key Pending Verification
+	var result models.KeyMergeResult
+	postSKSVerification("/sks/addKey", models.SKSAddKey{PublicKey: armorKey()}, &result, t)
+
+	if result.Challenge == nil || len(result.Challenge.PendingUserIDs) != 1 {
+		errorDie(fmt.Errorf("expected a challenge for the pending user id got %+v", result), t)
+	}
+
+	if len(searchSKSByEmail("verify@huebr.com", t)) != 0 {
+		errorDie(fmt.Errorf("expected key pending verification not to be searchable"), t)
+	}
+	// endregion
+	// region Test Verify Challenge
+	var challenge models.KeyVerificationChallenge
+	postSKSVerification("/sks/verify/challenge", models.SKSRequestChallenge{FingerPrint: fp}, &challenge, t)
+
+	if challenge.Challenge != result.Challenge.Challenge {
+		errorDie(fmt.Errorf("expected the same challenge"), t)
+	}
+
+	sig := bytes.NewBuffer(nil)
+	errorDie(openpgp.ArmoredDetachSign(sig, e, strings.NewReader(challenge.Challenge), cfg), t)
+
+	var verification models.KeyVerificationResult
+	postSKSVerification("/sks/verify", models.SKSVerifyChallenge{FingerPrint: fp, Signature: sig.String()}, &verification, t)
+
+	if len(verification.PendingUserIDs) != 0 || len(verification.VerifiedUserIDs) != 1 {
+		errorDie(fmt.Errorf("expected the user id to be verified got %+v", verification), t)
+	}
+
+	if len(searchSKSByEmail("verify@huebr.com", t)) != 1 {
+		errorDie(fmt.Errorf("expected verified key to be searchable"), t)
+	}
+	// endregion
+	// region Test Email Verification
+	smtpServer, err := test.RunSMTPServer()
+	errorDie(err, t)
+	defer smtpServer.Close()
+
+	config.SMTPServer = smtpServer.Addr
+	config.SMTPFrom = "chevron@huebr.com"
+
+	uid := packet.NewUserId("Verify Two", "", "verify2@huebr.com")
+	selfSig := &packet.Signature{
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         cfg.Hash(),
+		CreationTime: cfg.Now(),
+		IssuerKeyId:  &e.PrivateKey.KeyId,
+	}
+	errorDie(selfSig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, cfg), t)
+	e.Identities[uid.Id] = &openpgp.Identity{Name: uid.Id, UserId: uid, SelfSignature: selfSig}
+
+	postSKSVerification("/sks/addKey", models.SKSAddKey{PublicKey: armorKey()}, &result, t)
+
+	if result.Challenge == nil || len(result.Challenge.PendingUserIDs) != 1 || result.Challenge.PendingUserIDs[0] != uid.Id {
+		errorDie(fmt.Errorf("expected only the new user id to be pending got %+v", result), t)
+	}
+
+	postSKSVerification("/sks/verify/email", models.SKSRequestEmailVerification{FingerPrint: fp, Email: "verify2@huebr.com"}, nil, t)
+
+	msg := <-smtpServer.Messages
+	token := regexp.MustCompile(`Token: ([0-9a-f]+)`).FindStringSubmatch(msg)
+	if token == nil {
+		errorDie(fmt.Errorf("expected token in email got %s", msg), t)
+	}
+
+	postSKSVerification("/sks/verify/email/confirm", models.SKSConfirmEmailVerification{FingerPrint: fp, Token: token[1]}, &verification, t)
+
+	if len(verification.PendingUserIDs) != 0 || len(verification.VerifiedUserIDs) != 2 {
+		errorDie(fmt.Errorf("expected both user ids to be verified got %+v", verification), t)
+	}
+
+	if len(searchSKSByEmail("verify2@huebr.com", t)) != 1 {
+		errorDie(fmt.Errorf("expected email verified key to be searchable"), t)
+	}
+	// endregion
+}
+
+func TestSKSRemoveAndUpdateKey(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.EnableRethinkSKS = true
+
+	cfg := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Remove", "", "remove@huebr.com", cfg)
+	errorDie(err, t)
+
+	other, err := openpgp.NewEntity("Other", "", "other@huebr.com", cfg)
+	errorDie(err, t)
+
+	armorKey := func() string {
+		buf := bytes.NewBuffer(nil)
+		w, _ := armor.Encode(buf, openpgp.PublicKeyType, nil)
+		errorDie(e.Serialize(w), t)
+		_ = w.Close()
+		return buf.String()
+	}
+
+	fp := strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+
+	signOperation := func(signer *openpgp.Entity, op models.SKSKeyOperation) models.SKSSignedKeyOperation {
+		op.FingerPrint = fp
+		op.Nonce = tools.GeneratePassword() + tools.GeneratePassword()
+		op.Timestamp = time.Now()
+
+		data, _ := json.Marshal(op)
+		sig := bytes.NewBuffer(nil)
+		errorDie(openpgp.ArmoredDetachSign(sig, signer, bytes.NewReader(data), cfg), t)
+
+		return models.SKSSignedKeyOperation{
+			Base64Data: base64.StdEncoding.EncodeToString(data),
+			Signature:  sig.String(),
+		}
+	}
+
+	expectRefused := func(endpoint string, payload interface{}) {
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+		errorDie(err, t)
+
+		res := executeRequest(req)
+		if res.Code == 200 {
+			errorDie(fmt.Errorf("expected %s to be refused", endpoint), t)
+		}
+	}
+
+	uid := packet.NewUserId("Remove Two", "", "remove2@huebr.com")
+	selfSig := &packet.Signature{
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         cfg.Hash(),
+		CreationTime: cfg.Now(),
+		IssuerKeyId:  &e.PrivateKey.KeyId,
+	}
+	errorDie(selfSig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, cfg), t)
+	e.Identities[uid.Id] = &openpgp.Identity{Name: uid.Id, UserId: uid, SelfSignature: selfSig}
+
+	postSKSVerification("/sks/addKey", models.SKSAddKey{PublicKey: armorKey()}, nil, t)
+
+	if len(searchSKSByEmail("remove2@huebr.com", t)) != 1 {
+		errorDie(fmt.Errorf("expected added key to be searchable"), t)
+	}
+
+	// region Test Update Key
+	delete(e.Identities, uid.Id)
+	update := signOperation(e, models.SKSKeyOperation{Operation: models.SKSKeyOperationUpdate, PublicKey: armorKey()})
+
+	expectRefused("/sks/removeKey", update)
+	postSKSVerification("/sks/updateKey", update, nil, t)
+
+	if len(searchSKSByEmail("remove2@huebr.com", t)) != 0 {
+		errorDie(fmt.Errorf("expected the removed user id not to be searchable"), t)
+	}
+
+	// Replayed requests are refused
+	expectRefused("/sks/updateKey", update)
+	// endregion
+	// region Test Remove Key
+	expectRefused("/sks/removeKey", signOperation(other, models.SKSKeyOperation{Operation: models.SKSKeyOperationRemove}))
+
+	if len(searchSKSByEmail("remove@huebr.com", t)) != 1 {
+		errorDie(fmt.Errorf("expected key not to be removed by other key"), t)
+	}
+
+	postSKSVerification("/sks/removeKey", signOperation(e, models.SKSKeyOperation{Operation: models.SKSKeyOperationRemove}), nil, t)
+
+	if len(searchSKSByEmail("remove@huebr.com", t)) != 0 {
+		errorDie(fmt.Errorf("expected removed key not to be searchable"), t)
+	}
+	// endregion
+}
+
+func TestParseGPGKeySearch(t *testing.T) {
+	q := url.Values{}
+	q.Set("email", "huebr")
+	q.Set("keyId", "0551F452ABE463A4")
+	q.Set("createdAfter", "2019-01-02T03:04:05Z")
+	q.Set("revoked", "false")
+	q.Set("sort", models.GPGKeySearchSortCreationTime)
+	q.Set("order", "desc")
+	q.Set("limit", "10")
+
+	search, field, err := parseGPGKeySearch(q)
+	errorDie(err, t)
+
+	if field != "" || search.Email != "huebr" || search.KeyID != "0551F452ABE463A4" || search.Limit != 10 || !search.Descending {
+		t.Errorf("Unexpected search %+v", search)
+	}
+
+	if search.CreatedAfter == nil || !search.CreatedAfter.Equal(time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected createdAfter to be parsed got %v", search.CreatedAfter)
+	}
+
+	if search.Revoked == nil || *search.Revoked {
+		t.Errorf("Expected revoked to be false")
+	}
+
+	invalid := map[string]string{
+		"expiresBefore": "yesterday",
+		"revoked":       "maybe",
+		"sort":          "name",
+		"order":         "random",
+		"limit":         "100000",
+	}
+
+	for field, value := range invalid {
+		q := url.Values{}
+		q.Set(field, value)
+
+		_, invalidField, err := parseGPGKeySearch(q)
+		if err == nil || invalidField != field {
+			t.Errorf("Expected %s=%s to be invalid", field, value)
+		}
+	}
+}
+
+func TestSKSSearchKeys(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.EnableRethinkSKS = true
+
+	cfg := &packet.Config{RSABits: 1024}
+	fingerPrints := map[string]bool{}
+
+	for _, name := range []string{"Paginated One", "Paginated Two"} {
+		e, err := openpgp.NewEntity(name, "", "search@paginated.huebr.com", cfg)
+		errorDie(err, t)
+
+		buf := bytes.NewBuffer(nil)
+		w, _ := armor.Encode(buf, openpgp.PublicKeyType, nil)
+		errorDie(e.Serialize(w), t)
+		_ = w.Close()
+
+		postSKSVerification("/sks/addKey", models.SKSAddKey{PublicKey: buf.String()}, nil, t)
+		fingerPrints[strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))] = true
+	}
+
+	searchKeys := func(query string) models.GPGKeySearchResult {
+		req, err := http.NewRequest("GET", "/sks/searchKeys?"+query, nil)
+		errorDie(err, t)
+
+		res := executeRequest(req)
+		if res.Code != 200 {
+			errorDie(fmt.Errorf("expected status 200 got %d: %s", res.Code, res.Body.String()), t)
+		}
+
+		var result models.GPGKeySearchResult
+		errorDie(json.NewDecoder(res.Body).Decode(&result), t)
+
+		return result
+	}
+
+	// region Test Cursor Pagination
+	seen := map[string]bool{}
+	cursor := ""
+
+	for page := 0; page < 2; page++ {
+		result := searchKeys("email=SEARCH@paginated&sort=creationTime&limit=1&total=true&cursor=" + cursor)
+
+		if result.Total == nil || *result.Total != 2 || len(result.Keys) != 1 {
+			errorDie(fmt.Errorf("expected 1 of 2 keys in page %d got %d of %v", page, len(result.Keys), result.Total), t)
+		}
+
+		seen[result.Keys[0].FullFingerPrint] = true
+		cursor = result.NextCursor
+
+		if (page == 0) == (cursor == "") {
+			errorDie(fmt.Errorf("unexpected cursor %q in page %d", cursor, page), t)
+		}
+	}
+
+	for fp := range fingerPrints {
+		if !seen[fp] {
+			errorDie(fmt.Errorf("expected key %s in the pages", fp), t)
+		}
+	}
+	// endregion
+	// region Test Key ID Filter
+	for fp := range fingerPrints {
+		for _, keyID := range []string{fp, fp[len(fp)-16:], fp[len(fp)-8:]} {
+			result := searchKeys("keyId=" + keyID)
+			if len(result.Keys) != 1 || result.Keys[0].FullFingerPrint != fp || result.Total != nil {
+				errorDie(fmt.Errorf("expected only key %s for key id %s got %d keys", fp, keyID, len(result.Keys)), t)
+			}
+
+			result = searchKeys("valueData=" + keyID)
+			if len(result.Keys) != 1 || result.Keys[0].FullFingerPrint != fp {
+				errorDie(fmt.Errorf("expected value %s to find key %s got %d keys", keyID, fp, len(result.Keys)), t)
+			}
+		}
+	}
+	// endregion
+	// region Test Revoked Filter
+	result := searchKeys("email=search@paginated&revoked=true")
+	if len(result.Keys) != 0 {
+		errorDie(fmt.Errorf("expected no revoked keys got %d", len(result.Keys)), t)
+	}
+	// endregion
+	// region Test Name Prefix Filter
+	result = searchKeys("name=paginated%20o&total=true")
+	if result.Total == nil || *result.Total != 1 || len(result.Keys) != 1 || result.Keys[0].Names[0] != "Paginated One" {
+		errorDie(fmt.Errorf("expected only the key of Paginated One got %d keys", len(result.Keys)), t)
+	}
+	// endregion
+}
+
+func TestSKSSync(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.EnableRethinkSKS = true
+
+	e, err := openpgp.NewEntity("Synced", "", "synced@huebr.com", &packet.Config{RSABits: 1024})
+	errorDie(err, t)
+
+	buf := bytes.NewBuffer(nil)
+	w, _ := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	errorDie(e.Serialize(w), t)
+	_ = w.Close()
+
+	postSKSVerification("/sks/addKey", models.SKSAddKey{PublicKey: buf.String()}, nil, t)
+
+	fp := strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+	digest, err := tools.KeyDigest(buf.String())
+	errorDie(err, t)
+
+	getJSON := func(endpoint string, out interface{}) int {
+		req, err := http.NewRequest("GET", endpoint, nil)
+		errorDie(err, t)
+
+		res := executeRequest(req)
+		if res.Code == 200 {
+			errorDie(json.NewDecoder(res.Body).Decode(out), t)
+		}
+
+		return res.Code
+	}
+
+	// region Test Buckets
+	var buckets models.PKSSyncBuckets
+	if code := getJSON("/sks/sync/buckets", &buckets); code != 200 {
+		errorDie(fmt.Errorf("expected status 200 got %d", code), t)
+	}
+
+	if buckets.Keys == 0 || buckets.Buckets[fp[:2]] == "" {
+		errorDie(fmt.Errorf("expected bucket %s in %+v", fp[:2], buckets), t)
+	}
+	// endregion
+
+	// region Test Bucket Entries
+	var entries []models.PKSSyncEntry
+	if code := getJSON("/sks/sync/bucket?prefix="+strings.ToLower(fp[:2]), &entries); code != 200 {
+		errorDie(fmt.Errorf("expected status 200 got %d", code), t)
+	}
+
+	found := false
+	for _, entry := range entries {
+		found = found || (entry.FingerPrint == fp && entry.Digest == digest)
+	}
+
+	if !found {
+		errorDie(fmt.Errorf("expected entry %s with digest %s in %+v", fp, digest, entries), t)
+	}
+
+	if code := getJSON("/sks/sync/bucket?prefix=XYZ", &entries); code != 400 {
+		errorDie(fmt.Errorf("expected status 400 for an invalid bucket got %d", code), t)
+	}
+	// endregion
+
+	// region Test Keys
+	var keys []string
+	postSKSVerification("/sks/sync/keys", models.SKSSyncKeys{FingerPrints: []string{fp}}, &keys, t)
+
+	if len(keys) != 1 {
+		errorDie(fmt.Errorf("expected 1 key got %d", len(keys)), t)
+	}
+
+	pulled, err := tools.ReadKeyToEntity(keys[0])
+	errorDie(err, t)
+
+	if pulled.PrimaryKey.KeyId != e.PrimaryKey.KeyId {
+		errorDie(fmt.Errorf("expected key %s", fp), t)
+	}
+
+	body, _ := json.Marshal(models.SKSSyncKeys{FingerPrints: make([]string, 101)})
+	req, err := http.NewRequest("POST", "/sks/sync/keys", bytes.NewReader(body))
+	errorDie(err, t)
+
+	if res := executeRequest(req); res.Code != 400 {
+		errorDie(fmt.Errorf("expected status 400 for too many keys got %d", res.Code), t)
+	}
+	// endregion
+
+	// region Test Status
+	var status []models.PKSPeerSyncStatus
+	if code := getJSON("/sks/sync/status", &status); code != 200 {
+		errorDie(fmt.Errorf("expected status 200 got %d", code), t)
+	}
+	// endregion
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/slog"
+)
+
+const defaultTLSReloadInterval = time.Minute
+
+type tlsContextKey string
+
+// ctxTLSIdentity is the context key of the identity of the client certificate
+const ctxTLSIdentity tlsContextKey = "tlsIdentity"
+
+// tlsProtectedPaths require a client certificate mapped to an identity when TLS_CLIENT_CA_FILE is set
+var tlsProtectedPaths = []string{
+	"/gpg",
+	"/keyRing",
+	"/__internal",
+	"/fieldCipher",
+	"/keyImport",
+}
+
+// tlsClusterPaths are also served to the requests signed by the cluster peers, which are verified by the endpoints
+var tlsClusterPaths = []string{
+	"/__internal",
+}
+
+// tlsIdentityKey is a key of an identity, with at least 16 characters since short key IDs are not unique
+var tlsIdentityKey = regexp.MustCompile("^[0-9A-Fa-f]{16,40}$")
+
+// tlsAdminPaths also require an admin identity
+var tlsAdminPaths = []string{
+	"/__internal",
+	"/keyImport",
+	"/keyRing/addPrivateKey",
+	"/keyRing/deletePrivateKey",
+	"/keyRing/versions/undelete",
+	"/keyRing/versions/rollback",
+	"/gpg/unlockKey",
+}
+
+// TLSManager holds the server certificate, the client CAs and the client identities, reloading them when the files change
+type TLSManager struct {
+	sync.RWMutex
+	log               slog.Instance
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	identitiesFile    string
+	requireClientCert bool
+
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	identities  map[string]models.TLSIdentity
+	modTimes    map[string]time.Time
+}
+
+// MakeTLSManager creates a TLSManager from the configuration and loads the files
+func MakeTLSManager(log slog.Instance) (*TLSManager, error) {
+	if log == nil {
+		log = slog.Scope("TLS")
+	} else {
+		log = log.SubScope("TLS")
+	}
+
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE are required to serve TLS")
+	}
+
+	if config.TLSClientAuth != "optional" && config.TLSClientAuth != "require" {
+		return nil, fmt.Errorf("invalid TLS_CLIENT_AUTH %q. Expected optional or require", config.TLSClientAuth)
+	}
+
+	if config.TLSClientCAFile != "" && config.TLSIdentitiesFile == "" {
+		return nil, fmt.Errorf("TLS_IDENTITIES_FILE is required when TLS_CLIENT_CA_FILE is set")
+	}
+
+	m := &TLSManager{
+		log:               log,
+		certFile:          config.TLSCertFile,
+		keyFile:           config.TLSKeyFile,
+		clientCAFile:      config.TLSClientCAFile,
+		identitiesFile:    config.TLSIdentitiesFile,
+		requireClientCert: config.TLSClientAuth == "require",
+	}
+
+	if _, err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+var tlsManager *TLSManager
+var tlsManagerConfig string
+var tlsManagerLock sync.Mutex
+
+// GetTLSManager returns the shared TLSManager. It is recreated if the configuration changes
+func GetTLSManager(log slog.Instance) (*TLSManager, error) {
+	tlsManagerLock.Lock()
+	defer tlsManagerLock.Unlock()
+
+	cfg := fmt.Sprint(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile, config.TLSClientAuth, config.TLSIdentitiesFile)
+
+	if tlsManager == nil || cfg != tlsManagerConfig {
+		m, err := MakeTLSManager(log)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsManager = m
+		tlsManagerConfig = cfg
+	}
+
+	return tlsManager, nil
+}
+
+func (m *TLSManager) files() []string {
+	files := []string{m.certFile, m.keyFile}
+	if m.clientCAFile != "" {
+		files = append(files, m.clientCAFile, m.identitiesFile)
+	}
+
+	return files
+}
+
+func loadTLSIdentities(filename string) (map[string]models.TLSIdentity, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []models.TLSIdentity
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", filename, err)
+	}
+
+	identities := make(map[string]models.TLSIdentity, len(list))
+	for _, identity := range list {
+		if identity.Identity == "" {
+			return nil, fmt.Errorf("error parsing %s: empty identity", filename)
+		}
+
+		for _, key := range identity.Keys {
+			if key = strings.TrimSpace(key); key != "*" && !tlsIdentityKey.MatchString(key) {
+				return nil, fmt.Errorf("error parsing %s: the key %q of %s should be * or a fingerprint with 16 to 40 hex characters", filename, key, identity.Identity)
+			}
+		}
+		identities[identity.Identity] = identity
+	}
+
+	return identities, nil
+}
+
+// Reload loads the files again if any of them changed. Returns if they were reloaded.
+// The previous certificate and identities are kept if the new files are invalid
+func (m *TLSManager) Reload() (bool, error) {
+	modTimes := map[string]time.Time{}
+	changed := false
+
+	m.RLock()
+	for _, file := range m.files() {
+		stat, err := os.Stat(file)
+		if err != nil {
+			m.RUnlock()
+			return false, err
+		}
+
+		modTimes[file] = stat.ModTime()
+		if last, ok := m.modTimes[file]; !ok || !last.Equal(stat.ModTime()) {
+			changed = true
+		}
+	}
+	m.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading the server certificate: %s", err)
+	}
+
+	var clientCAs *x509.CertPool
+	var identities map[string]models.TLSIdentity
+
+	if m.clientCAFile != "" {
+		data, err := ioutil.ReadFile(m.clientCAFile)
+		if err != nil {
+			return false, err
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return false, fmt.Errorf("no certificates found in %s", m.clientCAFile)
+		}
+
+		identities, err = loadTLSIdentities(m.identitiesFile)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	m.Lock()
+	m.certificate = &certificate
+	m.clientCAs = clientCAs
+	m.identities = identities
+	m.modTimes = modTimes
+	m.Unlock()
+
+	m.log.Info("Loaded the TLS certificate from %s with %d client identities", m.certFile, len(identities))
+
+	return true, nil
+}
+
+// Run checks the files for changes in each interval until stop receives a value
+func (m *TLSManager) Run(stop chan bool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			m.log.Info("Stopped TLS reload")
+			return
+		case <-ticker.C:
+			if _, err := m.Reload(); err != nil {
+				m.log.Error("Error reloading the TLS files. Keeping the current ones: %s", err)
+			}
+		}
+	}
+}
+
+// ReloadInterval returns the TLS_RELOAD_INTERVAL
+func (m *TLSManager) ReloadInterval() time.Duration {
+	d, err := time.ParseDuration(config.TLSReloadInterval)
+	if err != nil || d <= 0 {
+		m.log.Error("Invalid TLS_RELOAD_INTERVAL %q. Using %s", config.TLSReloadInterval, defaultTLSReloadInterval)
+		return defaultTLSReloadInterval
+	}
+
+	return d
+}
+
+// ClientAuthentication returns if the clients are authenticated by certificate
+func (m *TLSManager) ClientAuthentication() bool {
+	return m.clientCAFile != ""
+}
+
+func (m *TLSManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.certificate, nil
+}
+
+// TLSConfig returns the server TLS configuration, which always uses the last loaded certificate and client CAs
+func (m *TLSManager) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.getCertificate,
+	}
+
+	if !m.ClientAuthentication() {
+		return cfg
+	}
+
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		m.RLock()
+		clientCAs := m.clientCAs
+		m.RUnlock()
+
+		clientAuth := tls.VerifyClientCertIfGiven
+		if m.requireClientCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: m.getCertificate,
+			ClientCAs:      clientCAs,
+			ClientAuth:     clientAuth,
+		}, nil
+	}
+
+	return cfg
+}
+
+// certificateIdentity returns the SPIFFE ID of the certificate or else its subject
+func certificateIdentity(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+
+	return cert.Subject.String()
+}
+
+// Identity returns the identity of the verified client certificate of the request
+func (m *TLSManager) Identity(r *http.Request) (models.TLSIdentity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return models.TLSIdentity{}, false
+	}
+
+	name := certificateIdentity(r.TLS.VerifiedChains[0][0])
+
+	m.RLock()
+	identity, ok := m.identities[name]
+	m.RUnlock()
+
+	return identity, ok
+}
+
+func hasPathPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware requires a known client identity in the protected endpoints and stores it in the request context
+func (m *TLSManager) Middleware(log slog.Instance) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := strings.TrimPrefix(r.URL.Path, "/remoteSigner")
+			if !hasPathPrefix(path, tlsProtectedPaths) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The cluster peers do not have a client identity. Their signature is verified by the endpoint
+			if hasPathPrefix(path, tlsClusterPaths) && r.Header.Get(keymagic.ClusterSignatureHeader) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			identity, ok := m.Identity(r)
+			if !ok {
+				InitHTTPTimer(log, r)
+				PermissionDenied("certificate", "A client certificate of a known identity is required", w, r, log)
+				return
+			}
+
+			if hasPathPrefix(path, tlsAdminPaths) && !identity.Admin {
+				InitHTTPTimer(log, r)
+				PermissionDenied("certificate", fmt.Sprintf("The identity %s cannot use this endpoint", identity.Identity), w, r, log)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxTLSIdentity, identity)))
+		})
+	}
+}
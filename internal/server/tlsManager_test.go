@@ -0,0 +1,266 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/keymagic"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func makeTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.X509KeyPair(c.pem, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func writeTestFile(t *testing.T, filename string, data []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSManager(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	dir, err := ioutil.TempDir("", "chevron-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+
+	serverCert := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "chevron"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+
+	spiffeID, _ := url.Parse("spiffe://huebr.com/billing")
+	billingCert := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "billing"},
+		URIs:         []*url.URL{spiffeID},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	unknownCert := makeTestCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "unknown"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	serverKey, _ := x509.MarshalECPrivateKey(serverCert.key)
+	modTime := time.Now().Add(-time.Minute)
+
+	config.TLSCertFile = path.Join(dir, "server.pem")
+	config.TLSKeyFile = path.Join(dir, "server.key")
+	config.TLSClientCAFile = path.Join(dir, "ca.pem")
+	config.TLSIdentitiesFile = path.Join(dir, "identities.json")
+	config.TLSClientAuth = "optional"
+
+	writeTestFile(t, config.TLSCertFile, serverCert.pem, modTime)
+	writeTestFile(t, config.TLSKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: serverKey}), modTime)
+	writeTestFile(t, config.TLSClientCAFile, ca.pem, modTime)
+	writeTestFile(t, config.TLSIdentitiesFile, []byte(`[{"Identity": "spiffe://huebr.com/billing", "Keys": ["0551F452ABE463A4"]}]`), modTime)
+
+	tm, err := MakeTLSManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(tm.Middleware(log))
+	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.URL.Query().Get("key"); key != "" && !keyAllowed(r, key) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewUnstartedServer(r)
+	srv.TLS = tm.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	client := func(cert *testCertificate) *http.Client {
+		cfg := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			cfg.Certificates = []tls.Certificate{cert.tlsCertificate(t)}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	}
+
+	check := func(c *http.Client, path string, expected int) {
+		res, err := c.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Error requesting %s: %s", path, err)
+		}
+		_ = res.Body.Close()
+
+		if res.StatusCode != expected {
+			t.Errorf("Expected status %d for %s got %d", expected, path, res.StatusCode)
+		}
+	}
+
+	anonymous := client(nil)
+	billing := client(billingCert)
+	unknown := client(unknownCert)
+
+	// region Public endpoints do not require a certificate
+	check(anonymous, "/pks/lookup", http.StatusOK)
+	check(anonymous, "/gpg/sign", http.StatusBadRequest)
+	check(unknown, "/remoteSigner/gpg/sign", http.StatusBadRequest)
+	// endregion
+
+	// region Identity authorization
+	check(billing, "/gpg/sign?key=0551F452ABE463A4", http.StatusOK)
+	check(billing, "/remoteSigner/gpg/sign?key=FFFFFFFFFFFFFFFF", http.StatusForbidden)
+	check(billing, "/gpg/sign?key=ABE463A4", http.StatusForbidden)
+	check(billing, "/gpg/unlockKey", http.StatusBadRequest)
+	check(billing, "/remoteSigner/__internal/__getUnlockPasswords", http.StatusBadRequest)
+	// endregion
+
+	// region Cluster requests are verified by the internal endpoint
+	for _, c := range []*http.Client{anonymous, billing} {
+		req, _ := http.NewRequest("GET", srv.URL+"/remoteSigner/__internal/__getUnlockPasswords", nil)
+		req.Header.Set(keymagic.ClusterSignatureHeader, "huebr")
+
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("Expected the cluster request to reach the endpoint got %d", res.StatusCode)
+		}
+	}
+	// endregion
+
+	// region Reload
+	if reloaded, err := tm.Reload(); reloaded || err != nil {
+		t.Errorf("Expected no reload without changes got %v %v", reloaded, err)
+	}
+
+	writeTestFile(t, config.TLSIdentitiesFile, []byte(`[{"Identity": "spiffe://huebr.com/billing", "Keys": ["*"], "Admin": true}]`), modTime.Add(time.Second))
+
+	if reloaded, err := tm.Reload(); !reloaded || err != nil {
+		t.Fatalf("Expected the identities to be reloaded got %v %v", reloaded, err)
+	}
+
+	check(billing, "/gpg/unlockKey", http.StatusOK)
+	check(billing, "/gpg/sign?key=FFFFFFFFFFFFFFFF", http.StatusOK)
+
+	writeTestFile(t, config.TLSIdentitiesFile, []byte(`not json`), modTime.Add(2*time.Second))
+
+	if _, err := tm.Reload(); err == nil {
+		t.Errorf("Expected error reloading invalid identities")
+	}
+
+	writeTestFile(t, config.TLSIdentitiesFile, []byte(`[{"Identity": "spiffe://huebr.com/billing", "Keys": ["ABE463A4"]}]`), modTime.Add(3*time.Second))
+
+	if _, err := tm.Reload(); err == nil {
+		t.Errorf("Expected error reloading identities with short keys")
+	}
+
+	check(billing, "/gpg/unlockKey", http.StatusOK)
+	// endregion
+
+	// region Required client certificates
+	config.TLSClientAuth = "require"
+	writeTestFile(t, config.TLSIdentitiesFile, []byte(`[]`), modTime.Add(3*time.Second))
+
+	required, err := MakeTLSManager(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requiredSrv := httptest.NewUnstartedServer(r)
+	requiredSrv.TLS = required.TLSConfig()
+	requiredSrv.StartTLS()
+	defer requiredSrv.Close()
+
+	if _, err := anonymous.Get(requiredSrv.URL + "/pks/lookup"); err == nil {
+		t.Errorf("Expected the connection without certificate to be rejected")
+	}
+	// endregion
+}
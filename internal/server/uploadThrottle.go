@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quan-to/chevron/internal/config"
+	"golang.org/x/time/rate"
+)
+
+const uploadThrottleCleanupInterval = 10 * time.Minute
+
+type uploadLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// uploadThrottle limits the key uploads per client IP to PKS_UPLOAD_RATE_LIMIT per minute
+type uploadThrottle struct {
+	sync.Mutex
+	limiters    map[string]*uploadLimiter
+	lastCleanup time.Time
+}
+
+var keyUploadThrottle = &uploadThrottle{
+	limiters: map[string]*uploadLimiter{},
+}
+
+// remoteIP returns the client IP address of the request. If PKS_UPLOAD_CLIENT_IP_HEADER is set,
+// the last address of that header is used, since it is the one added by the trusted proxy
+func remoteIP(r *http.Request) string {
+	if config.PKSUploadClientIPHeader != "" {
+		if values := r.Header[http.CanonicalHeaderKey(config.PKSUploadClientIPHeader)]; len(values) > 0 {
+			addresses := strings.Split(values[len(values)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(addresses[len(addresses)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// limitKeyUploadBody stops reading the key upload body after PKS_MAX_KEY_SIZE bytes. Form and JSON encodings
+// can use up to three bytes for each key byte, so that is allowed in the body
+func limitKeyUploadBody(w http.ResponseWriter, r *http.Request) {
+	if config.PKSMaxKeySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(config.PKSMaxKeySize)*3)
+	}
+}
+
+// Allow returns if the client of the request can upload a key now
+func (t *uploadThrottle) Allow(r *http.Request) bool {
+	if config.PKSUploadRateLimit <= 0 {
+		return true
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastCleanup) > uploadThrottleCleanupInterval {
+		for ip, l := range t.limiters {
+			if now.Sub(l.lastSeen) > uploadThrottleCleanupInterval {
+				delete(t.limiters, ip)
+			}
+		}
+		t.lastCleanup = now
+	}
+
+	ip := remoteIP(r)
+	l, ok := t.limiters[ip]
+	limit := rate.Every(time.Minute / time.Duration(config.PKSUploadRateLimit))
+	if !ok || l.limiter.Limit() != limit {
+		l = &uploadLimiter{
+			limiter: rate.NewLimiter(limit, config.PKSUploadRateLimit),
+		}
+		t.limiters[ip] = l
+	}
+
+	l.lastSeen = now
+
+	return l.limiter.AllowN(now, 1)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quan-to/chevron/internal/config"
+)
+
+func TestUploadThrottle(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	throttle := &uploadThrottle{limiters: map[string]*uploadLimiter{}}
+
+	req := httptest.NewRequest("POST", "/pks/add", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	other := httptest.NewRequest("POST", "/pks/add", nil)
+	other.RemoteAddr = "10.0.0.2:1234"
+
+	config.PKSUploadRateLimit = 0
+	for i := 0; i < 10; i++ {
+		if !throttle.Allow(req) {
+			t.Fatalf("Expected no throttle when the rate limit is disabled")
+		}
+	}
+
+	config.PKSUploadRateLimit = 2
+	for i := 0; i < 2; i++ {
+		if !throttle.Allow(req) {
+			t.Fatalf("Expected upload %d to be allowed", i)
+		}
+	}
+
+	if throttle.Allow(req) {
+		t.Errorf("Expected the third upload to be throttled")
+	}
+
+	// Another client has its own limit
+	if !throttle.Allow(other) {
+		t.Errorf("Expected the upload from another ip to be allowed")
+	}
+}
+
+func TestRemoteIPHeader(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	req := httptest.NewRequest("POST", "/pks/add", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 192.168.0.10")
+
+	if ip := remoteIP(req); ip != "10.0.0.1" {
+		t.Errorf("Expected the connection address without PKS_UPLOAD_CLIENT_IP_HEADER got %s", ip)
+	}
+
+	config.PKSUploadClientIPHeader = "x-forwarded-for"
+
+	if ip := remoteIP(req); ip != "192.168.0.10" {
+		t.Errorf("Expected the address added by the proxy got %s", ip)
+	}
+
+	req.Header.Set("X-Forwarded-For", "invalid")
+
+	if ip := remoteIP(req); ip != "10.0.0.1" {
+		t.Errorf("Expected the connection address for an invalid header got %s", ip)
+	}
+}
+
+func TestLimitKeyUploadBody(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.PKSMaxKeySize = 10
+
+	req := httptest.NewRequest("POST", "/pks/add", strings.NewReader(strings.Repeat("A", 31)))
+	limitKeyUploadBody(httptest.NewRecorder(), req)
+
+	if _, err := ioutil.ReadAll(req.Body); err == nil {
+		t.Errorf("Expected a body over the limit to not be read")
+	}
+
+	req = httptest.NewRequest("POST", "/pks/add", strings.NewReader(strings.Repeat("A", 30)))
+	limitKeyUploadBody(httptest.NewRecorder(), req)
+
+	if _, err := ioutil.ReadAll(req.Body); err != nil {
+		t.Errorf("Expected a body under the limit to be read got %s", err)
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/quan-to/chevron/internal/keymagic"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/slog"
+	"net"
+	"net/http"
+	"regexp"
+)
+
+var wkdHashRegex = regexp.MustCompile(`^[ybndrfg8ejkmcpqxot1uwisza345h769]{32}$`)
+
+// WKDEndpoint serves the public keys of the PKS through the OpenPGP Web Key Directory
+type WKDEndpoint struct {
+	log slog.Instance
+}
+
+// MakeWKDEndpoint creates an instance of the Web Key Directory endpoints
+func MakeWKDEndpoint(log slog.Instance) *WKDEndpoint {
+	if log == nil {
+		log = slog.Scope("WKD")
+	} else {
+		log = log.SubScope("WKD")
+	}
+
+	return &WKDEndpoint{
+		log: log,
+	}
+}
+
+// AttachHandlers attaches the direct (/hu/{hash}) and advanced (/{domain}/hu/{hash}) layouts. Should be mounted at /.well-known/openpgpkey
+func (wkd *WKDEndpoint) AttachHandlers(r *mux.Router) {
+	r.HandleFunc("/policy", wkd.directPolicy).Methods("GET")
+	r.HandleFunc("/hu/{hash}", wkd.directKey).Methods("GET")
+	r.HandleFunc("/{domain}/policy", wkd.advancedPolicy).Methods("GET")
+	r.HandleFunc("/{domain}/hu/{hash}", wkd.advancedKey).Methods("GET")
+}
+
+// requestDomain returns the domain of the direct layout, which is the requested host
+func requestDomain(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return r.Host
+	}
+
+	return host
+}
+
+func (wkd *WKDEndpoint) directPolicy(w http.ResponseWriter, r *http.Request) {
+	wkd.policy(requestDomain(r), w, r)
+}
+
+func (wkd *WKDEndpoint) advancedPolicy(w http.ResponseWriter, r *http.Request) {
+	wkd.policy(mux.Vars(r)["domain"], w, r)
+}
+
+func (wkd *WKDEndpoint) directKey(w http.ResponseWriter, r *http.Request) {
+	wkd.key(requestDomain(r), mux.Vars(r)["hash"], w, r)
+}
+
+func (wkd *WKDEndpoint) advancedKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	wkd.key(vars["domain"], vars["hash"], w, r)
+}
+
+func (wkd *WKDEndpoint) policy(domain string, w http.ResponseWriter, r *http.Request) {
+	log := wrapLogWithRequestID(wkd.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if !keymagic.IsWKDDomain(domain) {
+		NotFound("domain", fmt.Sprintf("domain %s is not served by the web key directory", domain), w, r, log)
+		return
+	}
+
+	// An empty policy file means the default policy
+	w.Header().Set("Content-Type", models.MimeText)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(200)
+	LogExit(log, r, 200, 0)
+}
+
+func (wkd *WKDEndpoint) key(domain, hash string, w http.ResponseWriter, r *http.Request) {
+	ctx := wrapContextWithRequestID(r)
+	log := wrapLogWithRequestID(wkd.log, r)
+	InitHTTPTimer(log, r)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			CatchAllError(rec, w, r, log)
+		}
+	}()
+
+	if !keymagic.IsWKDDomain(domain) {
+		NotFound("domain", fmt.Sprintf("domain %s is not served by the web key directory", domain), w, r, log)
+		return
+	}
+
+	if !wkdHashRegex.MatchString(hash) {
+		NotFound("hash", "invalid web key directory hash", w, r, log)
+		return
+	}
+
+	data, err := keymagic.WKDGetKey(ctx, domain, hash, r.URL.Query().Get("l"))
+	if err != nil {
+		InternalServerError("There was an error fetching the key", err.Error(), w, r, log)
+		return
+	}
+
+	if data == nil {
+		NotFound("hash", "no key found", w, r, log)
+		return
+	}
+
+	w.Header().Set("Content-Type", models.MimeOctetStream)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(200)
+	n, _ := w.Write(data)
+	LogExit(log, r, 200, n)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/internal/tools"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/test"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWKD(t *testing.T) {
+	config.PushVariables()
+	defer config.PopVariables()
+
+	config.EnableRethinkSKS = true
+	config.WKDDomains = "huebr.com"
+
+	localPart := strings.Split(test.TestKeyEmail, "@")[0]
+	hash := tools.WKDHash(localPart)
+
+	// region Test Policy
+	req, err := http.NewRequest("GET", "/.well-known/openpgpkey/huebr.com/policy", nil)
+	errorDie(err, t)
+
+	res := executeRequest(req)
+
+	if res.Code != 200 {
+		errorDie(fmt.Errorf("expected policy to be served got status %d", res.Code), t)
+	}
+	// endregion
+	// region Test Advanced Layout
+	req, err = http.NewRequest("GET", "/.well-known/openpgpkey/huebr.com/hu/"+hash+"?l="+localPart, nil)
+	errorDie(err, t)
+
+	res = executeRequest(req)
+
+	if res.Code != 200 || res.Header().Get("Content-Type") != models.MimeOctetStream {
+		errorDie(fmt.Errorf("expected binary key got status %d: %s", res.Code, res.Body.String()), t)
+	}
+
+	keys, err := openpgp.ReadKeyRing(bytes.NewReader(res.Body.Bytes()))
+	errorDie(err, t)
+
+	if len(keys) != 1 || tools.ByteFingerPrint2FP16(keys[0].PrimaryKey.Fingerprint[:]) != test.TestKeyFingerprint {
+		errorDie(fmt.Errorf("expected key %s", test.TestKeyFingerprint), t)
+	}
+	// endregion
+	// region Test Direct Layout Without Local Part
+	req, err = http.NewRequest("GET", "/.well-known/openpgpkey/hu/"+hash, nil)
+	errorDie(err, t)
+	req.Host = "huebr.com:5100"
+
+	res = executeRequest(req)
+
+	if res.Code != 200 {
+		errorDie(fmt.Errorf("expected binary key got status %d: %s", res.Code, res.Body.String()), t)
+	}
+	// endregion
+	// region Test Unknown Local Part
+	req, err = http.NewRequest("GET", "/.well-known/openpgpkey/huebr.com/hu/"+tools.WKDHash("nobody"), nil)
+	errorDie(err, t)
+
+	res = executeRequest(req)
+
+	if res.Code != 404 {
+		errorDie(fmt.Errorf("expected status 404 got %d", res.Code), t)
+	}
+	// endregion
+	// region Test Domain Not Served
+	req, err = http.NewRequest("GET", "/.well-known/openpgpkey/example.com/hu/"+hash, nil)
+	errorDie(err, t)
+
+	res = executeRequest(req)
+
+	if res.Code != 404 {
+		errorDie(fmt.Errorf("expected status 404 got %d", res.Code), t)
+	}
+	// endregion
+}
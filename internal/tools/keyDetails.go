@@ -0,0 +1,202 @@
+package tools
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sigTypeCertificationRevocation is the signature type of a user ID certification revocation (RFC 4880 5.2.1)
+const sigTypeCertificationRevocation packet.SignatureType = 0x30
+
+var sigTypeNames = map[packet.SignatureType]string{
+	packet.SigTypeGenericCert:      "generic certification",
+	packet.SigTypePersonaCert:      "persona certification",
+	packet.SigTypeCasualCert:       "casual certification",
+	packet.SigTypePositiveCert:     "positive certification",
+	packet.SigTypeSubkeyBinding:    "subkey binding",
+	packet.SigTypeKeyRevocation:    "key revocation",
+	packet.SigTypeSubkeyRevocation: "subkey revocation",
+	sigTypeCertificationRevocation: "certification revocation",
+}
+
+var revocationReasonNames = map[uint8]string{
+	0:  "no reason specified",
+	1:  "key is superseded",
+	2:  "key material has been compromised",
+	3:  "key is retired and no longer used",
+	32: "user ID information is no longer valid",
+}
+
+func sigTypeName(sigType packet.SignatureType) string {
+	if name, ok := sigTypeNames[sigType]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown (0x%02x)", uint8(sigType))
+}
+
+func keyExpirationTime(creation time.Time, sig *packet.Signature) *time.Time {
+	if sig == nil || sig.KeyLifetimeSecs == nil || *sig.KeyLifetimeSecs == 0 {
+		return nil
+	}
+
+	t := creation.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+
+	return &t
+}
+
+// keyCapabilities returns the sign, encrypt and certify capabilities from the signature flags, or from the algorithm if there are no flags
+func keyCapabilities(algo packet.PublicKeyAlgorithm, sig *packet.Signature) (canSign, canEncrypt, canCertify bool) {
+	if sig != nil && sig.FlagsValid {
+		return sig.FlagSign, sig.FlagEncryptCommunications || sig.FlagEncryptStorage, sig.FlagCertify
+	}
+
+	return algo.CanSign(), algo.CanEncrypt(), algo.CanSign()
+}
+
+// subKeyCapabilities returns the capabilities of a subkey. Revoked or expired subkeys have none, and the algorithm is only
+// used when neither the subkey nor the primary key carry flags
+func subKeyCapabilities(sub openpgp.Subkey, primarySig *packet.Signature, now time.Time) (canSign, canEncrypt, canCertify bool) {
+	if subKeyRevoked(sub) || subKeyExpired(sub, now) {
+		return false, false, false
+	}
+
+	if (sub.Sig == nil || !sub.Sig.FlagsValid) && primarySig != nil && primarySig.FlagsValid {
+		return false, false, false
+	}
+
+	return keyCapabilities(sub.PublicKey.PubKeyAlgo, sub.Sig)
+}
+
+func subKeyRevoked(sub openpgp.Subkey) bool {
+	return sub.Sig != nil && sub.Sig.SigType == packet.SigTypeSubkeyRevocation
+}
+
+func subKeyExpired(sub openpgp.Subkey, now time.Time) bool {
+	expiration := keyExpirationTime(sub.PublicKey.CreationTime, sub.Sig)
+	return expiration != nil && expiration.Before(now)
+}
+
+func signatureDetails(sig *packet.Signature) models.KeySignatureDetails {
+	d := models.KeySignatureDetails{
+		Type:             sigTypeName(sig.SigType),
+		Hash:             sig.Hash.String(),
+		CreationTime:     sig.CreationTime,
+		RevocationReason: sig.RevocationReasonText,
+	}
+
+	if sig.IssuerKeyId != nil {
+		d.IssuerKeyID = IssuerKeyIdToFP16(*sig.IssuerKeyId)
+	}
+
+	if sig.RevocationReason != nil && d.RevocationReason == "" {
+		d.RevocationReason = revocationReasonNames[*sig.RevocationReason]
+	}
+
+	return d
+}
+
+// primarySelfSignature returns the self signature of the primary user ID, or of any user ID if none is marked as primary
+func primarySelfSignature(e *openpgp.Entity) *packet.Signature {
+	var sig *packet.Signature
+
+	for _, id := range e.Identities {
+		if id.SelfSignature == nil {
+			continue
+		}
+
+		if id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+			return id.SelfSignature
+		}
+
+		if sig == nil {
+			sig = id.SelfSignature
+		}
+	}
+
+	return sig
+}
+
+// GetKeyDetails parses the algorithms, capabilities, expiration, user IDs, certifications and revocations of the entity
+func GetKeyDetails(e *openpgp.Entity) models.KeyDetails {
+	bits, _ := e.PrimaryKey.BitLength()
+	selfSig := primarySelfSignature(e)
+	now := time.Now()
+
+	details := models.KeyDetails{
+		FingerPrint:        strings.ToUpper(fmt.Sprintf("%x", e.PrimaryKey.Fingerprint[:])),
+		KeyID:              ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:]),
+		Algorithm:          models.PubKeyAlgoName(e.PrimaryKey.PubKeyAlgo),
+		Bits:               int(bits),
+		CreationTime:       e.PrimaryKey.CreationTime,
+		ExpirationTime:     keyExpirationTime(e.PrimaryKey.CreationTime, selfSig),
+		Revoked:            len(e.Revocations) > 0,
+		Revocations:        make([]models.KeySignatureDetails, 0),
+		ContainsPrivateKey: e.PrivateKey != nil,
+		SubKeys:            make([]models.SubKeyDetails, 0),
+		UserIDs:            make([]models.UserIDDetails, 0),
+	}
+
+	details.CanSign, details.CanEncrypt, details.CanCertify = keyCapabilities(e.PrimaryKey.PubKeyAlgo, selfSig)
+
+	for _, sig := range e.Revocations {
+		details.Revocations = append(details.Revocations, signatureDetails(sig))
+	}
+
+	for _, sub := range e.Subkeys {
+		subBits, _ := sub.PublicKey.BitLength()
+		subDetails := models.SubKeyDetails{
+			FingerPrint:    strings.ToUpper(fmt.Sprintf("%x", sub.PublicKey.Fingerprint[:])),
+			KeyID:          ByteFingerPrint2FP16(sub.PublicKey.Fingerprint[:]),
+			Algorithm:      models.PubKeyAlgoName(sub.PublicKey.PubKeyAlgo),
+			Bits:           int(subBits),
+			CreationTime:   sub.PublicKey.CreationTime,
+			ExpirationTime: keyExpirationTime(sub.PublicKey.CreationTime, sub.Sig),
+			Revoked:        subKeyRevoked(sub),
+		}
+
+		subDetails.CanSign, subDetails.CanEncrypt, subDetails.CanCertify = subKeyCapabilities(sub, selfSig, now)
+		details.SubKeys = append(details.SubKeys, subDetails)
+	}
+
+	for _, id := range e.Identities {
+		uid := models.UserIDDetails{
+			UserID:         id.Name,
+			Certifications: make([]models.KeySignatureDetails, 0),
+		}
+
+		if id.UserId != nil {
+			uid.Name = id.UserId.Name
+			uid.Email = id.UserId.Email
+			uid.Comment = id.UserId.Comment
+		}
+
+		if id.SelfSignature != nil {
+			uid.SelfSignatureHash = id.SelfSignature.Hash.String()
+			uid.Primary = id.SelfSignature == selfSig
+		}
+
+		for _, sig := range id.Signatures {
+			if sig.SigType == sigTypeCertificationRevocation && sig.IssuerKeyId != nil && *sig.IssuerKeyId == e.PrimaryKey.KeyId {
+				uid.Revoked = true
+			}
+			uid.Certifications = append(uid.Certifications, signatureDetails(sig))
+		}
+
+		details.UserIDs = append(details.UserIDs, uid)
+	}
+
+	sort.Slice(details.UserIDs, func(i, j int) bool {
+		if details.UserIDs[i].Primary != details.UserIDs[j].Primary {
+			return details.UserIDs[i].Primary
+		}
+		return details.UserIDs[i].UserID < details.UserIDs[j].UserID
+	})
+
+	return details
+}
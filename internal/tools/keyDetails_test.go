@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"github.com/quan-to/chevron/test"
+	"testing"
+	"time"
+)
+
+func TestGetKeyDetails(t *testing.T) {
+	e, err := ReadKeyToEntity(test.TestPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := GetKeyDetails(e)
+
+	if d.KeyID != test.TestKeyFingerprint || d.Algorithm != "RSA" || d.ContainsPrivateKey {
+		t.Errorf("Unexpected key details: %+v", d)
+	}
+
+	if !d.CanSign || !d.CanCertify || d.CanEncrypt {
+		t.Errorf("Expected primary key to only sign and certify: %+v", d)
+	}
+
+	if len(d.SubKeys) != 1 || !d.SubKeys[0].CanEncrypt || d.SubKeys[0].CanSign {
+		t.Errorf("Expected one encryption subkey got %+v", d.SubKeys)
+	}
+
+	if len(d.UserIDs) != 1 || d.UserIDs[0].Email != test.TestKeyEmail || !d.UserIDs[0].Primary || d.UserIDs[0].SelfSignatureHash != "SHA-256" {
+		t.Errorf("Unexpected user IDs: %+v", d.UserIDs)
+	}
+}
+
+func TestGetKeyDetailsCertifications(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+
+	e, err := openpgp.NewEntity("Owner", "", "owner@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := openpgp.NewEntity("Signer", "", "signer@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lifetime := uint32(3600)
+	for _, id := range e.Identities {
+		id.SelfSignature.KeyLifetimeSecs = &lifetime
+	}
+
+	err = e.SignIdentity("Owner <owner@huebr.com>", signer, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := GetKeyDetails(e)
+
+	if !d.ContainsPrivateKey {
+		t.Errorf("Expected key to contain private key")
+	}
+
+	if d.ExpirationTime == nil || d.ExpirationTime.Sub(d.CreationTime) != time.Hour {
+		t.Errorf("Expected key to expire in one hour, got %v", d.ExpirationTime)
+	}
+
+	if len(d.UserIDs) != 1 || len(d.UserIDs[0].Certifications) != 1 {
+		t.Fatalf("Expected one certification got %+v", d.UserIDs)
+	}
+
+	signerID := ByteFingerPrint2FP16(signer.PrimaryKey.Fingerprint[:])
+	if c := d.UserIDs[0].Certifications[0]; c.IssuerKeyID != signerID || c.Type != "generic certification" {
+		t.Errorf("Expected a generic certification by %s got %+v", signerID, c)
+	}
+
+	if d.Revoked || d.UserIDs[0].Revoked {
+		t.Errorf("Expected key to not be revoked")
+	}
+}
+
+func TestGetKeyDetailsSubKeyCapabilities(t *testing.T) {
+	e, err := openpgp.NewEntity("Owner", "", "owner@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := GetKeyDetails(e); len(d.SubKeys) != 1 || !d.SubKeys[0].CanEncrypt {
+		t.Fatalf("Expected one encryption subkey got %+v", d.SubKeys)
+	}
+
+	sub := &e.Subkeys[0]
+
+	lifetime := uint32(3600)
+	sub.Sig.KeyLifetimeSecs = &lifetime
+	sub.PublicKey.CreationTime = time.Now().Add(-2 * time.Hour)
+
+	if d := GetKeyDetails(e); d.SubKeys[0].CanEncrypt || d.SubKeys[0].CanSign {
+		t.Errorf("Expected expired subkey to have no capabilities got %+v", d.SubKeys[0])
+	}
+
+	sub.Sig.KeyLifetimeSecs = nil
+	sub.Sig.FlagsValid = false
+
+	if d := GetKeyDetails(e); d.SubKeys[0].CanEncrypt || d.SubKeys[0].CanSign {
+		t.Errorf("Expected subkey without flags to not use the algorithm when the primary key has flags got %+v", d.SubKeys[0])
+	}
+
+	sub.Sig.FlagsValid = true
+	sub.Sig.SigType = packet.SigTypeSubkeyRevocation
+
+	if d := GetKeyDetails(e); !d.SubKeys[0].Revoked || d.SubKeys[0].CanEncrypt || d.SubKeys[0].CanSign {
+		t.Errorf("Expected revoked subkey to have no capabilities got %+v", d.SubKeys[0])
+	}
+}
@@ -0,0 +1,37 @@
+package tools
+
+import (
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"time"
+)
+
+// GetKeyExpiration returns the key or subkey of the entity that expires first and its expiration time.
+// Subkeys that are revoked or already expired at now are ignored since they are usually replaced by newer ones.
+// Returns a nil expiration if the key never expires
+func GetKeyExpiration(e *openpgp.Entity, now time.Time) (keyID string, expiration *time.Time) {
+	keyID = ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+	expiration = keyExpirationTime(e.PrimaryKey.CreationTime, primarySelfSignature(e))
+
+	if expiration != nil && expiration.Before(now) {
+		return keyID, expiration
+	}
+
+	for _, sub := range e.Subkeys {
+		if sub.Sig != nil && sub.Sig.SigType == packet.SigTypeSubkeyRevocation {
+			continue
+		}
+
+		subExpiration := keyExpirationTime(sub.PublicKey.CreationTime, sub.Sig)
+		if subExpiration == nil || subExpiration.Before(now) {
+			continue
+		}
+
+		if expiration == nil || subExpiration.Before(*expiration) {
+			keyID = ByteFingerPrint2FP16(sub.PublicKey.Fingerprint[:])
+			expiration = subExpiration
+		}
+	}
+
+	return keyID, expiration
+}
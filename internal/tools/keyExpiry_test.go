@@ -0,0 +1,35 @@
+package tools
+
+import (
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"testing"
+	"time"
+)
+
+func TestGetKeyExpiration(t *testing.T) {
+	e, err := openpgp.NewEntity("Expiring", "", "expiring@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyID, expiration := GetKeyExpiration(e, time.Now())
+	if expiration != nil || keyID != ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:]) {
+		t.Errorf("Expected key to never expire got %s %v", keyID, expiration)
+	}
+
+	subLifetime := uint32(3600)
+	e.Subkeys[0].Sig.KeyLifetimeSecs = &subLifetime
+
+	subKeyID := ByteFingerPrint2FP16(e.Subkeys[0].PublicKey.Fingerprint[:])
+	keyID, expiration = GetKeyExpiration(e, time.Now())
+	if expiration == nil || keyID != subKeyID {
+		t.Errorf("Expected subkey %s to expire first got %s %v", subKeyID, keyID, expiration)
+	}
+
+	// Expired subkeys are ignored
+	keyID, expiration = GetKeyExpiration(e, time.Now().Add(2*time.Hour))
+	if expiration != nil {
+		t.Errorf("Expected expired subkey to be ignored got %s %v", keyID, expiration)
+	}
+}
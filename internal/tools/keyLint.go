@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"time"
+)
+
+// minS2KCount is the minimum iteration count of a private key S2K recommended by RFC 4880 3.7.1.3
+const minS2KCount = 65536
+
+var keyLintSeverityLevels = map[string]int{
+	models.KeyLintInfo:    1,
+	models.KeyLintWarning: 2,
+	models.KeyLintError:   3,
+}
+
+// KeyLintSeverityLevel returns the numeric level of the severity. Higher is more severe, 0 for unknown severities
+func KeyLintSeverityLevel(severity string) int {
+	return keyLintSeverityLevels[severity]
+}
+
+type keyLinter struct {
+	result models.KeyLintResult
+}
+
+func (l *keyLinter) add(severity, code, keyID, format string, args ...interface{}) {
+	l.result.Issues = append(l.result.Issues, models.KeyLintIssue{
+		Code:     code,
+		Severity: severity,
+		KeyID:    keyID,
+		Message:  fmt.Sprintf(format, args...),
+	})
+
+	if KeyLintSeverityLevel(severity) > KeyLintSeverityLevel(l.result.Severity) {
+		l.result.Severity = severity
+	}
+}
+
+func isRSA(algo packet.PublicKeyAlgorithm) bool {
+	return algo == packet.PubKeyAlgoRSA || algo == packet.PubKeyAlgoRSAEncryptOnly || algo == packet.PubKeyAlgoRSASignOnly
+}
+
+func (l *keyLinter) lintKeySize(keyID string, pub *packet.PublicKey, minKeyBits int) {
+	bits, _ := pub.BitLength()
+	if isRSA(pub.PubKeyAlgo) && int(bits) < minKeyBits {
+		l.add(models.KeyLintError, "RSA_KEY_TOO_SMALL", keyID, "RSA key has %d bits, the minimum is %d", bits, minKeyBits)
+	}
+}
+
+func (l *keyLinter) lintSignatureHash(keyID, what string, sig *packet.Signature) {
+	if sig == nil {
+		return
+	}
+
+	switch sig.Hash {
+	case crypto.MD5:
+		l.add(models.KeyLintError, "MD5_SIGNATURE", keyID, "%s uses MD5", what)
+	case crypto.SHA1:
+		l.add(models.KeyLintWarning, "SHA1_SIGNATURE", keyID, "%s uses SHA-1", what)
+	}
+}
+
+func (l *keyLinter) lintExpiration(keyID string, primary bool, expiration *time.Time, expiringIn time.Duration, now time.Time) {
+	if expiration == nil {
+		return
+	}
+
+	if expiration.Before(now) {
+		if primary {
+			l.add(models.KeyLintError, "KEY_EXPIRED", keyID, "key expired at %s", expiration)
+		} else {
+			l.add(models.KeyLintWarning, "SUBKEY_EXPIRED", keyID, "subkey expired at %s", expiration)
+		}
+	} else if expiration.Before(now.Add(expiringIn)) {
+		l.add(models.KeyLintWarning, "KEY_EXPIRING_SOON", keyID, "key expires at %s", expiration)
+	}
+}
+
+func (l *keyLinter) lintS2K(keyID string, pk *packet.PrivateKey) {
+	if pk == nil {
+		return
+	}
+
+	if !pk.Encrypted {
+		l.add(models.KeyLintWarning, "UNENCRYPTED_PRIVATE_KEY", keyID, "private key is not encrypted with a password")
+		return
+	}
+
+	s2kType, s2kConfig, cipher := pk.S2KParams()
+
+	switch s2kConfig.S2KMode {
+	case 0, 1:
+		l.add(models.KeyLintError, "WEAK_S2K_MODE", keyID, "private key password is not iterated (S2K mode %d)", s2kConfig.S2KMode)
+	case 3:
+		if s2kConfig.S2KCount < minS2KCount {
+			l.add(models.KeyLintWarning, "WEAK_S2K_COUNT", keyID, "private key password has only %d S2K iterations, at least %d are recommended", s2kConfig.S2KCount, minS2KCount)
+		}
+	}
+
+	if s2kConfig.Hash == crypto.MD5 {
+		l.add(models.KeyLintWarning, "WEAK_S2K_HASH", keyID, "private key password is hashed with MD5")
+	}
+
+	if s2kType == packet.S2KCHECKSUM {
+		l.add(models.KeyLintWarning, "WEAK_S2K_CHECKSUM", keyID, "private key uses a simple checksum instead of SHA-1 for integrity")
+	}
+
+	if cipher == packet.Cipher3DES || cipher == packet.CipherCAST5 {
+		l.add(models.KeyLintWarning, "WEAK_S2K_CIPHER", keyID, "private key is encrypted with a legacy cipher (%d)", cipher)
+	}
+}
+
+// LintEntity checks the entity for small RSA keys, weak self-signature hashes, expired or expiring keys,
+// missing encryption subkeys and weak private key encryption
+func LintEntity(e *openpgp.Entity, minKeyBits int, expiringIn time.Duration, now time.Time) models.KeyLintResult {
+	keyID := ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+	selfSig := primarySelfSignature(e)
+
+	l := &keyLinter{
+		result: models.KeyLintResult{
+			FingerPrint: keyID,
+			Issues:      make([]models.KeyLintIssue, 0),
+		},
+	}
+
+	l.lintKeySize(keyID, e.PrimaryKey, minKeyBits)
+	l.lintExpiration(keyID, true, keyExpirationTime(e.PrimaryKey.CreationTime, selfSig), expiringIn, now)
+	l.lintS2K(keyID, e.PrivateKey)
+
+	if len(e.Revocations) > 0 {
+		l.add(models.KeyLintError, "KEY_REVOKED", keyID, "key is revoked")
+	}
+
+	for _, id := range e.Identities {
+		l.lintSignatureHash(keyID, fmt.Sprintf("Self-signature of %q", id.Name), id.SelfSignature)
+	}
+
+	_, canEncrypt, _ := keyCapabilities(e.PrimaryKey.PubKeyAlgo, selfSig)
+
+	for _, sub := range e.Subkeys {
+		subKeyID := ByteFingerPrint2FP16(sub.PublicKey.Fingerprint[:])
+		expiration := keyExpirationTime(sub.PublicKey.CreationTime, sub.Sig)
+
+		l.lintKeySize(subKeyID, sub.PublicKey, minKeyBits)
+		l.lintSignatureHash(subKeyID, "Subkey binding signature", sub.Sig)
+		l.lintExpiration(subKeyID, false, expiration, expiringIn, now)
+		l.lintS2K(subKeyID, sub.PrivateKey)
+
+		_, subCanEncrypt, _ := subKeyCapabilities(sub, selfSig, now)
+
+		if subCanEncrypt {
+			canEncrypt = true
+		}
+	}
+
+	if !canEncrypt {
+		l.add(models.KeyLintWarning, "NO_ENCRYPTION_SUBKEY", keyID, "key does not have a valid encryption subkey")
+	}
+
+	return l.result
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"github.com/quan-to/chevron/test"
+	"testing"
+	"time"
+)
+
+func hasLintIssue(result models.KeyLintResult, code string) bool {
+	for _, issue := range result.Issues {
+		if issue.Code == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestLintEntity(t *testing.T) {
+	e, err := ReadKeyToEntity(test.TestPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := LintEntity(e, 2048, time.Hour, time.Now())
+
+	if result.FingerPrint != test.TestKeyFingerprint {
+		t.Errorf("Expected fingerprint %s got %s", test.TestKeyFingerprint, result.FingerPrint)
+	}
+
+	if len(result.Issues) != 0 {
+		t.Errorf("Expected no issues got %+v", result.Issues)
+	}
+}
+
+func TestLintEntityIssues(t *testing.T) {
+	e, err := openpgp.NewEntity("Weak", "", "weak@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lifetime := uint32(3600)
+	for _, id := range e.Identities {
+		id.SelfSignature.KeyLifetimeSecs = &lifetime
+	}
+
+	result := LintEntity(e, 2048, 24*time.Hour, time.Now())
+
+	for _, code := range []string{"RSA_KEY_TOO_SMALL", "KEY_EXPIRING_SOON", "UNENCRYPTED_PRIVATE_KEY"} {
+		if !hasLintIssue(result, code) {
+			t.Errorf("Expected issue %s got %+v", code, result.Issues)
+		}
+	}
+
+	if result.Severity != models.KeyLintError {
+		t.Errorf("Expected severity %s got %s", models.KeyLintError, result.Severity)
+	}
+
+	result = LintEntity(e, 1024, 24*time.Hour, time.Now().Add(2*time.Hour))
+
+	if !hasLintIssue(result, "KEY_EXPIRED") || hasLintIssue(result, "RSA_KEY_TOO_SMALL") {
+		t.Errorf("Expected only an expired key got %+v", result.Issues)
+	}
+}
@@ -0,0 +1,896 @@
+package tools
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/quan-to/chevron/internal/models"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenPGP packet tags used in certificates (RFC 4880 4.3)
+const (
+	tagSignature     = 2
+	tagSecretKey     = 5
+	tagPublicKey     = 6
+	tagSecretSubkey  = 7
+	tagUserID        = 13
+	tagPublicSubkey  = 14
+	tagUserAttribute = 17
+)
+
+// certSignature is a signature packet of a certificate with the parsed fields needed for merging
+type certSignature struct {
+	raw          *packet.OpaquePacket
+	parsed       packet.Packet
+	sigType      packet.SignatureType
+	issuer       uint64
+	creationTime time.Time
+	// verified is true if the signature is issued by the key and was verified against it
+	verified bool
+}
+
+// certComponent is a user ID, user attribute or subkey packet followed by its signatures
+type certComponent struct {
+	raw  *packet.OpaquePacket
+	sigs []*certSignature
+}
+
+// certificate is a transferable public key split in its components (RFC 4880 11.1)
+type certificate struct {
+	primary   *packet.OpaquePacket
+	publicKey *packet.PublicKey
+	keyID     uint64
+	keySigs   []*certSignature
+	userIDs   []*certComponent
+	subKeys   []*certComponent
+}
+
+func packetKey(op *packet.OpaquePacket) string {
+	return fmt.Sprintf("%d:%x", op.Tag, op.Contents)
+}
+
+// publicKeyPacket returns the public part of a secret key or subkey packet
+func publicKeyPacket(op *packet.OpaquePacket) (*packet.OpaquePacket, error) {
+	if op.Tag != tagSecretKey && op.Tag != tagSecretSubkey {
+		return op, nil
+	}
+
+	p, err := op.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	pk, ok := p.(*packet.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid secret key packet")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := pk.PublicKey.Serialize(buf); err != nil {
+		return nil, err
+	}
+
+	return packet.NewOpaqueReader(buf).Next()
+}
+
+func parseCertSignature(op *packet.OpaquePacket) (*certSignature, error) {
+	p, err := op.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &certSignature{raw: op, parsed: p}
+
+	switch s := p.(type) {
+	case *packet.Signature:
+		sig.sigType = s.SigType
+		sig.creationTime = s.CreationTime
+		if s.IssuerKeyId != nil {
+			sig.issuer = *s.IssuerKeyId
+		}
+	case *packet.SignatureV3:
+		sig.sigType = s.SigType
+		sig.creationTime = s.CreationTime
+		sig.issuer = s.IssuerKeyId
+	default:
+		return nil, fmt.Errorf("invalid signature packet")
+	}
+
+	return sig, nil
+}
+
+// parseCertificate reads the first certificate of the armored data. Private key material is dropped
+func parseCertificate(armored string) (*certificate, error) {
+	block, err := armor.Decode(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &certificate{}
+	reader := packet.NewOpaqueReader(block.Body)
+
+	var current *certComponent
+
+	for {
+		op, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Tag {
+		case tagPublicKey, tagSecretKey:
+			if cert.primary != nil {
+				// Only the first certificate is read
+				return cert, nil
+			}
+
+			cert.primary, err = publicKeyPacket(op)
+			if err != nil {
+				return nil, err
+			}
+
+			p, err := cert.primary.Parse()
+			if err != nil {
+				return nil, err
+			}
+
+			pk, ok := p.(*packet.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("unsupported primary key packet")
+			}
+
+			cert.publicKey = pk
+			cert.keyID = pk.KeyId
+		case tagPublicSubkey, tagSecretSubkey:
+			op, err = publicKeyPacket(op)
+			if err != nil {
+				return nil, err
+			}
+			current = &certComponent{raw: op}
+			cert.subKeys = append(cert.subKeys, current)
+		case tagUserID, tagUserAttribute:
+			current = &certComponent{raw: op}
+			cert.userIDs = append(cert.userIDs, current)
+		case tagSignature:
+			if cert.primary == nil {
+				return nil, fmt.Errorf("signature found before the primary key")
+			}
+
+			sig, err := parseCertSignature(op)
+			if err != nil {
+				return nil, err
+			}
+
+			if current == nil {
+				cert.keySigs = append(cert.keySigs, sig)
+			} else {
+				current.sigs = append(current.sigs, sig)
+			}
+		default:
+			// Trust and unknown packets are not kept
+		}
+	}
+
+	if cert.primary == nil {
+		return nil, fmt.Errorf("no public key found")
+	}
+
+	return cert, nil
+}
+
+// userAttributeSignatureHash returns the hash of the data signed by a certification of a user attribute (RFC 4880 5.2.4)
+func userAttributeSignatureHash(primary, attribute *packet.OpaquePacket, hashFunc crypto.Hash) (hash.Hash, error) {
+	if !hashFunc.Available() {
+		return nil, fmt.Errorf("unsupported hash function")
+	}
+
+	h := hashFunc.New()
+
+	n := len(primary.Contents)
+	_, _ = h.Write([]byte{0x99, byte(n >> 8), byte(n)})
+	_, _ = h.Write(primary.Contents)
+
+	n = len(attribute.Contents)
+	_, _ = h.Write([]byte{0xd1, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	_, _ = h.Write(attribute.Contents)
+
+	return h, nil
+}
+
+// verifySignature checks a signature issued by the key against its primary key.
+// comp is the user ID, user attribute or subkey signed, or nil for the signatures of the primary key
+func (c *certificate) verifySignature(comp *certComponent, sig *certSignature) error {
+	switch s := sig.parsed.(type) {
+	case *packet.Signature:
+		if comp == nil {
+			// Direct key signatures and key revocations are made over the primary key only
+			return c.publicKey.VerifyRevocationSignature(s)
+		}
+
+		switch comp.raw.Tag {
+		case tagUserID:
+			return c.publicKey.VerifyUserIdSignature(string(comp.raw.Contents), c.publicKey, s)
+		case tagUserAttribute:
+			h, err := userAttributeSignatureHash(c.primary, comp.raw, s.Hash)
+			if err != nil {
+				return err
+			}
+			return c.publicKey.VerifySignature(h, s)
+		default:
+			p, err := comp.raw.Parse()
+			if err != nil {
+				return err
+			}
+
+			subKey, ok := p.(*packet.PublicKey)
+			if !ok {
+				return fmt.Errorf("unsupported subkey packet")
+			}
+
+			return c.publicKey.VerifyKeySignature(subKey, s)
+		}
+	case *packet.SignatureV3:
+		if comp != nil && comp.raw.Tag == tagUserID {
+			return c.publicKey.VerifyUserIdSignatureV3(string(comp.raw.Contents), c.publicKey, s)
+		}
+	}
+
+	return fmt.Errorf("unsupported signature")
+}
+
+// verifySelfSignatures verifies the signatures that claim to be issued by the key itself
+func (c *certificate) verifySelfSignatures() {
+	verify := func(comp *certComponent, sigs []*certSignature) {
+		for _, sig := range sigs {
+			sig.verified = sig.issuer == c.keyID && c.verifySignature(comp, sig) == nil
+		}
+	}
+
+	verify(nil, c.keySigs)
+
+	for _, comps := range [][]*certComponent{c.userIDs, c.subKeys} {
+		for _, comp := range comps {
+			verify(comp, comp.sigs)
+		}
+	}
+}
+
+// isSelfSignature returns true for verified signatures made by the key itself that replace the previous ones of the same kind
+func (c *certificate) isSelfSignature(sig *certSignature) bool {
+	if !sig.verified {
+		return false
+	}
+
+	switch sig.sigType {
+	case packet.SigTypeGenericCert, packet.SigTypePersonaCert, packet.SigTypeCasualCert, packet.SigTypePositiveCert,
+		packet.SigTypeSubkeyBinding, packet.SigTypeDirectSignature:
+		return true
+	}
+
+	return false
+}
+
+func isRevocation(sig *certSignature) bool {
+	return sig.sigType == packet.SigTypeKeyRevocation || sig.sigType == packet.SigTypeSubkeyRevocation || sig.sigType == sigTypeCertificationRevocation
+}
+
+// mergeSignatures adds the signatures of incoming not in existing, keeping only the newest self-signature.
+// Incoming signatures that claim to be issued by the key but were not verified are dropped.
+// Returns the merged signatures and the number of added revocations, certifications, replaced self-signatures and dropped invalid signatures
+func (c *certificate) mergeSignatures(existing, incoming []*certSignature) (merged []*certSignature, revocations, certifications, selfSignatures, invalid int) {
+	seen := map[string]bool{}
+	merged = make([]*certSignature, 0, len(existing)+len(incoming))
+
+	for _, sig := range existing {
+		seen[packetKey(sig.raw)] = true
+		merged = append(merged, sig)
+	}
+
+	var newestSelf *certSignature
+
+	for _, sig := range merged {
+		if c.isSelfSignature(sig) && (newestSelf == nil || sig.creationTime.After(newestSelf.creationTime)) {
+			newestSelf = sig
+		}
+	}
+
+	for _, sig := range incoming {
+		key := packetKey(sig.raw)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if sig.issuer == c.keyID && !sig.verified {
+			invalid++
+			continue
+		}
+
+		switch {
+		case c.isSelfSignature(sig):
+			if newestSelf != nil && !sig.creationTime.After(newestSelf.creationTime) {
+				continue
+			}
+			selfSignatures++
+			newestSelf = sig
+		case isRevocation(sig):
+			revocations++
+		default:
+			certifications++
+		}
+
+		merged = append(merged, sig)
+	}
+
+	// Only the newest self-signature is kept, revocations first so they are seen by single signature readers
+	result := make([]*certSignature, 0, len(merged))
+	for _, sig := range merged {
+		if isRevocation(sig) {
+			result = append(result, sig)
+		}
+	}
+
+	if newestSelf != nil {
+		result = append(result, newestSelf)
+	}
+
+	for _, sig := range merged {
+		if !isRevocation(sig) && !c.isSelfSignature(sig) {
+			result = append(result, sig)
+		}
+	}
+
+	return result, revocations, certifications, selfSignatures, invalid
+}
+
+// hasSelfSignature returns true if the component has a verified self-signature binding it to the key
+func (c *certificate) hasSelfSignature(comp *certComponent) bool {
+	for _, sig := range comp.sigs {
+		if c.isSelfSignature(sig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeComponents merges the incoming components into existing. New components are only added
+// if they have a verified self-signature
+func (c *certificate) mergeComponents(existing, incoming []*certComponent, result *models.KeyMergeResult, describe func(*certComponent) string) ([]*certComponent, []string) {
+	added := make([]string, 0)
+	index := map[string]*certComponent{}
+
+	for _, comp := range existing {
+		index[packetKey(comp.raw)] = comp
+	}
+
+	for _, comp := range incoming {
+		current, ok := index[packetKey(comp.raw)]
+		if !ok {
+			current = &certComponent{raw: comp.raw}
+		}
+
+		sigs, revocations, certifications, selfSignatures, invalid := c.mergeSignatures(current.sigs, comp.sigs)
+		result.DroppedInvalidSignatures += invalid
+
+		if !ok {
+			if !c.hasSelfSignature(&certComponent{raw: comp.raw, sigs: sigs}) {
+				// Without a valid self-signature the component is not bound to the key
+				continue
+			}
+
+			existing = append(existing, current)
+			index[packetKey(comp.raw)] = current
+			added = append(added, describe(comp))
+		}
+
+		current.sigs = sigs
+		result.AddedRevocations += revocations
+		result.AddedCertifications += certifications
+		if ok {
+			result.UpdatedSelfSignatures += selfSignatures
+		}
+	}
+
+	return existing, added
+}
+
+// write writes the certificate packets to w
+func (c *certificate) write(w io.Writer) error {
+	var err error
+
+	write := func(op *packet.OpaquePacket) {
+		if err == nil {
+			err = op.Serialize(w)
+		}
+	}
+
+	write(c.primary)
+	for _, sig := range c.keySigs {
+		write(sig.raw)
+	}
+
+	for _, comps := range [][]*certComponent{c.userIDs, c.subKeys} {
+		for _, comp := range comps {
+			write(comp.raw)
+			for _, sig := range comp.sigs {
+				write(sig.raw)
+			}
+		}
+	}
+
+	return err
+}
+
+func (c *certificate) serialize() (string, error) {
+	buf := bytes.NewBuffer(nil)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err = c.write(w); err != nil {
+		return "", err
+	}
+
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func describeUserID(comp *certComponent) string {
+	if comp.raw.Tag == tagUserAttribute {
+		return "[user attribute]"
+	}
+
+	return string(comp.raw.Contents)
+}
+
+func describeSubKey(comp *certComponent) string {
+	p, err := comp.raw.Parse()
+	if err != nil {
+		return ""
+	}
+
+	if pk, ok := p.(*packet.PublicKey); ok {
+		return ByteFingerPrint2FP16(pk.Fingerprint[:])
+	}
+
+	return ""
+}
+
+// KeyLimits are the limits applied to the keys merged in the PKS to prevent certificate flooding. Zero values disable the limits
+type KeyLimits struct {
+	MaxSize           int
+	MaxUserIDs        int
+	MaxCertifications int
+	// StripCertifications removes the third-party certifications not made by the TrustedCertifiers fingerprints
+	StripCertifications bool
+	TrustedCertifiers   []string
+	StripUserAttributes bool
+}
+
+// MergeKeys merges the incoming public key into the existing one: the union of the user IDs, subkeys and signatures,
+// without duplicated signatures and keeping only the newest self-signature of each component.
+// If existing is empty the incoming key is returned normalized. Private key material is never returned.
+// The merged key is parsed to ensure it is valid.
+func MergeKeys(existing, incoming string) (string, models.KeyMergeResult, error) {
+	return MergeKeysWithLimits(existing, incoming, KeyLimits{})
+}
+
+// MergeKeysWithLimits merges the keys like MergeKeys applying the limits. The user IDs and certifications that
+// do not fit in the limits are dropped, preferring the ones already in the existing key
+func MergeKeysWithLimits(existing, incoming string, limits KeyLimits) (string, models.KeyMergeResult, error) {
+	var result models.KeyMergeResult
+
+	if limits.MaxSize > 0 && len(incoming) > limits.MaxSize {
+		return "", result, fmt.Errorf("key has %d bytes which is more than the maximum of %d bytes", len(incoming), limits.MaxSize)
+	}
+
+	in, err := parseCertificate(incoming)
+	if err != nil {
+		return "", result, fmt.Errorf("invalid incoming key: %s", err)
+	}
+
+	in.verifySelfSignatures()
+
+	cert := &certificate{primary: in.primary, publicKey: in.publicKey, keyID: in.keyID}
+	stored := map[string]bool{}
+
+	if existing != "" {
+		cert, err = parseCertificate(existing)
+		if err != nil {
+			return "", result, fmt.Errorf("invalid existing key: %s", err)
+		}
+
+		if packetKey(cert.primary) != packetKey(in.primary) {
+			return "", result, fmt.Errorf("keys have different primary keys")
+		}
+
+		cert.verifySelfSignatures()
+		stored = cert.packetKeys()
+	} else {
+		result.Created = true
+	}
+
+	var revocations, certifications, selfSignatures, invalid int
+	cert.keySigs, revocations, certifications, selfSignatures, invalid = cert.mergeSignatures(cert.keySigs, in.keySigs)
+	result.DroppedInvalidSignatures += invalid
+	result.AddedRevocations += revocations
+	result.AddedCertifications += certifications
+	if !result.Created {
+		result.UpdatedSelfSignatures += selfSignatures
+	}
+
+	cert.userIDs, result.AddedUserIDs = cert.mergeComponents(cert.userIDs, in.userIDs, &result, describeUserID)
+	cert.subKeys, result.AddedSubKeys = cert.mergeComponents(cert.subKeys, in.subKeys, &result, describeSubKey)
+
+	cert.applyLimits(stored, limits, &result)
+
+	if result.Created {
+		// Everything is new, only report the key creation
+		result.AddedUserIDs = []string{}
+		result.AddedSubKeys = []string{}
+		result.AddedRevocations = 0
+		result.AddedCertifications = 0
+		result.UpdatedSelfSignatures = 0
+	}
+
+	merged, err := cert.serialize()
+	if err != nil {
+		return "", result, err
+	}
+
+	if limits.MaxSize > 0 && len(merged) > limits.MaxSize {
+		return "", result, fmt.Errorf("merged key has %d bytes which is more than the maximum of %d bytes", len(merged), limits.MaxSize)
+	}
+
+	e, err := ReadKeyToEntity(merged)
+	if err != nil {
+		return "", result, fmt.Errorf("merged key is invalid: %s", err)
+	}
+
+	result.FingerPrint = ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+	result.Changed = result.Created || len(result.AddedUserIDs) > 0 || len(result.AddedSubKeys) > 0 ||
+		result.AddedRevocations > 0 || result.AddedCertifications > 0 || result.UpdatedSelfSignatures > 0
+
+	return merged, result, nil
+}
+
+// packetKeys returns the keys of all the user ID, subkey and signature packets of the certificate
+func (c *certificate) packetKeys() map[string]bool {
+	keys := map[string]bool{}
+
+	for _, sig := range c.keySigs {
+		keys[packetKey(sig.raw)] = true
+	}
+
+	for _, comps := range [][]*certComponent{c.userIDs, c.subKeys} {
+		for _, comp := range comps {
+			keys[packetKey(comp.raw)] = true
+			for _, sig := range comp.sigs {
+				keys[packetKey(sig.raw)] = true
+			}
+		}
+	}
+
+	return keys
+}
+
+// KeyDigest returns a digest of the packets of the armored public key that does not depend on their order,
+// so keys with the same user IDs, subkeys and signatures have the same digest after merging
+func KeyDigest(armored string) (string, error) {
+	cert, err := parseCertificate(armored)
+	if err != nil {
+		return "", err
+	}
+
+	items := []string{packetKey(cert.primary)}
+
+	for _, sig := range cert.keySigs {
+		items = append(items, packetKey(sig.raw))
+	}
+
+	for _, components := range [][]*certComponent{cert.userIDs, cert.subKeys} {
+		for _, c := range components {
+			key := packetKey(c.raw)
+			items = append(items, key)
+			for _, sig := range c.sigs {
+				items = append(items, key+"/"+packetKey(sig.raw))
+			}
+		}
+	}
+
+	sort.Strings(items)
+
+	h := sha256.New()
+	for _, item := range items {
+		_, _ = h.Write([]byte(item))
+		_, _ = h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func removeString(list []string, value string) []string {
+	for i, v := range list {
+		if v == value {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+
+	return list
+}
+
+// applyLimits drops the user IDs and third-party signatures that do not fit in the limits. Packets in stored are kept first
+func (c *certificate) applyLimits(stored map[string]bool, limits KeyLimits, result *models.KeyMergeResult) {
+	userIDs := make([]*certComponent, 0, len(c.userIDs))
+	for _, uid := range c.userIDs {
+		if limits.StripUserAttributes && uid.raw.Tag == tagUserAttribute {
+			result.DroppedUserAttributes++
+			if !stored[packetKey(uid.raw)] {
+				result.AddedUserIDs = removeString(result.AddedUserIDs, describeUserID(uid))
+			}
+			continue
+		}
+		userIDs = append(userIDs, uid)
+	}
+
+	if limits.MaxUserIDs > 0 && len(userIDs) > limits.MaxUserIDs {
+		kept := make([]*certComponent, 0, limits.MaxUserIDs)
+		for _, uid := range userIDs {
+			if stored[packetKey(uid.raw)] {
+				kept = append(kept, uid)
+			}
+		}
+
+		for _, uid := range userIDs {
+			if stored[packetKey(uid.raw)] {
+				continue
+			}
+
+			if len(kept) < limits.MaxUserIDs {
+				kept = append(kept, uid)
+			} else {
+				result.DroppedUserIDs++
+				result.AddedUserIDs = removeString(result.AddedUserIDs, describeUserID(uid))
+			}
+		}
+
+		// Keep the original order
+		keep := map[*certComponent]bool{}
+		for _, uid := range kept {
+			keep[uid] = true
+		}
+
+		userIDs = userIDs[:0:0]
+		for _, uid := range c.userIDs {
+			if keep[uid] {
+				userIDs = append(userIDs, uid)
+			}
+		}
+	}
+
+	c.userIDs = userIDs
+
+	trusted := map[uint64]bool{}
+	for _, fp := range limits.TrustedCertifiers {
+		fp = strings.ToUpper(strings.TrimSpace(fp))
+		if len(fp) > 16 {
+			fp = fp[len(fp)-16:]
+		}
+
+		if keyID, err := strconv.ParseUint(fp, 16, 64); err == nil {
+			trusted[keyID] = true
+		}
+	}
+
+	drop := func(sig *certSignature) {
+		result.DroppedCertifications++
+		if stored[packetKey(sig.raw)] {
+			return
+		}
+
+		if isRevocation(sig) {
+			result.AddedRevocations--
+		} else {
+			result.AddedCertifications--
+		}
+	}
+
+	// Verified self-signatures are never limited. Stored certifications are counted first, so new ones cannot push them out
+	certifications := 0
+	for _, pass := range []bool{true, false} {
+		filter := func(sigs []*certSignature) []*certSignature {
+			kept := make([]*certSignature, 0, len(sigs))
+			for _, sig := range sigs {
+				if sig.verified || stored[packetKey(sig.raw)] != pass {
+					kept = append(kept, sig)
+					continue
+				}
+
+				if limits.StripCertifications && !trusted[sig.issuer] {
+					drop(sig)
+					continue
+				}
+
+				if limits.MaxCertifications > 0 && certifications >= limits.MaxCertifications && !pass {
+					drop(sig)
+					continue
+				}
+
+				certifications++
+				kept = append(kept, sig)
+			}
+			return kept
+		}
+
+		c.keySigs = filter(c.keySigs)
+		for _, uid := range c.userIDs {
+			uid.sigs = filter(uid.sigs)
+		}
+	}
+}
+
+// FilterUserIDs returns the armored public key with only the specified user IDs. User attributes are removed
+func FilterUserIDs(armored string, userIDs []string) (string, error) {
+	cert, err := parseCertificate(armored)
+	if err != nil {
+		return "", err
+	}
+
+	keep := map[string]bool{}
+	for _, id := range userIDs {
+		keep[id] = true
+	}
+
+	filtered := make([]*certComponent, 0, len(cert.userIDs))
+	for _, uid := range cert.userIDs {
+		if uid.raw.Tag == tagUserID && keep[string(uid.raw.Contents)] {
+			filtered = append(filtered, uid)
+		}
+	}
+
+	cert.userIDs = filtered
+
+	return cert.serialize()
+}
+
+// KeyComponents returns the user IDs and the subkey fingerprints of the armored public key, described like in KeyMergeResult
+func KeyComponents(armored string) (userIDs, subKeys []string, err error) {
+	cert, err := parseCertificate(armored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userIDs = make([]string, 0, len(cert.userIDs))
+	for _, uid := range cert.userIDs {
+		if uid.raw.Tag == tagUserID {
+			userIDs = append(userIDs, describeUserID(uid))
+		}
+	}
+
+	subKeys = make([]string, 0, len(cert.subKeys))
+	for _, subKey := range cert.subKeys {
+		subKeys = append(subKeys, describeSubKey(subKey))
+	}
+
+	return userIDs, subKeys, nil
+}
+
+// RemoveComponents returns the armored public key without the specified user IDs and subkey fingerprints
+func RemoveComponents(armored string, userIDs, subKeys []string) (string, error) {
+	cert, err := parseCertificate(armored)
+	if err != nil {
+		return "", err
+	}
+
+	remove := map[string]bool{}
+	for _, id := range userIDs {
+		remove[id] = true
+	}
+
+	filtered := make([]*certComponent, 0, len(cert.userIDs))
+	for _, uid := range cert.userIDs {
+		if uid.raw.Tag != tagUserID || !remove[describeUserID(uid)] {
+			filtered = append(filtered, uid)
+		}
+	}
+
+	cert.userIDs = filtered
+
+	remove = map[string]bool{}
+	for _, fp := range subKeys {
+		remove[strings.ToUpper(fp)] = true
+	}
+
+	filtered = make([]*certComponent, 0, len(cert.subKeys))
+	for _, subKey := range cert.subKeys {
+		if !remove[describeSubKey(subKey)] {
+			filtered = append(filtered, subKey)
+		}
+	}
+
+	cert.subKeys = filtered
+
+	return cert.serialize()
+}
+
+// KeepRevocations returns the updated armored public key with the revocation signatures of previous added back,
+// for the key itself and for the user IDs and subkeys still in updated. Returns the number of revocations added
+func KeepRevocations(previous, updated string) (string, int, error) {
+	prev, err := parseCertificate(previous)
+	if err != nil {
+		return "", 0, err
+	}
+
+	cert, err := parseCertificate(updated)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if packetKey(prev.primary) != packetKey(cert.primary) {
+		return "", 0, fmt.Errorf("keys have different primary keys")
+	}
+
+	added := 0
+	keep := func(existing, previous []*certSignature) []*certSignature {
+		seen := map[string]bool{}
+		for _, sig := range existing {
+			seen[packetKey(sig.raw)] = true
+		}
+
+		// Revocations go first like in mergeSignatures
+		kept := make([]*certSignature, 0, len(existing))
+		for _, sig := range previous {
+			if isRevocation(sig) && !seen[packetKey(sig.raw)] {
+				kept = append(kept, sig)
+				added++
+			}
+		}
+
+		return append(kept, existing...)
+	}
+
+	cert.keySigs = keep(cert.keySigs, prev.keySigs)
+
+	previousComponents := map[string]*certComponent{}
+	for _, comps := range [][]*certComponent{prev.userIDs, prev.subKeys} {
+		for _, comp := range comps {
+			previousComponents[packetKey(comp.raw)] = comp
+		}
+	}
+
+	for _, comps := range [][]*certComponent{cert.userIDs, cert.subKeys} {
+		for _, comp := range comps {
+			if p, ok := previousComponents[packetKey(comp.raw)]; ok {
+				comp.sigs = keep(comp.sigs, p.sigs)
+			}
+		}
+	}
+
+	if added == 0 {
+		return updated, 0, nil
+	}
+
+	merged, err := cert.serialize()
+
+	return merged, added, err
+}
@@ -0,0 +1,576 @@
+package tools
+
+import (
+	"bytes"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/armor"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"testing"
+	"time"
+)
+
+func armorEntity(t *testing.T, e *openpgp.Entity, extra ...*packet.Signature) string {
+	buf := bytes.NewBuffer(nil)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+
+	// Extra signatures are appended to the last subkey
+	for _, sig := range extra {
+		if err := sig.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func addIdentity(t *testing.T, e *openpgp.Entity, name, email string, config *packet.Config) {
+	uid := packet.NewUserId(name, "", email)
+	sig := &packet.Signature{
+		SigType:      packet.SigTypePositiveCert,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		CreationTime: config.Now(),
+		IssuerKeyId:  &e.PrivateKey.KeyId,
+	}
+
+	if err := sig.SignUserId(uid.Id, e.PrimaryKey, e.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Identities[uid.Id] = &openpgp.Identity{
+		Name:          uid.Id,
+		UserId:        uid,
+		SelfSignature: sig,
+	}
+}
+
+func TestMergeKeys(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Merge", "", "merge@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := armorEntity(t, e)
+
+	merged, result, err := MergeKeys("", original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := ByteFingerPrint2FP16(e.PrimaryKey.Fingerprint[:])
+	if !result.Created || !result.Changed || result.FingerPrint != fp {
+		t.Errorf("Expected created key %s got %+v", fp, result)
+	}
+
+	// Adding the same key again should not change anything
+	_, result, err = MergeKeys(merged, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Created || result.Changed {
+		t.Errorf("Expected no changes got %+v", result)
+	}
+
+	// New user ID and third party certification
+	addIdentity(t, e, "Merge Two", "merge2@huebr.com", config)
+	signer, err := openpgp.NewEntity("Signer", "", "signer@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.SignIdentity("Merge <merge@huebr.com>", signer, config); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, result, err = MergeKeys(merged, armorEntity(t, e))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.Changed || len(result.AddedUserIDs) != 1 || result.AddedUserIDs[0] != "Merge Two <merge2@huebr.com>" {
+		t.Errorf("Expected added user id got %+v", result)
+	}
+
+	if result.AddedCertifications != 1 {
+		t.Errorf("Expected 1 added certification got %d", result.AddedCertifications)
+	}
+
+	me, err := ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(me.Identities) != 2 {
+		t.Errorf("Expected 2 identities got %d", len(me.Identities))
+	}
+
+	if len(me.Identities["Merge <merge@huebr.com>"].Signatures) != 1 {
+		t.Errorf("Expected the third party certification to be kept")
+	}
+
+	// Subkey revocation
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeSubkeyRevocation,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		CreationTime: time.Now(),
+		IssuerKeyId:  &e.PrivateKey.KeyId,
+	}
+
+	if err := revocation.SignKey(e.Subkeys[0].PublicKey, e.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, result, err = MergeKeys(merged, armorEntity(t, e, revocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.AddedRevocations != 1 || len(result.AddedUserIDs) != 0 || result.AddedCertifications != 0 {
+		t.Errorf("Expected only 1 added revocation got %+v", result)
+	}
+
+	me, err = ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(me.Subkeys) != 1 || me.Subkeys[0].Sig.SigType != packet.SigTypeSubkeyRevocation {
+		t.Errorf("Expected the subkey to be revoked")
+	}
+}
+
+func TestMergeKeysNewestSelfSignature(t *testing.T) {
+	e, err := openpgp.NewEntity("Merge", "", "merge@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	older := armorEntity(t, e)
+
+	newerTime := time.Now().Add(time.Hour)
+	addIdentity(t, e, "Merge", "merge@huebr.com", &packet.Config{Time: func() time.Time { return newerTime }})
+	newer := armorEntity(t, e)
+
+	merged, result, err := MergeKeys(older, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.UpdatedSelfSignatures != 1 {
+		t.Errorf("Expected 1 updated self signature got %+v", result)
+	}
+
+	// An older self signature should be ignored
+	merged, result, err = MergeKeys(merged, older)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Changed {
+		t.Errorf("Expected no changes got %+v", result)
+	}
+
+	me, err := ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := me.Identities["Merge <merge@huebr.com>"].SelfSignature
+	if sig.CreationTime.Unix() != newerTime.Unix() {
+		t.Errorf("Expected self signature from %s got %s", newerTime, sig.CreationTime)
+	}
+}
+
+func forgeUserIDSignature(t *testing.T, victim, forger *openpgp.Entity, uid string, sigType packet.SignatureType, config *packet.Config) *packet.Signature {
+	sig := &packet.Signature{
+		SigType:      sigType,
+		PubKeyAlgo:   forger.PrivateKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		CreationTime: config.Now(),
+		IssuerKeyId:  &victim.PrimaryKey.KeyId,
+	}
+
+	if err := sig.SignUserId(uid, victim.PrimaryKey, forger.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func TestMergeKeysForgedSignatures(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Merge", "", "merge@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forger, err := openpgp.NewEntity("Forger", "", "forger@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := armorEntity(t, e)
+
+	// Newer self certification and revocation claiming to be issued by the key, plus a new user ID bound by a forged signature
+	uid := "Merge <merge@huebr.com>"
+	newerTime := time.Now().Add(time.Hour)
+	newer := &packet.Config{Time: func() time.Time { return newerTime }}
+	identity := e.Identities[uid]
+	identity.SelfSignature = forgeUserIDSignature(t, e, forger, uid, packet.SigTypeGenericCert, newer)
+	identity.Signatures = append(identity.Signatures, forgeUserIDSignature(t, e, forger, uid, sigTypeCertificationRevocation, newer))
+
+	forged := packet.NewUserId("Forged", "", "forged@huebr.com")
+	e.Identities[forged.Id] = &openpgp.Identity{
+		Name:          forged.Id,
+		UserId:        forged,
+		SelfSignature: forgeUserIDSignature(t, e, forger, forged.Id, packet.SigTypePositiveCert, config),
+	}
+
+	merged, result, err := MergeKeys(original, armorEntity(t, e))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Changed || result.DroppedInvalidSignatures != 3 {
+		t.Errorf("Expected 3 dropped invalid signatures and no changes got %+v", result)
+	}
+
+	if merged != original {
+		t.Errorf("Expected the stored key to be kept")
+	}
+
+	me, err := ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(me.Identities) != 1 || me.Identities[uid] == nil || me.Identities[uid].SelfSignature.SigType != packet.SigTypePositiveCert {
+		t.Errorf("Expected the genuine self signature to be kept")
+	}
+}
+
+func TestMergeKeysDifferentKeys(t *testing.T) {
+	a, err := openpgp.NewEntity("A", "", "a@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := openpgp.NewEntity("B", "", "b@huebr.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := MergeKeys(armorEntity(t, a), armorEntity(t, b)); err == nil {
+		t.Errorf("Expected error merging different keys")
+	}
+}
+
+func TestFilterUserIDs(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Filter", "", "filter@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addIdentity(t, e, "Filter Two", "filter2@huebr.com", config)
+
+	filtered, err := FilterUserIDs(armorEntity(t, e), []string{"Filter Two <filter2@huebr.com>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fe, err := ReadKeyToEntity(filtered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fe.Identities) != 1 || fe.Identities["Filter Two <filter2@huebr.com>"] == nil {
+		t.Errorf("Expected only the identity Filter Two <filter2@huebr.com>")
+	}
+
+	if len(fe.Subkeys) != 1 {
+		t.Errorf("Expected the subkey to be kept")
+	}
+}
+
+func TestRemoveComponents(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Remove", "", "remove@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addIdentity(t, e, "Remove Two", "remove2@huebr.com", config)
+	armored := armorEntity(t, e)
+
+	userIDs, subKeys, err := KeyComponents(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subKey := ByteFingerPrint2FP16(e.Subkeys[0].PublicKey.Fingerprint[:])
+	if len(userIDs) != 2 || len(subKeys) != 1 || subKeys[0] != subKey {
+		t.Fatalf("Expected 2 user ids and the subkey %s got %v and %v", subKey, userIDs, subKeys)
+	}
+
+	removed, err := RemoveComponents(armored, []string{"Remove Two <remove2@huebr.com>"}, subKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	re, err := ReadKeyToEntity(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(re.Identities) != 1 || re.Identities["Remove <remove@huebr.com>"] == nil || len(re.Subkeys) != 0 {
+		t.Errorf("Expected only the identity Remove <remove@huebr.com> without subkeys")
+	}
+}
+
+func TestKeepRevocations(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Keep", "", "keep@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addIdentity(t, e, "Keep Two", "keep2@huebr.com", config)
+
+	revocation := &packet.Signature{
+		SigType:      packet.SigTypeSubkeyRevocation,
+		PubKeyAlgo:   e.PrivateKey.PubKeyAlgo,
+		Hash:         config.Hash(),
+		CreationTime: time.Now(),
+		IssuerKeyId:  &e.PrivateKey.KeyId,
+	}
+
+	if err := revocation.SignKey(e.Subkeys[0].PublicKey, e.PrivateKey, config); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _, err := MergeKeys("", armorEntity(t, e, revocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, added, err := KeepRevocations(stored, stored); err != nil || added != 0 {
+		t.Errorf("Expected no revocations to be added to the same key got %d (%v)", added, err)
+	}
+
+	// The update removes a user ID and does not have the subkey revocation
+	delete(e.Identities, "Keep Two <keep2@huebr.com>")
+	updated, added, err := KeepRevocations(stored, armorEntity(t, e))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added != 1 {
+		t.Errorf("Expected 1 revocation to be kept got %d", added)
+	}
+
+	ue, err := ReadKeyToEntity(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ue.Identities) != 1 || len(ue.Subkeys) != 1 || ue.Subkeys[0].Sig.SigType != packet.SigTypeSubkeyRevocation {
+		t.Errorf("Expected the user id to be removed and the subkey to stay revoked")
+	}
+}
+
+func TestMergeKeysWithLimits(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Limits", "", "limits@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const identity = "Limits <limits@huebr.com>"
+
+	stored, _, err := MergeKeys("", armorEntity(t, e))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signers []*openpgp.Entity
+	for _, name := range []string{"Signer One", "Signer Two", "Signer Three"} {
+		signer, err := openpgp.NewEntity(name, "", "signer@huebr.com", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+
+	if err := e.SignIdentity(identity, signers[0], config); err != nil {
+		t.Fatal(err)
+	}
+
+	limits := KeyLimits{MaxCertifications: 1}
+	stored, result, err := MergeKeysWithLimits(stored, armorEntity(t, e), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.AddedCertifications != 1 || result.DroppedCertifications != 0 {
+		t.Errorf("Expected 1 added certification got %+v", result)
+	}
+
+	// The stored certification should be kept over the new ones
+	for _, signer := range signers[1:] {
+		if err := e.SignIdentity(identity, signer, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flooded := armorEntity(t, e)
+	merged, result, err := MergeKeysWithLimits(stored, flooded, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Changed || result.AddedCertifications != 0 || result.DroppedCertifications != 2 {
+		t.Errorf("Expected 2 dropped certifications and no changes got %+v", result)
+	}
+
+	me, err := ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sigs := me.Identities[identity].Signatures
+	if len(sigs) != 1 || *sigs[0].IssuerKeyId != signers[0].PrimaryKey.KeyId {
+		t.Errorf("Expected only the first certification to be kept")
+	}
+
+	// Only the trusted certifier should be kept
+	limits = KeyLimits{
+		StripCertifications: true,
+		TrustedCertifiers:   []string{ByteFingerPrint2FP16(signers[1].PrimaryKey.Fingerprint[:])},
+	}
+	merged, result, err = MergeKeysWithLimits("", flooded, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.DroppedCertifications != 2 {
+		t.Errorf("Expected 2 dropped certifications got %d", result.DroppedCertifications)
+	}
+
+	me, err = ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sigs = me.Identities[identity].Signatures
+	if len(sigs) != 1 || *sigs[0].IssuerKeyId != signers[1].PrimaryKey.KeyId {
+		t.Errorf("Expected only the trusted certification to be kept")
+	}
+
+	// New user IDs over the limit are dropped
+	addIdentity(t, e, "Limits Two", "limits2@huebr.com", config)
+	addIdentity(t, e, "Limits Three", "limits3@huebr.com", config)
+	merged, result, err = MergeKeysWithLimits(stored, armorEntity(t, e), KeyLimits{MaxUserIDs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.AddedUserIDs) != 1 || result.DroppedUserIDs != 1 {
+		t.Errorf("Expected 1 added and 1 dropped user id got %+v", result)
+	}
+
+	me, err = ReadKeyToEntity(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(me.Identities) != 2 || me.Identities[identity] == nil {
+		t.Errorf("Expected the stored identity and one new identity")
+	}
+
+	// Keys over the maximum size are refused
+	_, _, err = MergeKeysWithLimits(stored, flooded, KeyLimits{MaxSize: 1024})
+	if err == nil {
+		t.Errorf("Expected an error for a key over the maximum size")
+	}
+
+	// Stored signatures claiming to be issued by the key are limited unless they are verified
+	clean := armorEntity(t, signers[2])
+	forged := signers[2].Identities["Signer Three <signer@huebr.com>"]
+	forged.Signatures = append(forged.Signatures, forgeUserIDSignature(t, signers[2], signers[0], forged.Name, packet.SigTypeGenericCert, config))
+
+	merged, result, err = MergeKeysWithLimits(armorEntity(t, signers[2]), clean, KeyLimits{StripCertifications: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.DroppedCertifications != 1 {
+		t.Errorf("Expected the forged signature to be dropped got %+v", result)
+	}
+
+	if merged != clean {
+		t.Errorf("Expected only the verified self signature to be kept")
+	}
+}
+
+func TestKeyDigest(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("Digest", "", "digest@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := armorEntity(t, e)
+
+	addIdentity(t, e, "Digest A", "a@huebr.com", config)
+	withA := armorEntity(t, e)
+	delete(e.Identities, "Digest A <a@huebr.com>")
+
+	addIdentity(t, e, "Digest B", "b@huebr.com", config)
+	withB := armorEntity(t, e)
+
+	// Merging in different orders gives the same user IDs in different positions
+	ab, _, err := MergeKeys(withA, withB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ba, _, err := MergeKeys(withB, withA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := func(key string) string {
+		d, err := KeyDigest(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	if digest(ab) != digest(ba) {
+		t.Errorf("Expected the same digest for keys with the same packets")
+	}
+
+	if digest(original) == digest(ab) || digest(withA) == digest(withB) {
+		t.Errorf("Expected different digests for keys with different packets")
+	}
+
+	if _, err := KeyDigest("huebr"); err == nil {
+		t.Errorf("Expected error for an invalid key")
+	}
+}
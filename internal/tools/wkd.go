@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// zBase32Encoding is the z-base-32 encoding used by the Web Key Directory
+var zBase32Encoding = base32.NewEncoding("ybndrfg8ejkmcpqxot1uwisza345h769").WithPadding(base32.NoPadding)
+
+// WKDHash returns the Web Key Directory hash of the email local part: the z-base-32 encoded SHA-1 of the lowercase local part
+func WKDHash(localPart string) string {
+	h := sha1.Sum([]byte(strings.ToLower(localPart)))
+	return zBase32Encoding.EncodeToString(h[:])
+}
+
+// UserIDEmail returns the email of a user ID in the form "Name (Comment) <email>" or just "email"
+func UserIDEmail(uid string) string {
+	addr, err := mail.ParseAddress(uid)
+	if err == nil {
+		return addr.Address
+	}
+
+	// Some user IDs are not valid RFC 5322 addresses, like names with dots
+	start := strings.LastIndex(uid, "<")
+	end := strings.LastIndex(uid, ">")
+	if start >= 0 && end > start {
+		return uid[start+1 : end]
+	}
+
+	return uid
+}
+
+// ExportKeyForEmail returns the binary public key with only the user IDs that have the specified email,
+// as served by the Web Key Directory. Returns an error if no user ID matches
+func ExportKeyForEmail(armored, email string) ([]byte, error) {
+	cert, err := parseCertificate(armored)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]*certComponent, 0)
+	for _, uid := range cert.userIDs {
+		if uid.raw.Tag == tagUserID && strings.EqualFold(UserIDEmail(string(uid.raw.Contents)), email) {
+			userIDs = append(userIDs, uid)
+		}
+	}
+
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("no user id with email %s", email)
+	}
+
+	cert.userIDs = userIDs
+
+	buf := bytes.NewBuffer(nil)
+	if err := cert.write(buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"bytes"
+	"github.com/quan-to/chevron/pkg/openpgp"
+	"github.com/quan-to/chevron/pkg/openpgp/packet"
+	"testing"
+)
+
+func TestWKDHash(t *testing.T) {
+	// Test vector from draft-koch-openpgp-webkey-service
+	expected := "iy9q119eutrkn8s1mk4r39qejnbu3n5q"
+
+	if h := WKDHash("Joe.Doe"); h != expected {
+		t.Errorf("Expected hash %s got %s", expected, h)
+	}
+}
+
+func TestExportKeyForEmail(t *testing.T) {
+	config := &packet.Config{RSABits: 1024}
+	e, err := openpgp.NewEntity("WKD", "", "wkd@huebr.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addIdentity(t, e, "WKD Other", "other@huebr.com", config)
+
+	data, err := ExportKeyForEmail(armorEntity(t, e), "WKD@huebr.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || len(keys[0].Identities) != 1 || keys[0].Identities["WKD <wkd@huebr.com>"] == nil {
+		t.Errorf("Expected only the identity WKD <wkd@huebr.com>")
+	}
+
+	if len(keys[0].Subkeys) != 1 {
+		t.Errorf("Expected the subkey to be kept")
+	}
+
+	if _, err := ExportKeyForEmail(armorEntity(t, e), "nobody@huebr.com"); err == nil {
+		t.Errorf("Expected error for email without user id")
+	}
+}
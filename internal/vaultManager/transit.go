@@ -0,0 +1,64 @@
+// +build !js,!wasm
+
+package vaultManager
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+)
+
+func transitPath(operation, keyName string) string {
+	return fmt.Sprintf("%s/%s/%s", config.VaultTransitPath, operation, keyName)
+}
+
+// EnsureTransitKey creates the transit key if it does not exists
+func (vm *VaultManager) EnsureTransitKey(keyName string) error {
+	s, err := vm.getClient().Logical().Read(transitPath("keys", keyName))
+	if err != nil {
+		return err
+	}
+
+	if s != nil {
+		return nil
+	}
+
+	vm.log.Info("Transit key %s does not exists. Creating it.", keyName)
+	_, err = vm.getClient().Logical().Write(transitPath("keys", keyName), map[string]interface{}{})
+
+	return err
+}
+
+// TransitEncrypt encrypts the data using the specified transit key. The returned ciphertext is prefixed by vault:vN:
+func (vm *VaultManager) TransitEncrypt(keyName string, data []byte) (string, error) {
+	s, err := vm.getClient().Logical().Write(transitPath("encrypt", keyName), map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(data),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if s == nil || s.Data["ciphertext"] == nil {
+		return "", fmt.Errorf("no ciphertext returned by vault")
+	}
+
+	return s.Data["ciphertext"].(string), nil
+}
+
+// TransitDecrypt decrypts a ciphertext returned by TransitEncrypt
+func (vm *VaultManager) TransitDecrypt(keyName, ciphertext string) ([]byte, error) {
+	s, err := vm.getClient().Logical().Write(transitPath("decrypt", keyName), map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil || s.Data["plaintext"] == nil {
+		return nil, fmt.Errorf("no plaintext returned by vault")
+	}
+
+	return base64.StdEncoding.DecodeString(s.Data["plaintext"].(string))
+}
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"github.com/hashicorp/vault/api"
 	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/pkg/interfaces"
 	"github.com/quan-to/slog"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,10 +24,13 @@ type VaultToken struct {
 }
 
 type VaultManager struct {
-	client *api.Client
-	prefix string
-	log    slog.Instance
-	token  *VaultToken
+	client       *api.Client
+	prefix       string
+	skipDataType bool
+	kvVersion    int
+	kvOnce       sync.Once
+	log          slog.Instance
+	token        *VaultToken
 }
 
 // MakeVaultManager creates an instance of VaultManager
@@ -67,9 +73,10 @@ func MakeVaultManager(log slog.Instance, prefix string) *VaultManager {
 	}
 
 	vm := &VaultManager{
-		client: client,
-		prefix: prefix,
-		log:    slog.Scope(fmt.Sprintf("Vault (%s)", prefix)),
+		client:       client,
+		prefix:       prefix,
+		skipDataType: config.VaultSkipDataType,
+		log:          slog.Scope(fmt.Sprintf("Vault (%s)", prefix)),
 		token: &VaultToken{
 			ttl:     vaultTTL,
 			getTime: nil,
@@ -93,8 +100,13 @@ func MakeVaultManager(log slog.Instance, prefix string) *VaultManager {
 	return vm
 }
 
-func baseVaultPath(dataType string) string {
-	if config.VaultSkipDataType {
+// SetSkipDataType overrides VaultSkipDataType for this instance
+func (vm *VaultManager) SetSkipDataType(skip bool) {
+	vm.skipDataType = skip
+}
+
+func (vm *VaultManager) baseVaultPath(dataType string) string {
+	if vm.skipDataType {
 		return fmt.Sprintf("%s/%s", config.VaultBackend, config.VaultNamespace)
 	}
 	return fmt.Sprintf("%s/%s/%s", config.VaultBackend, dataType, config.VaultNamespace)
@@ -155,7 +167,7 @@ func (vm *VaultManager) getClient() *api.Client {
 }
 
 func (vm *VaultManager) vaultPath(dataType, key string) string {
-	return fmt.Sprintf("%s/%s", baseVaultPath(dataType), key)
+	return fmt.Sprintf("%s/%s", vm.baseVaultPath(dataType), key)
 }
 
 func (vm *VaultManager) putSecret(key string, data map[string]string) error {
@@ -179,6 +191,10 @@ func (vm *VaultManager) deleteSecret(key string) error {
 		return err
 	}
 
+	if vm.IsVersioned() {
+		vm.log.Info("KV v2 detected. Soft deleting %s, previous versions can still be restored", key)
+	}
+
 	_, err = vm.getClient().Logical().Delete(vm.vaultPath(VaultData, key))
 	if err != nil {
 		vm.log.ErrorDone("Error deleting from %s: %s", vm.vaultPath(VaultData, key), err)
@@ -195,10 +211,18 @@ func (vm *VaultManager) getSecret(key string) (string, string, error) {
 	}
 
 	if s == nil {
-		return "", "", fmt.Errorf("not found")
+		return "", "", interfaces.ErrKeyNotFound
 	}
 
-	data := s.Data["data"].(map[string]interface{})
+	return parseSecretData(s)
+}
+
+func parseSecretData(s *api.Secret) (string, string, error) {
+	data, ok := s.Data["data"].(map[string]interface{})
+	if !ok {
+		// Soft deleted versions on KV v2 only returns the metadata
+		return "", "", interfaces.ErrKeyNotFound
+	}
 
 	if data["data"] == nil {
 		return "", "", fmt.Errorf("corrupted data")
@@ -224,6 +248,46 @@ func (vm *VaultManager) Save(key, data string) error {
 	})
 }
 
+// Create saves a key only if it does not exist yet. On KV v2 it is an atomic check-and-set write.
+// KV v1 has no conditional writes, so the key is only checked before being written
+func (vm *VaultManager) Create(key, data string) error {
+	vm.log.DebugAwait("Creating %s", key)
+
+	if !vm.IsVersioned() {
+		_, _, err := vm.getSecret(vm.prefix + key)
+		if err == nil {
+			return interfaces.ErrKeyConflict
+		}
+
+		if err != interfaces.ErrKeyNotFound {
+			return err
+		}
+
+		return vm.putSecret(vm.prefix+key, map[string]string{
+			"data": data,
+		})
+	}
+
+	_, err := vm.getClient().Logical().Write(vm.vaultPath(VaultData, vm.prefix+key), map[string]interface{}{
+		"options": map[string]interface{}{
+			"cas": 0,
+		},
+		"data": map[string]string{
+			"data": data,
+		},
+	})
+
+	if err != nil && strings.Contains(err.Error(), "check-and-set") {
+		return interfaces.ErrKeyConflict
+	}
+
+	if err != nil {
+		vm.log.ErrorDone("Error creating %s: %s", key, err)
+	}
+
+	return err
+}
+
 func (vm *VaultManager) SaveWithMetadata(key, data, metadata string) error {
 	return vm.putSecret(vm.prefix+key, map[string]string{
 		"data":     data,
@@ -248,8 +312,8 @@ func (vm *VaultManager) Read(key string) (data string, metadata string, err erro
 }
 
 func (vm *VaultManager) List() ([]string, error) {
-	vm.log.Debug("Listing keys on %s", baseVaultPath(VaultMetadata))
-	s, err := vm.getClient().Logical().List(baseVaultPath(VaultMetadata))
+	vm.log.Debug("Listing keys on %s", vm.baseVaultPath(VaultMetadata))
+	s, err := vm.getClient().Logical().List(vm.baseVaultPath(VaultMetadata))
 	if err != nil {
 		return nil, err
 	}
@@ -3,6 +3,7 @@ package vaultManager
 import (
 	"github.com/quan-to/chevron/internal/config"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -160,3 +161,99 @@ func TestVaultManager_SaveWithMetadata(t *testing.T) {
 		t.Errorf("Expected %s got %s", "testmetadata", metadata)
 	}
 }
+
+func TestVaultManager_Transit(t *testing.T) {
+	err := vm.EnsureTransitKey("test-transit")
+	if err != nil {
+		t.Fatalf("Error creating transit key: %s", err)
+	}
+
+	// Should be a no-op when the key already exists
+	err = vm.EnsureTransitKey("test-transit")
+	if err != nil {
+		t.Fatalf("Error checking transit key: %s", err)
+	}
+
+	ciphertext, err := vm.TransitEncrypt("test-transit", []byte("huebr"))
+	if err != nil {
+		t.Fatalf("Error encrypting: %s", err)
+	}
+
+	if !strings.HasPrefix(ciphertext, "vault:v") {
+		t.Errorf("Expected vault ciphertext got %s", ciphertext)
+	}
+
+	plaintext, err := vm.TransitDecrypt("test-transit", ciphertext)
+	if err != nil {
+		t.Fatalf("Error decrypting: %s", err)
+	}
+
+	if string(plaintext) != "huebr" {
+		t.Errorf("Expected huebr got %s", string(plaintext))
+	}
+
+	_, err = vm.TransitDecrypt("test-transit", "vault:v1:invalid")
+	if err == nil {
+		t.Errorf("Expected error decrypting invalid ciphertext")
+	}
+}
+
+func TestVaultManager_Versions(t *testing.T) {
+	if !vm.IsVersioned() {
+		t.Skip("Vault backend is not KV v2")
+	}
+
+	_ = vm.SaveWithMetadata("versioned", "v1", "m1")
+	_ = vm.SaveWithMetadata("versioned", "v2", "m2")
+
+	versions, err := vm.ListVersions("versioned")
+	if err != nil {
+		t.Fatalf("Error listing versions: %s", err)
+	}
+
+	if len(versions) < 2 || !versions[len(versions)-1].Current {
+		t.Fatalf("Expected at least 2 versions with the last one being current, got %+v", versions)
+	}
+
+	last := versions[len(versions)-1].Version
+
+	data, metadata, err := vm.ReadVersion("versioned", last-1)
+	if err != nil {
+		t.Fatalf("Error reading version %d: %s", last-1, err)
+	}
+
+	if data != "v1" || metadata != "m1" {
+		t.Errorf("Expected v1 / m1 got %s / %s", data, metadata)
+	}
+
+	// Delete should be a soft delete
+	err = vm.Delete("versioned")
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+
+	_, _, err = vm.Read("versioned")
+	if err == nil {
+		t.Fatalf("Expected error reading deleted key")
+	}
+
+	err = vm.Undelete("versioned", last)
+	if err != nil {
+		t.Fatalf("Error undeleting: %s", err)
+	}
+
+	data, _, err = vm.Read("versioned")
+	if err != nil || data != "v2" {
+		t.Fatalf("Expected v2 after undelete, got %s (%v)", data, err)
+	}
+
+	err = vm.Rollback("versioned", last-1)
+	if err != nil {
+		t.Fatalf("Error rolling back: %s", err)
+	}
+
+	data, metadata, err = vm.Read("versioned")
+	if err != nil || data != "v1" || metadata != "m1" {
+		t.Errorf("Expected v1 / m1 after rollback, got %s / %s (%v)", data, metadata, err)
+	}
+}
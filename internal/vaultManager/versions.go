@@ -0,0 +1,155 @@
+// +build !js,!wasm
+
+package vaultManager
+
+import (
+	"fmt"
+	"github.com/quan-to/chevron/internal/config"
+	"github.com/quan-to/chevron/internal/models"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const VaultUndelete = "undelete"
+
+var errNotVersioned = fmt.Errorf("key versioning requires a KV v2 secrets engine")
+
+// IsVersioned returns true if the secrets engine is a KV v2 (that keeps the versions of the keys)
+func (vm *VaultManager) IsVersioned() bool {
+	vm.kvOnce.Do(func() {
+		vm.kvVersion = vm.detectKVVersion()
+		vm.log.Info("Using KV v%d secrets engine at %s", vm.kvVersion, config.VaultBackend)
+	})
+
+	return vm.kvVersion == 2
+}
+
+func (vm *VaultManager) detectKVVersion() int {
+	if vm.skipDataType {
+		// KV v2 paths always have the data type
+		return 1
+	}
+
+	s, err := vm.getClient().Logical().Read("sys/internal/ui/mounts/" + config.VaultBackend)
+	if err != nil || s == nil {
+		vm.log.Warn("Cannot detect KV version of %s (%v). Assuming v2 since data types are enabled.", config.VaultBackend, err)
+		return 2
+	}
+
+	if options, ok := s.Data["options"].(map[string]interface{}); ok && fmt.Sprint(options["version"]) == "2" {
+		return 2
+	}
+
+	return 1
+}
+
+func parseVaultTime(v interface{}) *time.Time {
+	str, ok := v.(string)
+	if !ok || str == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return nil
+	}
+
+	return &t
+}
+
+// ListVersions returns all versions of the specified key
+func (vm *VaultManager) ListVersions(key string) ([]models.KeyVersion, error) {
+	if !vm.IsVersioned() {
+		return nil, errNotVersioned
+	}
+
+	s, err := vm.getClient().Logical().Read(vm.vaultPath(VaultMetadata, vm.prefix+key))
+	if err != nil {
+		return nil, err
+	}
+
+	if s == nil {
+		return nil, fmt.Errorf("not found")
+	}
+
+	currentVersion, _ := strconv.Atoi(fmt.Sprint(s.Data["current_version"]))
+	rawVersions, _ := s.Data["versions"].(map[string]interface{})
+	versions := make([]models.KeyVersion, 0, len(rawVersions))
+
+	for k, v := range rawVersions {
+		n, err := strconv.Atoi(k)
+		if err != nil {
+			continue
+		}
+
+		info, _ := v.(map[string]interface{})
+		kv := models.KeyVersion{
+			Version:      n,
+			DeletionTime: parseVaultTime(info["deletion_time"]),
+			Current:      n == currentVersion,
+		}
+
+		if created := parseVaultTime(info["created_time"]); created != nil {
+			kv.CreatedTime = *created
+		}
+
+		kv.Destroyed, _ = info["destroyed"].(bool)
+
+		versions = append(versions, kv)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+// ReadVersion returns the data and metadata of a specific version of the key
+func (vm *VaultManager) ReadVersion(key string, version int) (data, metadata string, err error) {
+	if !vm.IsVersioned() {
+		return "", "", errNotVersioned
+	}
+
+	vm.log.Debug("Reading %s version %d", key, version)
+	s, err := vm.getClient().Logical().ReadWithData(vm.vaultPath(VaultData, vm.prefix+key), map[string][]string{
+		"version": {strconv.Itoa(version)},
+	})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if s == nil {
+		return "", "", fmt.Errorf("not found")
+	}
+
+	return parseSecretData(s)
+}
+
+// Undelete restores a soft deleted version of the key
+func (vm *VaultManager) Undelete(key string, version int) error {
+	if !vm.IsVersioned() {
+		return errNotVersioned
+	}
+
+	vm.log.Info("Undeleting %s version %d", key, version)
+	_, err := vm.getClient().Logical().Write(vm.vaultPath(VaultUndelete, vm.prefix+key), map[string]interface{}{
+		"versions": []int{version},
+	})
+
+	return err
+}
+
+// Rollback writes the specified version as the new current version of the key
+func (vm *VaultManager) Rollback(key string, version int) error {
+	data, metadata, err := vm.ReadVersion(key, version)
+	if err != nil {
+		return fmt.Errorf("error reading version %d: %s", version, err)
+	}
+
+	vm.log.Info("Rolling back %s to version %d", key, version)
+
+	return vm.SaveWithMetadata(key, data, metadata)
+}
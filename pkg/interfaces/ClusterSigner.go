@@ -0,0 +1,15 @@
+package interfaces
+
+import (
+	"context"
+
+	"github.com/quan-to/chevron/pkg/openpgp"
+)
+
+// ClusterSigner is an interface for signing the requests between the cluster peers with the master key
+type ClusterSigner interface {
+	// SignWithMasterKey returns an armored detached signature of the data made with the master key
+	SignWithMasterKey(ctx context.Context, data []byte) (string, error)
+	// GetMasterPublicKey returns the public key of the master key
+	GetMasterPublicKey(ctx context.Context) *openpgp.Entity
+}
@@ -0,0 +1,8 @@
+package interfaces
+
+// ConditionalStorageBackend is a StorageBackend that can atomically create a key only if it does not exist yet
+type ConditionalStorageBackend interface {
+	StorageBackend
+	// Create saves a key to the backend only if it does not exist. Returns ErrKeyConflict if it already exists
+	Create(key, data string) error
+}
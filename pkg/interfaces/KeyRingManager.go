@@ -11,6 +11,8 @@ import (
 type KeyRingManager interface {
 	// GetCachedKeys returns a list of the memory-cached keys
 	GetCachedKeys(ctx context.Context) []models.KeyInfo
+	// GetCacheStats returns the hit, miss and eviction counters of the key cache
+	GetCacheStats(ctx context.Context) models.KeyRingCacheStats
 	// ContainsKey checks if a key with the specified fingerprint exists in Key Ring
 	ContainsKey(ctx context.Context, fingerprint string) bool
 	// GetKey returns a key with the specified fingerprint if exists. Returns nil if it does not
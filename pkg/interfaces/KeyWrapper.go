@@ -0,0 +1,13 @@
+package interfaces
+
+import "context"
+
+// KeyWrapper is an interface for wrapping / unwrapping data encryption keys with a master key
+type KeyWrapper interface {
+	// WrapKey encrypts the specified data key with the master key
+	WrapKey(ctx context.Context, dataKey []byte) (string, error)
+	// UnwrapKey decrypts a data key previously wrapped by WrapKey
+	UnwrapKey(ctx context.Context, wrappedKey string) ([]byte, error)
+	// WrapperID returns an identifier of the master key used for wrapping (for example its fingerprint)
+	WrapperID(ctx context.Context) string
+}
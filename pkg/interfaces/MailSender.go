@@ -0,0 +1,8 @@
+package interfaces
+
+import "context"
+
+// MailSender sends plain text emails, like the PKS ownership verification tokens
+type MailSender interface {
+	SendMail(ctx context.Context, to, subject, body string) error
+}
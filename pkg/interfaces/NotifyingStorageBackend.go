@@ -0,0 +1,8 @@
+package interfaces
+
+// NotifyingStorageBackend is a StorageBackend that can notify changes of the stored keys without polling
+type NotifyingStorageBackend interface {
+	StorageBackend
+	// Notify returns a channel that receives a value every time something changes in the backend until stop receives a value
+	Notify(stop chan bool) (<-chan bool, error)
+}
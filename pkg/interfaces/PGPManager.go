@@ -29,8 +29,12 @@ type PGPManager interface {
 	GetLoadedKeys() []models.KeyInfo
 	// SaveKey saves the specified key in PGP Manager Key Backend
 	SaveKey(fingerprint, armoredData string, password interface{}) error
+	// SaveKeyWithMetadata saves the specified key with its metadata in PGP Manager Key Backend
+	SaveKeyWithMetadata(fingerprint, armoredData string, password interface{}, metadata *models.KeyMetadata) error
 	// DeleteKey removes the specified key from the memory and key backend
 	DeleteKey(ctx context.Context, fingerprint string) error
+	// UnloadKey removes the specified key from the memory without touching the key backend
+	UnloadKey(ctx context.Context, fingerprint string)
 	// SignData signs the specified data with a unlocked private key
 	SignData(ctx context.Context, fingerprint string, data []byte, hashAlgorithm crypto.Hash) (string, error)
 	// GetPublicKeyEntity returns the public key entity
@@ -55,8 +59,14 @@ type PGPManager interface {
 	Encrypt(ctx context.Context, filename, fingerprint string, data []byte, dataOnly bool) (string, error)
 	// Decrypt decrypts data using any available unlocked private key
 	Decrypt(ctx context.Context, data string, dataOnly bool) (*models.GPGDecryptedData, error)
+	// DecryptWithKey decrypts data using only the unlocked private key of the fingerprint
+	DecryptWithKey(ctx context.Context, data string, dataOnly bool, fingerPrint string) (*models.GPGDecryptedData, error)
+	// GetDecryptionKeys returns the fingerprints of the unlocked private keys of the recipients of the data
+	GetDecryptionKeys(ctx context.Context, data string, dataOnly bool) ([]string, error)
 	// GetCachedKeys returns all cached public keys in memory
 	GetCachedKeys(ctx context.Context) []models.KeyInfo
+	// GetCacheStats returns the hit, miss and eviction counters of the public key cache
+	GetCacheStats(ctx context.Context) models.KeyRingCacheStats
 	// SetKeysBase64Encoded sets if keys should be stored in Base64 Encoded format
 	SetKeysBase64Encoded(bool)
 	// MinKeyBits returns the minimum key bits allowed for generating PGP Keys
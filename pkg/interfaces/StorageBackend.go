@@ -1,5 +1,13 @@
 package interfaces
 
+import "errors"
+
+// ErrKeyNotFound is returned when the requested key does not exist, like by StorageBackend.Read or by the key servers
+var ErrKeyNotFound = errors.New("not found")
+
+// ErrKeyConflict is returned when a key was created or modified by someone else, like by ConditionalStorageBackend.Create
+var ErrKeyConflict = errors.New("conflict")
+
 // StorageBackend is a interface for storing / reading keys
 type StorageBackend interface {
 	// Save saves a key to the backend
@@ -8,7 +16,7 @@ type StorageBackend interface {
 	SaveWithMetadata(key, data, metadata string) error
 	// Delete delete a key from backend
 	Delete(key string) error
-	// Read reads a key from the backend
+	// Read reads a key from the backend. Returns ErrKeyNotFound if the key does not exist
 	Read(key string) (data string, metadata string, err error)
 	// List lists the stored keys
 	List() ([]string, error)
@@ -1,5 +1,7 @@
 package interfaces
 
+import "github.com/quan-to/chevron/internal/models"
+
 // TokenManager is an interface to a Login Token Manager
 type TokenManager interface {
 	// AddUser adds a user to Token Manager and returns a login token
@@ -12,4 +14,8 @@ type TokenManager interface {
 	GetUserData(token string) UserData
 	// InvalidateToken invalidates the specified token
 	InvalidateToken(token string) error
+	// ListAPIKeys returns the unexpired tokens with API scopes
+	ListAPIKeys() []models.APIKey
+	// RevokeAPIKey invalidates the token with API scopes of the API key ID
+	RevokeAPIKey(id string) error
 }
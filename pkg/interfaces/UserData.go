@@ -17,3 +17,10 @@ type UserData interface {
 	// GetFingerPrint returns the user key fingerprint
 	GetFingerPrint() string
 }
+
+// ScopedUserData is an UserData of an API key, that has the scopes of the endpoints it can use
+type ScopedUserData interface {
+	UserData
+	// GetScopes returns the API scopes
+	GetScopes() []string
+}
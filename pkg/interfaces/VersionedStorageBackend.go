@@ -0,0 +1,18 @@
+package interfaces
+
+import "github.com/quan-to/chevron/internal/models"
+
+// VersionedStorageBackend is a StorageBackend that keeps the previous versions of the stored keys
+type VersionedStorageBackend interface {
+	StorageBackend
+	// IsVersioned returns if the backend currently keeps versions (for example KV v2 in Vault)
+	IsVersioned() bool
+	// ListVersions returns all versions of the specified key
+	ListVersions(key string) ([]models.KeyVersion, error)
+	// ReadVersion returns the data and metadata of a specific version of the key
+	ReadVersion(key string, version int) (data, metadata string, err error)
+	// Undelete restores a soft deleted version of the key
+	Undelete(key string, version int) error
+	// Rollback writes the specified version as the new current version of the key
+	Rollback(key string, version int) error
+}
@@ -252,39 +252,56 @@ func ReadArmoredKeyRing(r io.Reader) (EntityList, error) {
 // ReadKeyRing reads one or more public/private keys. Unsupported keys are
 // ignored as long as at least a single valid key is found.
 func ReadKeyRing(r io.Reader) (el EntityList, err error) {
-	packets := packet.NewReader(r)
 	var lastUnsupportedError error
 
+	err = ReadKeyRingFunc(r, func(e *Entity) error {
+		el = append(el, e)
+		return nil
+	}, func(skipErr error) {
+		lastUnsupportedError = skipErr
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(el) == 0 {
+		err = lastUnsupportedError
+	}
+	return
+}
+
+// ReadKeyRingFunc reads one or more public/private keys like ReadKeyRing, calling
+// fn for each key as it is read instead of keeping all of them in memory.
+// Unsupported and badly-formatted keys are skipped and their errors passed to skipped.
+// Reading stops at the first error returned by fn.
+func ReadKeyRingFunc(r io.Reader, fn func(e *Entity) error, skipped func(err error)) error {
+	packets := packet.NewReader(r)
+
 	for {
-		var e *Entity
-		e, err = ReadEntity(packets)
+		e, err := ReadEntity(packets)
 		if err != nil {
-			// TODO: warn about skipped unsupported/unreadable keys
 			if _, ok := err.(errors.UnsupportedError); ok {
-				lastUnsupportedError = err
+				skipped(err)
 				err = readToNextPublicKey(packets)
 			} else if _, ok := err.(errors.StructuralError); ok {
 				// Skip unreadable, badly-formatted keys
-				lastUnsupportedError = err
+				skipped(err)
 				err = readToNextPublicKey(packets)
 			}
 			if err == io.EOF {
-				err = nil
-				break
+				return nil
 			}
 			if err != nil {
-				el = nil
-				break
+				return err
 			}
-		} else {
-			el = append(el, e)
+			continue
 		}
-	}
 
-	if len(el) == 0 && err == nil {
-		err = lastUnsupportedError
+		if err := fn(e); err != nil {
+			return err
+		}
 	}
-	return
 }
 
 // readToNextPublicKey reads packets until the start of the entity and leaves
@@ -53,6 +53,11 @@ const (
 	S2KCHECKSUM S2KType = 255
 )
 
+// S2KParams returns the string-to-key type, configuration and cipher used to encrypt the private key
+func (pk *PrivateKey) S2KParams() (s2kType S2KType, config s2k.Config, cipher CipherFunction) {
+	return pk.s2kType, pk.s2kConfig, pk.cipher
+}
+
 func NewRSAPrivateKey(currentTime time.Time, priv *rsa.PrivateKey) *PrivateKey {
 	pk := new(PrivateKey)
 	pk.PublicKey = *NewRSAPublicKey(currentTime, &priv.PublicKey)
@@ -16,6 +16,7 @@ echo "Vault started with PID `cat $HOME/vault.pid`"
 vault auth enable userpass
 vault policy write test-policy ./test-policy.hcl
 vault write auth/userpass/users/${VAULT_USERNAME} password=${VAULT_PASSWORD} policies=test-policy
+vault secrets enable transit
 
 echo "Done. Please run this before the tests:
 export VAULT_ADDR='http://127.0.0.1:8200'
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+)
+
+// SMTPServer is a local SMTP server stand-in for tests. Every message received is sent to Messages
+type SMTPServer struct {
+	Addr     string
+	Messages chan string
+	listener net.Listener
+}
+
+// RunSMTPServer starts a SMTPServer listening at a random localhost port
+func RunSMTPServer() (*SMTPServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SMTPServer{
+		Addr:     l.Addr().String(),
+		Messages: make(chan string, 16),
+		listener: l,
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	return s, nil
+}
+
+// Close stops the server
+func (s *SMTPServer) Close() {
+	_ = s.listener.Close()
+}
+
+func (s *SMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost SMTP test server")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var sb strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				sb.WriteString(l)
+			}
+			s.Messages <- sb.String()
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			// MAIL, RCPT, RSET and NOOP
+			reply("250 OK")
+		}
+	}
+}